@@ -93,7 +93,7 @@ func main() {
 
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key", "If-Match"}
 	corsConfig.AllowCredentials = true
 	corsConfig.MaxAge = 12 * time.Hour
 	corsConfig.AllowOriginFunc = func(origin string) bool {
@@ -116,8 +116,10 @@ func main() {
 		app.RegisterModule(system.NewModule(cfg.Database))
 	}
 
+	var stockModule *stock.Module
 	if cfg.Modules.Stock {
-		app.RegisterModule(stock.NewModule(cfg.Auth))
+		stockModule = stock.NewModule(cfg.Auth, auditModule.Service)
+		app.RegisterModule(stockModule)
 	}
 
 	if cfg.Modules.Users {
@@ -139,6 +141,10 @@ func main() {
 	bootstrap.Run(database)
 	files.CleanupOrphanedImages(database)
 	api.InitAPI(r, app, auditModule.Service, cfg.Auth)
+	if stockModule != nil {
+		stockModule.StartWorkers()
+		defer stockModule.StopWorkers()
+	}
 
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 
@@ -5,6 +5,7 @@ go 1.24.0
 toolchain go1.24.11
 
 require (
+	github.com/boombuler/barcode v1.1.0
 	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/gorm v1.31.0
 )
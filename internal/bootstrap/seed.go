@@ -17,6 +17,17 @@ func Run(db *gorm.DB) {
 		return
 	}
 
+	// базовые роли проверяются при каждом запуске: права, появившиеся после установки, добавляются в существующие роли
+	workerRole := users.Role{Name: "worker", Permissions: []string{"view_dashboard", "view_stock", "create_document", "post_document"}}
+	if err := ensureRole(db, &workerRole); err != nil {
+		log.Printf("❌ [BOOTSTRAP] Не удалось создать роль worker: %v", err)
+	}
+
+	managerRole := users.Role{Name: "manager", Permissions: []string{"view_dashboard", "view_stock", "view_reports", "create_document", "approve_document", "post_document", "cancel_document", "manage_directories", "close_period"}}
+	if err := ensureRole(db, &managerRole); err != nil {
+		log.Printf("❌ [BOOTSTRAP] Не удалось создать роль manager: %v", err)
+	}
+
 	var adminRole users.Role
 	err := db.Where("name = ?", "admin").First(&adminRole).Error
 
@@ -41,16 +52,6 @@ func Run(db *gorm.DB) {
 		return
 	}
 
-	workerRole := users.Role{Name: "worker", Permissions: []string{"view_dashboard", "view_stock", "create_document"}}
-	if err := ensureRole(db, &workerRole); err != nil {
-		log.Printf("❌ [BOOTSTRAP] Не удалось создать роль worker: %v", err)
-	}
-
-	managerRole := users.Role{Name: "manager", Permissions: []string{"view_dashboard", "view_stock", "view_reports", "create_document", "approve_document"}}
-	if err := ensureRole(db, &managerRole); err != nil {
-		log.Printf("❌ [BOOTSTRAP] Не удалось создать роль manager: %v", err)
-	}
-
 	err = createUser(db, "Super Admin", "admin@sklad.com", "admin", adminRole.ID)
 	if err != nil {
 		log.Printf("❌ [BOOTSTRAP] КРИТИЧЕСКАЯ ОШИБКА: Не удалось создать админа: %v", err)
@@ -70,7 +71,7 @@ func ensureRole(db *gorm.DB, role *users.Role) error {
 	if err == nil {
 		role.ID = existing.ID
 		log.Printf("   -> Роль '%s' найдена (ID: %d)", role.Name, role.ID)
-		return nil
+		return grantMissingPermissions(db, &existing, role.Permissions)
 	}
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -84,6 +85,29 @@ func ensureRole(db *gorm.DB, role *users.Role) error {
 	return err
 }
 
+// grantMissingPermissions дописывает в существующую роль недостающие права, не трогая выданные вручную.
+func grantMissingPermissions(db *gorm.DB, role *users.Role, perms []string) error {
+	has := make(map[string]bool, len(role.Permissions))
+	for _, p := range role.Permissions {
+		has[p] = true
+	}
+	var added []string
+	for _, p := range perms {
+		if !has[p] {
+			role.Permissions = append(role.Permissions, p)
+			added = append(added, p)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if err := db.Model(role).Update("permissions", role.Permissions).Error; err != nil {
+		return err
+	}
+	log.Printf("   -> Роли '%s' добавлены права: %v", role.Name, added)
+	return nil
+}
+
 func createUser(db *gorm.DB, name, email, password string, roleID uint) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
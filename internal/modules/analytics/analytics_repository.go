@@ -10,6 +10,7 @@ import (
 
 type Repository interface {
 	GetTotalStock(warehouseID *uint) (decimal.Decimal, int64, error)
+	GetInTransit(warehouseID *uint) (decimal.Decimal, error)
 	GetActivityStats(warehouseID *uint, days int) (int64, decimal.Decimal, decimal.Decimal, error)
 	GetChartData(warehouseID *uint, days int) ([]stockModels.StockMovement, error)
 	GetInventoryHealth(warehouseID *uint) (int64, int64, int64, error)
@@ -44,6 +45,20 @@ func (r *repository) GetTotalStock(warehouseID *uint) (decimal.Decimal, int64, e
 	return totalQty, int64(len(balances)), nil
 }
 
+func (r *repository) GetInTransit(warehouseID *uint) (decimal.Decimal, error) {
+	var total decimal.Decimal
+	query := r.db.Model(&stockModels.StockInTransit{}).
+		Select("COALESCE(SUM(quantity), 0)").
+		Where("received_at IS NULL")
+
+	if warehouseID != nil {
+		query = query.Where("to_warehouse_id = ?", *warehouseID)
+	}
+
+	err := query.Scan(&total).Error
+	return total, err
+}
+
 func (r *repository) GetActivityStats(warehouseID *uint, days int) (int64, decimal.Decimal, decimal.Decimal, error) {
 	startDate := time.Now().AddDate(0, 0, -days)
 	startToday := time.Now().Truncate(24 * time.Hour)
@@ -80,12 +95,13 @@ func (r *repository) GetChartData(warehouseID *uint, days int) ([]stockModels.St
 	var movements []stockModels.StockMovement
 	startDate := time.Now().AddDate(0, 0, -days)
 
-	query := r.db.Model(&stockModels.StockMovement{}).Where("created_at >= ?", startDate)
+	// график строится по учётной дате: документ задним числом попадает в свой день
+	query := r.db.Model(&stockModels.StockMovement{}).Where("document_date >= ?", startDate)
 	if warehouseID != nil {
 		query = query.Where("warehouse_id = ?", *warehouseID)
 	}
 
-	err := query.Order("created_at asc").Find(&movements).Error
+	err := query.Order("document_date asc").Find(&movements).Error
 	return movements, err
 }
 
@@ -22,6 +22,11 @@ func (s *service) GetDashboardData(warehouseID *uint) (*DashboardData, error) {
 		return nil, err
 	}
 
+	inTransit, err := s.repo.GetInTransit(warehouseID)
+	if err != nil {
+		return nil, err
+	}
+
 	totalVariants, inStock, lowStock, err := s.repo.GetInventoryHealth(warehouseID)
 	if err != nil {
 		return nil, err
@@ -40,7 +45,7 @@ func (s *service) GetDashboardData(warehouseID *uint) (*DashboardData, error) {
 	chartMap := make(map[string]map[string]decimal.Decimal)
 
 	for _, m := range movementsRaw {
-		date := m.CreatedAt.Format("2006-01-02")
+		date := m.DocumentDate.Format("2006-01-02")
 		if _, ok := chartMap[date]; !ok {
 			chartMap[date] = map[string]decimal.Decimal{
 				"INCOME":  decimal.Zero,
@@ -76,6 +81,7 @@ func (s *service) GetDashboardData(warehouseID *uint) (*DashboardData, error) {
 
 	return &DashboardData{
 		TotalStock:       totalStock,
+		InTransit:        inTransit,
 		TotalItemsCount:  totalVariants,
 		TotalVariants:    totalVariants,
 		ItemsInStock:     inStock,
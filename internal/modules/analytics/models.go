@@ -8,6 +8,7 @@ import (
 
 type DashboardData struct {
 	TotalStock decimal.Decimal `json:"total_stock"`
+	InTransit  decimal.Decimal `json:"in_transit"`
 
 	TotalItemsCount  int64 `json:"total_items_count"`
 	RecentOperations int64 `json:"recent_operations"`
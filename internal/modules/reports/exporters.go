@@ -273,6 +273,50 @@ func (g *ExcelGenerator) GenerateABC(data []ABCItem) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func (g *ExcelGenerator) GenerateWriteOffs(data []WriteOffItem) ([]byte, error) {
+	f := excelize.NewFile()
+	sheet := "Write-offs"
+	f.SetSheetName("Sheet1", sheet)
+
+	headerStyle, dataStyle, moneyStyle := g.createStyles(f)
+
+	headers := []string{"Причина", "Склад", "Артикул", "Товар", "Ед.", "Кол-во", "Себестоимость"}
+	for i, h := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(sheet, cell, h)
+	}
+
+	lastRow := len(data) + 1
+	for i, item := range data {
+		row := i + 2
+		f.SetCellValue(sheet, fmt.Sprintf("A%d", row), item.ReasonName)
+		f.SetCellValue(sheet, fmt.Sprintf("B%d", row), item.WarehouseName)
+		f.SetCellValue(sheet, fmt.Sprintf("C%d", row), item.SKU)
+		f.SetCellValue(sheet, fmt.Sprintf("D%d", row), item.ProductName)
+		f.SetCellValue(sheet, fmt.Sprintf("E%d", row), item.Unit)
+		f.SetCellValue(sheet, fmt.Sprintf("F%d", row), item.Quantity.InexactFloat64())
+		f.SetCellValue(sheet, fmt.Sprintf("G%d", row), item.TotalCost.InexactFloat64())
+	}
+
+	f.SetCellStyle(sheet, "A1", "G1", headerStyle)
+	if len(data) > 0 {
+		f.SetCellStyle(sheet, "A2", fmt.Sprintf("F%d", lastRow), dataStyle)
+		f.SetCellStyle(sheet, "G2", fmt.Sprintf("G%d", lastRow), moneyStyle)
+	}
+
+	f.SetColWidth(sheet, "A", "B", 25)
+	f.SetColWidth(sheet, "C", "C", 15)
+	f.SetColWidth(sheet, "D", "D", 40)
+	f.SetColWidth(sheet, "E", "F", 12)
+	f.SetColWidth(sheet, "G", "G", 15)
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 type CSVGenerator struct{}
 
 func NewCSVGenerator() *CSVGenerator { return &CSVGenerator{} }
@@ -369,3 +413,19 @@ func (g *CSVGenerator) GenerateABC(data []ABCItem) ([]byte, error) {
 	w.Flush()
 	return buf.Bytes(), w.Error()
 }
+
+func (g *CSVGenerator) GenerateWriteOffs(data []WriteOffItem) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	g.writeBOM(buf)
+	w := csv.NewWriter(buf)
+	w.Comma = ';'
+	w.Write([]string{"Код причины", "Причина", "Склад", "Артикул", "Товар", "Ед.", "Кол-во", "Себестоимость"})
+	for _, item := range data {
+		w.Write([]string{
+			item.ReasonCode, item.ReasonName, item.WarehouseName, item.SKU, item.ProductName, item.Unit,
+			item.Quantity.StringFixed(2), item.TotalCost.StringFixed(2),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,488 @@
+package reports
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed forms/*.yml
+var builtinForms embed.FS
+
+// FormTemplate - макет печатной формы документа. Встроенные макеты лежат в forms/, свои кладутся в каталог
+// форм и подхватываются при старте без пересборки; макет с тем же name заменяет встроенный.
+// Текстовые поля - шаблоны text/template: шапка и итоги над FormData, ячейки таблицы над FormRow.
+type FormTemplate struct {
+	Name          string   `yaml:"name"`
+	Title         string   `yaml:"title"`
+	DocumentTypes []string `yaml:"document_types"`
+	// DefaultFor - типы документов, для которых эта форма печатается, если форма не указана.
+	DefaultFor    []string     `yaml:"default_for"`
+	Orientation   string       `yaml:"orientation"`
+	Fields        []FormField  `yaml:"fields"`
+	Columns       []FormColumn `yaml:"columns"`
+	AmountInWords bool         `yaml:"amount_in_words"`
+	Signatures    []string     `yaml:"signatures"`
+
+	title  *template.Template
+	fields []*template.Template
+	cells  []*template.Template
+	totals []*template.Template
+}
+
+// FormField - реквизит в шапке формы; пустые значения не печатаются.
+type FormField struct {
+	Label string `yaml:"label"`
+	Value string `yaml:"value"`
+}
+
+type FormColumn struct {
+	Title string  `yaml:"title"`
+	Width float64 `yaml:"width"`
+	Align string  `yaml:"align"`
+	Wrap  bool    `yaml:"wrap"`
+	Value string  `yaml:"value"`
+	// Total - значение колонки в строке итогов; если итогов нет ни у одной колонки, строка не печатается.
+	Total string `yaml:"total"`
+}
+
+// FormParty - реквизиты склада или контрагента.
+type FormParty struct {
+	Name    string
+	Address string
+	Phone   string
+	Email   string
+}
+
+// FormData - документ, подготовленный к печати.
+type FormData struct {
+	Organization  string
+	Type          string
+	Number        string
+	Status        string
+	Date          time.Time
+	Currency      string
+	Comment       string
+	Warehouse     FormParty
+	ToWarehouse   FormParty
+	Counterparty  FormParty
+	Rows          []FormRow
+	TotalQuantity decimal.Decimal
+	TotalAmount   decimal.Decimal
+	AmountInWords string
+}
+
+type FormRow struct {
+	N             int
+	SKU           string
+	Name          string
+	Unit          string
+	BatchNumber   string
+	SerialNumbers []string
+	Quantity      decimal.Decimal
+	Price         decimal.Decimal
+	Amount        decimal.Decimal
+	// EnteredUnit и EnteredQuantity - единица и количество, в которых строку ввели (коробки, паллеты);
+	// EnteredUnit пуст, если строка введена в базовой единице. Unit и Quantity всегда в базовой единице.
+	EnteredUnit     string
+	EnteredQuantity decimal.Decimal
+	// BookQuantity и Difference заполняются для инвентаризации: остаток по учёту и отклонение факта от него.
+	BookQuantity decimal.Decimal
+	Difference   decimal.Decimal
+}
+
+var formFuncs = template.FuncMap{
+	"money": fmtMoney,
+	"qty":   func(d decimal.Decimal) string { return d.String() },
+	"date":  func(t time.Time) string { return t.Format("02.01.2006") },
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+}
+
+// Supports сообщает, печатается ли форма для документов типа docType.
+func (f *FormTemplate) Supports(docType string) bool {
+	return containsType(f.DocumentTypes, docType)
+}
+
+// IsDefaultFor сообщает, печатается ли форма для docType, когда форма не указана.
+func (f *FormTemplate) IsDefaultFor(docType string) bool {
+	return containsType(f.DefaultFor, docType)
+}
+
+func containsType(types []string, docType string) bool {
+	for _, t := range types {
+		if strings.EqualFold(t, docType) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFormTemplates читает встроенные формы, а затем формы из dir (*.yml, *.yaml).
+// Отсутствующий каталог не ошибка: остаются встроенные формы.
+func LoadFormTemplates(dir string) (map[string]*FormTemplate, error) {
+	forms := make(map[string]*FormTemplate)
+
+	builtin, err := fs.Glob(builtinForms, "forms/*.yml")
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range builtin {
+		data, err := builtinForms.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := addForm(forms, data, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir == "" {
+		return forms, nil
+	}
+	var custom []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		paths, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		custom = append(custom, paths...)
+	}
+	sort.Strings(custom)
+	for _, path := range custom {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := addForm(forms, data, path); err != nil {
+			return nil, err
+		}
+	}
+	return forms, nil
+}
+
+func addForm(forms map[string]*FormTemplate, data []byte, source string) error {
+	form, err := ParseFormTemplate(data)
+	if err != nil {
+		return fmt.Errorf("print form %s: %w", source, err)
+	}
+	forms[form.Name] = form
+	return nil
+}
+
+// ParseFormTemplate разбирает YAML-макет и компилирует его шаблоны, чтобы ошибки в макете были видны при загрузке.
+func ParseFormTemplate(data []byte) (*FormTemplate, error) {
+	var form FormTemplate
+	if err := yaml.Unmarshal(data, &form); err != nil {
+		return nil, err
+	}
+	if form.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if len(form.DocumentTypes) == 0 {
+		return nil, fmt.Errorf("document_types is required")
+	}
+	for _, t := range form.DefaultFor {
+		if !form.Supports(t) {
+			return nil, fmt.Errorf("default_for type %s is not listed in document_types", t)
+		}
+	}
+	if len(form.Columns) == 0 {
+		return nil, fmt.Errorf("at least one column is required")
+	}
+	switch form.Orientation {
+	case "":
+		form.Orientation = "P"
+	case "P", "L":
+	default:
+		return nil, fmt.Errorf("orientation must be P or L")
+	}
+
+	var err error
+	parse := func(kind, text string) *template.Template {
+		if err != nil {
+			return nil
+		}
+		var t *template.Template
+		t, err = template.New(form.Name + "/" + kind).Funcs(formFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", kind, err)
+		}
+		return t
+	}
+
+	form.title = parse("title", form.Title)
+	for i, field := range form.Fields {
+		form.fields = append(form.fields, parse(fmt.Sprintf("fields[%d]", i), field.Value))
+	}
+	for i, col := range form.Columns {
+		if col.Width <= 0 {
+			return nil, fmt.Errorf("columns[%d]: width must be positive", i)
+		}
+		form.cells = append(form.cells, parse(fmt.Sprintf("columns[%d].value", i), col.Value))
+		form.totals = append(form.totals, parse(fmt.Sprintf("columns[%d].total", i), col.Total))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &form, nil
+}
+
+func execute(t *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return cleanString(strings.TrimSpace(buf.String())), nil
+}
+
+// GenerateForm печатает документ по макету form.
+func (g *PDFGenerator) GenerateForm(form *FormTemplate, data FormData) ([]byte, error) {
+	pdf := g.initPDF(form.Orientation)
+
+	title, err := execute(form.title, data)
+	if err != nil {
+		return nil, err
+	}
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Roboto", "B", 14)
+	pdf.MultiCell(0, 7, title, "", "C", false)
+	pdf.Ln(3)
+
+	for i, field := range form.Fields {
+		value, err := execute(form.fields[i], data)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			continue
+		}
+		pdf.SetFont("Roboto", "B", 9)
+		pdf.CellFormat(45, 5, field.Label, "", 0, "L", false, 0, "")
+		pdf.SetFont("Roboto", "", 9)
+		pdf.MultiCell(0, 5, value, "", "L", false)
+	}
+	pdf.Ln(4)
+
+	headers := make([]string, len(form.Columns))
+	widths := make([]float64, len(form.Columns))
+	aligns := make([]string, len(form.Columns))
+	wrapCols := make([]bool, len(form.Columns))
+	hasTotals := false
+	for i, col := range form.Columns {
+		headers[i], widths[i], wrapCols[i] = col.Title, col.Width, col.Wrap
+		aligns[i] = col.Align
+		if aligns[i] == "" {
+			aligns[i] = "L"
+		}
+		if col.Total != "" {
+			hasTotals = true
+		}
+	}
+	g.drawTableHeader(pdf, headers, widths, aligns)
+
+	pdf.SetFont("Roboto", "", 9)
+	for _, row := range data.Rows {
+		values := make([]string, len(form.Columns))
+		for i := range form.Columns {
+			if values[i], err = execute(form.cells[i], row); err != nil {
+				return nil, err
+			}
+		}
+		g.drawSmartRow(pdf, widths, aligns, wrapCols, values)
+	}
+
+	if hasTotals {
+		// колонки до первой с итогом сливаются под подпись "Итого"
+		var merged []int
+		for i := 0; i < len(form.Columns) && form.Columns[i].Total == ""; i++ {
+			merged = append(merged, i)
+		}
+		if len(merged) == 0 {
+			merged = []int{0}
+		}
+		values := []string{"Итого:"}
+		for i := len(merged); i < len(form.Columns); i++ {
+			total, err := execute(form.totals[i], data)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, total)
+		}
+		g.drawTotalRow(pdf, widths, values, merged)
+	} else {
+		pdf.Ln(4)
+	}
+
+	if form.AmountInWords {
+		pdf.SetFont("Roboto", "B", 9)
+		pdf.MultiCell(0, 5, "Всего на сумму: "+data.AmountInWords, "", "L", false)
+		pdf.Ln(4)
+	}
+
+	g.drawSignatures(pdf, form.Signatures)
+
+	pdf.SetY(-12)
+	pdf.SetFont("Roboto", "", 7)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.Cell(0, 5, fmt.Sprintf("Документ создан в FlowKeeper: %s | Страница %d", time.Now().Format("02.01.2006 15:04"), pdf.PageNo()))
+
+	var buf bytes.Buffer
+	err = pdf.Output(&buf)
+	return buf.Bytes(), err
+}
+
+// drawSignatures рисует блоки подписей по два в строке: должность, подпись и расшифровка.
+func (g *PDFGenerator) drawSignatures(pdf *fpdf.Fpdf, labels []string) {
+	if len(labels) == 0 {
+		return
+	}
+	const rowHeight = 14.0
+	pageW, pageH := pdf.GetPageSize()
+	left, _, right, bottom := pdf.GetMargins()
+	colW := (pageW - left - right) / 2
+	rows := (len(labels) + 1) / 2
+	if pdf.GetY()+float64(rows)*rowHeight > pageH-bottom-10 {
+		pdf.AddPage()
+	}
+
+	startY := pdf.GetY() + 4
+	pdf.SetTextColor(0, 0, 0)
+	for i, label := range labels {
+		x := left + float64(i%2)*colW
+		y := startY + float64(i/2)*rowHeight
+		pdf.SetXY(x, y)
+		pdf.SetFont("Roboto", "B", 9)
+		pdf.CellFormat(colW, 5, label, "", 0, "L", false, 0, "")
+		pdf.SetXY(x, y+6)
+		pdf.SetFont("Roboto", "", 9)
+		pdf.CellFormat(colW, 5, "____________ / ______________________ /", "", 0, "L", false, 0, "")
+	}
+	pdf.SetXY(left, startY+float64(rows)*rowHeight)
+}
+
+type currencyWords struct {
+	major    [3]string
+	minor    [3]string
+	feminine bool
+}
+
+var currencyNames = map[string]currencyWords{
+	"RUB": {major: [3]string{"рубль", "рубля", "рублей"}, minor: [3]string{"копейка", "копейки", "копеек"}},
+	"USD": {major: [3]string{"доллар", "доллара", "долларов"}, minor: [3]string{"цент", "цента", "центов"}},
+	"EUR": {major: [3]string{"евро", "евро", "евро"}, minor: [3]string{"цент", "цента", "центов"}},
+	"CNY": {major: [3]string{"юань", "юаня", "юаней"}, minor: [3]string{"фэнь", "фэня", "фэней"}},
+}
+
+// AmountInWords - сумма прописью для печатных форм: "Сто двадцать рублей 50 копеек".
+// Для валют без словаря после суммы пишется код валюты.
+func AmountInWords(amount decimal.Decimal, currency string) string {
+	amount = amount.Abs().Round(2)
+	whole := amount.Truncate(0)
+	n := whole.IntPart()
+	cents := amount.Sub(whole).Shift(2).IntPart()
+
+	var text string
+	if names, ok := currencyNames[strings.ToUpper(currency)]; ok {
+		text = fmt.Sprintf("%s %s %02d %s", numberInWords(n, names.feminine), plural(n, names.major), cents, plural(cents, names.minor))
+	} else {
+		text = fmt.Sprintf("%s %s %02d", numberInWords(n, false), currency, cents)
+	}
+	runes := []rune(text)
+	return strings.ToUpper(string(runes[0])) + string(runes[1:])
+}
+
+func plural(n int64, forms [3]string) string {
+	n %= 100
+	if n >= 11 && n <= 19 {
+		return forms[2]
+	}
+	switch n % 10 {
+	case 1:
+		return forms[0]
+	case 2, 3, 4:
+		return forms[1]
+	default:
+		return forms[2]
+	}
+}
+
+var (
+	unitsMasculine = []string{"", "один", "два", "три", "четыре", "пять", "шесть", "семь", "восемь", "девять"}
+	unitsFeminine  = []string{"", "одна", "две", "три", "четыре", "пять", "шесть", "семь", "восемь", "девять"}
+	teens          = []string{"десять", "одиннадцать", "двенадцать", "тринадцать", "четырнадцать", "пятнадцать", "шестнадцать", "семнадцать", "восемнадцать", "девятнадцать"}
+	tens           = []string{"", "", "двадцать", "тридцать", "сорок", "пятьдесят", "шестьдесят", "семьдесят", "восемьдесят", "девяносто"}
+	hundreds       = []string{"", "сто", "двести", "триста", "четыреста", "пятьсот", "шестьсот", "семьсот", "восемьсот", "девятьсот"}
+
+	// разряды по три цифры: тысячи женского рода, остальные мужского
+	scales = []struct {
+		forms    [3]string
+		feminine bool
+	}{
+		{forms: [3]string{"тысяча", "тысячи", "тысяч"}, feminine: true},
+		{forms: [3]string{"миллион", "миллиона", "миллионов"}},
+		{forms: [3]string{"миллиард", "миллиарда", "миллиардов"}},
+		{forms: [3]string{"триллион", "триллиона", "триллионов"}},
+		{forms: [3]string{"квадриллион", "квадриллиона", "квадриллионов"}},
+		{forms: [3]string{"квинтиллион", "квинтиллиона", "квинтиллионов"}},
+	}
+)
+
+func numberInWords(n int64, feminine bool) string {
+	if n == 0 {
+		return "ноль"
+	}
+	var triads []int64
+	for ; n > 0; n /= 1000 {
+		triads = append(triads, n%1000)
+	}
+	var words []string
+	for i := len(triads) - 1; i >= 0; i-- {
+		t := triads[i]
+		if t == 0 {
+			continue
+		}
+		fem := feminine
+		if i > 0 {
+			fem = scales[i-1].feminine
+		}
+		words = append(words, triadInWords(t, fem)...)
+		if i > 0 {
+			words = append(words, plural(t, scales[i-1].forms))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func triadInWords(n int64, feminine bool) []string {
+	var words []string
+	if h := n / 100; h > 0 {
+		words = append(words, hundreds[h])
+	}
+	rest := n % 100
+	if rest >= 10 && rest < 20 {
+		return append(words, teens[rest-10])
+	}
+	if t := rest / 10; t > 0 {
+		words = append(words, tens[t])
+	}
+	units := unitsMasculine
+	if feminine {
+		units = unitsFeminine
+	}
+	if u := rest % 10; u > 0 {
+		words = append(words, units[u])
+	}
+	return words
+}
@@ -0,0 +1,207 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/qr"
+	"github.com/go-pdf/fpdf"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	LabelShelf = "shelf"
+	LabelPrice = "price"
+)
+
+// Label - этикетка варианта: наименование, цена и штрихкод (EAN13, CODE128 или QR).
+type Label struct {
+	Name        string
+	SKU         string
+	Details     string
+	Price       *decimal.Decimal
+	Currency    string
+	Barcode     string
+	BarcodeType string
+}
+
+// labelGrids - раскладка этикеток на листе A4: колонки и строки.
+var labelGrids = map[string]struct{ cols, rows int }{
+	LabelShelf: {cols: 3, rows: 7},
+	LabelPrice: {cols: 4, rows: 10},
+}
+
+// GenerateLabels печатает этикетки сеткой на листах A4: shelf - полочные с крупной ценой, price - мелкие ценники.
+func (g *PDFGenerator) GenerateLabels(labels []Label, format string) ([]byte, error) {
+	grid, ok := labelGrids[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown label format %q", format)
+	}
+	pdf := g.initPDF("P")
+	pageW, pageH := pdf.GetPageSize()
+	left, top, right, bottom := pdf.GetMargins()
+	w := (pageW - left - right) / float64(grid.cols)
+	h := (pageH - top - bottom) / float64(grid.rows)
+	perPage := grid.cols * grid.rows
+
+	for i, label := range labels {
+		if i > 0 && i%perPage == 0 {
+			pdf.AddPage()
+		}
+		n := i % perPage
+		x := left + float64(n%grid.cols)*w
+		y := top + float64(n/grid.cols)*h
+		if format == LabelShelf {
+			g.drawShelfLabel(pdf, label, x, y, w, h)
+		} else {
+			g.drawPriceLabel(pdf, label, x, y, w, h)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	return buf.Bytes(), err
+}
+
+func (g *PDFGenerator) drawShelfLabel(pdf *fpdf.Fpdf, l Label, x, y, w, h float64) {
+	const pad = 2.0
+	drawCutFrame(pdf, x, y, w, h)
+
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Roboto", "B", 9)
+	drawClippedText(pdf, l.Name, x+pad, y+pad, w-2*pad, 4, 2)
+	pdf.SetFont("Roboto", "", 7)
+	info := "Арт. " + l.SKU
+	if l.Details != "" {
+		info += ", " + l.Details
+	}
+	drawClippedText(pdf, info, x+pad, y+pad+8.5, w-2*pad, 3, 1)
+
+	// штрихкод слева внизу, цена справа от него
+	codeW, codeH := w*0.55, 12.0
+	drawLabelBarcode(pdf, l, x+pad, y+h-pad-codeH-3, codeW, codeH)
+	if l.Price != nil {
+		pdf.SetFont("Roboto", "B", 16)
+		pdf.SetXY(x+pad+codeW, y+h-pad-13)
+		pdf.CellFormat(w-2*pad-codeW, 8, fmtMoney(*l.Price), "", 0, "R", false, 0, "")
+		pdf.SetFont("Roboto", "", 7)
+		pdf.SetXY(x+pad+codeW, y+h-pad-5)
+		pdf.CellFormat(w-2*pad-codeW, 3, currencyLabel(l.Currency), "", 0, "R", false, 0, "")
+	}
+}
+
+func (g *PDFGenerator) drawPriceLabel(pdf *fpdf.Fpdf, l Label, x, y, w, h float64) {
+	const pad = 1.5
+	drawCutFrame(pdf, x, y, w, h)
+
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Roboto", "B", 7)
+	drawClippedText(pdf, l.Name, x+pad, y+pad, w-2*pad, 3, 2)
+	if l.Price != nil {
+		pdf.SetFont("Roboto", "B", 12)
+		pdf.SetXY(x+pad, y+pad+6)
+		pdf.CellFormat(w-2*pad, 6, fmtMoney(*l.Price)+" "+currencyLabel(l.Currency), "", 0, "C", false, 0, "")
+	}
+	drawLabelBarcode(pdf, l, x+pad+4, y+h-pad-10, w-2*pad-8, 7)
+}
+
+// drawLabelBarcode рисует штрихкод в прямоугольнике; у линейных кодов под штрихами печатаются цифры.
+// QR вписывается квадратом по высоте вместе с местом под подпись.
+func drawLabelBarcode(pdf *fpdf.Fpdf, l Label, x, y, w, h float64) {
+	if l.Barcode == "" {
+		return
+	}
+	name, err := registerBarcode(pdf, l.Barcode, l.BarcodeType)
+	if err != nil {
+		pdf.SetError(fmt.Errorf("barcode %s: %w", l.Barcode, err))
+		return
+	}
+	opts := fpdf.ImageOptions{ImageType: "PNG"}
+	if l.BarcodeType == "QR" {
+		side := h + 3
+		pdf.ImageOptions(name, x, y, side, side, false, opts, 0, "")
+		return
+	}
+	pdf.ImageOptions(name, x, y, w, h, false, opts, 0, "")
+	pdf.SetFont("Roboto", "", 6)
+	pdf.SetXY(x, y+h)
+	pdf.CellFormat(w, 3, l.Barcode, "", 0, "C", false, 0, "")
+}
+
+// registerBarcode кодирует штрихкод в PNG и регистрирует его в документе один раз на код.
+func registerBarcode(pdf *fpdf.Fpdf, code, codeType string) (string, error) {
+	name := "barcode:" + codeType + ":" + code
+	if pdf.GetImageInfo(name) != nil {
+		return name, nil
+	}
+
+	var (
+		bc  barcode.Barcode
+		err error
+	)
+	switch codeType {
+	case "QR":
+		bc, err = qr.Encode(code, qr.M, qr.Auto)
+	case "EAN13":
+		bc, err = ean.Encode(code)
+	default:
+		bc, err = code128.Encode(code)
+	}
+	if err != nil {
+		return "", err
+	}
+	// растягиваем до целого числа пикселей на модуль, чтобы штрихи не размывались при печати
+	bounds := bc.Bounds()
+	width, height := bounds.Dx()*4, 80
+	if codeType == "QR" {
+		width, height = bounds.Dx()*8, bounds.Dy()*8
+	}
+	if bc, err = barcode.Scale(bc, width, height); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, bc); err != nil {
+		return "", err
+	}
+	pdf.RegisterImageOptionsReader(name, fpdf.ImageOptions{ImageType: "PNG"}, &buf)
+	return name, pdf.Error()
+}
+
+func drawCutFrame(pdf *fpdf.Fpdf, x, y, w, h float64) {
+	pdf.SetDrawColor(180, 180, 180)
+	pdf.SetLineWidth(0.1)
+	pdf.SetDashPattern([]float64{1, 1}, 0)
+	pdf.Rect(x, y, w, h, "D")
+	pdf.SetDashPattern([]float64{}, 0)
+	pdf.SetDrawColor(0, 0, 0)
+}
+
+// drawClippedText печатает текст не длиннее maxLines строк, обрезая остаток многоточием.
+func drawClippedText(pdf *fpdf.Fpdf, text string, x, y, w, lineHeight float64, maxLines int) {
+	lines := pdf.SplitText(cleanString(text), w)
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := []rune(strings.TrimSpace(lines[maxLines-1]))
+		for len(last) > 0 && pdf.GetStringWidth(string(last)+"…") > w {
+			last = last[:len(last)-1]
+		}
+		lines[maxLines-1] = string(last) + "…"
+	}
+	for i, line := range lines {
+		pdf.SetXY(x, y+float64(i)*lineHeight)
+		pdf.CellFormat(w, lineHeight, line, "", 0, "L", false, 0, "")
+	}
+}
+
+func currencyLabel(currency string) string {
+	if currency == "" || strings.EqualFold(currency, "RUB") {
+		return "руб."
+	}
+	return currency
+}
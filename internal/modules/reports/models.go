@@ -71,3 +71,15 @@ type ABCItem struct {
 	SharePercent decimal.Decimal `json:"share_percent"` // Доля в общей выручке %
 	Class        string          `json:"class"`         // A, B, C
 }
+
+// Списания
+type WriteOffItem struct {
+	ReasonCode    string          `json:"reason_code"`
+	ReasonName    string          `json:"reason_name"`
+	WarehouseName string          `json:"warehouse_name"`
+	SKU           string          `json:"sku"`
+	ProductName   string          `json:"product_name"`
+	Unit          string          `json:"unit"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	TotalCost     decimal.Decimal `json:"total_cost"` // себестоимость списанного
+}
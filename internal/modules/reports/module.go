@@ -1,8 +1,12 @@
 package reports
 
 import (
+	"fmt"
+
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+
+	stockConfig "github.com/maksroxx/flowkeeper/internal/modules/stock/config"
 )
 
 type Module struct{}
@@ -16,7 +20,12 @@ func (m *Module) Migrate(db *gorm.DB) error {
 }
 
 func (m *Module) RegisterRoutes(r *gin.Engine, db *gorm.DB) {
-	repo := NewRepository(db)
+	stockCfg, err := stockConfig.LoadStockConfig("./config/stock_config.yml")
+	if err != nil {
+		panic(fmt.Sprintf("failed to load stock module config: %v", err))
+	}
+
+	repo := NewRepository(db, stockCfg.AccountingPolicy)
 	svc := NewService(repo)
 	handler := NewHandler(svc)
 
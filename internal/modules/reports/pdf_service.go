@@ -220,6 +220,38 @@ func (g *PDFGenerator) GenerateABCReport(data []ABCItem, from, to time.Time) ([]
 	return buf.Bytes(), err
 }
 
+func (g *PDFGenerator) GenerateWriteOffReport(data []WriteOffItem, from, to time.Time) ([]byte, error) {
+	pdf := g.initPDF("L")
+	g.drawReportHeaderSimple(pdf, "Списания товаров", fmt.Sprintf("Период: %s - %s", from.Format("02.01.2006"), to.Format("02.01.2006")))
+
+	headers := []string{"Причина", "Склад", "Артикул", "Товар", "Ед.", "Кол-во", "Себестоимость"}
+	widths := []float64{40, 40, 30, 95, 15, 25, 30} // 275
+	aligns := []string{"L", "L", "L", "L", "C", "R", "R"}
+	wrapCols := []bool{true, true, false, true, false, false, false}
+
+	g.drawTableHeader(pdf, headers, widths, aligns)
+
+	pdf.SetFont("Roboto", "", 9)
+	pdf.SetTextColor(0, 0, 0)
+	totalQty := decimal.Zero
+	totalCost := decimal.Zero
+
+	for _, item := range data {
+		totalQty = totalQty.Add(item.Quantity)
+		totalCost = totalCost.Add(item.TotalCost)
+		rowValues := []string{
+			cleanString(item.ReasonName), cleanString(item.WarehouseName), cleanString(item.SKU),
+			cleanString(item.ProductName), cleanString(item.Unit), item.Quantity.StringFixed(2), fmtMoney(item.TotalCost),
+		}
+		g.drawSmartRow(pdf, widths, aligns, wrapCols, rowValues)
+	}
+	g.drawTotalRow(pdf, widths, []string{"ИТОГО:", totalQty.StringFixed(2), fmtMoney(totalCost)}, []int{0, 1, 2, 3, 4})
+	g.drawFooter(pdf)
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	return buf.Bytes(), err
+}
+
 func (g *PDFGenerator) drawSmartRow(pdf *fpdf.Fpdf, widths []float64, aligns []string, wrapCols []bool, data []string) {
 	const lineHeight = 3.5
 	const cellPadding = 1.0
@@ -400,6 +432,16 @@ func getDocTypeShort(t string) string {
 		return "Заказ"
 	case "TRANSFER":
 		return "Перем"
+	case "RETURN_IN":
+		return "ВозвП"
+	case "RETURN_OUT":
+		return "ВозвПс"
+	case "WRITE_OFF":
+		return "Спис"
+	case "ASSEMBLY":
+		return "Сборка"
+	case "DISASSEMBLY":
+		return "Разбор"
 	default:
 		return t
 	}
@@ -34,6 +34,8 @@ func (s *Service) GenerateReport(req ReportRequest) ([]byte, string, error) {
 		return s.generateCustomers(req)
 	case "abc":
 		return s.generateABCReport(req)
+	case "write_offs":
+		return s.generateWriteOffs(req)
 	default:
 		return nil, "", fmt.Errorf("unknown report type: %s", req.Type)
 	}
@@ -193,3 +195,22 @@ func (s *Service) generateABCReport(req ReportRequest) ([]byte, string, error) {
 		return b, "pdf", err
 	}
 }
+
+func (s *Service) generateWriteOffs(req ReportRequest) ([]byte, string, error) {
+	data, err := s.repo.GetWriteOffData(req.DateFrom, req.DateTo, req.WarehouseID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch req.Format {
+	case "excel", "xlsx":
+		b, err := s.excelGen.GenerateWriteOffs(data)
+		return b, "xlsx", err
+	case "csv":
+		b, err := s.csvGen.GenerateWriteOffs(data)
+		return b, "csv", err
+	default:
+		b, err := s.pdfGen.GenerateWriteOffReport(data, req.DateFrom, req.DateTo)
+		return b, "pdf", err
+	}
+}
@@ -13,14 +13,30 @@ type Repository interface {
 	GetMovementsData(from, to time.Time, warehouseID *uint) ([]MovementItem, error)
 	GetCustomerData(from, to time.Time) ([]CustomerReportItem, error)
 	GetSalesRanking(from, to time.Time, warehouseID *uint) ([]ABCItem, error)
+	GetWriteOffData(from, to time.Time, warehouseID *uint) ([]WriteOffItem, error)
 }
 
 type repository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	policy string
 }
 
-func NewRepository(db *gorm.DB) Repository {
-	return &repository{db: db}
+// NewRepository создаёт репозиторий отчётов. policy — метод учёта себестоимости из stock_config.yml
+// ("fifo", "lifo", "fefo", "average", "total"), по нему выбирается способ оценки себестоимости и остатков:
+// при партионном учёте - по партиям, иначе по средней себестоимости остатков и движений.
+// Суммы приводятся к базовой валюте: цены строк документов умножаются на documents.exchange_rate
+// (курс на дату документа, фиксируется при проведении), себестоимость партий и движений уже в базовой валюте.
+func NewRepository(db *gorm.DB, policy string) Repository {
+	return &repository{db: db, policy: policy}
+}
+
+// isLotPolicy - политики, при которых себестоимость ведётся по партиям, а avg_cost в остатках не обновляется.
+func isLotPolicy(policy string) bool {
+	switch policy {
+	case "fifo", "lifo", "fefo":
+		return true
+	}
+	return false
 }
 
 type ProfitRecord struct {
@@ -33,37 +49,118 @@ type ProfitRecord struct {
 }
 
 func (r *repository) GetFIFOProfitData(from, to time.Time, warehouseID *uint) ([]ProfitRecord, error) {
+	if !isLotPolicy(r.policy) {
+		return r.getMovementCostProfitData(from, to, warehouseID)
+	}
+
 	var results []ProfitRecord
 	query := r.db.Table("stock_movements as sm").
 		Select(`
 			variants.id as variant_id, variants.sku, products.name as product_name,
 			COALESCE(SUM(ABS(sm.quantity)), 0) as quantity,
-			COALESCE(SUM(ABS(sm.quantity) * COALESCE(sale_item.price, 0)), 0) as revenue,
-			COALESCE(SUM(ABS(sm.quantity) * COALESCE(buy_item.price, 0)), 0) as cost
+			COALESCE(SUM(ABS(sm.quantity) * COALESCE(sale_item.price, 0) * COALESCE(NULLIF(sale_doc.exchange_rate, 0), 1)), 0) as revenue,
+			COALESCE(SUM(ABS(sm.quantity) * COALESCE(lot.unit_cost, buy_item.price * COALESCE(NULLIF(buy_doc.exchange_rate, 0), 1), 0)), 0) as cost
 		`).
 		Joins("JOIN variants ON variants.id = sm.item_id").
 		Joins("JOIN products ON products.id = variants.product_id").
 		Joins("LEFT JOIN document_items as sale_item ON sale_item.document_id = sm.document_id AND sale_item.item_id = sm.item_id").
+		Joins("JOIN documents as sale_doc ON sale_doc.id = sm.document_id").
 		Joins("LEFT JOIN stock_lots as lot ON lot.id = sm.source_lot_id").
 		Joins("LEFT JOIN document_items as buy_item ON buy_item.document_id = lot.income_document_id AND buy_item.item_id = lot.variant_id").
-		Where("sm.type = ?", "OUTCOME").
-		Where("sm.created_at BETWEEN ? AND ?", from, to)
+		Joins("LEFT JOIN documents as buy_doc ON buy_doc.id = lot.income_document_id").
+		Where("sm.type = ? AND sale_doc.status = ? AND sm.reversed_by_id IS NULL", "OUTCOME", "posted").
+		Where("sm.document_date BETWEEN ? AND ?", from, to)
 
 	if warehouseID != nil {
 		query = query.Where("sm.warehouse_id = ?", *warehouseID)
 	}
-	err := query.Group("variants.id, variants.sku, products.name").Scan(&results).Error
-	return results, err
+	if err := query.Group("variants.id, variants.sku, products.name").Scan(&results).Error; err != nil {
+		return nil, err
+	}
+	return r.netReturns(results, from, to, warehouseID)
+}
+
+// getMovementCostProfitData считает себестоимость по цене, зафиксированной в самом движении расхода.
+func (r *repository) getMovementCostProfitData(from, to time.Time, warehouseID *uint) ([]ProfitRecord, error) {
+	var results []ProfitRecord
+	query := r.db.Table("stock_movements as sm").
+		Select(`
+			variants.id as variant_id, variants.sku, products.name as product_name,
+			COALESCE(SUM(ABS(sm.quantity)), 0) as quantity,
+			COALESCE(SUM(ABS(sm.quantity) * COALESCE(sale_item.price, 0) * COALESCE(NULLIF(sale_doc.exchange_rate, 0), 1)), 0) as revenue,
+			COALESCE(SUM(ABS(sm.quantity) * COALESCE(sm.unit_cost, 0)), 0) as cost
+		`).
+		Joins("JOIN variants ON variants.id = sm.item_id").
+		Joins("JOIN products ON products.id = variants.product_id").
+		Joins("LEFT JOIN document_items as sale_item ON sale_item.document_id = sm.document_id AND sale_item.item_id = sm.item_id").
+		Joins("JOIN documents as sale_doc ON sale_doc.id = sm.document_id").
+		Where("sm.type = ? AND sale_doc.status = ? AND sm.reversed_by_id IS NULL", "OUTCOME", "posted").
+		Where("sm.document_date BETWEEN ? AND ?", from, to)
+
+	if warehouseID != nil {
+		query = query.Where("sm.warehouse_id = ?", *warehouseID)
+	}
+	if err := query.Group("variants.id, variants.sku, products.name").Scan(&results).Error; err != nil {
+		return nil, err
+	}
+	return r.netReturns(results, from, to, warehouseID)
+}
+
+// netReturns вычитает из продаж проведённые возвраты покупателей за период: выручку - по ценам возврата,
+// себестоимость - по оценке, с которой товар вернулся на склад (себестоимость исходной продажи).
+func (r *repository) netReturns(sales []ProfitRecord, from, to time.Time, warehouseID *uint) ([]ProfitRecord, error) {
+	var returns []ProfitRecord
+	query := r.db.Table("stock_movements as sm").
+		Select(`
+			variants.id as variant_id, variants.sku, products.name as product_name,
+			COALESCE(SUM(sm.quantity), 0) as quantity,
+			COALESCE(SUM(sm.quantity * COALESCE(ret_item.price, 0) * COALESCE(NULLIF(ret_doc.exchange_rate, 0), 1)), 0) as revenue,
+			COALESCE(SUM(sm.quantity * COALESCE(sm.unit_cost, 0)), 0) as cost
+		`).
+		Joins("JOIN variants ON variants.id = sm.item_id").
+		Joins("JOIN products ON products.id = variants.product_id").
+		Joins("JOIN documents as ret_doc ON ret_doc.id = sm.document_id").
+		Joins("LEFT JOIN document_items as ret_item ON ret_item.document_id = sm.document_id AND ret_item.item_id = sm.item_id").
+		Where("sm.type = ? AND ret_doc.status = ? AND sm.reversed_by_id IS NULL", "RETURN_IN", "posted").
+		Where("sm.document_date BETWEEN ? AND ?", from, to)
+
+	if warehouseID != nil {
+		query = query.Where("sm.warehouse_id = ?", *warehouseID)
+	}
+	if err := query.Group("variants.id, variants.sku, products.name").Scan(&returns).Error; err != nil {
+		return nil, err
+	}
+
+	index := make(map[uint]int, len(sales))
+	for i, rec := range sales {
+		index[rec.VariantID] = i
+	}
+	for _, ret := range returns {
+		i, ok := index[ret.VariantID]
+		if !ok {
+			sales = append(sales, ProfitRecord{VariantID: ret.VariantID, SKU: ret.SKU, ProductName: ret.ProductName})
+			i = len(sales) - 1
+			index[ret.VariantID] = i
+		}
+		sales[i].Quantity = sales[i].Quantity.Sub(ret.Quantity)
+		sales[i].Revenue = sales[i].Revenue.Sub(ret.Revenue)
+		sales[i].Cost = sales[i].Cost.Sub(ret.Cost)
+	}
+	return sales, nil
 }
 
 func (r *repository) GetStockData(warehouseID *uint) ([]StockItem, error) {
+	if !isLotPolicy(r.policy) {
+		return r.getBalanceStockData(warehouseID)
+	}
+
 	var results []StockItem
 	query := r.db.Table("stock_lots").
 		Select(`
 			warehouses.name as warehouse_name, categories.name as category,
 			variants.sku, products.name as product_name, units.name as unit,
 			SUM(stock_lots.current_quantity) as quantity,
-			SUM(stock_lots.current_quantity * COALESCE(di.price, 0)) as total_value
+			SUM(stock_lots.current_quantity * COALESCE(stock_lots.unit_cost, di.price * COALESCE(NULLIF(income_doc.exchange_rate, 0), 1), 0)) as total_value
 		`).
 		Joins("JOIN warehouses ON warehouses.id = stock_lots.warehouse_id").
 		Joins("JOIN variants ON variants.id = stock_lots.variant_id").
@@ -71,6 +168,7 @@ func (r *repository) GetStockData(warehouseID *uint) ([]StockItem, error) {
 		Joins("JOIN categories ON categories.id = products.category_id").
 		Joins("JOIN units ON units.id = variants.unit_id").
 		Joins("LEFT JOIN document_items di ON di.document_id = stock_lots.income_document_id AND di.item_id = stock_lots.variant_id").
+		Joins("LEFT JOIN documents income_doc ON income_doc.id = stock_lots.income_document_id").
 		Where("stock_lots.current_quantity > 0")
 
 	if warehouseID != nil {
@@ -82,6 +180,32 @@ func (r *repository) GetStockData(warehouseID *uint) ([]StockItem, error) {
 	return results, err
 }
 
+// getBalanceStockData оценивает остатки по средней себестоимости, которую ведёт сам остаток.
+func (r *repository) getBalanceStockData(warehouseID *uint) ([]StockItem, error) {
+	var results []StockItem
+	query := r.db.Table("stock_balances as sb").
+		Select(`
+			warehouses.name as warehouse_name, categories.name as category,
+			variants.sku, products.name as product_name, units.name as unit,
+			SUM(sb.quantity) as quantity,
+			SUM(sb.quantity * COALESCE(sb.avg_cost, 0)) as total_value
+		`).
+		Joins("JOIN warehouses ON warehouses.id = sb.warehouse_id").
+		Joins("JOIN variants ON variants.id = sb.item_id").
+		Joins("JOIN products ON products.id = variants.product_id").
+		Joins("JOIN categories ON categories.id = products.category_id").
+		Joins("JOIN units ON units.id = variants.unit_id").
+		Where("sb.quantity > 0")
+
+	if warehouseID != nil {
+		query = query.Where("sb.warehouse_id = ?", *warehouseID)
+	}
+	err := query.Group("warehouses.name, categories.name, variants.sku, products.name, units.name").
+		Order("warehouses.name, categories.name, products.name").
+		Scan(&results).Error
+	return results, err
+}
+
 func (r *repository) GetMovementsData(from, to time.Time, warehouseID *uint) ([]MovementItem, error) {
 	var rows []struct {
 		Date      time.Time
@@ -96,7 +220,7 @@ func (r *repository) GetMovementsData(from, to time.Time, warehouseID *uint) ([]
 	}
 	query := r.db.Table("stock_movements").
 		Select(`
-			stock_movements.created_at as date, documents.type as doc_type, documents.number as doc_number,
+			stock_movements.document_date as date, documents.type as doc_type, documents.number as doc_number,
 			warehouses.name as wh_name, variants.sku as sku, products.name as prod_name,
 			units.name as unit_name, stock_movements.quantity as qty, stock_movements.type as type
 		`).
@@ -105,12 +229,12 @@ func (r *repository) GetMovementsData(from, to time.Time, warehouseID *uint) ([]
 		Joins("JOIN variants ON variants.id = stock_movements.item_id").
 		Joins("JOIN products ON products.id = variants.product_id").
 		Joins("JOIN units ON units.id = variants.unit_id").
-		Where("stock_movements.created_at BETWEEN ? AND ?", from, to)
+		Where("stock_movements.document_date BETWEEN ? AND ?", from, to)
 
 	if warehouseID != nil {
 		query = query.Where("stock_movements.warehouse_id = ?", *warehouseID)
 	}
-	if err := query.Order("stock_movements.created_at desc").Scan(&rows).Error; err != nil {
+	if err := query.Order("stock_movements.document_date desc, stock_movements.id desc").Scan(&rows).Error; err != nil {
 		return nil, err
 	}
 
@@ -120,7 +244,8 @@ func (r *repository) GetMovementsData(from, to time.Time, warehouseID *uint) ([]
 			Date: row.Date, DocumentType: row.DocType, DocumentNumber: row.DocNumber,
 			WarehouseName: row.WhName, SKU: row.Sku, ProductName: row.ProdName, Unit: row.UnitName,
 		}
-		if row.Type == "INCOME" || (row.Type == "TRANSFER" && row.Qty.IsPositive()) {
+		bySign := row.Type == "TRANSFER" || row.Type == "ASSEMBLY" || row.Type == "DISASSEMBLY"
+		if row.Type == "INCOME" || row.Type == "RETURN_IN" || (bySign && row.Qty.IsPositive()) {
 			item.QuantityIn = row.Qty.Abs()
 		} else {
 			item.QuantityOut = row.Qty.Abs()
@@ -136,12 +261,12 @@ func (r *repository) GetCustomerData(from, to time.Time) ([]CustomerReportItem,
 		Select(`
             COALESCE(counterparties.name, 'Розничный покупатель') as counterparty_name,
             COUNT(documents.id) as operations_count,
-            COALESCE(SUM(di.quantity * di.price), 0) as total_revenue
+            COALESCE(SUM(di.quantity * di.price * COALESCE(NULLIF(documents.exchange_rate, 0), 1)), 0) as total_revenue
         `).
 		Joins("LEFT JOIN counterparties ON counterparties.id = documents.counterparty_id").
 		Joins("JOIN document_items di ON di.document_id = documents.id").
 		Where("documents.type = ? AND documents.status = ?", "OUTCOME", "posted").
-		Where("documents.created_at BETWEEN ? AND ?", from, to).
+		Where("documents.document_date BETWEEN ? AND ?", from, to).
 		Group("COALESCE(counterparties.name, 'Розничный покупатель')").
 		Order("total_revenue DESC").
 		Scan(&results).Error
@@ -157,13 +282,14 @@ func (r *repository) GetSalesRanking(from, to time.Time, warehouseID *uint) ([]A
 			variants.sku, 
 			products.name as product_name,
 			COALESCE(SUM(ABS(sm.quantity)), 0) as quantity_sold,
-			COALESCE(SUM(ABS(sm.quantity) * COALESCE(sale_item.price, 0)), 0) as revenue
+			COALESCE(SUM(ABS(sm.quantity) * COALESCE(sale_item.price, 0) * COALESCE(NULLIF(sale_doc.exchange_rate, 0), 1)), 0) as revenue
 		`).
 		Joins("JOIN variants ON variants.id = sm.item_id").
 		Joins("JOIN products ON products.id = variants.product_id").
 		Joins("LEFT JOIN document_items as sale_item ON sale_item.document_id = sm.document_id AND sale_item.item_id = sm.item_id").
-		Where("sm.type = ?", "OUTCOME").
-		Where("sm.created_at BETWEEN ? AND ?", from, to)
+		Joins("JOIN documents as sale_doc ON sale_doc.id = sm.document_id").
+		Where("sm.type = ? AND sale_doc.status = ? AND sm.reversed_by_id IS NULL", "OUTCOME", "posted").
+		Where("sm.document_date BETWEEN ? AND ?", from, to)
 
 	if warehouseID != nil {
 		query = query.Where("sm.warehouse_id = ?", *warehouseID)
@@ -175,3 +301,33 @@ func (r *repository) GetSalesRanking(from, to time.Time, warehouseID *uint) ([]A
 
 	return results, err
 }
+
+// GetWriteOffData - проведённые списания за период по причинам и складам. Себестоимость берётся из партии,
+// из которой списано, либо из оценки, записанной в движении.
+func (r *repository) GetWriteOffData(from, to time.Time, warehouseID *uint) ([]WriteOffItem, error) {
+	var results []WriteOffItem
+	query := r.db.Table("stock_movements as sm").
+		Select(`
+			COALESCE(reasons.code, '') as reason_code, COALESCE(reasons.name, 'Без причины') as reason_name,
+			warehouses.name as warehouse_name, variants.sku, products.name as product_name, COALESCE(units.name, '') as unit,
+			COALESCE(SUM(ABS(sm.quantity)), 0) as quantity,
+			COALESCE(SUM(ABS(sm.quantity) * COALESCE(lot.unit_cost, sm.unit_cost, 0)), 0) as total_cost
+		`).
+		Joins("JOIN documents as wo_doc ON wo_doc.id = sm.document_id").
+		Joins("LEFT JOIN write_off_reasons as reasons ON reasons.id = wo_doc.write_off_reason_id").
+		Joins("JOIN warehouses ON warehouses.id = sm.warehouse_id").
+		Joins("JOIN variants ON variants.id = sm.item_id").
+		Joins("JOIN products ON products.id = variants.product_id").
+		Joins("LEFT JOIN units ON units.id = variants.unit_id").
+		Joins("LEFT JOIN stock_lots as lot ON lot.id = sm.source_lot_id").
+		Where("sm.type = ? AND wo_doc.status = ? AND sm.reversed_by_id IS NULL", "WRITE_OFF", "posted").
+		Where("sm.document_date BETWEEN ? AND ?", from, to)
+
+	if warehouseID != nil {
+		query = query.Where("sm.warehouse_id = ?", *warehouseID)
+	}
+	err := query.Group("reasons.code, reasons.name, warehouses.name, variants.sku, products.name, units.name").
+		Order("reason_name, warehouses.name, products.name").
+		Scan(&results).Error
+	return results, err
+}
@@ -10,12 +10,31 @@ import (
 type Config struct {
 	AccountingPolicy   string `yaml:"accounting_policy"`
 	AllowNegativeStock bool   `yaml:"allow_negative_stock"`
+	BaseCurrency       string `yaml:"base_currency"`
+	// ReservationSweepSeconds - как часто снимать истёкшие резервы заказов.
+	ReservationSweepSeconds int `yaml:"reservation_sweep_seconds"`
+	// IdempotencyRetentionHours - сколько хранятся ответы на запросы с Idempotency-Key.
+	IdempotencyRetentionHours int `yaml:"idempotency_retention_hours"`
+	// OrganizationName - организация в реквизитах печатных форм.
+	OrganizationName string `yaml:"organization_name"`
+	// PrintFormsDir - каталог своих макетов печатных форм, FontsDir - шрифты для PDF.
+	PrintFormsDir string `yaml:"print_forms_dir"`
+	FontsDir      string `yaml:"fonts_dir"`
+	// BarcodePrefix - префикс внутреннего диапазона EAN-13 для своих штрихкодов (200-299 - коды для внутреннего использования).
+	BarcodePrefix string `yaml:"barcode_ean_prefix"`
 }
 
 func LoadStockConfig(path string) (*Config, error) {
 	cfg := &Config{
-		AccountingPolicy:   "fifo",
-		AllowNegativeStock: false,
+		AccountingPolicy:          "fifo",
+		AllowNegativeStock:        false,
+		BaseCurrency:              "RUB",
+		ReservationSweepSeconds:   60,
+		IdempotencyRetentionHours: 24,
+		OrganizationName:          "Мой Склад (ООО)",
+		PrintFormsDir:             "./config/forms",
+		FontsDir:                  "assets/fonts/",
+		BarcodePrefix:             "200",
 	}
 
 	data, err := os.ReadFile(path)
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+type ApprovalHandler struct {
+	service service.ApprovalService
+}
+
+func NewApprovalHandler(s service.ApprovalService) *ApprovalHandler {
+	return &ApprovalHandler{service: s}
+}
+
+func (h *ApprovalHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/approval-rules")
+	{
+		grp.POST("", h.Create)
+		grp.GET("", h.List)
+		grp.PUT("/:id", h.Update)
+		grp.DELETE("/:id", h.Delete)
+	}
+}
+
+func (h *ApprovalHandler) Create(c *gin.Context) {
+	var rule models.ApprovalRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	created, err := h.service.CreateRule(&rule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *ApprovalHandler) List(c *gin.Context) {
+	rules, err := h.service.ListRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *ApprovalHandler) Update(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var rule models.ApprovalRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updated, err := h.service.UpdateRule(uint(id), &rule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *ApprovalHandler) Delete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if err := h.service.DeleteRule(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
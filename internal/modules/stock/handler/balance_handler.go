@@ -32,6 +32,9 @@ func (h *BalanceHandler) GetByWarehouse(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Warehouse ID"})
 		return
 	}
+	if !authorizeWarehouse(c, uint(warehouseID)) {
+		return
+	}
 
 	var filter models.StockFilter
 	if catIDStr := c.Query("category_id"); catIDStr != "" {
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+type BarcodeHandler struct {
+	service service.BarcodeService
+}
+
+func NewBarcodeHandler(s service.BarcodeService) *BarcodeHandler {
+	return &BarcodeHandler{service: s}
+}
+
+func (h *BarcodeHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/variants")
+	{
+		grp.GET("/by-barcode/:code", h.FindByBarcode) // поиск варианта по скану
+		grp.GET("/labels", h.Labels)                  // этикетки в PDF: ?variant_id=1&variant_id=2&price_type_id=&format=shelf|price&copies=&date=2006-01-02
+		grp.GET("/:id/barcodes", h.List)
+		grp.POST("/:id/barcodes", h.Add) // пустой code - следующий EAN-13 из внутреннего диапазона
+		grp.DELETE("/:id/barcodes/:barcodeId", h.Delete)
+	}
+}
+
+func (h *BarcodeHandler) List(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	codes, err := h.service.List(uint(id))
+	if err != nil {
+		respondBarcodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+func (h *BarcodeHandler) Add(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var dto models.BarcodeCreateDTO
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&dto); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	created, err := h.service.Add(uint(id), dto)
+	if err != nil {
+		respondBarcodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *BarcodeHandler) Delete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	barcodeID, err := strconv.ParseUint(c.Param("barcodeId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if err := h.service.Delete(uint(id), uint(barcodeID)); err != nil {
+		respondBarcodeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *BarcodeHandler) FindByBarcode(c *gin.Context) {
+	variant, err := h.service.FindVariant(c.Param("code"))
+	if err != nil {
+		respondBarcodeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, variant)
+}
+
+func (h *BarcodeHandler) Labels(c *gin.Context) {
+	var filter models.LabelFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	content, err := h.service.Labels(filter)
+	if err != nil {
+		respondBarcodeError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", "inline; filename=labels.pdf")
+	c.Data(http.StatusOK, "application/pdf", content)
+}
+
+func respondBarcodeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrVariantNotFound), errors.Is(err, service.ErrBarcodeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrBarcodeTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrBarcodeInvalid), errors.Is(err, service.ErrPriceTypeNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
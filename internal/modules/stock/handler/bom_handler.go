@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+type BOMHandler struct {
+	service service.BOMService
+}
+
+func NewBOMHandler(s service.BOMService) *BOMHandler {
+	return &BOMHandler{service: s}
+}
+
+func (h *BOMHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/variants")
+	{
+		grp.GET("/:id/bom", h.Get)
+		grp.PUT("/:id/bom", h.Set)
+	}
+}
+
+func (h *BOMHandler) Get(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	components, err := h.service.Get(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, components)
+}
+
+func (h *BOMHandler) Set(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var req models.BOMUpdateDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	components, err := h.service.Set(uint(id), req.Components)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, components)
+}
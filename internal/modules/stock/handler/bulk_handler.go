@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+	"github.com/maksroxx/flowkeeper/internal/modules/users"
+)
+
+type BulkHandler struct {
+	service service.BulkService
+}
+
+func NewBulkHandler(s service.BulkService) *BulkHandler {
+	return &BulkHandler{service: s}
+}
+
+func (h *BulkHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/documents/bulk")
+	{
+		grp.POST("", h.Start)      // провести или отменить пакет документов в фоне
+		grp.GET("/:id", h.GetByID) // ход выполнения и результаты по документам
+	}
+}
+
+// Start ставит задачу в очередь и отвечает 202 с её номером. Задачи живут в памяти процесса (см. BulkService):
+// после перезапуска сервера GET по номеру задачи вернёт 404, и состояние документов нужно проверять по ним самим.
+func (h *BulkHandler) Start(c *gin.Context) {
+	var payload models.BulkDocumentDTO
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.Filter != nil {
+		payload.Filter.WarehouseIDs = warehouseScope(c)
+	}
+	docs, err := h.service.Resolve(&payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(docs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No documents to process"})
+		return
+	}
+
+	perm := PermPostDocument
+	if payload.Action == "cancel" {
+		perm = PermCancelDocument
+	}
+	for _, doc := range docs {
+		if !authorizeDocument(c, perm, doc.Type, doc.WarehouseID) || !authorizeDestination(c, doc.Type, doc.ToWarehouseID) {
+			return
+		}
+	}
+
+	job := h.service.Start(payload.Action, payload.Mode, docs, currentUser(c))
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (h *BulkHandler) GetByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	job, err := h.service.GetJob(uint(id))
+	if err != nil || !jobVisible(c, job) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bulk job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// jobVisible - задачу видит только тот, кто её запустил, и администратор: в результатах номера документов
+// складов, которые другим пользователям могут быть недоступны.
+func jobVisible(c *gin.Context, job *models.BulkJobDTO) bool {
+	if users.IsAdmin(c) {
+		return true
+	}
+	userID := currentUser(c)
+	return job.CreatedBy != nil && userID != nil && *job.CreatedBy == *userID
+}
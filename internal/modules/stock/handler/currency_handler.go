@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+type CurrencyHandler struct {
+	service service.CurrencyService
+}
+
+func NewCurrencyHandler(s service.CurrencyService) *CurrencyHandler {
+	return &CurrencyHandler{service: s}
+}
+
+func (h *CurrencyHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/currency-rates")
+	{
+		grp.GET("", h.List)
+		grp.POST("", h.Set)
+		grp.POST("/import", h.Import) // CSV: валюта;дата;курс
+	}
+}
+
+func (h *CurrencyHandler) List(c *gin.Context) {
+	rates, err := h.service.ListRates(c.Query("currency"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"base_currency": h.service.BaseCurrency(), "rates": rates})
+}
+
+func (h *CurrencyHandler) Set(c *gin.Context) {
+	var req struct {
+		Currency string          `json:"currency" binding:"required"`
+		Date     string          `json:"date" binding:"required"`
+		Rate     decimal.Decimal `json:"rate"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	date, err := time.Parse("2006-01-02", req.Date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, use YYYY-MM-DD"})
+		return
+	}
+
+	rate := models.ExchangeRate{Currency: req.Currency, Date: date, Rate: req.Rate}
+	if err := h.service.SetRate(rate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (h *CurrencyHandler) Import(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File required"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot open file"})
+		return
+	}
+	defer file.Close()
+
+	count, err := h.service.ImportCSV(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Import failed: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "imported": count})
+}
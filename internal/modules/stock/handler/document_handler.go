@@ -1,34 +1,51 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+	"github.com/shopspring/decimal"
 )
 
 type DocumentHandler struct {
-	service service.DocumentService
+	service     service.DocumentService
+	idempotency service.IdempotencyService
 }
 
-func NewDocumentHandler(s service.DocumentService) *DocumentHandler {
-	return &DocumentHandler{service: s}
+func NewDocumentHandler(s service.DocumentService, idempotency service.IdempotencyService) *DocumentHandler {
+	return &DocumentHandler{service: s, idempotency: idempotency}
 }
 
 func (h *DocumentHandler) Register(r *gin.RouterGroup) {
 	grp := r.Group("/documents")
+	idempotent := Idempotent(h.idempotency)
 	{
-		grp.POST("", h.Create)
+		grp.POST("", idempotent, h.Create)
 		grp.GET("", h.List)
 		grp.GET("/:id", h.GetByID)
 		grp.PUT("/:id", h.Update)
 		grp.DELETE("/:id", h.Delete)
 
-		grp.POST("/:id/post", h.Post)     // провести документ
-		grp.POST("/:id/cancel", h.Cancel) // отменить
+		grp.POST("/:id/post", idempotent, h.Post)     // провести документ
+		grp.POST("/:id/cancel", idempotent, h.Cancel) // отменить
+		grp.POST("/:id/correct", h.Correct)           // исправить проведённый документ с перепроведением
+
+		grp.POST("/:id/ship", h.Ship)       // отгрузить перемещение (товар в пути)
+		grp.POST("/:id/receive", h.Receive) // принять перемещение на складе-получателе
+
+		grp.POST("/:id/submit", h.Submit)   // отправить на согласование
+		grp.POST("/:id/approve", h.Approve) // согласовать
+		grp.POST("/:id/reject", h.Reject)   // отклонить с указанием причины
+		grp.GET("/:id/history", h.History)
+
+		grp.POST("/:id/create-based", h.CreateBasedOn) // создать документ на основании
+		grp.GET("/:id/fulfillment", h.Fulfillment)     // выполнение заказа по строкам
 	}
 }
 
@@ -38,7 +55,11 @@ func (h *DocumentHandler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !authorizeDocument(c, PermCreateDocument, doc.Type, doc.WarehouseID) {
+		return
+	}
 
+	doc.CreatedBy = currentUser(c)
 	createdDoc, err := h.service.Create(&doc)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -83,6 +104,7 @@ func (h *DocumentHandler) List(c *gin.Context) {
 	if offset, err := strconv.Atoi(c.Query("offset")); err == nil {
 		filter.Offset = offset
 	}
+	filter.WarehouseIDs = warehouseScope(c)
 
 	docDTOs, err := h.service.SearchAsDTO(filter)
 	if err != nil {
@@ -94,12 +116,11 @@ func (h *DocumentHandler) List(c *gin.Context) {
 }
 
 func (h *DocumentHandler) GetByID(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+	stored, ok := h.viewStoredDocument(c)
+	if !ok {
 		return
 	}
-	doc, err := h.service.GetByIDAsDTO(uint(id))
+	doc, err := h.service.GetByIDAsDTO(stored.ID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
@@ -119,10 +140,15 @@ func (h *DocumentHandler) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	doc, ok := h.authorizeStoredDocument(c, uint(id), PermCreateDocument, false)
+	if !ok || !authorizeDocument(c, PermCreateDocument, doc.Type, updatePayload.WarehouseID) ||
+		!requireVersion(c, &updatePayload) {
+		return
+	}
 
 	updatedDoc, err := h.service.Update(uint(id), &updatePayload)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(documentErrorStatus(err), documentErrorBody(err))
 		return
 	}
 
@@ -135,8 +161,11 @@ func (h *DocumentHandler) Delete(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
+	if _, ok := h.authorizeStoredDocument(c, uint(id), PermCreateDocument, false); !ok {
+		return
+	}
 	if err := h.service.Delete(uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(documentErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.Status(http.StatusNoContent)
@@ -148,8 +177,16 @@ func (h *DocumentHandler) Post(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
-	if err := h.service.Post(uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	doc, ok := h.authorizeStoredDocument(c, uint(id), PermPostDocument, false)
+	if !ok || !authorizeDestination(c, doc.Type, doc.ToWarehouseID) {
+		return
+	}
+	version, ok := requiredVersion(c)
+	if !ok {
+		return
+	}
+	if err := h.service.Post(uint(id), currentUser(c), version); err != nil {
+		c.JSON(documentErrorStatus(err), documentErrorBody(err))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "Document posted successfully"})
@@ -161,9 +198,279 @@ func (h *DocumentHandler) Cancel(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
-	if err := h.service.Cancel(uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	doc, ok := h.authorizeStoredDocument(c, uint(id), PermCancelDocument, false)
+	if !ok || !authorizeDestination(c, doc.Type, doc.ToWarehouseID) {
+		return
+	}
+	if err := h.service.Cancel(uint(id), currentUser(c)); err != nil {
+		c.JSON(documentErrorStatus(err), documentErrorBody(err))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "Document canceled successfully"})
 }
+
+// Correct правит проведённый документ: нужны права и на отмену, и на проведение, в том числе на новом складе.
+func (h *DocumentHandler) Correct(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var payload models.DocumentUpdateDTO
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	doc, ok := h.authorizeStoredDocument(c, uint(id), PermCancelDocument, false)
+	if !ok || !authorizeDocument(c, PermPostDocument, doc.Type, doc.WarehouseID) ||
+		!authorizeDocument(c, PermPostDocument, doc.Type, payload.WarehouseID) ||
+		!authorizeDestination(c, doc.Type, doc.ToWarehouseID) || !authorizeDestination(c, doc.Type, payload.ToWarehouseID) ||
+		!requireVersion(c, &payload) {
+		return
+	}
+
+	corrected, err := h.service.Correct(uint(id), &payload, currentUser(c))
+	if err != nil {
+		c.JSON(documentErrorStatus(err), documentErrorBody(err))
+		return
+	}
+	c.JSON(http.StatusOK, corrected)
+}
+
+func (h *DocumentHandler) Ship(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if _, ok := h.authorizeStoredDocument(c, uint(id), PermPostDocument, false); !ok {
+		return
+	}
+	if err := h.service.Ship(uint(id), currentUser(c)); err != nil {
+		c.JSON(documentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Document shipped successfully"})
+}
+
+func (h *DocumentHandler) Receive(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if _, ok := h.authorizeStoredDocument(c, uint(id), PermPostDocument, true); !ok {
+		return
+	}
+
+	var payload models.TransferReceiptDTO
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var received map[uint]decimal.Decimal
+	if len(payload.Items) > 0 {
+		received = make(map[uint]decimal.Decimal, len(payload.Items))
+		for _, it := range payload.Items {
+			received[it.VariantID] = received[it.VariantID].Add(it.Quantity)
+		}
+	}
+
+	if err := h.service.Receive(uint(id), received, currentUser(c)); err != nil {
+		c.JSON(documentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Document received successfully"})
+}
+
+func (h *DocumentHandler) Submit(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if _, ok := h.authorizeStoredDocument(c, uint(id), PermCreateDocument, false); !ok {
+		return
+	}
+	if err := h.service.Submit(uint(id), currentUser(c)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Document submitted for approval"})
+}
+
+func (h *DocumentHandler) Approve(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	doc, ok := h.authorizeStoredDocument(c, uint(id), PermApproveDocument, false)
+	if !ok {
+		return
+	}
+	// правило согласования может требовать от согласующего отдельного права
+	perms, err := h.service.ApproverPermissions(doc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, perm := range perms {
+		if !authorizeDocument(c, perm, doc.Type, nil) {
+			return
+		}
+	}
+	if err := h.service.Approve(uint(id), currentUser(c)); err != nil {
+		if errors.Is(err, service.ErrSelfApproval) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Document approved"})
+}
+
+func (h *DocumentHandler) Reject(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var payload models.DocumentRejectDTO
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := h.authorizeStoredDocument(c, uint(id), PermApproveDocument, false); !ok {
+		return
+	}
+	if err := h.service.Reject(uint(id), currentUser(c), payload.Reason); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Document rejected"})
+}
+
+func (h *DocumentHandler) History(c *gin.Context) {
+	doc, ok := h.viewStoredDocument(c)
+	if !ok {
+		return
+	}
+	history, err := h.service.GetHistory(doc.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+func (h *DocumentHandler) CreateBasedOn(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var payload models.CreateBasedOnDTO
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	base, ok := h.authorizeStoredDocument(c, uint(id), PermViewStock, false)
+	if !ok || !authorizeDocument(c, PermCreateDocument, payload.Type, base.WarehouseID) {
+		return
+	}
+
+	doc, err := h.service.CreateBasedOn(uint(id), payload.Type, currentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, doc)
+}
+
+func (h *DocumentHandler) Fulfillment(c *gin.Context) {
+	doc, ok := h.viewStoredDocument(c)
+	if !ok {
+		return
+	}
+	dto, err := h.service.GetFulfillment(doc.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, dto)
+}
+
+// documentErrorStatus - 409 для ошибок состояния документа (нужно согласование, период закрыт,
+// цены уже изменены более поздними документами), иначе 500.
+func documentErrorStatus(err error) int {
+	var stale *service.VersionConflictError
+	var prices *service.PriceConflictError
+	if errors.Is(err, service.ErrApprovalRequired) || errors.Is(err, service.ErrPeriodClosed) ||
+		errors.As(err, &stale) || errors.As(err, &prices) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// documentErrorBody - тело ответа с ошибкой; при конфликте версий в нём и текущая версия документа,
+// при конфликте цен - мешающие документы.
+func documentErrorBody(err error) gin.H {
+	body := gin.H{"error": err.Error()}
+	var stale *service.VersionConflictError
+	if errors.As(err, &stale) {
+		body["current_version"] = stale.Current
+	}
+	var prices *service.PriceConflictError
+	if errors.As(err, &prices) {
+		body["conflicts"] = prices.Conflicts
+	}
+	return body
+}
+
+// requireVersion берёт версию правки из тела или заголовка If-Match. Правка без версии затёрла бы изменения,
+// сделанные после того, как клиент прочитал документ, поэтому без версии отвечает 428.
+func requireVersion(c *gin.Context, payload *models.DocumentUpdateDTO) bool {
+	if payload.Version != nil {
+		return true
+	}
+	version, ok := requiredVersion(c)
+	payload.Version = version
+	return ok
+}
+
+// requiredVersion - версия из If-Match, без которой проведение и правка не выполняются: иначе клиент
+// провёл бы черновик, изменённый после того, как он его прочитал. Без заголовка отвечает 428.
+func requiredVersion(c *gin.Context) (*uint, bool) {
+	version, ok := expectedVersion(c)
+	if !ok {
+		return nil, false
+	}
+	if version == nil {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "Document version is required: send If-Match or version"})
+		return nil, false
+	}
+	return version, true
+}
+
+// expectedVersion читает из заголовка If-Match версию документа, с которой работает клиент.
+// Без заголовка возвращает nil; на неверное значение сам отвечает 400.
+func expectedVersion(c *gin.Context) (*uint, bool) {
+	raw := strings.Trim(strings.TrimPrefix(c.GetHeader("If-Match"), "W/"), `"`)
+	if raw == "" {
+		return nil, true
+	}
+	v, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match version"})
+		return nil, false
+	}
+	version := uint(v)
+	return &version, true
+}
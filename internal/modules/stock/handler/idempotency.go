@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// responseRecorder дублирует тело ответа, чтобы его можно было сохранить для повторов.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotent отвечает на повтор запроса с тем же заголовком Idempotency-Key сохранённым ответом,
+// не выполняя запрос второй раз. Запросы без заголовка проходят как обычно.
+func Idempotent(svc service.IdempotencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.Path+"\n"), body...))
+
+		var userID uint
+		if id := currentUser(c); id != nil {
+			userID = *id
+		}
+		rec, replay, err := svc.Begin(userID, key, hex.EncodeToString(sum[:]))
+		switch {
+		case errors.Is(err, service.ErrIdempotencyMismatch):
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, service.ErrIdempotencyInProgress):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if replay {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(rec.StatusCode, "application/json; charset=utf-8", rec.Response)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// обработчик упал с паникой: ключ освобождается, сама паника уходит дальше в recovery
+			if err := svc.Abort(rec); err != nil {
+				log.Printf("[ERROR] Failed to release idempotency key %q: %v", key, err)
+			}
+		}()
+		c.Next()
+
+		// после ошибки сервера повтор с тем же ключом должен выполниться заново
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			err = svc.Abort(rec)
+		} else {
+			err = svc.Complete(rec, c.Writer.Status(), recorder.body.Bytes())
+		}
+		completed = true
+		if err != nil {
+			log.Printf("[ERROR] Failed to store idempotent response for key %q: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+type LotHandler struct {
+	service service.LotService
+}
+
+func NewLotHandler(s service.LotService) *LotHandler {
+	return &LotHandler{service: s}
+}
+
+func (h *LotHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/lots")
+	{
+		grp.GET("", h.Search)
+		grp.GET("/:id/trace", h.Trace) // движения по партии для отзыва товара
+	}
+}
+
+func (h *LotHandler) Search(c *gin.Context) {
+	var filter models.LotFilter
+
+	if whIDStr := c.Query("warehouse_id"); whIDStr != "" {
+		id, err := strconv.ParseUint(whIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse_id"})
+			return
+		}
+		idUint := uint(id)
+		filter.WarehouseID = &idUint
+	}
+	if variantIDStr := c.Query("variant_id"); variantIDStr != "" {
+		id, err := strconv.ParseUint(variantIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant_id"})
+			return
+		}
+		idUint := uint(id)
+		filter.VariantID = &idUint
+	}
+	if batch := c.Query("batch_number"); batch != "" {
+		filter.BatchNumber = &batch
+	}
+	if daysStr := c.Query("expires_within_days"); daysStr != "" {
+		days, err := strconv.Atoi(daysStr)
+		if err != nil || days < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expires_within_days"})
+			return
+		}
+		filter.ExpiresWithinDays = &days
+	}
+	filter.IncludeEmpty = c.Query("include_empty") == "true"
+	filter.WarehouseIDs = warehouseScope(c)
+
+	if limit, err := strconv.Atoi(c.DefaultQuery("limit", "100")); err == nil {
+		filter.Limit = limit
+	}
+	if offset, err := strconv.Atoi(c.Query("offset")); err == nil {
+		filter.Offset = offset
+	}
+
+	lots, err := h.service.SearchAsDTO(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, lots)
+}
+
+func (h *LotHandler) Trace(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	trace, err := h.service.TraceAsDTO(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorizeWarehouse(c, trace.Lot.WarehouseID) {
+		return
+	}
+	c.JSON(http.StatusOK, trace)
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+	"github.com/maksroxx/flowkeeper/internal/modules/users"
+)
+
+type PeriodHandler struct {
+	service service.PeriodService
+}
+
+func NewPeriodHandler(s service.PeriodService) *PeriodHandler {
+	return &PeriodHandler{service: s}
+}
+
+func (h *PeriodHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/periods")
+	{
+		grp.GET("", h.List)
+		grp.GET("/:id", h.GetByID)
+		grp.POST("", h.Close)
+		grp.POST("/:id/reopen", h.Reopen)
+	}
+}
+
+func (h *PeriodHandler) List(c *gin.Context) {
+	var warehouseID *uint
+	if whIDStr := c.Query("warehouse_id"); whIDStr != "" {
+		id, err := strconv.ParseUint(whIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse_id"})
+			return
+		}
+		idUint := uint(id)
+		warehouseID = &idUint
+	}
+	periods, err := h.service.List(warehouseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, periods)
+}
+
+func (h *PeriodHandler) GetByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	period, err := h.service.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Period close not found"})
+		return
+	}
+	c.JSON(http.StatusOK, period)
+}
+
+func (h *PeriodHandler) Close(c *gin.Context) {
+	var payload models.PeriodCloseDTO
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.WarehouseID != nil && !users.WarehouseAllowed(c, *payload.WarehouseID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to warehouse"})
+		return
+	}
+	period, err := h.service.Close(&payload, currentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, period)
+}
+
+func (h *PeriodHandler) Reopen(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var payload models.PeriodReopenDTO
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	period, err := h.service.Reopen(uint(id), payload.Reason, currentUser(c), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, period)
+}
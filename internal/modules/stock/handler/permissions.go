@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/users"
+)
+
+// Права на маршруты склада. Права на документы можно ограничить типом документа ("post_document:INCOME"),
+// а пользователя - складами ("warehouse:1").
+const (
+	PermViewStock         = "view_stock"
+	PermCreateDocument    = "create_document" // создание и правка черновиков
+	PermPostDocument      = "post_document"   // проведение, отгрузка и приёмка перемещений, исправление проведённых
+	PermCancelDocument    = "cancel_document"
+	PermApproveDocument   = "approve_document"   // согласование и отклонение документов
+	PermManageDirectories = "manage_directories" // товары, склады, контрагенты и прочие справочники
+	PermClosePeriod       = "close_period"
+	PermReopenPeriod      = "reopen_period" // отдельное право: переоткрытие меняет уже сданную отчётность
+)
+
+// RequireStockPermissions проверяет право на маршрут по методу и пути. basePath - префикс группы маршрутов склада.
+func RequireStockPermissions(basePath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		perm := routePermission(c.Request.Method, strings.TrimPrefix(c.FullPath(), basePath))
+		users.RequirePermission(perm)(c)
+	}
+}
+
+func routePermission(method, route string) string {
+	if method == http.MethodGet {
+		return PermViewStock
+	}
+	if strings.HasPrefix(route, "/periods") {
+		if strings.HasSuffix(route, "/reopen") {
+			return PermReopenPeriod
+		}
+		return PermClosePeriod
+	}
+	if strings.HasPrefix(route, "/scan-sessions") {
+		// сканирование набирает черновик: право на создание документа проверяется по типу и складу сеанса
+		return PermViewStock
+	}
+	if !strings.HasPrefix(route, "/documents") {
+		return PermManageDirectories
+	}
+	switch {
+	case route == "/documents/bulk":
+		// право на проведение или отмену проверяется по каждому документу пакета
+		return PermViewStock
+	case strings.HasSuffix(route, "/post"), strings.HasSuffix(route, "/ship"), strings.HasSuffix(route, "/receive"),
+		strings.HasSuffix(route, "/correct"):
+		return PermPostDocument
+	case strings.HasSuffix(route, "/cancel"):
+		return PermCancelDocument
+	case strings.HasSuffix(route, "/approve"), strings.HasSuffix(route, "/reject"):
+		return PermApproveDocument
+	default:
+		return PermCreateDocument
+	}
+}
+
+// authorizeDocument проверяет право perm на документ данного типа и доступ к складу.
+// При отказе сам отвечает 403 и возвращает false.
+func authorizeDocument(c *gin.Context, perm, docType string, warehouseID *uint) bool {
+	docType = strings.ToUpper(docType)
+	if !users.HasPermission(c, perm, docType) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Missing permission: %s:%s", perm, docType)})
+		return false
+	}
+	return warehouseID == nil || authorizeWarehouse(c, *warehouseID)
+}
+
+// authorizeDestination проверяет доступ к складу-получателю перемещения: проведение и отмена TRANSFER
+// меняют остаток и на нём. При отказе сам отвечает 403.
+func authorizeDestination(c *gin.Context, docType string, toWarehouseID *uint) bool {
+	if !strings.EqualFold(docType, "TRANSFER") || toWarehouseID == nil {
+		return true
+	}
+	return authorizeWarehouse(c, *toWarehouseID)
+}
+
+// authorizeWarehouse проверяет доступ к складу. При отказе сам отвечает 403.
+func authorizeWarehouse(c *gin.Context, warehouseID uint) bool {
+	if !users.WarehouseAllowed(c, warehouseID) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Access denied to warehouse %d", warehouseID)})
+		return false
+	}
+	return true
+}
+
+// warehouseScope - склады, которыми ограничен пользователь, для фильтров списков; nil - без ограничения.
+func warehouseScope(c *gin.Context) []uint {
+	if allowed, restricted := users.AllowedWarehouses(c); restricted {
+		return allowed
+	}
+	return nil
+}
+
+// authorizeDocumentRead проверяет, что документ виден пользователю: доступен его склад или склад-получатель
+// перемещения. Документы без склада видны всем. При отказе сам отвечает 403.
+func authorizeDocumentRead(c *gin.Context, warehouseID, toWarehouseID *uint) bool {
+	if warehouseID == nil || users.WarehouseAllowed(c, *warehouseID) {
+		return true
+	}
+	if toWarehouseID != nil && users.WarehouseAllowed(c, *toWarehouseID) {
+		return true
+	}
+	return authorizeWarehouse(c, *warehouseID)
+}
+
+// authorizeStoredDocument загружает документ и проверяет право на него. Для приёмки перемещения
+// проверяется склад-получатель, для остальных действий - склад документа.
+func (h *DocumentHandler) authorizeStoredDocument(c *gin.Context, id uint, perm string, destination bool) (*models.Document, bool) {
+	doc, err := h.service.GetByID(id)
+	if err != nil || doc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return nil, false
+	}
+	warehouseID := doc.WarehouseID
+	if destination {
+		warehouseID = doc.ToWarehouseID
+	}
+	return doc, authorizeDocument(c, perm, doc.Type, warehouseID)
+}
+
+// viewStoredDocument загружает документ и проверяет, что он виден пользователю.
+func (h *DocumentHandler) viewStoredDocument(c *gin.Context) (*models.Document, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, false
+	}
+	doc, err := h.service.GetByID(uint(id))
+	if err != nil || doc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return nil, false
+	}
+	return doc, authorizeDocumentRead(c, doc.WarehouseID, doc.ToWarehouseID)
+}
+
+// currentUser - пользователь из токена для записи в историю документа.
+func currentUser(c *gin.Context) *uint {
+	id, ok := users.CurrentUserID(c)
+	if !ok {
+		return nil
+	}
+	return &id
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
@@ -19,27 +20,28 @@ func NewPriceHandler(s service.PriceService) *PriceHandler {
 func (h *PriceHandler) Register(r *gin.RouterGroup) {
 	grp := r.Group("/prices")
 	{
-		grp.GET("", h.GetItemPrice)
+		grp.GET("", h.GetItemPrice)            // ?date= - цена на дату, по умолчанию текущая
+		grp.GET("/history", h.GetPriceHistory) // все изменения цены, включая запланированные
 	}
 }
 
 func (h *PriceHandler) GetItemPrice(c *gin.Context) {
-	itemIDStr := c.Query("item_id")
-	priceTypeIDStr := c.Query("price_type_id")
-
-	itemID, err := strconv.ParseUint(itemIDStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item_id parameter"})
+	itemID, priceTypeID, ok := parsePriceKey(c)
+	if !ok {
 		return
 	}
 
-	priceTypeID, err := strconv.ParseUint(priceTypeIDStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price_type_id parameter"})
-		return
+	at := time.Now()
+	if dateStr := c.Query("date"); dateStr != "" {
+		parsed, err := parsePriceDate(dateStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date parameter, use YYYY-MM-DD or RFC3339"})
+			return
+		}
+		at = parsed
 	}
 
-	price, err := h.service.GetPrice(uint(itemID), uint(priceTypeID))
+	price, err := h.service.GetPriceAt(itemID, priceTypeID, at)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -52,3 +54,40 @@ func (h *PriceHandler) GetItemPrice(c *gin.Context) {
 
 	c.JSON(http.StatusOK, price)
 }
+
+func (h *PriceHandler) GetPriceHistory(c *gin.Context) {
+	itemID, priceTypeID, ok := parsePriceKey(c)
+	if !ok {
+		return
+	}
+
+	history, err := h.service.GetHistory(itemID, priceTypeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+func parsePriceKey(c *gin.Context) (uint, uint, bool) {
+	itemID, err := strconv.ParseUint(c.Query("item_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item_id parameter"})
+		return 0, 0, false
+	}
+
+	priceTypeID, err := strconv.ParseUint(c.Query("price_type_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price_type_id parameter"})
+		return 0, 0, false
+	}
+	return uint(itemID), uint(priceTypeID), true
+}
+
+// parsePriceDate принимает дату без времени (начало дня по местному времени) или RFC3339.
+func parsePriceDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
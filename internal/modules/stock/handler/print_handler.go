@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+type PrintHandler struct {
+	service service.PrintService
+	docs    service.DocumentService
+}
+
+func NewPrintHandler(s service.PrintService, docs service.DocumentService) *PrintHandler {
+	return &PrintHandler{service: s, docs: docs}
+}
+
+func (h *PrintHandler) Register(r *gin.RouterGroup) {
+	r.GET("/documents/:id/print", h.Print) // печатная форма документа в PDF, ?form= - имя макета
+}
+
+func (h *PrintHandler) Print(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if doc, err := h.docs.GetByID(uint(id)); err == nil && doc != nil &&
+		!authorizeDocumentRead(c, doc.WarehouseID, doc.ToWarehouseID) {
+		return
+	}
+
+	content, filename, err := h.service.Print(uint(id), c.Query("form"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrDocumentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		case errors.Is(err, service.ErrPrintFormNotFound), errors.Is(err, service.ErrPrintFormNotApplicable):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", "inline; filename*=UTF-8''"+url.PathEscape(filename))
+	c.Data(http.StatusOK, "application/pdf", content)
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+	"github.com/maksroxx/flowkeeper/internal/modules/users"
+)
+
+type ScanHandler struct {
+	service service.ScanService
+}
+
+func NewScanHandler(s service.ScanService) *ScanHandler {
+	return &ScanHandler{service: s}
+}
+
+func (h *ScanHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/scan-sessions")
+	{
+		grp.POST("", h.Open)
+		grp.GET("", h.List) // ?status=open&warehouse_id= - незакрытые сеансы для продолжения
+		grp.GET("/:id", h.Get)
+		grp.POST("/:id/scans", h.Scan)
+		grp.POST("/:id/close", h.Close)
+		grp.DELETE("/:id", h.Cancel) // отказ от сеанса вместе с черновиком
+	}
+}
+
+func (h *ScanHandler) Open(c *gin.Context) {
+	var dto models.ScanSessionCreateDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorizeDocument(c, PermCreateDocument, dto.DocumentType, &dto.WarehouseID) {
+		return
+	}
+	session, err := h.service.Open(dto, currentUser(c))
+	if err != nil {
+		respondScanError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, session)
+}
+
+func (h *ScanHandler) List(c *gin.Context) {
+	var warehouseID *uint
+	if raw := c.Query("warehouse_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse_id"})
+			return
+		}
+		whID := uint(id)
+		warehouseID = &whID
+	}
+	sessions, err := h.service.List(c.Query("status"), warehouseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	visible := make([]models.ScanSession, 0, len(sessions))
+	for _, session := range sessions {
+		if users.WarehouseAllowed(c, session.WarehouseID) {
+			visible = append(visible, session)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// Get показывает сеанс: для просмотра достаточно view_stock и доступа к складу сеанса.
+func (h *ScanHandler) Get(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	session, err := h.service.Get(uint(id))
+	if err != nil {
+		respondScanError(c, err)
+		return
+	}
+	if !authorizeWarehouse(c, session.WarehouseID) {
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+func (h *ScanHandler) Scan(c *gin.Context) {
+	id, ok := h.authorizeSession(c)
+	if !ok {
+		return
+	}
+	var dto models.ScanDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := h.service.Scan(id, dto)
+	if err != nil {
+		respondScanError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *ScanHandler) Close(c *gin.Context) {
+	id, ok := h.authorizeSession(c)
+	if !ok {
+		return
+	}
+	doc, err := h.service.Close(id)
+	if err != nil {
+		respondScanError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, doc)
+}
+
+func (h *ScanHandler) Cancel(c *gin.Context) {
+	id, ok := h.authorizeSession(c)
+	if !ok {
+		return
+	}
+	if err := h.service.Cancel(id); err != nil {
+		respondScanError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// authorizeSession загружает сеанс и проверяет право на создание документа его типа на его складе.
+func (h *ScanHandler) authorizeSession(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return 0, false
+	}
+	session, err := h.service.GetSession(uint(id))
+	if err != nil {
+		respondScanError(c, err)
+		return 0, false
+	}
+	return session.ID, authorizeDocument(c, PermCreateDocument, session.DocumentType, &session.WarehouseID)
+}
+
+func respondScanError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrScanSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrScanSessionClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrScanInvalid), errors.Is(err, service.ErrScanSessionEmpty):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+type SerialHandler struct {
+	service service.SerialService
+}
+
+func NewSerialHandler(s service.SerialService) *SerialHandler {
+	return &SerialHandler{service: s}
+}
+
+func (h *SerialHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/serials")
+	{
+		grp.GET("/:serial", h.GetHistory) // текущее место и история движений единицы
+	}
+}
+
+func (h *SerialHandler) GetHistory(c *gin.Context) {
+	var variantID *uint
+	if variantIDStr := c.Query("variant_id"); variantIDStr != "" {
+		id, err := strconv.ParseUint(variantIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant_id"})
+			return
+		}
+		idUint := uint(id)
+		variantID = &idUint
+	}
+
+	history, err := h.service.GetHistory(c.Param("serial"), variantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSerialNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrSerialAmbiguous):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
@@ -46,6 +46,9 @@ func (h *MovementHandler) GetByID(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Movement not found"})
 		return
 	}
+	if !authorizeWarehouse(c, movement.WarehouseID) {
+		return
+	}
 	c.JSON(http.StatusOK, movement)
 }
 
@@ -59,12 +62,19 @@ func (h *MovementHandler) Search(c *gin.Context) {
 		}
 	}
 
+	if lotIDStr := c.Query("lot_id"); lotIDStr != "" {
+		if id, err := strconv.ParseUint(lotIDStr, 10, 64); err == nil {
+			filter.SourceLotIDs = []uint{uint(id)}
+		}
+	}
+
 	if limit, err := strconv.Atoi(c.DefaultQuery("limit", "20")); err == nil {
 		filter.Limit = limit
 	}
 	if offset, err := strconv.Atoi(c.DefaultQuery("offset", "0")); err == nil {
 		filter.Offset = offset
 	}
+	filter.WarehouseIDs = warehouseScope(c)
 
 	movementDTOs, err := h.service.SearchAsDTO(filter)
 	if err != nil {
@@ -28,16 +28,26 @@ func (h *UnitHandler) Register(r *gin.RouterGroup) {
 	}
 }
 
+// unitRequest - точность необязательна: при создании подставляется значение по умолчанию, при правке остаётся прежней.
+type unitRequest struct {
+	Name      string `json:"name"`
+	Precision *int   `json:"precision"`
+}
+
 func (h *UnitHandler) Create(c *gin.Context) {
-	var unit models.Unit
-	if err := c.ShouldBindJSON(&unit); err != nil {
+	var req unitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	createdUnit, err := h.service.Create(unit.Name)
+	unit := &models.Unit{Name: req.Name, Precision: service.DefaultUnitPrecision}
+	if req.Precision != nil {
+		unit.Precision = *req.Precision
+	}
+	createdUnit, err := h.service.Create(unit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusCreated, createdUnit)
@@ -73,16 +83,24 @@ func (h *UnitHandler) Update(c *gin.Context) {
 		return
 	}
 
-	var unit models.Unit
-	if err := c.ShouldBindJSON(&unit); err != nil {
+	var req unitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	unit.ID = uint(id)
+	unit, err := h.service.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unit not found"})
+		return
+	}
+	unit.Name = req.Name
+	if req.Precision != nil {
+		unit.Precision = *req.Precision
+	}
 
-	updatedUnit, err := h.service.Update(&unit)
+	updatedUnit, err := h.service.Update(unit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, updatedUnit)
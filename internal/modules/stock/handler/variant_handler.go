@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+	"github.com/maksroxx/flowkeeper/internal/modules/users"
 )
 
 type VariantHandler struct {
@@ -36,6 +37,7 @@ func (h *VariantHandler) Create(c *gin.Context) {
 		SKU             string                    `json:"sku"`
 		UnitID          uint                      `json:"unit_id"`
 		Characteristics models.CharacteristicsMap `json:"characteristics"`
+		TrackSerials    bool                      `json:"track_serials"`
 		Images          []string                  `json:"images"`
 	}
 
@@ -49,6 +51,7 @@ func (h *VariantHandler) Create(c *gin.Context) {
 		SKU:             req.SKU,
 		UnitID:          req.UnitID,
 		Characteristics: req.Characteristics,
+		TrackSerials:    req.TrackSerials,
 	}
 
 	created, err := h.service.Create(v, req.Images)
@@ -135,6 +138,9 @@ func (h *VariantHandler) Search(c *gin.Context) {
 			filter.WarehouseID = &whIDUint
 		}
 	}
+	if filter.WarehouseID != nil && !authorizeWarehouse(c, *filter.WarehouseID) {
+		return
+	}
 
 	if limit, err := strconv.Atoi(c.DefaultQuery("limit", "50")); err == nil {
 		filter.Limit = limit
@@ -164,6 +170,13 @@ func (h *VariantHandler) GetStock(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	visible := stockLevels[:0]
+	for _, level := range stockLevels {
+		if users.WarehouseAllowed(c, level.WarehouseID) {
+			visible = append(visible, level)
+		}
+	}
+	stockLevels = visible
 
 	c.JSON(http.StatusOK, stockLevels)
 }
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+// VariantUnitHandler - дополнительные единицы варианта (коробки, паллеты) с коэффициентами пересчёта.
+type VariantUnitHandler struct {
+	service service.UnitService
+}
+
+func NewVariantUnitHandler(s service.UnitService) *VariantUnitHandler {
+	return &VariantUnitHandler{service: s}
+}
+
+func (h *VariantUnitHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/variants")
+	{
+		grp.GET("/:id/units", h.List)
+		grp.PUT("/:id/units", h.Set)
+	}
+}
+
+func (h *VariantUnitHandler) List(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	units, err := h.service.ListVariantUnits(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, units)
+}
+
+func (h *VariantUnitHandler) Set(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var req models.VariantUnitsUpdateDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	units, err := h.service.SetVariantUnits(uint(id), req.Units)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, units)
+}
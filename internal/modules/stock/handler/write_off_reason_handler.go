@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+type WriteOffReasonHandler struct {
+	service service.WriteOffReasonService
+}
+
+func NewWriteOffReasonHandler(s service.WriteOffReasonService) *WriteOffReasonHandler {
+	return &WriteOffReasonHandler{service: s}
+}
+
+func (h *WriteOffReasonHandler) Register(r *gin.RouterGroup) {
+	grp := r.Group("/write-off-reasons")
+	{
+		grp.POST("", h.Create)
+		grp.GET("", h.List)
+		grp.PUT("/:id", h.Update)
+		grp.DELETE("/:id", h.Delete)
+	}
+}
+
+func (h *WriteOffReasonHandler) Create(c *gin.Context) {
+	var reason models.WriteOffReason
+	if err := c.ShouldBindJSON(&reason); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	created, err := h.service.Create(&reason)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *WriteOffReasonHandler) List(c *gin.Context) {
+	reasons, err := h.service.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reasons)
+}
+
+func (h *WriteOffReasonHandler) Update(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	var reason models.WriteOffReason
+	if err := c.ShouldBindJSON(&reason); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reason.ID = uint(id)
+
+	updated, err := h.service.Update(&reason)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *WriteOffReasonHandler) Delete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+	if err := h.service.Delete(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -19,7 +19,9 @@ type VariantDTO struct {
 	Characteristics CharacteristicsMap `json:"characteristics"`
 	UnitID          uint               `json:"unit_id"`
 	UnitName        string             `json:"unit_name"`
+	TrackSerials    bool               `json:"track_serials"`
 	Images          []ProductImageDTO  `json:"images"`
+	Barcodes        []Barcode          `json:"barcodes"`
 }
 
 type VariantListItemDTO struct {
@@ -38,53 +40,87 @@ type VariantListItemDTO struct {
 }
 
 type DocumentDTO struct {
-	ID               uint              `json:"id"`
-	Type             string            `json:"type"`
-	Number           string            `json:"number"`
-	WarehouseID      *uint             `json:"warehouse_id,omitempty"`
-	WarehouseName    string            `json:"warehouse_name,omitempty"`
-	ToWarehouseID    *uint             `json:"to_warehouse_id,omitempty"`
-	ToWarehouseName  string            `json:"to_warehouse_name,omitempty"`
-	CounterpartyID   *uint             `json:"counterparty_id,omitempty"`
-	CounterpartyName string            `json:"counterparty_name,omitempty"`
-	PriceTypeID      *uint             `json:"price_type_id,omitempty"`
-	PriceTypeName    string            `json:"price_type_name,omitempty"`
-	Comment          string            `json:"comment"`
-	BaseDocumentID   *uint             `json:"base_document_id,omitempty"`
-	Items            []DocumentItemDTO `json:"items"`
-	Status           string            `json:"status"`
-	PostedAt         *time.Time        `json:"posted_at,omitempty"`
-	CreatedAt        time.Time         `json:"created_at"`
+	ID                 uint              `json:"id"`
+	Type               string            `json:"type"`
+	Number             string            `json:"number"`
+	WarehouseID        *uint             `json:"warehouse_id,omitempty"`
+	WarehouseName      string            `json:"warehouse_name,omitempty"`
+	ToWarehouseID      *uint             `json:"to_warehouse_id,omitempty"`
+	ToWarehouseName    string            `json:"to_warehouse_name,omitempty"`
+	CounterpartyID     *uint             `json:"counterparty_id,omitempty"`
+	CounterpartyName   string            `json:"counterparty_name,omitempty"`
+	PriceTypeID        *uint             `json:"price_type_id,omitempty"`
+	PriceTypeName      string            `json:"price_type_name,omitempty"`
+	EffectiveFrom      *time.Time        `json:"effective_from,omitempty"`
+	EffectiveTo        *time.Time        `json:"effective_to,omitempty"`
+	Currency           string            `json:"currency"`
+	ExchangeRate       decimal.Decimal   `json:"exchange_rate"`
+	Comment            string            `json:"comment"`
+	DocumentDate       time.Time         `json:"document_date"`
+	BaseDocumentID     *uint             `json:"base_document_id,omitempty"`
+	WriteOffReasonID   *uint             `json:"write_off_reason_id,omitempty"`
+	WriteOffReasonName string            `json:"write_off_reason_name,omitempty"`
+	FulfillmentStatus  string            `json:"fulfillment_status,omitempty"`
+	ReservedUntil      *time.Time        `json:"reserved_until,omitempty"`
+	Version            uint              `json:"version"`
+	Items              []DocumentItemDTO `json:"items"`
+	Status             string            `json:"status"`
+	RejectReason       string            `json:"reject_reason,omitempty"`
+	ApprovedBy         *uint             `json:"approved_by,omitempty"`
+	ApprovedAt         *time.Time        `json:"approved_at,omitempty"`
+	PostedAt           *time.Time        `json:"posted_at,omitempty"`
+	CreatedAt          time.Time         `json:"created_at"`
 }
 
 type DocumentItemDTO struct {
-	ID           uint             `json:"id"`
-	VariantID    uint             `json:"variant_id"`
-	VariantSKU   string           `json:"variant_sku"`
-	ProductName  string           `json:"product_name"`
-	CategoryID   uint             `json:"category_id"`
-	CategoryName string           `json:"category_name"`
-	Quantity     decimal.Decimal  `json:"quantity"`
-	Price        *decimal.Decimal `json:"price,omitempty"`
+	ID             uint             `json:"id"`
+	VariantID      uint             `json:"variant_id"`
+	VariantSKU     string           `json:"variant_sku"`
+	ProductName    string           `json:"product_name"`
+	CategoryID     uint             `json:"category_id"`
+	CategoryName   string           `json:"category_name"`
+	Quantity       decimal.Decimal  `json:"quantity"`
+	Price          *decimal.Decimal `json:"price,omitempty"`
+	BatchNumber    string           `json:"batch_number,omitempty"`
+	ProductionDate *time.Time       `json:"production_date,omitempty"`
+	ExpiryDate     *time.Time       `json:"expiry_date,omitempty"`
+	SerialNumbers  []string         `json:"serial_numbers,omitempty"`
+	// Единица ввода строки, если она отличается от базовой.
+	UnitID       *uint            `json:"unit_id,omitempty"`
+	UnitName     string           `json:"unit_name,omitempty"`
+	UnitQuantity *decimal.Decimal `json:"unit_quantity,omitempty"`
+	// ShippedQuantity заполняется только для строк ORDER.
+	ShippedQuantity *decimal.Decimal `json:"shipped_quantity,omitempty"`
 }
 
 type DocumentListItemDTO struct {
-	ID               uint      `json:"id"`
-	Type             string    `json:"type"`
-	Number           string    `json:"number"`
-	WarehouseName    string    `json:"warehouse_name,omitempty"`
-	CounterpartyName string    `json:"counterparty_name,omitempty"`
-	ItemCount        int       `json:"item_count"`
-	Status           string    `json:"status"`
-	CreatedAt        time.Time `json:"created_at"`
+	ID                uint      `json:"id"`
+	Type              string    `json:"type"`
+	Number            string    `json:"number"`
+	WarehouseName     string    `json:"warehouse_name,omitempty"`
+	CounterpartyName  string    `json:"counterparty_name,omitempty"`
+	ItemCount         int       `json:"item_count"`
+	Status            string    `json:"status"`
+	FulfillmentStatus string    `json:"fulfillment_status,omitempty"`
+	DocumentDate      time.Time `json:"document_date"`
+	CreatedAt         time.Time `json:"created_at"`
 }
 
 type DocumentUpdateDTO struct {
-	WarehouseID    *uint          `json:"warehouse_id"`
-	CounterpartyID *uint          `json:"counterparty_id"`
-	PriceTypeID    *uint          `json:"price_type_id"`
-	Comment        string         `json:"comment"`
-	Items          []DocumentItem `json:"items"`
+	WarehouseID      *uint          `json:"warehouse_id"`
+	ToWarehouseID    *uint          `json:"to_warehouse_id"`
+	CounterpartyID   *uint          `json:"counterparty_id"`
+	PriceTypeID      *uint          `json:"price_type_id"`
+	EffectiveFrom    *time.Time     `json:"effective_from"`
+	EffectiveTo      *time.Time     `json:"effective_to"`
+	Currency         string         `json:"currency"`
+	Comment          string         `json:"comment"`
+	DocumentDate     *time.Time     `json:"document_date"`
+	ReservedUntil    *time.Time     `json:"reserved_until"`
+	WriteOffReasonID *uint          `json:"write_off_reason_id"`
+	Items            []DocumentItem `json:"items"`
+	// Version - версия, с которой начата правка; если документ уже изменён, правка отклоняется.
+	Version *uint `json:"version"`
 }
 
 type StockMovementDTO struct {
@@ -98,9 +134,48 @@ type StockMovementDTO struct {
 	WarehouseName  string          `json:"warehouse_name"`
 	Quantity       decimal.Decimal `json:"quantity"`
 	Type           string          `json:"type"`
+	SourceLotID    *uint           `json:"source_lot_id,omitempty"`
+	DocumentDate   time.Time       `json:"document_date"`
 	CreatedAt      time.Time       `json:"created_at"`
 }
 
+type LotDTO struct {
+	ID              uint            `json:"id"`
+	WarehouseID     uint            `json:"warehouse_id"`
+	WarehouseName   string          `json:"warehouse_name"`
+	VariantID       uint            `json:"variant_id"`
+	VariantSKU      string          `json:"variant_sku"`
+	ProductName     string          `json:"product_name"`
+	BatchNumber     string          `json:"batch_number"`
+	ProductionDate  *time.Time      `json:"production_date"`
+	ExpiryDate      *time.Time      `json:"expiry_date"`
+	ArrivalDate     time.Time       `json:"arrival_date"`
+	CurrentQuantity decimal.Decimal `json:"current_quantity"`
+	UnitCost        decimal.Decimal `json:"unit_cost"`
+}
+
+// LotTraceMovementDTO - движение по партии с контрагентом документа, для отзыва товара.
+type LotTraceMovementDTO struct {
+	ID               uint            `json:"id"`
+	LotID            uint            `json:"lot_id"`
+	DocumentID       *uint           `json:"document_id"`
+	DocumentNumber   string          `json:"document_number,omitempty"`
+	DocumentType     string          `json:"document_type,omitempty"`
+	CounterpartyID   *uint           `json:"counterparty_id,omitempty"`
+	CounterpartyName string          `json:"counterparty_name,omitempty"`
+	WarehouseID      uint            `json:"warehouse_id"`
+	WarehouseName    string          `json:"warehouse_name"`
+	Quantity         decimal.Decimal `json:"quantity"`
+	Type             string          `json:"type"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+type LotTraceDTO struct {
+	Lot       LotDTO                `json:"lot"`
+	Lots      []LotDTO              `json:"lots"`
+	Movements []LotTraceMovementDTO `json:"movements"`
+}
+
 type StockBalanceDTO struct {
 	ID           uint            `json:"id"`
 	WarehouseID  uint            `json:"warehouse_id"`
@@ -141,6 +216,139 @@ type VariantStockDTO struct {
 	OnHand        decimal.Decimal `json:"on_hand"`
 	Reserved      decimal.Decimal `json:"reserved"`
 	Available     decimal.Decimal `json:"available"`
+	InTransit     decimal.Decimal `json:"in_transit"`
+	// Buildable - сколько комплектов можно собрать из доступных компонентов, только для вариантов со спецификацией.
+	Buildable *decimal.Decimal `json:"buildable,omitempty"`
+	// Orders - заказы, под которые зарезервирован Reserved.
+	Orders []ReservationDTO `json:"orders"`
+}
+
+type ReservationDTO struct {
+	DocumentID       uint            `json:"document_id"`
+	DocumentNumber   string          `json:"document_number"`
+	CounterpartyName string          `json:"counterparty_name,omitempty"`
+	Quantity         decimal.Decimal `json:"quantity"`
+	ExpiresAt        *time.Time      `json:"expires_at,omitempty"`
+}
+
+type VariantUnitDTO struct {
+	UnitID   uint            `json:"unit_id"`
+	UnitName string          `json:"unit_name"`
+	Factor   decimal.Decimal `json:"factor"`
+}
+
+// BarcodeCreateDTO - новый штрихкод варианта. Без кода выдаётся EAN-13 из внутреннего диапазона,
+// без типа тип определяется по коду. Тип (EAN13, CODE128, QR) принимается в любом регистре.
+type BarcodeCreateDTO struct {
+	Code string `json:"code"`
+	Type string `json:"type"`
+}
+
+// LabelFilter - этикетки для печати: варианты, цена из вида цен и формат листа (shelf - полочные, price - ценники).
+type LabelFilter struct {
+	VariantIDs  []uint `form:"variant_id" binding:"required"`
+	PriceTypeID *uint  `form:"price_type_id"`
+	Format      string `form:"format" binding:"omitempty,oneof=shelf price"`
+	Copies      int    `form:"copies" binding:"omitempty,min=1,max=100"`
+	// Date - дата, на которую берутся цены; пусто - текущий момент.
+	Date *time.Time `form:"date" time_format:"2006-01-02"`
+}
+
+type VariantUnitsUpdateDTO struct {
+	Units []VariantUnit `json:"units"`
+}
+
+type BOMComponentDTO struct {
+	ComponentVariantID uint            `json:"component_variant_id"`
+	SKU                string          `json:"sku"`
+	ProductName        string          `json:"product_name"`
+	Quantity           decimal.Decimal `json:"quantity"`
+}
+
+type BOMUpdateDTO struct {
+	Components []BOMComponent `json:"components"`
+}
+
+type PeriodCloseDTO struct {
+	WarehouseID *uint     `json:"warehouse_id"`
+	LockDate    time.Time `json:"lock_date" binding:"required"`
+	Comment     string    `json:"comment"`
+}
+
+type PeriodReopenDTO struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type TransferReceiptItemDTO struct {
+	VariantID uint            `json:"variant_id"`
+	Quantity  decimal.Decimal `json:"quantity"`
+}
+
+type TransferReceiptDTO struct {
+	Items []TransferReceiptItemDTO `json:"items"`
+}
+
+type CreateBasedOnDTO struct {
+	Type string `json:"type" binding:"required"`
+}
+
+// OrderFulfillmentDTO - выполнение заказа по строкам с учётом всех проведённых расходов на его основании.
+type OrderFulfillmentDTO struct {
+	OrderID   uint                      `json:"order_id"`
+	Number    string                    `json:"number"`
+	Status    string                    `json:"status"`
+	Lines     []OrderFulfillmentLineDTO `json:"lines"`
+	Shipments []DocumentListItemDTO     `json:"shipments"`
+}
+
+type OrderFulfillmentLineDTO struct {
+	ItemID      uint            `json:"item_id"`
+	VariantID   uint            `json:"variant_id"`
+	VariantSKU  string          `json:"variant_sku"`
+	ProductName string          `json:"product_name"`
+	Ordered     decimal.Decimal `json:"ordered"`
+	Shipped     decimal.Decimal `json:"shipped"`
+	Remaining   decimal.Decimal `json:"remaining"`
+}
+
+// BulkDocumentDTO - массовое проведение или отмена. Документы задаются списком DocumentIDs или фильтром.
+type BulkDocumentDTO struct {
+	Action string `json:"action" binding:"required,oneof=post cancel"`
+	// Mode: best_effort (по умолчанию) - ошибка документа не мешает остальным,
+	// all_or_nothing - все документы в одной транзакции, первая ошибка откатывает всё.
+	Mode        string          `json:"mode" binding:"omitempty,oneof=best_effort all_or_nothing"`
+	DocumentIDs []uint          `json:"document_ids"`
+	Filter      *DocumentFilter `json:"filter"`
+}
+
+// BulkJobDTO - состояние фоновой массовой операции.
+type BulkJobDTO struct {
+	ID     uint   `json:"id"`
+	Action string `json:"action"`
+	Mode   string `json:"mode"`
+	// Status: running, completed или failed (all_or_nothing откатился).
+	Status     string          `json:"status"`
+	CreatedBy  *uint           `json:"created_by,omitempty"`
+	Total      int             `json:"total"`
+	Processed  int             `json:"processed"`
+	Succeeded  int             `json:"succeeded"`
+	Failed     int             `json:"failed"`
+	Results    []BulkResultDTO `json:"results"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+type BulkResultDTO struct {
+	DocumentID   uint      `json:"document_id"`
+	Number       string    `json:"number"`
+	DocumentDate time.Time `json:"document_date"`
+	// Status: pending, done, failed, rolled_back (откачен вместе с пакетом) или skipped (не обработан после ошибки).
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type DocumentRejectDTO struct {
+	Reason string `json:"reason" binding:"required"`
 }
 
 type ImportItemDTO struct {
@@ -151,3 +359,82 @@ type ImportItemDTO struct {
 	SKU             string
 	Characteristics map[string]string
 }
+
+type SerialHistoryEntryDTO struct {
+	DocumentID      uint      `json:"document_id"`
+	DocumentNumber  string    `json:"document_number"`
+	DocumentType    string    `json:"document_type"`
+	Type            string    `json:"type"`
+	FromWarehouseID *uint     `json:"from_warehouse_id"`
+	ToWarehouseID   *uint     `json:"to_warehouse_id"`
+	FromStatus      string    `json:"from_status"`
+	ToStatus        string    `json:"to_status"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type SerialHistoryDTO struct {
+	Serial        string                  `json:"serial"`
+	VariantID     uint                    `json:"variant_id"`
+	VariantSKU    string                  `json:"variant_sku"`
+	ProductName   string                  `json:"product_name"`
+	Status        string                  `json:"status"`
+	WarehouseID   *uint                   `json:"warehouse_id"`
+	WarehouseName string                  `json:"warehouse_name,omitempty"`
+	History       []SerialHistoryEntryDTO `json:"history"`
+}
+
+// PriceConflictDTO - цена, изменённая более поздним документом после отменяемого.
+type PriceConflictDTO struct {
+	VariantID      uint            `json:"variant_id"`
+	PriceTypeID    uint            `json:"price_type_id"`
+	DocumentID     uint            `json:"document_id"`
+	DocumentNumber string          `json:"document_number"`
+	Price          decimal.Decimal `json:"price"`
+}
+
+// ScanSessionCreateDTO - открытие сеанса сканирования. С BaseDocumentID сеанс набирает расход по заказу:
+// контрагент и цены берутся из заказа, сканы сверх остатка отклоняются.
+type ScanSessionCreateDTO struct {
+	DocumentType   string `json:"document_type" binding:"required"`
+	WarehouseID    uint   `json:"warehouse_id" binding:"required"`
+	ToWarehouseID  *uint  `json:"to_warehouse_id"`
+	CounterpartyID *uint  `json:"counterparty_id"`
+	BaseDocumentID *uint  `json:"base_document_id"`
+}
+
+// ScanDTO - событие скана. Quantity по умолчанию 1, отрицательное количество снимает ошибочный скан.
+// Для вариантов с серийным учётом вместе со штрихкодом сканируется серийный номер, по одной единице за скан.
+type ScanDTO struct {
+	Barcode  string           `json:"barcode" binding:"required"`
+	Serial   string           `json:"serial" binding:"max=100"`
+	Quantity *decimal.Decimal `json:"quantity"`
+	EventID  string           `json:"event_id" binding:"max=100"`
+}
+
+type ScanResultDTO struct {
+	Event ScanEvent `json:"event"`
+	// Replayed - событие с этим event_id уже было принято, количество повторно не добавлено.
+	Replayed bool         `json:"replayed"`
+	Line     *ScanLineDTO `json:"line,omitempty"`
+}
+
+// ScanLineDTO - строка черновика сеанса. Remaining - неотгруженный остаток заказа-основания.
+type ScanLineDTO struct {
+	VariantID   uint             `json:"variant_id"`
+	VariantSKU  string           `json:"variant_sku"`
+	ProductName string           `json:"product_name"`
+	Quantity    decimal.Decimal  `json:"quantity"`
+	Remaining   *decimal.Decimal `json:"remaining,omitempty"`
+}
+
+// ScanSessionDTO - состояние сеанса для продолжения после переподключения: строки черновика
+// и сканы, требующие внимания.
+type ScanSessionDTO struct {
+	ScanSession
+	DocumentNumber string        `json:"document_number"`
+	DocumentStatus string        `json:"document_status"`
+	Lines          []ScanLineDTO `json:"lines"`
+	ScanCount      int           `json:"scan_count"`
+	Unknown        []ScanEvent   `json:"unknown"`
+	OverScans      []ScanEvent   `json:"over_scans"`
+}
@@ -26,22 +26,44 @@ type StockFilter struct {
 }
 
 type MovementFilter struct {
-	VariantID *uint
-	Limit     int
-	Offset    int
+	VariantID    *uint
+	SourceLotIDs []uint
+	// Склады, которыми ограничен пользователь; nil - без ограничения.
+	WarehouseIDs []uint
+	Limit        int
+	Offset       int
 }
 
-type DocumentFilter struct {
-	Search   *string
-	Status   *string
-	Types    []string
-	DateFrom *time.Time
-	DateTo   *time.Time
+type LotFilter struct {
+	WarehouseID *uint
+	VariantID   *uint
+	BatchNumber *string
+	// Партии, срок годности которых истекает не позже чем через N дней (включая уже просроченные).
+	ExpiresWithinDays *int
+	// По умолчанию показываются только партии с ненулевым остатком.
+	IncludeEmpty bool
+	// Склады, которыми ограничен пользователь; nil - без ограничения.
+	WarehouseIDs []uint
 
 	Limit  int
 	Offset int
 }
 
+// DocumentFilter заполняется из query-параметров списка документов или из тела массовой операции.
+type DocumentFilter struct {
+	Search   *string    `json:"search"`
+	Status   *string    `json:"status"`
+	Types    []string   `json:"types"`
+	DateFrom *time.Time `json:"date_from"`
+	DateTo   *time.Time `json:"date_to"`
+	// Склады, которыми ограничен пользователь: документ попадает в выборку, если к ним относится его склад
+	// или склад-получатель перемещения. Документы без склада видны всем. nil - без ограничения.
+	WarehouseIDs []uint `json:"-"`
+
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
 type CounterpartyFilter struct {
 	Search *string
 	Limit  int
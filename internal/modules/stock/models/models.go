@@ -56,9 +56,20 @@ type Variant struct {
 	SKU             string             `gorm:"unique" json:"sku"`
 	Characteristics CharacteristicsMap `gorm:"type:jsonb" json:"characteristics"`
 	UnitID          uint               `json:"unit_id"`
+	TrackSerials    bool               `gorm:"default:false" json:"track_serials"`
 	Images          []ProductImage     `gorm:"foreignKey:VariantID;constraint:OnDelete:CASCADE" json:"images"`
 }
 
+// BOMComponent - строка спецификации комплекта: сколько компонента уходит на один комплект.
+type BOMComponent struct {
+	ID                 uint            `gorm:"primaryKey" json:"id"`
+	KitVariantID       uint            `gorm:"index;not null" json:"kit_variant_id"`
+	KitVariant         Variant         `gorm:"foreignKey:KitVariantID;constraint:OnDelete:CASCADE;" json:"-"`
+	ComponentVariantID uint            `gorm:"not null" json:"component_variant_id"`
+	ComponentVariant   Variant         `gorm:"foreignKey:ComponentVariantID;constraint:OnDelete:RESTRICT;" json:"-"`
+	Quantity           decimal.Decimal `gorm:"type:decimal(14,4);not null" json:"quantity"`
+}
+
 type CharacteristicType struct {
 	ID   uint   `gorm:"primaryKey" json:"id"`
 	Name string `gorm:"unique;not null" json:"name"`
@@ -78,6 +89,38 @@ type Category struct {
 type Unit struct {
 	ID   uint   `gorm:"primaryKey" json:"id"`
 	Name string `gorm:"unique;not null" json:"name"`
+	// Precision - допустимое число знаков после запятой в количестве: 0 для штук, 3 для килограммов.
+	Precision int `gorm:"not null;default:4" json:"precision"`
+}
+
+// VariantUnit - дополнительная единица варианта (коробка, паллета): в одной такой Factor базовых единиц.
+type VariantUnit struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	VariantID uint            `gorm:"uniqueIndex:idx_variant_unit;not null" json:"variant_id"`
+	Variant   Variant         `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	UnitID    uint            `gorm:"uniqueIndex:idx_variant_unit;not null" json:"unit_id"`
+	Unit      Unit            `gorm:"constraint:OnDelete:RESTRICT;" json:"-"`
+	Factor    decimal.Decimal `gorm:"type:decimal(14,4);not null" json:"factor"`
+}
+
+// Типы штрихкодов варианта.
+const (
+	BarcodeEAN13   = "EAN13"
+	BarcodeCode128 = "CODE128"
+	BarcodeQR      = "QR"
+)
+
+// Barcode - штрихкод варианта. У варианта их может быть несколько: заводской EAN, свой из внутреннего диапазона,
+// код упаковки. Код уникален во всём справочнике, чтобы скан однозначно находил вариант.
+type Barcode struct {
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	VariantID uint    `gorm:"index;not null" json:"variant_id"`
+	Variant   Variant `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	Code      string  `gorm:"uniqueIndex;size:255;not null" json:"code"`
+	Type      string  `gorm:"size:20;not null" json:"type"`
+	// Generated - код выдан из внутреннего диапазона EAN.
+	Generated bool      `gorm:"not null;default:false" json:"generated"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Warehouse struct {
@@ -86,6 +129,13 @@ type Warehouse struct {
 	Address string `json:"address"`
 }
 
+// WriteOffReason - причина списания: порча, истёк срок годности, кража, образцы и т.п.
+type WriteOffReason struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Code string `gorm:"uniqueIndex;size:50;not null" json:"code"`
+	Name string `gorm:"not null" json:"name"`
+}
+
 type Counterparty struct {
 	ID       uint   `gorm:"primaryKey" json:"id"`
 	Name     string `gorm:"unique;not null" json:"name"`
@@ -101,23 +151,55 @@ type PriceType struct {
 }
 
 type Document struct {
-	ID             uint           `gorm:"primaryKey" json:"id"`
-	Type           string         `json:"type"`
-	Number         string         `gorm:"uniqueIndex" json:"number"`
-	WarehouseID    *uint          `json:"warehouse_id"`
-	Warehouse      *Warehouse     `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
-	ToWarehouseID  *uint          `json:"to_warehouse_id"`
-	ToWarehouse    *Warehouse     `gorm:"foreignKey:ToWarehouseID;constraint:OnDelete:SET NULL;" json:"-"`
-	CounterpartyID *uint          `json:"counterparty_id"`
-	Counterparty   *Counterparty  `gorm:"constraint:OnDelete:SET NULL;" json:"-"`
-	PriceTypeID    *uint          `json:"price_type_id"`
-	Comment        string         `json:"comment"`
-	BaseDocumentID *uint          `json:"base_document_id"`
-	Items          []DocumentItem `gorm:"foreignKey:DocumentID;constraint:OnDelete:CASCADE" json:"items"`
-	Status         string         `gorm:"default:draft" json:"status"`
-	CreatedBy      *uint          `json:"created_by"`
-	PostedAt       *time.Time     `json:"posted_at"`
-	CreatedAt      time.Time      `json:"created_at"`
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	Type           string          `json:"type"`
+	Number         string          `gorm:"uniqueIndex" json:"number"`
+	WarehouseID    *uint           `json:"warehouse_id"`
+	Warehouse      *Warehouse      `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	ToWarehouseID  *uint           `json:"to_warehouse_id"`
+	ToWarehouse    *Warehouse      `gorm:"foreignKey:ToWarehouseID;constraint:OnDelete:SET NULL;" json:"-"`
+	CounterpartyID *uint           `json:"counterparty_id"`
+	Counterparty   *Counterparty   `gorm:"constraint:OnDelete:SET NULL;" json:"-"`
+	PriceTypeID    *uint           `json:"price_type_id"`
+	EffectiveFrom  *time.Time      `json:"effective_from,omitempty"`                          // для PRICE_UPDATE: дата начала действия цен
+	EffectiveTo    *time.Time      `json:"effective_to,omitempty"`                            // для PRICE_UPDATE: дата окончания, пусто - бессрочно
+	Currency       string          `gorm:"size:3" json:"currency"`                            // валюта цен документа, пусто - базовая
+	ExchangeRate   decimal.Decimal `gorm:"type:decimal(18,6);default:1" json:"exchange_rate"` // курс к базовой валюте на дату документа
+	Comment        string          `json:"comment"`
+	// DocumentDate - дата документа для учёта: по ней упорядочиваются движения и партии, берутся курс и отчётные периоды.
+	// Может быть задним числом; CreatedAt - только момент ввода в систему.
+	DocumentDate time.Time `gorm:"index" json:"document_date"`
+	// WriteOffReasonID обязателен для WRITE_OFF.
+	WriteOffReasonID *uint           `json:"write_off_reason_id,omitempty"`
+	WriteOffReason   *WriteOffReason `gorm:"constraint:OnDelete:SET NULL;" json:"-"`
+	BaseDocumentID   *uint           `json:"base_document_id"`
+	// Version растёт при каждой записи документа: по ней отклоняются правка и проведение устаревшей копии.
+	Version uint `gorm:"not null;default:1" json:"version"`
+	// ReservedUntil - для ORDER: до какого момента держится резерв, пусто - до отгрузки или отмены.
+	ReservedUntil *time.Time `json:"reserved_until,omitempty"`
+	// FulfillmentStatus ведётся только у ORDER: open, partially_shipped или fulfilled по проведённым расходам на его основании.
+	FulfillmentStatus string         `gorm:"size:20" json:"fulfillment_status,omitempty"`
+	Items             []DocumentItem `gorm:"foreignKey:DocumentID;constraint:OnDelete:CASCADE" json:"items"`
+	Status            string         `gorm:"default:draft" json:"status"`
+	RejectReason      string         `json:"reject_reason,omitempty"`
+	ApprovedBy        *uint          `json:"approved_by,omitempty"`
+	ApprovedAt        *time.Time     `json:"approved_at,omitempty"`
+	CreatedBy         *uint          `json:"created_by"`
+	PostedAt          *time.Time     `json:"posted_at"`
+	CreatedAt         time.Time      `json:"created_at"`
+}
+
+// ApprovalRule - правило согласования: документы типа DocumentType на сумму выше MinAmount (в базовой валюте)
+// перед проведением проходят draft -> submitted -> approved. Нулевая MinAmount - согласование всех документов типа с суммой.
+type ApprovalRule struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	DocumentType string          `gorm:"index" json:"document_type"`
+	MinAmount    decimal.Decimal `gorm:"type:decimal(14,2);" json:"min_amount"`
+	// ApproverPermission - право, которое кроме approve_document нужно согласующему; пусто - достаточно approve_document.
+	ApproverPermission string    `gorm:"size:100" json:"approver_permission"`
+	Active             bool      `json:"active"`
+	Comment            string    `json:"comment"`
+	CreatedAt          time.Time `json:"created_at"`
 }
 
 type DocumentItem struct {
@@ -127,6 +209,22 @@ type DocumentItem struct {
 	Variant    Variant          `gorm:"foreignKey:VariantID;constraint:OnDelete:CASCADE;" json:"-"`
 	Quantity   decimal.Decimal  `gorm:"type:decimal(14,4);" json:"quantity"`
 	Price      *decimal.Decimal `gorm:"type:decimal(14,2);" json:"price"`
+
+	// UnitID и UnitQuantity - единица и количество, в которых строка введена (коробки, паллеты).
+	// Quantity всегда в базовой единице варианта и пересчитывается из них; цена - тоже за базовую единицу.
+	UnitID       *uint            `json:"unit_id,omitempty"`
+	UnitQuantity *decimal.Decimal `gorm:"type:decimal(14,4);" json:"unit_quantity,omitempty"`
+
+	// Партия, дата производства и срок годности указываются в строках прихода и переносятся на партию склада.
+	BatchNumber    string     `gorm:"size:100" json:"batch_number,omitempty"`
+	ProductionDate *time.Time `json:"production_date,omitempty"`
+	ExpiryDate     *time.Time `json:"expiry_date,omitempty"`
+
+	// Серийные номера обязательны для вариантов с TrackSerials, их число должно совпадать с Quantity.
+	SerialNumbers []string `gorm:"serializer:json" json:"serial_numbers,omitempty"`
+
+	// ShippedQuantity - для строк ORDER: сколько отгружено проведёнными расходами на основании заказа.
+	ShippedQuantity decimal.Decimal `gorm:"type:decimal(14,4);default:0" json:"-"`
 }
 
 type DocumentHistory struct {
@@ -137,6 +235,35 @@ type DocumentHistory struct {
 	CreatedAt  time.Time `json:"created_at"`
 	CreatedBy  *uint     `json:"created_by"`
 	Comment    string    `json:"comment"`
+	// Snapshot заполняется при исправлении проведённого документа: шапка и строки до и после.
+	Snapshot *DocumentCorrection `gorm:"serializer:json" json:"snapshot,omitempty"`
+}
+
+// DocumentCorrection - состояние документа до и после исправления, для аудита и сравнения.
+type DocumentCorrection struct {
+	Before DocumentSnapshot `json:"before"`
+	After  DocumentSnapshot `json:"after"`
+}
+
+type DocumentSnapshot struct {
+	WarehouseID      *uint                  `json:"warehouse_id,omitempty"`
+	ToWarehouseID    *uint                  `json:"to_warehouse_id,omitempty"`
+	CounterpartyID   *uint                  `json:"counterparty_id,omitempty"`
+	DocumentDate     time.Time              `json:"document_date"`
+	Currency         string                 `json:"currency,omitempty"`
+	Comment          string                 `json:"comment,omitempty"`
+	WriteOffReasonID *uint                  `json:"write_off_reason_id,omitempty"`
+	Items            []DocumentItemSnapshot `json:"items"`
+}
+
+type DocumentItemSnapshot struct {
+	VariantID     uint             `json:"variant_id"`
+	Quantity      decimal.Decimal  `json:"quantity"`
+	Price         *decimal.Decimal `json:"price,omitempty"`
+	UnitID        *uint            `json:"unit_id,omitempty"`
+	UnitQuantity  *decimal.Decimal `json:"unit_quantity,omitempty"`
+	BatchNumber   string           `json:"batch_number,omitempty"`
+	SerialNumbers []string         `json:"serial_numbers,omitempty"`
 }
 
 type StockMovement struct {
@@ -149,9 +276,12 @@ type StockMovement struct {
 	Warehouse      Warehouse       `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
 	CounterpartyID *uint           `json:"counterparty_id"`
 	Quantity       decimal.Decimal `gorm:"type:decimal(14,4);" json:"quantity"`
+	UnitCost       decimal.Decimal `gorm:"type:decimal(14,4);" json:"unit_cost"`
 	SourceLotID    *uint           `gorm:"index" json:"source_lot_id,omitempty"`
+	ReversedByID   *uint           `gorm:"index" json:"reversed_by_id,omitempty"` // CANCEL-движение, сторнировавшее это
 	Type           string          `json:"type"`
 	Comment        string          `json:"comment"`
+	DocumentDate   time.Time       `gorm:"index" json:"document_date"` // дата документа-основания движения
 	CreatedAt      time.Time       `json:"created_at"`
 }
 
@@ -162,15 +292,83 @@ type StockBalance struct {
 	VariantID   uint            `gorm:"column:item_id;index:idx_wh_variant,unique" json:"variant_id"`
 	Variant     Variant         `gorm:"foreignKey:VariantID;constraint:OnDelete:CASCADE;" json:"-"`
 	Quantity    decimal.Decimal `gorm:"type:decimal(14,4);" json:"quantity"`
+	AvgCost     decimal.Decimal `gorm:"type:decimal(14,4);" json:"avg_cost"`
 }
 
-type StockReservation struct {
-	ID          uint            `gorm:"primaryKey" json:"id"`
-	WarehouseID uint            `gorm:"index:idx_wh_variant_res,unique" json:"warehouse_id"`
-	Warehouse   Warehouse       `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
-	VariantID   uint            `gorm:"index:idx_wh_variant_res,unique" json:"variant_id"`
-	Variant     Variant         `gorm:"foreignKey:VariantID;constraint:OnDelete:CASCADE;" json:"-"`
-	Quantity    decimal.Decimal `gorm:"type:decimal(14,4);" json:"quantity"`
+// Состояния сеанса сканирования.
+const (
+	ScanSessionOpen   = "open"
+	ScanSessionClosed = "closed"
+)
+
+// Результаты скана: accepted - количество добавлено в черновик, unknown - код не найден,
+// over_scan - скан сверх неотгруженного остатка заказа-основания, в черновик не попал.
+const (
+	ScanAccepted = "accepted"
+	ScanUnknown  = "unknown"
+	ScanOverScan = "over_scan"
+)
+
+// ScanSession - сеанс сканирования с ТСД: сканы копятся в черновик документа DocumentID по строке на вариант.
+// Сеанс хранится в базе, поэтому после обрыва связи клиент продолжает его с того же места.
+type ScanSession struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	DocumentID   uint      `gorm:"index;not null" json:"document_id"`
+	Document     *Document `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	DocumentType string    `gorm:"size:20;not null" json:"document_type"`
+	WarehouseID  uint      `gorm:"index;not null" json:"warehouse_id"`
+	// BaseDocumentID - проведённый заказ, сверх остатка которого сканировать нельзя.
+	BaseDocumentID *uint      `json:"base_document_id,omitempty"`
+	Status         string     `gorm:"size:20;index;not null" json:"status"`
+	CreatedBy      *uint      `json:"created_by"`
+	ClosedAt       *time.Time `json:"closed_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ScanEvent - один скан сеанса. EventID - ключ события на стороне клиента: повторная отправка
+// после переподключения возвращает записанный результат и не удваивает количество.
+type ScanEvent struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	SessionID uint            `gorm:"index;not null" json:"session_id"`
+	Session   ScanSession     `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	EventID   string          `gorm:"size:100;index" json:"event_id,omitempty"`
+	Barcode   string          `gorm:"size:255;not null" json:"barcode"`
+	Serial    string          `gorm:"size:100" json:"serial,omitempty"`
+	Quantity  decimal.Decimal `gorm:"type:decimal(14,4);" json:"quantity"`
+	VariantID *uint           `json:"variant_id,omitempty"`
+	Result    string          `gorm:"size:20;not null" json:"result"`
+	Message   string          `json:"message,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ReservationLine - резерв строки проведённого заказа: сколько ещё не отгружено и держится на складе под этот заказ.
+// Резерв с истёкшим ExpiresAt не уменьшает доступный остаток и снимается фоновой задачей.
+type ReservationLine struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	DocumentID     uint            `gorm:"index" json:"document_id"`
+	Document       Document        `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	DocumentItemID uint            `gorm:"index" json:"document_item_id"`
+	WarehouseID    uint            `gorm:"index:idx_reservation_wh_variant" json:"warehouse_id"`
+	VariantID      uint            `gorm:"index:idx_reservation_wh_variant" json:"variant_id"`
+	Quantity       decimal.Decimal `gorm:"type:decimal(14,4);" json:"quantity"`
+	ExpiresAt      *time.Time      `gorm:"index" json:"expires_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// IdempotencyRecord - ответ на запрос с заголовком Idempotency-Key. Повтор запроса с тем же ключом
+// до ExpiresAt получает этот ответ, а не выполняется заново.
+type IdempotencyRecord struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	UserID uint   `gorm:"uniqueIndex:idx_idempotency_user_key" json:"user_id"`
+	Key    string `gorm:"size:255;uniqueIndex:idx_idempotency_user_key" json:"key"`
+	// Fingerprint - хеш метода, пути и тела: тот же ключ с другим запросом отклоняется.
+	Fingerprint string `gorm:"size:64" json:"-"`
+	// StatusCode равен 0, пока первый запрос ещё выполняется.
+	StatusCode int       `json:"status_code"`
+	Response   []byte    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `gorm:"index" json:"expires_at"`
 }
 
 type ItemPrice struct {
@@ -182,6 +380,33 @@ type ItemPrice struct {
 	UpdatedAt   time.Time       `json:"updated_at"`
 }
 
+// ItemPriceHistory - изменение цены документом PRICE_UPDATE на период [EffectiveFrom, EffectiveTo).
+// Applied - документ сразу обновил текущую цену в ItemPrice; OldPrice хранит значение до него (пусто, если цены не было).
+type ItemPriceHistory struct {
+	ID            uint             `gorm:"primaryKey" json:"id"`
+	VariantID     uint             `gorm:"index:idx_price_hist_item" json:"variant_id"`
+	PriceTypeID   uint             `gorm:"index:idx_price_hist_item" json:"price_type_id"`
+	DocumentID    uint             `gorm:"index" json:"document_id"`
+	OldPrice      *decimal.Decimal `gorm:"type:decimal(14,2);" json:"old_price"`
+	OldCurrency   string           `json:"old_currency,omitempty"`
+	NewPrice      decimal.Decimal  `gorm:"type:decimal(14,2);" json:"new_price"`
+	Currency      string           `json:"currency"`
+	EffectiveFrom time.Time        `gorm:"index" json:"effective_from"`
+	EffectiveTo   *time.Time       `json:"effective_to,omitempty"`
+	Applied       bool             `json:"applied"`
+	RevertedAt    *time.Time       `json:"reverted_at,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+}
+
+// ExchangeRate - курс валюты на дату: сколько единиц базовой валюты стоит одна единица Currency.
+type ExchangeRate struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	Currency  string          `gorm:"size:3;uniqueIndex:idx_currency_date" json:"currency"`
+	Date      time.Time       `gorm:"uniqueIndex:idx_currency_date" json:"date"`
+	Rate      decimal.Decimal `gorm:"type:decimal(18,6);" json:"rate"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
 type StockLot struct {
 	ID               uint      `gorm:"primaryKey"`
 	WarehouseID      uint      `gorm:"index:idx_wh_variant_lot"`
@@ -191,6 +416,83 @@ type StockLot struct {
 	IncomeDocumentID uint
 	ArrivalDate      time.Time       `gorm:"index"`
 	CurrentQuantity  decimal.Decimal `gorm:"type:decimal(14,4);"`
+	UnitCost         decimal.Decimal `gorm:"type:decimal(14,4);"`
+	BatchNumber      string          `gorm:"size:100;index"`
+	ProductionDate   *time.Time
+	ExpiryDate       *time.Time `gorm:"index"`
+}
+
+type StockInTransit struct {
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	DocumentID       uint            `gorm:"index" json:"document_id"`
+	Document         Document        `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	VariantID        uint            `gorm:"index" json:"variant_id"`
+	Variant          Variant         `gorm:"foreignKey:VariantID;constraint:OnDelete:CASCADE;" json:"-"`
+	FromWarehouseID  uint            `json:"from_warehouse_id"`
+	ToWarehouseID    uint            `gorm:"index" json:"to_warehouse_id"`
+	SourceLotID      *uint           `json:"source_lot_id,omitempty"`
+	DestLotID        *uint           `json:"dest_lot_id,omitempty"`
+	ArrivalDate      time.Time       `json:"arrival_date"`
+	UnitCost         decimal.Decimal `gorm:"type:decimal(14,4);" json:"unit_cost"`
+	Quantity         decimal.Decimal `gorm:"type:decimal(14,4);" json:"quantity"`
+	ReceivedQuantity decimal.Decimal `gorm:"type:decimal(14,4);" json:"received_quantity"`
+	ShippedAt        time.Time       `json:"shipped_at"`
+	ReceivedAt       *time.Time      `json:"received_at"`
+}
+
+// SerialUnit - единица серийного товара и её текущее состояние.
+type SerialUnit struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	VariantID   uint      `gorm:"uniqueIndex:idx_variant_serial" json:"variant_id"`
+	Variant     Variant   `gorm:"foreignKey:VariantID;constraint:OnDelete:CASCADE;" json:"-"`
+	Serial      string    `gorm:"size:100;uniqueIndex:idx_variant_serial" json:"serial"`
+	WarehouseID *uint     `gorm:"index" json:"warehouse_id"`
+	Status      string    `gorm:"size:20;index" json:"status"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SerialMovement - запись истории серийного номера: из какого состояния и в какое его перевёл документ.
+type SerialMovement struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	SerialUnitID    uint       `gorm:"index" json:"serial_unit_id"`
+	SerialUnit      SerialUnit `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	DocumentID      uint       `gorm:"index" json:"document_id"`
+	Document        Document   `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	Type            string     `json:"type"`
+	FromWarehouseID *uint      `json:"from_warehouse_id"`
+	ToWarehouseID   *uint      `json:"to_warehouse_id"`
+	FromStatus      string     `json:"from_status"`
+	ToStatus        string     `json:"to_status"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// PeriodClose - закрытие периода по LockDate включительно для всех складов (WarehouseID пусто) или одного склада.
+// Документы с датой в закрытом периоде нельзя проводить, отменять, править и удалять. Переоткрытое закрытие
+// (Status "reopened") больше не действует, запрет снова определяется предыдущим.
+type PeriodClose struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	WarehouseID *uint           `gorm:"index" json:"warehouse_id"`
+	Warehouse   *Warehouse      `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	LockDate    time.Time       `gorm:"index" json:"lock_date"`
+	Status      string          `gorm:"size:20;default:closed" json:"status"`
+	Comment     string          `json:"comment"`
+	ClosedBy    *uint           `json:"closed_by"`
+	ClosedAt    time.Time       `json:"closed_at"`
+	ReopenedBy  *uint           `json:"reopened_by,omitempty"`
+	ReopenedAt  *time.Time      `json:"reopened_at,omitempty"`
+	ReopenNote  string          `json:"reopen_note,omitempty"`
+	Balances    []PeriodBalance `gorm:"foreignKey:PeriodCloseID;constraint:OnDelete:CASCADE" json:"balances,omitempty"`
+}
+
+// PeriodBalance - остаток и его оценка в базовой валюте на конец закрытого периода.
+type PeriodBalance struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	PeriodCloseID uint            `gorm:"index" json:"-"`
+	WarehouseID   uint            `json:"warehouse_id"`
+	VariantID     uint            `json:"variant_id"`
+	Quantity      decimal.Decimal `gorm:"type:decimal(14,4);" json:"quantity"`
+	Value         decimal.Decimal `gorm:"type:decimal(18,2);" json:"value"`
 }
 
 type DocumentSequence struct {
@@ -2,11 +2,14 @@ package stock
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 
 	auconf "github.com/maksroxx/flowkeeper/internal/config"
+	"github.com/maksroxx/flowkeeper/internal/modules/reports"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/config"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/handler"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
@@ -17,17 +20,22 @@ import (
 
 type Module struct {
 	authConfig auconf.AuthConfig
+	audit      service.AuditLogger
+
+	reservations service.ReservationService
+	sweep        time.Duration
 }
 
-func NewModule(authCfg auconf.AuthConfig) *Module {
-	return &Module{authConfig: authCfg}
+// NewModule создаёт модуль склада. auditLog получает записи о действиях, которые нужно отразить в журнале аудита.
+func NewModule(authCfg auconf.AuthConfig, auditLog service.AuditLogger) *Module {
+	return &Module{authConfig: authCfg, audit: auditLog}
 }
 
 func (m *Module) Name() string { return "stock" }
 
 func (m *Module) RegisterRoutes(r *gin.Engine, db *gorm.DB) {
 	grp := r.Group("/api/v1/stock")
-	grp.Use(users.AuthMiddleware(m.authConfig))
+	grp.Use(users.AuthMiddleware(m.authConfig), handler.RequireStockPermissions(grp.BasePath()))
 
 	stockCfg, err := config.LoadStockConfig("./config/stock_config.yml")
 	if err != nil {
@@ -51,67 +59,295 @@ func (m *Module) RegisterRoutes(r *gin.Engine, db *gorm.DB) {
 	seqRepo := repository.NewSequenceRepository()
 	reservRepo := repository.NewReservationRepository(db)
 	txManager := repository.NewTxManager(db)
-	lotRepo := repository.NewLotRepository()
+	lotRepo := repository.NewLotRepository(db)
+	transitRepo := repository.NewTransitRepository(db)
+	serialRepo := repository.NewSerialRepository(db)
+	currencyRepo := repository.NewCurrencyRepository(db)
+	approvalRepo := repository.NewApprovalRuleRepository(db)
+	reasonRepo := repository.NewWriteOffReasonRepository(db)
+	bomRepo := repository.NewBOMRepository(db)
+	variantUnitRepo := repository.NewVariantUnitRepository(db)
+	periodRepo := repository.NewPeriodRepository(db)
+	idempotencyRepo := repository.NewIdempotencyRepository(db)
+	barcodeRepo := repository.NewBarcodeRepository(db)
+	scanRepo := repository.NewScanSessionRepository(db)
 
 	// --- services ---
 	productSvc := service.NewProductService(productRepo, variantRepo)
-	variantSvc := service.NewVariantService(variantRepo, productRepo, unitRepo)
+	variantSvc := service.NewVariantService(variantRepo, productRepo, unitRepo, barcodeRepo)
 	charactSvc := service.NewCharacteristicService(charactRepo)
 	priceTypeSvc := service.NewPriceTypeService(priceTypeRepo)
-	priceSvc := service.NewPriceService(priceRepo)
+	currencySvc := service.NewCurrencyService(currencyRepo, stockCfg.BaseCurrency)
+	approvalSvc := service.NewApprovalService(approvalRepo, currencySvc)
+	unitSvc := service.NewUnitService(unitRepo, variantUnitRepo, variantRepo)
+	periodSvc := service.NewPeriodService(periodRepo, whRepo, txManager, m.audit)
+	priceSvc := service.NewPriceService(priceRepo, docRepo)
 	seqSvc := service.NewSequenceService(seqRepo, docRepo, txManager)
 	catSvc := service.NewCategoryService(catRepo)
 	cpSvc := service.NewCounterpartyService(cpRepo)
-	strategyFactory := service.NewStrategyFactory(balanceRepo, movRepo, lotRepo, variantRepo, productRepo)
-	inventorySvc := service.NewInventoryService(strategyFactory, reservRepo, balanceRepo, stockCfg, variantRepo, productRepo, unitRepo, catRepo, whRepo)
+	strategyFactory := service.NewStrategyFactory(balanceRepo, movRepo, lotRepo, variantRepo, productRepo, transitRepo)
+	inventorySvc := service.NewInventoryService(strategyFactory, reservRepo, balanceRepo, stockCfg, variantRepo, productRepo, unitRepo, catRepo, whRepo, transitRepo, serialRepo, movRepo, lotRepo, docRepo, bomRepo)
 	docSvc := service.NewDocumentService(
 		docRepo, historyRepo,
 		inventorySvc, priceSvc,
 		seqSvc, txManager,
 		variantRepo, productRepo,
 		whRepo, cpRepo,
-		priceTypeRepo,
+		priceTypeRepo, currencySvc,
+		approvalSvc, reasonRepo, unitSvc,
+		periodSvc,
 	)
 	movSvc := service.NewStockMovementService(movRepo, docRepo, variantRepo, productRepo, whRepo)
-	unitSvc := service.NewUnitService(unitRepo)
+	lotSvc := service.NewLotService(lotRepo, movRepo, docRepo, variantRepo, productRepo, whRepo, cpRepo)
+	serialSvc := service.NewSerialService(serialRepo, docRepo, variantRepo, productRepo, whRepo)
 	whSvc := service.NewWarehouseService(whRepo)
+	reasonSvc := service.NewWriteOffReasonService(reasonRepo)
+	bomSvc := service.NewBOMService(bomRepo, variantRepo, productRepo)
+	reservationSvc := service.NewReservationService(reservRepo, serialRepo, historyRepo, txManager)
+	bulkSvc := service.NewBulkService(docSvc, docRepo, txManager)
+	retention := stockCfg.IdempotencyRetentionHours
+	if retention <= 0 {
+		retention = 24
+	}
+	idempotencySvc := service.NewIdempotencyService(idempotencyRepo, time.Duration(retention)*time.Hour)
+	printForms, err := reports.LoadFormTemplates(stockCfg.PrintFormsDir)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load print forms: %v", err))
+	}
+	pdfGen := reports.NewPDFGenerator(stockCfg.FontsDir)
+	printSvc := service.NewPrintService(
+		docSvc, whRepo, cpRepo, variantRepo, unitSvc, balanceRepo, movRepo,
+		pdfGen, printForms, stockCfg.OrganizationName,
+	)
+	if !validBarcodePrefix(stockCfg.BarcodePrefix) {
+		panic(fmt.Sprintf("invalid barcode_ean_prefix %q: expected 2-7 digits", stockCfg.BarcodePrefix))
+	}
+	barcodeSvc := service.NewBarcodeService(
+		barcodeRepo, variantRepo, productRepo, priceSvc, priceTypeRepo, seqRepo,
+		variantSvc, txManager, pdfGen, stockCfg.BarcodePrefix,
+	)
+	scanSvc := service.NewScanService(scanRepo, docSvc, docRepo, barcodeRepo, variantRepo, productRepo, whRepo, unitSvc, txManager)
 
 	// --- handlers ---
 	handler.NewProductHandler(productSvc).Register(grp)
 	handler.NewCharacteristicHandler(charactSvc).Register(grp)
 	handler.NewVariantHandler(variantSvc, inventorySvc).Register(grp)
+	handler.NewBarcodeHandler(barcodeSvc).Register(grp)
+	handler.NewBOMHandler(bomSvc).Register(grp)
 	handler.NewPriceTypeHandler(priceTypeSvc).Register(grp)
 	handler.NewPriceHandler(priceSvc).Register(grp)
+	handler.NewCurrencyHandler(currencySvc).Register(grp)
 	handler.NewCategoryHandler(catSvc).Register(grp)
 	handler.NewCounterpartyHandler(cpSvc).Register(grp)
-	handler.NewDocumentHandler(docSvc).Register(grp)
+	handler.NewDocumentHandler(docSvc, idempotencySvc).Register(grp)
+	handler.NewBulkHandler(bulkSvc).Register(grp)
+	handler.NewScanHandler(scanSvc).Register(grp)
+	handler.NewPrintHandler(printSvc, docSvc).Register(grp)
+	handler.NewApprovalHandler(approvalSvc).Register(grp)
 	handler.NewMovementHandler(movSvc).Register(grp)
+	handler.NewLotHandler(lotSvc).Register(grp)
+	handler.NewSerialHandler(serialSvc).Register(grp)
 	handler.NewUnitHandler(unitSvc).Register(grp)
+	handler.NewVariantUnitHandler(unitSvc).Register(grp)
 	handler.NewWarehouseHandler(whSvc).Register(grp)
+	handler.NewPeriodHandler(periodSvc).Register(grp)
+	handler.NewWriteOffReasonHandler(reasonSvc).Register(grp)
 	handler.NewBalanceHandler(inventorySvc).Register(grp)
+
+	// --- workers ---
+	sweep := stockCfg.ReservationSweepSeconds
+	if sweep <= 0 {
+		sweep = 60
+	}
+	m.reservations = reservationSvc
+	m.sweep = time.Duration(sweep) * time.Second
+}
+
+// StartWorkers запускает фоновое снятие истёкших резервов. Вызывается после RegisterRoutes.
+func (m *Module) StartWorkers() {
+	if m.reservations != nil {
+		m.reservations.StartWorker(m.sweep)
+	}
+}
+
+// StopWorkers останавливает фоновые задачи модуля и дожидается их завершения.
+func (m *Module) StopWorkers() {
+	if m.reservations != nil {
+		m.reservations.StopWorker()
+	}
 }
 
 func (m *Module) Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	// точность единиц появилась позже самих единиц: при её добавлении штучным единицам ставим целые количества
+	unitsHadPrecision := !db.Migrator().HasTable(&models.Unit{}) || db.Migrator().HasColumn(&models.Unit{}, "precision")
+	err := db.AutoMigrate(
 		&models.Product{},
 		&models.Variant{},
+		&models.BOMComponent{},
 		&models.CharacteristicType{},
 		&models.CharacteristicValue{},
-		&models.StockReservation{},
 		&models.StockLot{},
+		&models.StockInTransit{},
+		&models.SerialUnit{},
+		&models.SerialMovement{},
 
 		&models.Category{},
 		&models.Counterparty{},
 		&models.Document{},
 		&models.DocumentItem{},
 		&models.ItemPrice{},
+		&models.ItemPriceHistory{},
+		&models.ExchangeRate{},
 		&models.PriceType{},
 		&models.StockMovement{},
 		&models.Unit{},
+		&models.VariantUnit{},
 		&models.Warehouse{},
+		&models.WriteOffReason{},
 		&models.StockBalance{},
 		&models.DocumentHistory{},
+		&models.ReservationLine{},
+		&models.IdempotencyRecord{},
+		&models.ApprovalRule{},
 		&models.DocumentSequence{},
+		&models.PeriodClose{},
+		&models.PeriodBalance{},
 		&models.ProductImage{},
+		&models.Barcode{},
+		&models.ScanSession{},
+		&models.ScanEvent{},
 	)
+	if err != nil {
+		return err
+	}
+	if err := backfillDocumentDates(db); err != nil {
+		return err
+	}
+	if err := backfillReservationLines(db); err != nil {
+		return err
+	}
+	if !unitsHadPrecision {
+		if err := backfillUnitPrecision(db); err != nil {
+			return err
+		}
+	}
+	return seedWriteOffReasons(db)
+}
+
+// validBarcodePrefix - префикс внутреннего диапазона EAN: цифры, и под порядковый номер остаётся не меньше 5 знаков.
+func validBarcodePrefix(prefix string) bool {
+	if len(prefix) < 2 || len(prefix) > 7 {
+		return false
+	}
+	for _, r := range prefix {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// seedWriteOffReasons заводит стандартные причины списания, если их ещё нет.
+func seedWriteOffReasons(db *gorm.DB) error {
+	defaults := []models.WriteOffReason{
+		{Code: "DAMAGE", Name: "Порча"},
+		{Code: "EXPIRED", Name: "Истёк срок годности"},
+		{Code: "THEFT", Name: "Кража"},
+		{Code: "SAMPLES", Name: "Образцы"},
+	}
+	for _, reason := range defaults {
+		if err := db.Where(models.WriteOffReason{Code: reason.Code}).FirstOrCreate(&reason).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countableUnits - единицы, которые до появления точности заводились без неё, но дробными не бывают.
+var countableUnits = []string{"шт", "шт.", "штука", "пара", "упак", "компл", "pcs"}
+
+// backfillUnitPrecision запрещает дробные количества в штучных единицах. Выполняется один раз, при добавлении
+// колонки точности: остальные единицы получают точность по умолчанию, и её меняют в справочнике.
+func backfillUnitPrecision(db *gorm.DB) error {
+	return db.Model(&models.Unit{}).Where("name IN ?", countableUnits).Update("precision", 0).Error
+}
+
+// backfillDocumentDates - документам и движениям, созданным до появления учётной даты, ставит дату создания.
+func backfillDocumentDates(db *gorm.DB) error {
+	if err := db.Exec("UPDATE documents SET document_date = created_at WHERE document_date IS NULL").Error; err != nil {
+		return err
+	}
+	return db.Exec("UPDATE stock_movements SET document_date = created_at WHERE document_date IS NULL").Error
+}
+
+// backfillReservationLines переносит резервы проведённых заказов в построчные, пока строк резерва ещё нет.
+// Отгрузки до появления строк резерва в shipped_quantity не попадали: сначала восстанавливаем их по проведённым
+// расходам на основании заказа, иначе отгруженные заказы снова зарезервировали бы всё заказанное.
+// Пустая таблица строк бывает и после того, как фоновая задача сняла все истёкшие резервы, поэтому заказы
+// с прошедшим сроком резерва или уже снятым резервом не переносятся: перенос повторно их не воскрешает.
+func backfillReservationLines(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&models.ReservationLine{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	const pending = `UPPER(d.type) = 'ORDER' AND d.status = 'posted' AND d.warehouse_id IS NOT NULL
+		AND (d.reserved_until IS NULL OR d.reserved_until > ?)
+		AND NOT EXISTS (SELECT 1 FROM document_histories h WHERE h.document_id = d.id AND h.action = 'reservation_released')`
+	now := time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var orders []models.Document
+		if err := tx.Table("documents AS d").Preload("Items").Where(pending, now).Find(&orders).Error; err != nil {
+			return err
+		}
+		for i := range orders {
+			if err := backfillOrderShipments(tx, &orders[i]); err != nil {
+				return err
+			}
+		}
+		return tx.Exec(`INSERT INTO reservation_lines (document_id, document_item_id, warehouse_id, variant_id, quantity, expires_at, created_at)
+			SELECT d.id, i.id, d.warehouse_id, i.item_id, i.quantity - i.shipped_quantity, d.reserved_until, COALESCE(d.posted_at, d.created_at)
+			FROM documents d JOIN document_items i ON i.document_id = d.id
+			WHERE `+pending+` AND i.quantity > i.shipped_quantity`, now).Error
+	})
+}
+
+// backfillOrderShipments раскладывает проведённые расходы по заказу на его строки и обновляет статус выполнения.
+func backfillOrderShipments(tx *gorm.DB, order *models.Document) error {
+	var shipments []models.Document
+	if err := tx.Preload("Items").
+		Where("base_document_id = ? AND UPPER(type) = 'OUTCOME' AND status = 'posted'", order.ID).
+		Find(&shipments).Error; err != nil {
+		return err
+	}
+	shipped := make(map[uint]decimal.Decimal)
+	for _, shipment := range shipments {
+		for _, item := range shipment.Items {
+			shipped[item.VariantID] = shipped[item.VariantID].Add(item.Quantity)
+		}
+	}
+
+	anyShipped, allShipped := false, true
+	for _, item := range order.Items {
+		qty := decimal.Min(shipped[item.VariantID], item.Quantity)
+		shipped[item.VariantID] = shipped[item.VariantID].Sub(qty)
+		if qty.IsPositive() {
+			anyShipped = true
+		}
+		if qty.LessThan(item.Quantity) {
+			allShipped = false
+		}
+		if err := tx.Model(&models.DocumentItem{}).Where("id = ?", item.ID).
+			Update("shipped_quantity", qty).Error; err != nil {
+			return err
+		}
+	}
+
+	status := "open"
+	switch {
+	case anyShipped && allShipped:
+		status = "fulfilled"
+	case anyShipped:
+		status = "partially_shipped"
+	}
+	return tx.Model(&models.Document{}).Where("id = ?", order.ID).Update("fulfillment_status", status).Error
 }
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+type ApprovalRuleRepository interface {
+	Create(rule *models.ApprovalRule) (*models.ApprovalRule, error)
+	GetByID(id uint) (*models.ApprovalRule, error)
+	List() ([]models.ApprovalRule, error)
+	Update(rule *models.ApprovalRule) (*models.ApprovalRule, error)
+	Delete(id uint) error
+	ListActiveByType(docType string) ([]models.ApprovalRule, error)
+}
+
+type approvalRuleRepo struct{ db *gorm.DB }
+
+func NewApprovalRuleRepository(db *gorm.DB) ApprovalRuleRepository { return &approvalRuleRepo{db: db} }
+
+func (r *approvalRuleRepo) Create(rule *models.ApprovalRule) (*models.ApprovalRule, error) {
+	err := r.db.Create(rule).Error
+	return rule, err
+}
+
+func (r *approvalRuleRepo) GetByID(id uint) (*models.ApprovalRule, error) {
+	var rule models.ApprovalRule
+	if err := r.db.First(&rule, id).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *approvalRuleRepo) List() ([]models.ApprovalRule, error) {
+	var rules []models.ApprovalRule
+	err := r.db.Order("document_type asc, min_amount asc").Find(&rules).Error
+	return rules, err
+}
+
+func (r *approvalRuleRepo) Update(rule *models.ApprovalRule) (*models.ApprovalRule, error) {
+	err := r.db.Save(rule).Error
+	return rule, err
+}
+
+func (r *approvalRuleRepo) Delete(id uint) error {
+	return r.db.Delete(&models.ApprovalRule{}, id).Error
+}
+
+func (r *approvalRuleRepo) ListActiveByType(docType string) ([]models.ApprovalRule, error) {
+	var rules []models.ApprovalRule
+	err := r.db.Where("document_type = ? AND active = ?", docType, true).Find(&rules).Error
+	return rules, err
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	stock "github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"gorm.io/gorm"
+)
+
+type BarcodeRepository interface {
+	ListByVariant(variantID uint) ([]stock.Barcode, error)
+	ListByVariants(variantIDs []uint) ([]stock.Barcode, error)
+	// FindByCodeWithTx возвращает nil без ошибки, если такого кода нет.
+	FindByCodeWithTx(tx *gorm.DB, code string) (*stock.Barcode, error)
+	CreateWithTx(tx *gorm.DB, b *stock.Barcode) error
+	Delete(variantID, id uint) (bool, error)
+}
+
+type barcodeRepo struct{ db *gorm.DB }
+
+func NewBarcodeRepository(db *gorm.DB) BarcodeRepository { return &barcodeRepo{db: db} }
+
+func (r *barcodeRepo) ListByVariant(variantID uint) ([]stock.Barcode, error) {
+	return r.ListByVariants([]uint{variantID})
+}
+
+func (r *barcodeRepo) ListByVariants(variantIDs []uint) ([]stock.Barcode, error) {
+	var codes []stock.Barcode
+	if len(variantIDs) == 0 {
+		return codes, nil
+	}
+	err := r.db.Where("variant_id IN ?", variantIDs).Order("variant_id, id").Find(&codes).Error
+	return codes, err
+}
+
+func (r *barcodeRepo) FindByCodeWithTx(tx *gorm.DB, code string) (*stock.Barcode, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	var codes []stock.Barcode
+	if err := db.Where("code = ?", code).Limit(1).Find(&codes).Error; err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	return &codes[0], nil
+}
+
+func (r *barcodeRepo) CreateWithTx(tx *gorm.DB, b *stock.Barcode) error {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	return db.Create(b).Error
+}
+
+func (r *barcodeRepo) Delete(variantID, id uint) (bool, error) {
+	res := r.db.Where("variant_id = ?", variantID).Delete(&stock.Barcode{}, id)
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	stock "github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"gorm.io/gorm"
+)
+
+type BOMRepository interface {
+	ListByKit(kitID uint) ([]stock.BOMComponent, error)
+	ListByKitWithTx(tx *gorm.DB, kitID uint) ([]stock.BOMComponent, error)
+	// ReplaceForKit заменяет всю спецификацию комплекта.
+	ReplaceForKit(kitID uint, components []stock.BOMComponent) error
+}
+
+type bomRepo struct{ db *gorm.DB }
+
+func NewBOMRepository(db *gorm.DB) BOMRepository { return &bomRepo{db: db} }
+
+func (r *bomRepo) ListByKit(kitID uint) ([]stock.BOMComponent, error) {
+	return r.ListByKitWithTx(nil, kitID)
+}
+
+func (r *bomRepo) ListByKitWithTx(tx *gorm.DB, kitID uint) ([]stock.BOMComponent, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	var components []stock.BOMComponent
+	err := db.Where("kit_variant_id = ?", kitID).Order("id").Find(&components).Error
+	return components, err
+}
+
+func (r *bomRepo) ReplaceForKit(kitID uint, components []stock.BOMComponent) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("kit_variant_id = ?", kitID).Delete(&stock.BOMComponent{}).Error; err != nil {
+			return err
+		}
+		if len(components) == 0 {
+			return nil
+		}
+		return tx.Create(&components).Error
+	})
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+type CurrencyRepository interface {
+	UpsertRates(rates []models.ExchangeRate) error
+	// GetRate возвращает последний курс валюты, установленный не позже даты at.
+	GetRate(currency string, at time.Time) (*models.ExchangeRate, error)
+	ListRates(currency string) ([]models.ExchangeRate, error)
+}
+
+type currencyRepo struct {
+	db *gorm.DB
+}
+
+func NewCurrencyRepository(db *gorm.DB) CurrencyRepository {
+	return &currencyRepo{db: db}
+}
+
+func (r *currencyRepo) UpsertRates(rates []models.ExchangeRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "currency"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate"}),
+	}).Create(&rates).Error
+}
+
+func (r *currencyRepo) GetRate(currency string, at time.Time) (*models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+	err := r.db.Where("currency = ? AND date <= ?", currency, at).Order("date desc").First(&rate).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rate, nil
+}
+
+func (r *currencyRepo) ListRates(currency string) ([]models.ExchangeRate, error) {
+	var rates []models.ExchangeRate
+	query := r.db.Order("currency asc, date desc")
+	if currency != "" {
+		query = query.Where("currency = ?", currency)
+	}
+	err := query.Find(&rates).Error
+	return rates, err
+}
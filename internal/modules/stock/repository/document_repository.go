@@ -3,6 +3,7 @@ package repository
 import (
 	"strings"
 
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
@@ -22,8 +23,12 @@ type DocumentRepository interface {
 	UpdateWithTx(tx *gorm.DB, doc *stock.Document) (*stock.Document, error)
 	CreateWithTx(tx *gorm.DB, doc *stock.Document) (*stock.Document, error)
 	GetByIDWithTx(tx *gorm.DB, id uint) (*stock.Document, error)
+	DeleteWithTx(tx *gorm.DB, id uint) error
 
 	GetByIDs(ids []uint) ([]stock.Document, error)
+	ListByBaseDocument(baseID uint) ([]stock.Document, error)
+	ListByBaseDocumentWithTx(tx *gorm.DB, baseID uint) ([]stock.Document, error)
+	UpdateItemShippedWithTx(tx *gorm.DB, itemID uint, shipped decimal.Decimal) error
 
 	Search(filter stock.DocumentFilter) ([]stock.Document, error)
 }
@@ -105,6 +110,7 @@ func (r *documentRepo) List() ([]stock.Document, error) {
 }
 
 func (r *documentRepo) Update(doc *stock.Document) (*stock.Document, error) {
+	doc.Version++
 	if err := r.db.Save(doc).Error; err != nil {
 		return nil, err
 	}
@@ -115,6 +121,7 @@ func (r *documentRepo) UpdateWithTx(tx *gorm.DB, doc *stock.Document) (*stock.Do
 	if tx == nil {
 		tx = r.db
 	}
+	doc.Version++
 	if err := tx.Save(doc).Error; err != nil {
 		return nil, err
 	}
@@ -122,7 +129,14 @@ func (r *documentRepo) UpdateWithTx(tx *gorm.DB, doc *stock.Document) (*stock.Do
 }
 
 func (r *documentRepo) Delete(id uint) error {
-	return r.db.Delete(&stock.Document{}, id).Error
+	return r.DeleteWithTx(nil, id)
+}
+
+func (r *documentRepo) DeleteWithTx(tx *gorm.DB, id uint) error {
+	if tx == nil {
+		tx = r.db
+	}
+	return tx.Delete(&stock.Document{}, id).Error
 }
 
 func (r *documentRepo) GetByIDs(ids []uint) ([]stock.Document, error) {
@@ -134,6 +148,26 @@ func (r *documentRepo) GetByIDs(ids []uint) ([]stock.Document, error) {
 	return documents, err
 }
 
+func (r *documentRepo) ListByBaseDocument(baseID uint) ([]stock.Document, error) {
+	return r.ListByBaseDocumentWithTx(nil, baseID)
+}
+
+func (r *documentRepo) ListByBaseDocumentWithTx(tx *gorm.DB, baseID uint) ([]stock.Document, error) {
+	if tx == nil {
+		tx = r.db
+	}
+	var docs []stock.Document
+	err := tx.Preload("Items").Where("base_document_id = ?", baseID).Order("id").Find(&docs).Error
+	return docs, err
+}
+
+func (r *documentRepo) UpdateItemShippedWithTx(tx *gorm.DB, itemID uint, shipped decimal.Decimal) error {
+	if tx == nil {
+		tx = r.db
+	}
+	return tx.Model(&stock.DocumentItem{}).Where("id = ?", itemID).Update("shipped_quantity", shipped).Error
+}
+
 func (r *documentRepo) Search(filter stock.DocumentFilter) ([]stock.Document, error) {
 	var docs []stock.Document
 	query := r.db.Model(&stock.Document{}).Preload("Items")
@@ -147,11 +181,15 @@ func (r *documentRepo) Search(filter stock.DocumentFilter) ([]stock.Document, er
 	}
 
 	if filter.DateFrom != nil {
-		query = query.Where("created_at >= ?", *filter.DateFrom)
+		query = query.Where("document_date >= ?", *filter.DateFrom)
 	}
 
 	if filter.DateTo != nil {
-		query = query.Where("created_at <= ?", *filter.DateTo)
+		query = query.Where("document_date <= ?", *filter.DateTo)
+	}
+
+	if filter.WarehouseIDs != nil {
+		query = query.Where("warehouse_id IS NULL OR warehouse_id IN ? OR to_warehouse_id IN ?", filter.WarehouseIDs, filter.WarehouseIDs)
 	}
 
 	if filter.Search != nil {
@@ -166,6 +204,6 @@ func (r *documentRepo) Search(filter stock.DocumentFilter) ([]stock.Document, er
 		query = query.Offset(filter.Offset)
 	}
 
-	err := query.Order("created_at desc").Find(&docs).Error
+	err := query.Order("document_date desc, id desc").Find(&docs).Error
 	return docs, err
 }
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	stock "github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+type IdempotencyRepository interface {
+	// Find возвращает запись ключа пользователя или nil, если её нет.
+	Find(userID uint, key string) (*stock.IdempotencyRecord, error)
+	Create(rec *stock.IdempotencyRecord) error
+	Save(rec *stock.IdempotencyRecord) error
+	Delete(id uint) error
+	DeleteExpired(now time.Time) error
+}
+
+type idempotencyRepo struct{ db *gorm.DB }
+
+func NewIdempotencyRepository(db *gorm.DB) IdempotencyRepository { return &idempotencyRepo{db: db} }
+
+func (r *idempotencyRepo) Find(userID uint, key string) (*stock.IdempotencyRecord, error) {
+	var records []stock.IdempotencyRecord
+	if err := r.db.Where("user_id = ? AND key = ?", userID, key).Limit(1).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+func (r *idempotencyRepo) Create(rec *stock.IdempotencyRecord) error {
+	return r.db.Create(rec).Error
+}
+
+func (r *idempotencyRepo) Save(rec *stock.IdempotencyRecord) error {
+	return r.db.Save(rec).Error
+}
+
+func (r *idempotencyRepo) Delete(id uint) error {
+	return r.db.Delete(&stock.IdempotencyRecord{}, id).Error
+}
+
+func (r *idempotencyRepo) DeleteExpired(now time.Time) error {
+	return r.db.Where("expires_at <= ?", now).Delete(&stock.IdempotencyRecord{}).Error
+}
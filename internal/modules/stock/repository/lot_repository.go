@@ -1,32 +1,59 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+// LotOrder задаёт порядок, в котором партии уходят в расход.
+type LotOrder string
+
+const (
+	LotOrderFIFO LotOrder = "fifo" // сначала самые старые поступления
+	LotOrderLIFO LotOrder = "lifo" // сначала самые свежие поступления
+	LotOrderFEFO LotOrder = "fefo" // сначала партии с ближайшим сроком годности, без срока - в конце
+)
+
 type LotRepository interface {
-	GetOldestLotsForUpdate(tx *gorm.DB, warehouseID, variantID uint) ([]models.StockLot, error)
+	GetLotsForUpdate(tx *gorm.DB, warehouseID, variantID uint, order LotOrder) ([]models.StockLot, error)
 	GetLotByIDForUpdate(tx *gorm.DB, lotID uint) (*models.StockLot, error)
+	ListByIncomeDocumentForUpdate(tx *gorm.DB, docID, warehouseID, variantID uint) ([]models.StockLot, error)
 	CreateWithTx(tx *gorm.DB, lot *models.StockLot) error
 	SaveWithTx(tx *gorm.DB, lot *models.StockLot) error
 	DeleteWithTx(tx *gorm.DB, lotIDs []uint) error
 	DeleteByIncomeDocumentID(tx *gorm.DB, docID uint) error
+
+	GetByID(id uint) (*models.StockLot, error)
+	Search(filter models.LotFilter) ([]models.StockLot, error)
+	ListByBatch(variantID uint, batchNumber string) ([]models.StockLot, error)
 }
 
-type lotRepo struct{}
+type lotRepo struct {
+	db *gorm.DB
+}
 
-func NewLotRepository() LotRepository {
-	return &lotRepo{}
+func NewLotRepository(db *gorm.DB) LotRepository {
+	return &lotRepo{db: db}
 }
 
-func (r *lotRepo) GetOldestLotsForUpdate(tx *gorm.DB, warehouseID, variantID uint) ([]models.StockLot, error) {
+func (r *lotRepo) GetLotsForUpdate(tx *gorm.DB, warehouseID, variantID uint, order LotOrder) ([]models.StockLot, error) {
 	var lots []models.StockLot
-	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-		Where("warehouse_id = ? AND variant_id = ? AND current_quantity > 0", warehouseID, variantID).
-		Order("arrival_date asc, id asc").
-		Find(&lots).Error
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("warehouse_id = ? AND variant_id = ? AND current_quantity > 0", warehouseID, variantID)
+
+	switch order {
+	case LotOrderLIFO:
+		query = query.Order("arrival_date desc, id desc")
+	case LotOrderFEFO:
+		query = query.Order("CASE WHEN expiry_date IS NULL THEN 1 ELSE 0 END, expiry_date asc, arrival_date asc, id asc")
+	default:
+		query = query.Order("arrival_date asc, id asc")
+	}
+
+	err := query.Find(&lots).Error
 	return lots, err
 }
 
@@ -36,6 +63,16 @@ func (r *lotRepo) GetLotByIDForUpdate(tx *gorm.DB, lotID uint) (*models.StockLot
 	return &lot, err
 }
 
+// ListByIncomeDocumentForUpdate возвращает непустые партии, созданные документом, в порядке создания.
+func (r *lotRepo) ListByIncomeDocumentForUpdate(tx *gorm.DB, docID, warehouseID, variantID uint) ([]models.StockLot, error) {
+	var lots []models.StockLot
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("income_document_id = ? AND warehouse_id = ? AND variant_id = ? AND current_quantity > 0", docID, warehouseID, variantID).
+		Order("id asc").
+		Find(&lots).Error
+	return lots, err
+}
+
 func (r *lotRepo) CreateWithTx(tx *gorm.DB, lot *models.StockLot) error {
 	return tx.Create(lot).Error
 }
@@ -54,3 +91,54 @@ func (r *lotRepo) DeleteWithTx(tx *gorm.DB, lotIDs []uint) error {
 func (r *lotRepo) DeleteByIncomeDocumentID(tx *gorm.DB, docID uint) error {
 	return tx.Where("income_document_id = ?", docID).Delete(&models.StockLot{}).Error
 }
+
+func (r *lotRepo) GetByID(id uint) (*models.StockLot, error) {
+	var lot models.StockLot
+	if err := r.db.First(&lot, id).Error; err != nil {
+		return nil, err
+	}
+	return &lot, nil
+}
+
+func (r *lotRepo) Search(filter models.LotFilter) ([]models.StockLot, error) {
+	var lots []models.StockLot
+	query := r.db.Model(&models.StockLot{})
+
+	if filter.WarehouseID != nil {
+		query = query.Where("warehouse_id = ?", *filter.WarehouseID)
+	}
+	if filter.WarehouseIDs != nil {
+		query = query.Where("warehouse_id IN ?", filter.WarehouseIDs)
+	}
+	if filter.VariantID != nil {
+		query = query.Where("variant_id = ?", *filter.VariantID)
+	}
+	if filter.BatchNumber != nil {
+		query = query.Where("batch_number = ?", *filter.BatchNumber)
+	}
+	if filter.ExpiresWithinDays != nil {
+		deadline := time.Now().AddDate(0, 0, *filter.ExpiresWithinDays)
+		query = query.Where("expiry_date IS NOT NULL AND expiry_date <= ?", deadline)
+	}
+	if !filter.IncludeEmpty {
+		query = query.Where("current_quantity > 0")
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	err := query.Order("CASE WHEN expiry_date IS NULL THEN 1 ELSE 0 END, expiry_date asc, arrival_date asc, id asc").Find(&lots).Error
+	return lots, err
+}
+
+func (r *lotRepo) ListByBatch(variantID uint, batchNumber string) ([]models.StockLot, error) {
+	var lots []models.StockLot
+	err := r.db.Where("variant_id = ? AND batch_number = ?", variantID, batchNumber).
+		Order("arrival_date asc, id asc").
+		Find(&lots).Error
+	return lots, err
+}
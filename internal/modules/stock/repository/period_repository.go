@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	stock "github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+type PeriodRepository interface {
+	CreateWithTx(tx *gorm.DB, p *stock.PeriodClose) error
+	SaveWithTx(tx *gorm.DB, p *stock.PeriodClose) error
+	GetByID(id uint) (*stock.PeriodClose, error)
+	// List возвращает закрытия без остатков, новые первыми; warehouseID ограничивает выборку складом и общими закрытиями.
+	List(warehouseID *uint) ([]stock.PeriodClose, error)
+	// LatestWithTx - действующее закрытие ровно этой области: общее при warehouseID == nil или склада.
+	LatestWithTx(tx *gorm.DB, warehouseID *uint) (*stock.PeriodClose, error)
+	// LockWithTx - самое позднее действующее закрытие, которое распространяется на любой из складов (включая общие).
+	LockWithTx(tx *gorm.DB, warehouseIDs []uint) (*stock.PeriodClose, error)
+	// ClosingBalancesWithTx считает остатки и их оценку по движениям с датой документа раньше before.
+	ClosingBalancesWithTx(tx *gorm.DB, warehouseID *uint, before time.Time) ([]stock.PeriodBalance, error)
+}
+
+type periodRepo struct{ db *gorm.DB }
+
+func NewPeriodRepository(db *gorm.DB) PeriodRepository { return &periodRepo{db: db} }
+
+func (r *periodRepo) CreateWithTx(tx *gorm.DB, p *stock.PeriodClose) error {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	return db.Create(p).Error
+}
+
+func (r *periodRepo) SaveWithTx(tx *gorm.DB, p *stock.PeriodClose) error {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	return db.Omit("Balances").Save(p).Error
+}
+
+func (r *periodRepo) GetByID(id uint) (*stock.PeriodClose, error) {
+	var p stock.PeriodClose
+	if err := r.db.Preload("Balances", func(db *gorm.DB) *gorm.DB {
+		return db.Order("warehouse_id, variant_id")
+	}).First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *periodRepo) List(warehouseID *uint) ([]stock.PeriodClose, error) {
+	query := r.db.Model(&stock.PeriodClose{})
+	if warehouseID != nil {
+		query = query.Where("warehouse_id IS NULL OR warehouse_id = ?", *warehouseID)
+	}
+	var periods []stock.PeriodClose
+	err := query.Order("lock_date desc, id desc").Find(&periods).Error
+	return periods, err
+}
+
+func (r *periodRepo) LatestWithTx(tx *gorm.DB, warehouseID *uint) (*stock.PeriodClose, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	query := db.Where("status = ?", "closed")
+	if warehouseID == nil {
+		query = query.Where("warehouse_id IS NULL")
+	} else {
+		query = query.Where("warehouse_id = ?", *warehouseID)
+	}
+	return r.first(query)
+}
+
+func (r *periodRepo) LockWithTx(tx *gorm.DB, warehouseIDs []uint) (*stock.PeriodClose, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	query := db.Where("status = ?", "closed")
+	if len(warehouseIDs) > 0 {
+		query = query.Where("warehouse_id IS NULL OR warehouse_id IN ?", warehouseIDs)
+	} else {
+		query = query.Where("warehouse_id IS NULL")
+	}
+	return r.first(query)
+}
+
+func (r *periodRepo) first(query *gorm.DB) (*stock.PeriodClose, error) {
+	var periods []stock.PeriodClose
+	if err := query.Order("lock_date desc, id desc").Limit(1).Find(&periods).Error; err != nil {
+		return nil, err
+	}
+	if len(periods) == 0 {
+		return nil, nil
+	}
+	return &periods[0], nil
+}
+
+func (r *periodRepo) ClosingBalancesWithTx(tx *gorm.DB, warehouseID *uint, before time.Time) ([]stock.PeriodBalance, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	query := db.Model(&stock.StockMovement{}).
+		Select("warehouse_id, item_id AS variant_id, SUM(quantity) AS quantity, SUM(quantity * unit_cost) AS value").
+		Where("document_date < ?", before)
+	if warehouseID != nil {
+		query = query.Where("warehouse_id = ?", *warehouseID)
+	}
+	var balances []stock.PeriodBalance
+	err := query.Group("warehouse_id, item_id").
+		Having("SUM(quantity) <> 0 OR SUM(quantity * unit_cost) <> 0").
+		Order("warehouse_id, item_id").
+		Scan(&balances).Error
+	return balances, err
+}
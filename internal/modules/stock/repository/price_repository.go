@@ -12,6 +12,17 @@ import (
 type PriceRepository interface {
 	UpsertPrices(tx *gorm.DB, prices []models.ItemPrice) error
 	GetPrice(itemID, priceTypeID uint) (*models.ItemPrice, error)
+	GetPriceWithTx(tx *gorm.DB, itemID, priceTypeID uint) (*models.ItemPrice, error)
+	DeletePriceWithTx(tx *gorm.DB, itemID, priceTypeID uint) error
+
+	CreateHistoryWithTx(tx *gorm.DB, rows []models.ItemPriceHistory) error
+	SaveHistoryWithTx(tx *gorm.DB, row *models.ItemPriceHistory) error
+	ListHistoryByDocumentWithTx(tx *gorm.DB, docID uint) ([]models.ItemPriceHistory, error)
+	// ListLaterChangesWithTx возвращает неотменённые изменения цены, сделанные после записи afterID.
+	ListLaterChangesWithTx(tx *gorm.DB, itemID, priceTypeID, afterID uint) ([]models.ItemPriceHistory, error)
+	// GetEffectiveHistory возвращает запись истории, действующую на дату at.
+	GetEffectiveHistory(itemID, priceTypeID uint, at time.Time) (*models.ItemPriceHistory, error)
+	ListHistory(itemID, priceTypeID uint) ([]models.ItemPriceHistory, error)
 }
 
 type priceRepo struct {
@@ -39,8 +50,12 @@ func (r *priceRepo) UpsertPrices(tx *gorm.DB, prices []models.ItemPrice) error {
 }
 
 func (r *priceRepo) GetPrice(itemID, priceTypeID uint) (*models.ItemPrice, error) {
+	return r.GetPriceWithTx(r.db, itemID, priceTypeID)
+}
+
+func (r *priceRepo) GetPriceWithTx(tx *gorm.DB, itemID, priceTypeID uint) (*models.ItemPrice, error) {
 	var price models.ItemPrice
-	err := r.db.Where("item_id = ? AND price_type_id = ?", itemID, priceTypeID).First(&price).Error
+	err := tx.Where("item_id = ? AND price_type_id = ?", itemID, priceTypeID).First(&price).Error
 
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -51,3 +66,53 @@ func (r *priceRepo) GetPrice(itemID, priceTypeID uint) (*models.ItemPrice, error
 
 	return &price, nil
 }
+
+func (r *priceRepo) DeletePriceWithTx(tx *gorm.DB, itemID, priceTypeID uint) error {
+	return tx.Where("item_id = ? AND price_type_id = ?", itemID, priceTypeID).Delete(&models.ItemPrice{}).Error
+}
+
+func (r *priceRepo) CreateHistoryWithTx(tx *gorm.DB, rows []models.ItemPriceHistory) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.Create(&rows).Error
+}
+
+func (r *priceRepo) SaveHistoryWithTx(tx *gorm.DB, row *models.ItemPriceHistory) error {
+	return tx.Save(row).Error
+}
+
+func (r *priceRepo) ListHistoryByDocumentWithTx(tx *gorm.DB, docID uint) ([]models.ItemPriceHistory, error) {
+	var rows []models.ItemPriceHistory
+	err := tx.Where("document_id = ? AND reverted_at IS NULL", docID).Order("id asc").Find(&rows).Error
+	return rows, err
+}
+
+func (r *priceRepo) ListLaterChangesWithTx(tx *gorm.DB, itemID, priceTypeID, afterID uint) ([]models.ItemPriceHistory, error) {
+	var rows []models.ItemPriceHistory
+	err := tx.Where("variant_id = ? AND price_type_id = ? AND id > ? AND reverted_at IS NULL", itemID, priceTypeID, afterID).
+		Order("id asc").Find(&rows).Error
+	return rows, err
+}
+
+func (r *priceRepo) GetEffectiveHistory(itemID, priceTypeID uint, at time.Time) (*models.ItemPriceHistory, error) {
+	var row models.ItemPriceHistory
+	err := r.db.Where("variant_id = ? AND price_type_id = ? AND reverted_at IS NULL", itemID, priceTypeID).
+		Where("effective_from <= ? AND (effective_to IS NULL OR effective_to > ?)", at, at).
+		Order("effective_from desc, id desc").
+		First(&row).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &row, nil
+}
+
+func (r *priceRepo) ListHistory(itemID, priceTypeID uint) ([]models.ItemPriceHistory, error) {
+	var rows []models.ItemPriceHistory
+	err := r.db.Where("variant_id = ? AND price_type_id = ?", itemID, priceTypeID).
+		Order("effective_from asc, id asc").Find(&rows).Error
+	return rows, err
+}
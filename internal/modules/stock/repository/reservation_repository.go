@@ -1,14 +1,26 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
 type ReservationRepository interface {
-	GetReservationWithTx(tx *gorm.DB, warehouseID, variantID uint) (*models.StockReservation, error)
-	SaveReservationWithTx(tx *gorm.DB, r *models.StockReservation) error
+	// ReservedQuantityWithTx - сумма действующих (не истёкших на момент now) резервов варианта на складе.
+	ReservedQuantityWithTx(tx *gorm.DB, warehouseID, variantID uint, now time.Time) (decimal.Decimal, error)
+	ListByDocumentWithTx(tx *gorm.DB, docID uint) ([]models.ReservationLine, error)
+	// ListActiveByVariant - действующие резервы варианта на всех складах вместе с заказом и контрагентом.
+	ListActiveByVariant(variantID uint, now time.Time) ([]models.ReservationLine, error)
+	// ListExpiredDocumentIDs - заказы, у которых есть резервы с истёкшим сроком.
+	ListExpiredDocumentIDs(now time.Time) ([]uint, error)
+	CreateWithTx(tx *gorm.DB, line *models.ReservationLine) error
+	SaveWithTx(tx *gorm.DB, line *models.ReservationLine) error
+	DeleteWithTx(tx *gorm.DB, ids []uint) error
+	DeleteByDocumentWithTx(tx *gorm.DB, docID uint) error
 }
 
 type reservationRepo struct{ db *gorm.DB }
@@ -17,30 +29,87 @@ func NewReservationRepository(db *gorm.DB) ReservationRepository {
 	return &reservationRepo{db: db}
 }
 
-func (r *reservationRepo) GetReservationWithTx(tx *gorm.DB, warehouseID, variantID uint) (*models.StockReservation, error) {
+func activeReservations(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Where("quantity > 0 AND (expires_at IS NULL OR expires_at > ?)", now)
+}
+
+func (r *reservationRepo) ReservedQuantityWithTx(tx *gorm.DB, warehouseID, variantID uint, now time.Time) (decimal.Decimal, error) {
 	db := r.db
 	if tx != nil {
 		db = tx
 	}
-	var res models.StockReservation
-	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
-		// Where("warehouse_id = ? AND item_id = ?", warehouseID, variantID).
+	var lines []models.ReservationLine
+	err := activeReservations(db, now).
 		Where("warehouse_id = ? AND variant_id = ?", warehouseID, variantID).
-		First(&res).Error
-
+		Find(&lines).Error
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, nil
-		}
-		return nil, err
+		return decimal.Zero, err
+	}
+	total := decimal.Zero
+	for _, line := range lines {
+		total = total.Add(line.Quantity)
+	}
+	return total, nil
+}
+
+func (r *reservationRepo) ListByDocumentWithTx(tx *gorm.DB, docID uint) ([]models.ReservationLine, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	var lines []models.ReservationLine
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("document_id = ?", docID).Order("id").Find(&lines).Error
+	return lines, err
+}
+
+func (r *reservationRepo) ListActiveByVariant(variantID uint, now time.Time) ([]models.ReservationLine, error) {
+	var lines []models.ReservationLine
+	err := activeReservations(r.db.Preload("Document.Counterparty"), now).
+		Where("variant_id = ?", variantID).
+		Order("warehouse_id, document_id, id").Find(&lines).Error
+	return lines, err
+}
+
+func (r *reservationRepo) ListExpiredDocumentIDs(now time.Time) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.ReservationLine{}).
+		Where("expires_at IS NOT NULL AND expires_at <= ?", now).
+		Distinct().Order("document_id").Pluck("document_id", &ids).Error
+	return ids, err
+}
+
+func (r *reservationRepo) CreateWithTx(tx *gorm.DB, line *models.ReservationLine) error {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	return db.Create(line).Error
+}
+
+func (r *reservationRepo) SaveWithTx(tx *gorm.DB, line *models.ReservationLine) error {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	return db.Save(line).Error
+}
+
+func (r *reservationRepo) DeleteWithTx(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db := r.db
+	if tx != nil {
+		db = tx
 	}
-	return &res, nil
+	return db.Delete(&models.ReservationLine{}, ids).Error
 }
 
-func (r *reservationRepo) SaveReservationWithTx(tx *gorm.DB, res *models.StockReservation) error {
+func (r *reservationRepo) DeleteByDocumentWithTx(tx *gorm.DB, docID uint) error {
 	db := r.db
 	if tx != nil {
 		db = tx
 	}
-	return db.Save(res).Error
+	return db.Where("document_id = ?", docID).Delete(&models.ReservationLine{}).Error
 }
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+type ScanSessionRepository interface {
+	CreateWithTx(tx *gorm.DB, session *models.ScanSession) error
+	GetByID(id uint) (*models.ScanSession, error)
+	// GetForUpdateWithTx блокирует сеанс до конца транзакции, чтобы сканы с разных соединений шли по очереди.
+	GetForUpdateWithTx(tx *gorm.DB, id uint) (*models.ScanSession, error)
+	List(status string, warehouseID *uint) ([]models.ScanSession, error)
+	UpdateWithTx(tx *gorm.DB, session *models.ScanSession) error
+	DeleteWithTx(tx *gorm.DB, id uint) error
+
+	// FindEventWithTx возвращает nil без ошибки, если событие с таким ключом в сеансе не записано.
+	FindEventWithTx(tx *gorm.DB, sessionID uint, eventID string) (*models.ScanEvent, error)
+	CreateEventWithTx(tx *gorm.DB, event *models.ScanEvent) error
+	CountEvents(sessionID uint) (int64, error)
+	ListEvents(sessionID uint, results ...string) ([]models.ScanEvent, error)
+}
+
+type scanSessionRepo struct{ db *gorm.DB }
+
+func NewScanSessionRepository(db *gorm.DB) ScanSessionRepository {
+	return &scanSessionRepo{db: db}
+}
+
+func (r *scanSessionRepo) CreateWithTx(tx *gorm.DB, session *models.ScanSession) error {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	return db.Create(session).Error
+}
+
+func (r *scanSessionRepo) GetByID(id uint) (*models.ScanSession, error) {
+	return r.get(r.db, id)
+}
+
+func (r *scanSessionRepo) GetForUpdateWithTx(tx *gorm.DB, id uint) (*models.ScanSession, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	return r.get(db.Clauses(clause.Locking{Strength: "UPDATE"}), id)
+}
+
+func (r *scanSessionRepo) get(db *gorm.DB, id uint) (*models.ScanSession, error) {
+	var sessions []models.ScanSession
+	if err := db.Where("id = ?", id).Limit(1).Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+	return &sessions[0], nil
+}
+
+func (r *scanSessionRepo) List(status string, warehouseID *uint) ([]models.ScanSession, error) {
+	var sessions []models.ScanSession
+	q := r.db.Model(&models.ScanSession{})
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if warehouseID != nil {
+		q = q.Where("warehouse_id = ?", *warehouseID)
+	}
+	err := q.Order("updated_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *scanSessionRepo) UpdateWithTx(tx *gorm.DB, session *models.ScanSession) error {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	return db.Save(session).Error
+}
+
+func (r *scanSessionRepo) DeleteWithTx(tx *gorm.DB, id uint) error {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	if err := db.Where("session_id = ?", id).Delete(&models.ScanEvent{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(&models.ScanSession{}, id).Error
+}
+
+func (r *scanSessionRepo) FindEventWithTx(tx *gorm.DB, sessionID uint, eventID string) (*models.ScanEvent, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	var events []models.ScanEvent
+	if err := db.Where("session_id = ? AND event_id = ?", sessionID, eventID).Limit(1).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	return &events[0], nil
+}
+
+func (r *scanSessionRepo) CreateEventWithTx(tx *gorm.DB, event *models.ScanEvent) error {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	return db.Create(event).Error
+}
+
+func (r *scanSessionRepo) CountEvents(sessionID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.ScanEvent{}).Where("session_id = ?", sessionID).Count(&count).Error
+	return count, err
+}
+
+func (r *scanSessionRepo) ListEvents(sessionID uint, results ...string) ([]models.ScanEvent, error) {
+	var events []models.ScanEvent
+	q := r.db.Where("session_id = ?", sessionID)
+	if len(results) > 0 {
+		q = q.Where("result IN ?", results)
+	}
+	err := q.Order("id").Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+type SerialRepository interface {
+	GetUnitsForUpdate(tx *gorm.DB, variantID uint, serials []string) ([]models.SerialUnit, error)
+	GetUnitByIDForUpdate(tx *gorm.DB, id uint) (*models.SerialUnit, error)
+	SaveUnitWithTx(tx *gorm.DB, unit *models.SerialUnit) error
+	CreateMovementWithTx(tx *gorm.DB, mv *models.SerialMovement) error
+	ListMovementsByDocumentWithTx(tx *gorm.DB, docID uint) ([]models.SerialMovement, error)
+	GetLastReservationWithTx(tx *gorm.DB, unitID uint) (*models.SerialMovement, error)
+
+	FindBySerial(serial string, variantID *uint) ([]models.SerialUnit, error)
+	ListMovementsByUnit(unitID uint) ([]models.SerialMovement, error)
+}
+
+type serialRepo struct {
+	db *gorm.DB
+}
+
+func NewSerialRepository(db *gorm.DB) SerialRepository {
+	return &serialRepo{db: db}
+}
+
+func (r *serialRepo) GetUnitsForUpdate(tx *gorm.DB, variantID uint, serials []string) ([]models.SerialUnit, error) {
+	var units []models.SerialUnit
+	if len(serials) == 0 {
+		return units, nil
+	}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("variant_id = ? AND serial IN ?", variantID, serials).
+		Find(&units).Error
+	return units, err
+}
+
+func (r *serialRepo) GetUnitByIDForUpdate(tx *gorm.DB, id uint) (*models.SerialUnit, error) {
+	var unit models.SerialUnit
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&unit, id).Error; err != nil {
+		return nil, err
+	}
+	return &unit, nil
+}
+
+func (r *serialRepo) SaveUnitWithTx(tx *gorm.DB, unit *models.SerialUnit) error {
+	return tx.Save(unit).Error
+}
+
+func (r *serialRepo) CreateMovementWithTx(tx *gorm.DB, mv *models.SerialMovement) error {
+	return tx.Create(mv).Error
+}
+
+func (r *serialRepo) ListMovementsByDocumentWithTx(tx *gorm.DB, docID uint) ([]models.SerialMovement, error) {
+	if tx == nil {
+		tx = r.db
+	}
+	var mvs []models.SerialMovement
+	err := tx.Where("document_id = ?", docID).Order("id asc").Find(&mvs).Error
+	return mvs, err
+}
+
+// GetLastReservationWithTx возвращает последнее движение, которым заказ зарезервировал единицу.
+func (r *serialRepo) GetLastReservationWithTx(tx *gorm.DB, unitID uint) (*models.SerialMovement, error) {
+	var mv models.SerialMovement
+	err := tx.Where("serial_unit_id = ? AND type = ? AND to_status = ?", unitID, "ORDER", "reserved").
+		Order("id desc").First(&mv).Error
+	if err != nil {
+		return nil, err
+	}
+	return &mv, nil
+}
+
+func (r *serialRepo) FindBySerial(serial string, variantID *uint) ([]models.SerialUnit, error) {
+	var units []models.SerialUnit
+	query := r.db.Where("serial = ?", serial)
+	if variantID != nil {
+		query = query.Where("variant_id = ?", *variantID)
+	}
+	err := query.Find(&units).Error
+	return units, err
+}
+
+func (r *serialRepo) ListMovementsByUnit(unitID uint) ([]models.SerialMovement, error) {
+	var mvs []models.SerialMovement
+	err := r.db.Where("serial_unit_id = ?", unitID).Order("created_at asc, id asc").Find(&mvs).Error
+	return mvs, err
+}
@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"time"
+
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 
 	stock "github.com/maksroxx/flowkeeper/internal/modules/stock/models"
@@ -16,6 +19,19 @@ type StockMovementRepository interface {
 
 	ListByDocument(docID uint) ([]stock.StockMovement, error)
 	ListByDocumentWithTx(tx *gorm.DB, docID uint) ([]stock.StockMovement, error)
+	// ListActiveByDocumentWithTx - движения документа, которые ещё не сторнированы, без самих сторно.
+	ListActiveByDocumentWithTx(tx *gorm.DB, docID uint) ([]stock.StockMovement, error)
+	// MarkReversedWithTx помечает движение id сторнированным движением cancelID.
+	MarkReversedWithTx(tx *gorm.DB, id, cancelID uint) error
+	// LastUnitCostWithTx - себестоимость последнего поступления варианта, ноль если оценки ещё не было.
+	LastUnitCostWithTx(tx *gorm.DB, variantID uint) (decimal.Decimal, error)
+	// ListLotConsumptionWithTx - списания из партий варианта на складе с даты from по проведённым документам
+	// (и документу docID, который проводится сейчас) в хронологическом порядке.
+	ListLotConsumptionWithTx(tx *gorm.DB, warehouseID, variantID uint, from time.Time, docID uint, types []string) ([]stock.StockMovement, error)
+	// ListLotConsumersWithTx - другие проведённые документы, которые списали из партий документа incomeDocID
+	// движениями, кроме типов except.
+	ListLotConsumersWithTx(tx *gorm.DB, incomeDocID uint, except []string) ([]uint, error)
+	DeleteWithTx(tx *gorm.DB, ids []uint) error
 
 	Search(filter stock.MovementFilter) ([]stock.StockMovement, error)
 }
@@ -29,10 +45,7 @@ func NewStockMovementRepository(db *gorm.DB) StockMovementRepository {
 }
 
 func (r *movementRepo) Create(m *stock.StockMovement) (*stock.StockMovement, error) {
-	if err := r.db.Create(m).Error; err != nil {
-		return nil, err
-	}
-	return m, nil
+	return r.CreateWithTx(nil, m)
 }
 
 func (r *movementRepo) CreateWithTx(tx *gorm.DB, m *stock.StockMovement) (*stock.StockMovement, error) {
@@ -88,6 +101,85 @@ func (r *movementRepo) ListByDocumentWithTx(tx *gorm.DB, docID uint) ([]stock.St
 	return ms, nil
 }
 
+func (r *movementRepo) ListActiveByDocumentWithTx(tx *gorm.DB, docID uint) ([]stock.StockMovement, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	var ms []stock.StockMovement
+	err := db.Where("document_id = ? AND reversed_by_id IS NULL AND type <> ?", docID, "CANCEL").Order("id asc").Find(&ms).Error
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+func (r *movementRepo) MarkReversedWithTx(tx *gorm.DB, id, cancelID uint) error {
+	if tx == nil {
+		tx = r.db
+	}
+	return tx.Model(&stock.StockMovement{}).Where("id = ?", id).Update("reversed_by_id", cancelID).Error
+}
+
+func (r *movementRepo) LastUnitCostWithTx(tx *gorm.DB, variantID uint) (decimal.Decimal, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	var ms []stock.StockMovement
+	err := db.Where("item_id = ? AND quantity > 0 AND unit_cost > 0 AND type <> ? AND reversed_by_id IS NULL", variantID, "CANCEL").
+		Order("id desc").Limit(1).Find(&ms).Error
+	if err != nil || len(ms) == 0 {
+		return decimal.Zero, err
+	}
+	return ms[0].UnitCost, nil
+}
+
+func (r *movementRepo) ListLotConsumptionWithTx(tx *gorm.DB, warehouseID, variantID uint, from time.Time, docID uint, types []string) ([]stock.StockMovement, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	var ms []stock.StockMovement
+	err := db.Model(&stock.StockMovement{}).Select("stock_movements.*").
+		Joins("JOIN documents ON documents.id = stock_movements.document_id").
+		Where("stock_movements.warehouse_id = ? AND stock_movements.item_id = ?", warehouseID, variantID).
+		Where("stock_movements.quantity < 0 AND stock_movements.source_lot_id IS NOT NULL AND stock_movements.type IN ?", types).
+		Where("stock_movements.reversed_by_id IS NULL").
+		Where("stock_movements.document_date >= ?", from).
+		Where("documents.status = ? OR documents.id = ?", "posted", docID).
+		Order("stock_movements.document_date asc, stock_movements.document_id asc, stock_movements.id asc").
+		Find(&ms).Error
+	return ms, err
+}
+
+func (r *movementRepo) ListLotConsumersWithTx(tx *gorm.DB, incomeDocID uint, except []string) ([]uint, error) {
+	db := r.db
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	err := db.Model(&stock.StockMovement{}).Distinct().
+		Joins("JOIN stock_lots ON stock_lots.id = stock_movements.source_lot_id").
+		Joins("JOIN documents ON documents.id = stock_movements.document_id").
+		Where("stock_lots.income_document_id = ? AND stock_movements.document_id <> ?", incomeDocID, incomeDocID).
+		Where("stock_movements.quantity < 0 AND stock_movements.reversed_by_id IS NULL AND stock_movements.type NOT IN ?", append([]string{"CANCEL"}, except...)).
+		Where("documents.status IN ?", []string{"posted", "in_transit"}).
+		Order("stock_movements.document_id").
+		Pluck("stock_movements.document_id", &ids).Error
+	return ids, err
+}
+
+func (r *movementRepo) DeleteWithTx(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if tx == nil {
+		tx = r.db
+	}
+	return tx.Delete(&stock.StockMovement{}, ids).Error
+}
+
 func (r *movementRepo) Search(filter stock.MovementFilter) ([]stock.StockMovement, error) {
 	var movements []stock.StockMovement
 
@@ -96,6 +188,12 @@ func (r *movementRepo) Search(filter stock.MovementFilter) ([]stock.StockMovemen
 	if filter.VariantID != nil {
 		query = query.Where("item_id = ?", *filter.VariantID)
 	}
+	if len(filter.SourceLotIDs) > 0 {
+		query = query.Where("source_lot_id IN ?", filter.SourceLotIDs)
+	}
+	if filter.WarehouseIDs != nil {
+		query = query.Where("warehouse_id IN ?", filter.WarehouseIDs)
+	}
 
 	if filter.Limit > 0 {
 		query = query.Limit(filter.Limit)
@@ -104,7 +202,7 @@ func (r *movementRepo) Search(filter stock.MovementFilter) ([]stock.StockMovemen
 		query = query.Offset(filter.Offset)
 	}
 
-	err := query.Order("created_at desc").Find(&movements).Error
+	err := query.Order("document_date desc, id desc").Find(&movements).Error
 
 	return movements, err
 }
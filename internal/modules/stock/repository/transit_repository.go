@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+type TransitRepository interface {
+	CreateWithTx(tx *gorm.DB, t *models.StockInTransit) error
+	SaveWithTx(tx *gorm.DB, t *models.StockInTransit) error
+	ListByDocumentForUpdate(tx *gorm.DB, docID uint) ([]models.StockInTransit, error)
+	DeleteByDocumentWithTx(tx *gorm.DB, docID uint) error
+
+	SumPendingByDestination(toWarehouseID, variantID uint) (decimal.Decimal, error)
+}
+
+type transitRepo struct {
+	db *gorm.DB
+}
+
+func NewTransitRepository(db *gorm.DB) TransitRepository {
+	return &transitRepo{db: db}
+}
+
+func (r *transitRepo) CreateWithTx(tx *gorm.DB, t *models.StockInTransit) error {
+	return tx.Create(t).Error
+}
+
+func (r *transitRepo) SaveWithTx(tx *gorm.DB, t *models.StockInTransit) error {
+	return tx.Save(t).Error
+}
+
+func (r *transitRepo) ListByDocumentForUpdate(tx *gorm.DB, docID uint) ([]models.StockInTransit, error) {
+	var rows []models.StockInTransit
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("document_id = ?", docID).
+		Order("arrival_date asc, id asc").
+		Find(&rows).Error
+	return rows, err
+}
+
+func (r *transitRepo) DeleteByDocumentWithTx(tx *gorm.DB, docID uint) error {
+	return tx.Where("document_id = ?", docID).Delete(&models.StockInTransit{}).Error
+}
+
+func (r *transitRepo) SumPendingByDestination(toWarehouseID, variantID uint) (decimal.Decimal, error) {
+	var total decimal.Decimal
+	err := r.db.Model(&models.StockInTransit{}).
+		Select("COALESCE(SUM(quantity), 0)").
+		Where("to_warehouse_id = ? AND variant_id = ? AND received_at IS NULL", toWarehouseID, variantID).
+		Scan(&total).Error
+	return total, err
+}
@@ -19,8 +19,17 @@ type unitRepo struct{ db *gorm.DB }
 func NewUnitRepository(db *gorm.DB) UnitRepository { return &unitRepo{db: db} }
 
 func (r *unitRepo) Create(u *stock.Unit) (*stock.Unit, error) {
-	err := r.db.Create(u).Error
-	return u, err
+	precision := u.Precision
+	if err := r.db.Create(u).Error; err != nil {
+		return u, err
+	}
+	// нулевая точность при вставке заменяется значением по умолчанию, записываем её явно
+	if precision == 0 {
+		if err := r.db.Model(u).Update("precision", 0).Error; err != nil {
+			return u, err
+		}
+	}
+	return u, nil
 }
 
 func (r *unitRepo) GetByID(id uint) (*stock.Unit, error) {
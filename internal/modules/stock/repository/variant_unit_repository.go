@@ -0,0 +1,46 @@
+package repository
+
+import (
+	stock "github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"gorm.io/gorm"
+)
+
+type VariantUnitRepository interface {
+	ListByVariant(variantID uint) ([]stock.VariantUnit, error)
+	// Get возвращает nil без ошибки, если у варианта нет такой единицы.
+	Get(variantID, unitID uint) (*stock.VariantUnit, error)
+	ReplaceForVariant(variantID uint, units []stock.VariantUnit) error
+}
+
+type variantUnitRepo struct{ db *gorm.DB }
+
+func NewVariantUnitRepository(db *gorm.DB) VariantUnitRepository { return &variantUnitRepo{db: db} }
+
+func (r *variantUnitRepo) ListByVariant(variantID uint) ([]stock.VariantUnit, error) {
+	var units []stock.VariantUnit
+	err := r.db.Where("variant_id = ?", variantID).Order("factor").Find(&units).Error
+	return units, err
+}
+
+func (r *variantUnitRepo) Get(variantID, unitID uint) (*stock.VariantUnit, error) {
+	var units []stock.VariantUnit
+	if err := r.db.Where("variant_id = ? AND unit_id = ?", variantID, unitID).Limit(1).Find(&units).Error; err != nil {
+		return nil, err
+	}
+	if len(units) == 0 {
+		return nil, nil
+	}
+	return &units[0], nil
+}
+
+func (r *variantUnitRepo) ReplaceForVariant(variantID uint, units []stock.VariantUnit) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("variant_id = ?", variantID).Delete(&stock.VariantUnit{}).Error; err != nil {
+			return err
+		}
+		if len(units) == 0 {
+			return nil
+		}
+		return tx.Create(&units).Error
+	})
+}
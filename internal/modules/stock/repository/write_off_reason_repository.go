@@ -0,0 +1,48 @@
+package repository
+
+import (
+	stock "github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"gorm.io/gorm"
+)
+
+type WriteOffReasonRepository interface {
+	Create(r *stock.WriteOffReason) (*stock.WriteOffReason, error)
+	GetByID(id uint) (*stock.WriteOffReason, error)
+	List() ([]stock.WriteOffReason, error)
+	Update(r *stock.WriteOffReason) (*stock.WriteOffReason, error)
+	Delete(id uint) error
+}
+
+type writeOffReasonRepo struct{ db *gorm.DB }
+
+func NewWriteOffReasonRepository(db *gorm.DB) WriteOffReasonRepository {
+	return &writeOffReasonRepo{db: db}
+}
+
+func (r *writeOffReasonRepo) Create(reason *stock.WriteOffReason) (*stock.WriteOffReason, error) {
+	err := r.db.Create(reason).Error
+	return reason, err
+}
+
+func (r *writeOffReasonRepo) GetByID(id uint) (*stock.WriteOffReason, error) {
+	var reason stock.WriteOffReason
+	if err := r.db.First(&reason, id).Error; err != nil {
+		return nil, err
+	}
+	return &reason, nil
+}
+
+func (r *writeOffReasonRepo) List() ([]stock.WriteOffReason, error) {
+	var reasons []stock.WriteOffReason
+	err := r.db.Order("code").Find(&reasons).Error
+	return reasons, err
+}
+
+func (r *writeOffReasonRepo) Update(reason *stock.WriteOffReason) (*stock.WriteOffReason, error) {
+	err := r.db.Save(reason).Error
+	return reason, err
+}
+
+func (r *writeOffReasonRepo) Delete(id uint) error {
+	return r.db.Delete(&stock.WriteOffReason{}, id).Error
+}
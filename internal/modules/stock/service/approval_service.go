@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+var (
+	ErrApprovalRequired = errors.New("document requires approval before posting")
+	ErrSelfApproval     = errors.New("document cannot be approved by its author")
+)
+
+type ApprovalService interface {
+	CreateRule(rule *models.ApprovalRule) (*models.ApprovalRule, error)
+	ListRules() ([]models.ApprovalRule, error)
+	UpdateRule(id uint, rule *models.ApprovalRule) (*models.ApprovalRule, error)
+	DeleteRule(id uint) error
+
+	// RequiresApproval сообщает, подпадает ли документ под какое-либо активное правило согласования.
+	RequiresApproval(doc *models.Document) (bool, error)
+	// ApproverPermissions - права согласующего, которых требуют сработавшие для документа правила.
+	ApproverPermissions(doc *models.Document) ([]string, error)
+}
+
+type approvalService struct {
+	repo     repository.ApprovalRuleRepository
+	currency CurrencyService
+}
+
+func NewApprovalService(repo repository.ApprovalRuleRepository, currency CurrencyService) ApprovalService {
+	return &approvalService{repo: repo, currency: currency}
+}
+
+func (s *approvalService) CreateRule(rule *models.ApprovalRule) (*models.ApprovalRule, error) {
+	if err := validateApprovalRule(rule); err != nil {
+		return nil, err
+	}
+	rule.ID = 0
+	rule.Active = true
+	return s.repo.Create(rule)
+}
+
+func (s *approvalService) ListRules() ([]models.ApprovalRule, error) {
+	return s.repo.List()
+}
+
+func (s *approvalService) UpdateRule(id uint, rule *models.ApprovalRule) (*models.ApprovalRule, error) {
+	existing, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateApprovalRule(rule); err != nil {
+		return nil, err
+	}
+	existing.DocumentType = rule.DocumentType
+	existing.MinAmount = rule.MinAmount
+	existing.ApproverPermission = rule.ApproverPermission
+	existing.Active = rule.Active
+	existing.Comment = rule.Comment
+	return s.repo.Update(existing)
+}
+
+func (s *approvalService) DeleteRule(id uint) error {
+	return s.repo.Delete(id)
+}
+
+func (s *approvalService) RequiresApproval(doc *models.Document) (bool, error) {
+	rules, err := s.matchingRules(doc)
+	return len(rules) > 0, err
+}
+
+func (s *approvalService) ApproverPermissions(doc *models.Document) ([]string, error) {
+	rules, err := s.matchingRules(doc)
+	if err != nil {
+		return nil, err
+	}
+	var perms []string
+	for _, rule := range rules {
+		if rule.ApproverPermission != "" {
+			perms = append(perms, rule.ApproverPermission)
+		}
+	}
+	return perms, nil
+}
+
+// matchingRules - активные правила типа документа, порог которых сумма документа в базовой валюте превышает.
+func (s *approvalService) matchingRules(doc *models.Document) ([]models.ApprovalRule, error) {
+	rules, err := s.repo.ListActiveByType(toUpper(doc.Type))
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+
+	rate, err := s.currency.RateToBase(doc.Currency, documentDate(doc))
+	if err != nil {
+		return nil, err
+	}
+	amount := documentAmount(doc).Mul(rate)
+	var matched []models.ApprovalRule
+	for _, rule := range rules {
+		if amount.GreaterThan(rule.MinAmount) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched, nil
+}
+
+func validateApprovalRule(rule *models.ApprovalRule) error {
+	rule.DocumentType = strings.ToUpper(strings.TrimSpace(rule.DocumentType))
+	if rule.DocumentType == "" {
+		return errors.New("document_type is required")
+	}
+	rule.ApproverPermission = strings.TrimSpace(rule.ApproverPermission)
+	if rule.MinAmount.IsNegative() {
+		return errors.New("min_amount must not be negative")
+	}
+	return nil
+}
+
+// documentAmount - сумма документа в его валюте; строки без цены не учитываются.
+func documentAmount(doc *models.Document) decimal.Decimal {
+	total := decimal.Zero
+	for _, it := range doc.Items {
+		if it.Price != nil {
+			total = total.Add(it.Quantity.Mul(*it.Price))
+		}
+	}
+	return total
+}
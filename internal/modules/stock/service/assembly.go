@@ -0,0 +1,176 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+// processAssembly собирает комплекты: списывает компоненты по спецификации и приходует комплект
+// по себестоимости списанных компонентов.
+func (s *inventoryService) processAssembly(tx *gorm.DB, doc *models.Document, strategy QuantityStrategy) error {
+	if doc.WarehouseID == nil {
+		return errors.New("warehouse_id is required")
+	}
+	for _, item := range doc.Items {
+		if !item.Quantity.IsPositive() {
+			return fmt.Errorf("variant %d: %s quantity must be positive", item.VariantID, toUpper(doc.Type))
+		}
+		components, err := s.kitComponents(tx, doc, item.VariantID)
+		if err != nil {
+			return err
+		}
+
+		consume := s.assemblyDoc(doc)
+		for _, c := range components {
+			consume.Items = append(consume.Items, models.DocumentItem{VariantID: c.ComponentVariantID, Quantity: c.Quantity.Mul(item.Quantity)})
+		}
+		cost, err := s.consumedCost(tx, doc.ID, func() error { return strategy.ProcessOutcome(tx, consume, s.config) })
+		if err != nil {
+			return err
+		}
+
+		unitCost := cost.Div(item.Quantity)
+		produce := s.assemblyDoc(doc)
+		produce.Items = []models.DocumentItem{{
+			VariantID: item.VariantID, Quantity: item.Quantity, Price: &unitCost,
+			BatchNumber: item.BatchNumber, ProductionDate: item.ProductionDate, ExpiryDate: item.ExpiryDate,
+		}}
+		if err := strategy.ProcessIncome(tx, produce, s.config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processDisassembly разбирает комплекты на компоненты. Себестоимость комплекта делится между компонентами
+// пропорционально их последней оценке, а если оценок нет - пропорционально количеству.
+// Состав берётся из текущей спецификации, а не из той, по которой комплект собирался: если спецификацию
+// меняли после сборки, разборка вернёт уже новые компоненты. Старый состав разбирать вручную, через оприходование.
+func (s *inventoryService) processDisassembly(tx *gorm.DB, doc *models.Document, strategy QuantityStrategy) error {
+	if doc.WarehouseID == nil {
+		return errors.New("warehouse_id is required")
+	}
+	for _, item := range doc.Items {
+		if !item.Quantity.IsPositive() {
+			return fmt.Errorf("variant %d: %s quantity must be positive", item.VariantID, toUpper(doc.Type))
+		}
+		components, err := s.kitComponents(tx, doc, item.VariantID)
+		if err != nil {
+			return err
+		}
+
+		consume := s.assemblyDoc(doc)
+		consume.Items = []models.DocumentItem{{VariantID: item.VariantID, Quantity: item.Quantity}}
+		cost, err := s.consumedCost(tx, doc.ID, func() error { return strategy.ProcessOutcome(tx, consume, s.config) })
+		if err != nil {
+			return err
+		}
+
+		weights := make([]decimal.Decimal, len(components))
+		totalWeight := decimal.Zero
+		for i, c := range components {
+			last, err := s.movementRepo.LastUnitCostWithTx(tx, c.ComponentVariantID)
+			if err != nil {
+				return err
+			}
+			weights[i] = last.Mul(c.Quantity)
+			totalWeight = totalWeight.Add(weights[i])
+		}
+		if totalWeight.IsZero() {
+			for i, c := range components {
+				weights[i] = c.Quantity
+				totalWeight = totalWeight.Add(c.Quantity)
+			}
+		}
+
+		produce := s.assemblyDoc(doc)
+		for i, c := range components {
+			qty := c.Quantity.Mul(item.Quantity)
+			unitCost := cost.Mul(weights[i]).Div(totalWeight).Div(qty)
+			produce.Items = append(produce.Items, models.DocumentItem{VariantID: c.ComponentVariantID, Quantity: qty, Price: &unitCost})
+		}
+		if err := strategy.ProcessIncome(tx, produce, s.config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kitComponents возвращает спецификацию комплекта. Серийный товар ни в комплекте, ни в компонентах не допускается:
+// сборка не переводит серийные единицы.
+func (s *inventoryService) kitComponents(tx *gorm.DB, doc *models.Document, kitID uint) ([]models.BOMComponent, error) {
+	components, err := s.bomRepo.ListByKitWithTx(tx, kitID)
+	if err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		return nil, fmt.Errorf("variant %d has no bill of materials", kitID)
+	}
+	check := &models.Document{Type: doc.Type, Items: []models.DocumentItem{{VariantID: kitID}}}
+	for _, c := range components {
+		check.Items = append(check.Items, models.DocumentItem{VariantID: c.ComponentVariantID})
+	}
+	if err := s.serials.rejectTracked(check); err != nil {
+		return nil, err
+	}
+	return components, nil
+}
+
+// assemblyDoc - служебный документ для вызова стратегии; себестоимость уже в базовой валюте.
+func (s *inventoryService) assemblyDoc(doc *models.Document) *models.Document {
+	return &models.Document{
+		ID: doc.ID, Type: doc.Type, WarehouseID: doc.WarehouseID, CreatedAt: doc.CreatedAt, DocumentDate: doc.DocumentDate,
+		ExchangeRate: decimal.NewFromInt(1),
+	}
+}
+
+// consumedCost выполняет списание consume и возвращает себестоимость появившихся при этом расходных движений документа.
+func (s *inventoryService) consumedCost(tx *gorm.DB, docID uint, consume func() error) (decimal.Decimal, error) {
+	before, err := s.outcomeCost(tx, docID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if err := consume(); err != nil {
+		return decimal.Zero, err
+	}
+	after, err := s.outcomeCost(tx, docID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return after.Sub(before), nil
+}
+
+func (s *inventoryService) outcomeCost(tx *gorm.DB, docID uint) (decimal.Decimal, error) {
+	moves, err := s.movementRepo.ListActiveByDocumentWithTx(tx, docID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	total := decimal.Zero
+	for _, mv := range moves {
+		if mv.Quantity.IsNegative() {
+			total = total.Add(mv.Quantity.Neg().Mul(mv.UnitCost))
+		}
+	}
+	return total, nil
+}
+
+// buildableKits - сколько целых комплектов можно собрать на складе из доступных (не зарезервированных) компонентов.
+func (s *inventoryService) buildableKits(warehouseID uint, components []models.BOMComponent) (decimal.Decimal, error) {
+	var buildable *decimal.Decimal
+	for _, c := range components {
+		available, err := s.GetAvailableQuantity(warehouseID, c.ComponentVariantID)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		kits := decimal.Max(available, decimal.Zero).Div(c.Quantity).Floor()
+		if buildable == nil || kits.LessThan(*buildable) {
+			buildable = &kits
+		}
+	}
+	return *buildable, nil
+}
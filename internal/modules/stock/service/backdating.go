@@ -0,0 +1,182 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+// lotResequencer реализуют стратегии партионного учёта: документ, проведённый задним числом, меняет очередь партий,
+// и списания после его даты нужно заново распределить по партиям.
+type lotResequencer interface {
+	Resequence(tx *gorm.DB, warehouseID, variantID uint, from time.Time, docID uint) error
+}
+
+// resequencedTypes - списания, которые перераспределяются по партиям; недостачи инвентаризации пишутся как OUTCOME.
+// Перемещения, сборки и возвраты поставщику не трогаем: от их партий зависят партии получателя, комплекта
+// и конкретного прихода.
+var resequencedTypes = []string{"OUTCOME", "WRITE_OFF"}
+
+func (s *inventoryService) ResequenceLotsWithTx(tx *gorm.DB, doc *models.Document) error {
+	return s.resequenceLots(tx, doc)
+}
+
+// CheckLotsUnusedWithTx не даёт исправить документ, партии которого уже ушли в перемещение, сборку или возврат
+// поставщику: такие списания не перераспределяются, и после удаления партии у них остались бы ссылка в никуда
+// и прежняя себестоимость.
+func (s *inventoryService) CheckLotsUnusedWithTx(tx *gorm.DB, doc *models.Document) error {
+	ids, err := s.movementRepo.ListLotConsumersWithTx(tx, doc.ID, resequencedTypes)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	numbers := make([]string, len(ids))
+	for i, id := range ids {
+		numbers[i] = fmt.Sprint(id)
+		if other, err := s.docRepo.GetByIDWithTx(tx, id); err != nil {
+			return err
+		} else if other != nil && other.Number != "" {
+			numbers[i] = other.Number
+		}
+	}
+	return fmt.Errorf("lots of this document were used by documents %s, cancel them first", strings.Join(numbers, ", "))
+}
+
+// resequenceLots перераспределяет по партиям списания, датированные не раньше проведённого документа.
+func (s *inventoryService) resequenceLots(tx *gorm.DB, doc *models.Document) error {
+	if toUpper(doc.Type) == "ORDER" || doc.WarehouseID == nil {
+		return nil
+	}
+	strategy, err := s.strategyFactory.GetStrategy(s.config.AccountingPolicy)
+	if err != nil {
+		return err
+	}
+	reseq, ok := strategy.(lotResequencer)
+	if !ok {
+		return nil
+	}
+
+	warehouses := []uint{*doc.WarehouseID}
+	if doc.ToWarehouseID != nil && *doc.ToWarehouseID != *doc.WarehouseID {
+		warehouses = append(warehouses, *doc.ToWarehouseID)
+	}
+	seen := make(map[[2]uint]bool)
+	for _, wh := range warehouses {
+		for _, item := range doc.Items {
+			key := [2]uint{wh, item.VariantID}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if err := reseq.Resequence(tx, wh, item.VariantID, documentDate(doc), doc.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Resequence отменяет списания варианта из партий начиная с даты from и списывает их заново в хронологическом порядке.
+// Списания самого docID тоже переписываются: при проведении они берутся из текущих партий без учёта даты,
+// а документ задним числом может списать только то, что к его дате уже пришло.
+func (s *FifoQuantityStrategy) Resequence(tx *gorm.DB, warehouseID, variantID uint, from time.Time, docID uint) error {
+	moves, err := s.movementRepo.ListLotConsumptionWithTx(tx, warehouseID, variantID, from, docID, resequencedTypes)
+	if err != nil {
+		return err
+	}
+	// списания того же момента от более ранних документов остаются перед docID
+	affected := moves[:0]
+	for _, mv := range moves {
+		if mv.DocumentDate.Equal(from) && *mv.DocumentID < docID {
+			continue
+		}
+		affected = append(affected, mv)
+	}
+	if len(affected) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(affected))
+	for i, mv := range affected {
+		ids[i] = mv.ID
+		lot, err := s.lotRepo.GetLotByIDForUpdate(tx, *mv.SourceLotID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// партия удалена вместе с отменённым приходом (исправление проведённого прихода) - возвращать некуда
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		lot.CurrentQuantity = lot.CurrentQuantity.Sub(mv.Quantity)
+		if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
+			return err
+		}
+	}
+	if err := s.movementRepo.DeleteWithTx(tx, ids); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(affected); {
+		head := affected[start]
+		qty := decimal.Zero
+		end := start
+		for ; end < len(affected) && *affected[end].DocumentID == *head.DocumentID && affected[end].Type == head.Type; end++ {
+			qty = qty.Add(affected[end].Quantity.Neg())
+		}
+		if err := s.consumeAt(tx, head, qty); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// consumeAt списывает qty только из партий, пришедших не позже даты движения-образца: более поздних приходов
+// на эту дату ещё не было, и ни количество, ни себестоимость из них брать нельзя.
+func (s *FifoQuantityStrategy) consumeAt(tx *gorm.DB, head models.StockMovement, qty decimal.Decimal) error {
+	lots, err := s.lotRepo.GetLotsForUpdate(tx, head.WarehouseID, head.VariantID, s.order)
+	if err != nil {
+		return err
+	}
+	ordered := make([]models.StockLot, 0, len(lots))
+	for _, lot := range lots {
+		if !lot.ArrivalDate.After(head.DocumentDate) {
+			ordered = append(ordered, lot)
+		}
+	}
+
+	for i := range ordered {
+		if qty.IsZero() {
+			break
+		}
+		lot := &ordered[i]
+		qtyFromLot := decimal.Min(qty, lot.CurrentQuantity)
+
+		mv := &models.StockMovement{
+			DocumentID: head.DocumentID, VariantID: head.VariantID, WarehouseID: head.WarehouseID, CounterpartyID: head.CounterpartyID,
+			Quantity: qtyFromLot.Neg(), UnitCost: lot.UnitCost, Type: head.Type, SourceLotID: &lot.ID, Comment: head.Comment,
+			DocumentDate: head.DocumentDate, CreatedAt: head.CreatedAt,
+		}
+		if _, err := s.movementRepo.CreateWithTx(tx, mv); err != nil {
+			return err
+		}
+		lot.CurrentQuantity = lot.CurrentQuantity.Sub(qtyFromLot)
+		if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
+			return err
+		}
+		qty = qty.Sub(qtyFromLot)
+	}
+	// партий может не хватить, если исправление убрало приход или документ задним числом забрал товар,
+	// который к дате этого списания ещё был на складе
+	if qty.IsPositive() {
+		productName, sku := s.describeVariant(head.VariantID)
+		return fmt.Errorf("Недостаточно товара '%s' (%s) в партиях для списания по документу %d: не хватает %s",
+			productName, sku, *head.DocumentID, qty.String())
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/reports"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+var (
+	ErrBarcodeInvalid    = errors.New("invalid barcode")
+	ErrBarcodeTaken      = errors.New("barcode is already assigned")
+	ErrBarcodeNotFound   = errors.New("barcode not found")
+	ErrVariantNotFound   = errors.New("variant not found")
+	ErrPriceTypeNotFound = errors.New("price type not found")
+)
+
+type BarcodeService interface {
+	List(variantID uint) ([]models.Barcode, error)
+	// Add привязывает штрихкод к варианту. Пустой код - выдать следующий EAN-13 из внутреннего диапазона.
+	Add(variantID uint, dto models.BarcodeCreateDTO) (*models.Barcode, error)
+	Delete(variantID, id uint) error
+	// FindVariant ищет вариант по отсканированному коду.
+	FindVariant(code string) (*models.VariantDTO, error)
+	// Labels печатает этикетки выбранных вариантов в PDF.
+	Labels(filter models.LabelFilter) ([]byte, error)
+}
+
+type barcodeService struct {
+	repo          repository.BarcodeRepository
+	variantRepo   repository.VariantRepository
+	productRepo   repository.ProductRepository
+	prices        PriceService
+	priceTypeRepo repository.PriceTypeRepository
+	seqRepo       repository.SequenceRepository
+	variants      VariantService
+	txManager     repository.TxManager
+	pdf           *reports.PDFGenerator
+	prefix        string
+}
+
+func NewBarcodeService(
+	repo repository.BarcodeRepository, variantRepo repository.VariantRepository, productRepo repository.ProductRepository,
+	prices PriceService, priceTypeRepo repository.PriceTypeRepository, seqRepo repository.SequenceRepository,
+	variants VariantService, txManager repository.TxManager, pdf *reports.PDFGenerator, prefix string,
+) BarcodeService {
+	return &barcodeService{
+		repo: repo, variantRepo: variantRepo, productRepo: productRepo, prices: prices, priceTypeRepo: priceTypeRepo,
+		seqRepo: seqRepo, variants: variants, txManager: txManager, pdf: pdf, prefix: prefix,
+	}
+}
+
+func (s *barcodeService) List(variantID uint) ([]models.Barcode, error) {
+	if _, err := s.variant(variantID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListByVariant(variantID)
+}
+
+func (s *barcodeService) Add(variantID uint, dto models.BarcodeCreateDTO) (*models.Barcode, error) {
+	if _, err := s.variant(variantID); err != nil {
+		return nil, err
+	}
+	barcode := &models.Barcode{VariantID: variantID}
+	if strings.TrimSpace(dto.Code) != "" {
+		code, codeType, err := normalizeBarcode(dto.Code, dto.Type)
+		if err != nil {
+			return nil, err
+		}
+		barcode.Code, barcode.Type = code, codeType
+	} else if t := strings.ToUpper(strings.TrimSpace(dto.Type)); t != "" && t != models.BarcodeEAN13 {
+		return nil, fmt.Errorf("%w: only EAN13 codes can be generated", ErrBarcodeInvalid)
+	}
+
+	err := s.txManager.DoInTx(func(tx *gorm.DB) error {
+		if barcode.Code == "" {
+			code, err := s.nextInternalEAN(tx)
+			if err != nil {
+				return err
+			}
+			barcode.Code, barcode.Type, barcode.Generated = code, models.BarcodeEAN13, true
+		} else {
+			existing, err := s.repo.FindByCodeWithTx(tx, barcode.Code)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return fmt.Errorf("%w: %s belongs to variant %d", ErrBarcodeTaken, barcode.Code, existing.VariantID)
+			}
+		}
+		return s.repo.CreateWithTx(tx, barcode)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return barcode, nil
+}
+
+// nextInternalEAN выдаёт следующий свободный код внутреннего диапазона: префикс, порядковый номер и контрольная цифра.
+// Коды, которые уже заведены вручную, пропускаются.
+func (s *barcodeService) nextInternalEAN(tx *gorm.DB) (string, error) {
+	width := 12 - len(s.prefix)
+	limit := uint(1)
+	for i := 0; i < width; i++ {
+		limit *= 10
+	}
+	for {
+		seq, err := s.seqRepo.GetNext(tx, "BARCODE_EAN_"+s.prefix)
+		if err != nil {
+			return "", err
+		}
+		if seq.LastNumber >= limit {
+			return "", fmt.Errorf("internal EAN range %s is exhausted", s.prefix)
+		}
+		body := fmt.Sprintf("%s%0*d", s.prefix, width, seq.LastNumber)
+		code := body + eanCheckDigit(body)
+		existing, err := s.repo.FindByCodeWithTx(tx, code)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return code, nil
+		}
+	}
+}
+
+func (s *barcodeService) Delete(variantID, id uint) error {
+	deleted, err := s.repo.Delete(variantID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrBarcodeNotFound
+	}
+	return nil
+}
+
+func (s *barcodeService) FindVariant(code string) (*models.VariantDTO, error) {
+	barcode, err := findBarcodeWithTx(s.repo, nil, code)
+	if err != nil {
+		return nil, err
+	}
+	if barcode == nil {
+		return nil, ErrBarcodeNotFound
+	}
+	return s.variants.GetByIDAsDTO(barcode.VariantID)
+}
+
+// findBarcodeWithTx ищет штрихкод по скану; nil без ошибки, если код не заведён.
+func findBarcodeWithTx(repo repository.BarcodeRepository, tx *gorm.DB, code string) (*models.Barcode, error) {
+	code = strings.TrimSpace(code)
+	barcode, err := repo.FindByCodeWithTx(tx, code)
+	if err == nil && barcode == nil && len(code) == 12 && isDigits(code) {
+		// сканер UPC-A отдаёт 12 цифр - это тот же EAN-13 с ведущим нулём
+		barcode, err = repo.FindByCodeWithTx(tx, "0"+code)
+	}
+	return barcode, err
+}
+
+func (s *barcodeService) Labels(filter models.LabelFilter) ([]byte, error) {
+	if filter.Format == "" {
+		filter.Format = reports.LabelShelf
+	}
+	if filter.Copies == 0 {
+		filter.Copies = 1
+	}
+	if filter.PriceTypeID != nil {
+		if _, err := s.priceTypeRepo.GetByID(*filter.PriceTypeID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: price type %d", ErrPriceTypeNotFound, *filter.PriceTypeID)
+			}
+			return nil, err
+		}
+	}
+
+	variants, err := s.variantRepo.GetByIDs(filter.VariantIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Variant, len(variants))
+	productIDs := make([]uint, 0, len(variants))
+	for _, v := range variants {
+		byID[v.ID] = v
+		productIDs = append(productIDs, v.ProductID)
+	}
+	products, err := s.productRepo.GetByIDs(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	productNames := make(map[uint]string, len(products))
+	for _, p := range products {
+		productNames[p.ID] = p.Name
+	}
+	codes, err := s.repo.ListByVariants(filter.VariantIDs)
+	if err != nil {
+		return nil, err
+	}
+	codesByVariant := make(map[uint][]models.Barcode)
+	for _, c := range codes {
+		codesByVariant[c.VariantID] = append(codesByVariant[c.VariantID], c)
+	}
+
+	// ценники печатают и заранее, к дате смены цен
+	at := time.Now()
+	if filter.Date != nil {
+		at = *filter.Date
+	}
+	var labels []reports.Label
+	for _, id := range filter.VariantIDs {
+		v, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", ErrVariantNotFound, id)
+		}
+		label := reports.Label{Name: productNames[v.ProductID], SKU: v.SKU, Details: characteristicsLine(v.Characteristics)}
+		label.Barcode, label.BarcodeType = labelBarcode(v, codesByVariant[id])
+		if filter.PriceTypeID != nil {
+			// цена на дату этикетки с учётом запланированных и срочных цен, а не последняя бессрочная
+			price, err := s.prices.GetPriceAt(id, *filter.PriceTypeID, at)
+			if err != nil {
+				return nil, err
+			}
+			if price != nil {
+				label.Price, label.Currency = &price.Price, price.Currency
+			}
+		}
+		for i := 0; i < filter.Copies; i++ {
+			labels = append(labels, label)
+		}
+	}
+	return s.pdf.GenerateLabels(labels, filter.Format)
+}
+
+func (s *barcodeService) variant(id uint) (*models.Variant, error) {
+	v, err := s.variantRepo.GetByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && v == nil) {
+		return nil, ErrVariantNotFound
+	}
+	return v, err
+}
+
+// labelBarcode выбирает код для этикетки: первый EAN-13, иначе первый заведённый, иначе CODE128 из артикула.
+func labelBarcode(v models.Variant, codes []models.Barcode) (string, string) {
+	for _, c := range codes {
+		if c.Type == models.BarcodeEAN13 {
+			return c.Code, c.Type
+		}
+	}
+	if len(codes) > 0 {
+		return codes[0].Code, codes[0].Type
+	}
+	if v.SKU != "" && isPrintableASCII(v.SKU) {
+		return v.SKU, models.BarcodeCode128
+	}
+	return "", ""
+}
+
+func characteristicsLine(chars models.CharacteristicsMap) string {
+	parts := make([]string, 0, len(chars))
+	for k, v := range chars {
+		parts = append(parts, k+": "+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// normalizeBarcode проверяет код по его типу без учёта регистра, пустой тип определяется по содержимому.
+// 12 цифр считаются UPC-A и хранятся как EAN-13 с ведущим нулём.
+func normalizeBarcode(code, codeType string) (string, string, error) {
+	code = strings.TrimSpace(code)
+	codeType = strings.ToUpper(strings.TrimSpace(codeType))
+	if codeType == "" {
+		switch {
+		case isDigits(code) && (len(code) == 12 || len(code) == 13):
+			codeType = models.BarcodeEAN13
+		case isPrintableASCII(code) && len(code) <= 80:
+			codeType = models.BarcodeCode128
+		default:
+			codeType = models.BarcodeQR
+		}
+	}
+
+	switch codeType {
+	case models.BarcodeEAN13:
+		if !isDigits(code) || (len(code) != 12 && len(code) != 13) {
+			return "", "", fmt.Errorf("%w: EAN13 must contain 13 digits or 12 for UPC-A", ErrBarcodeInvalid)
+		}
+		if len(code) == 12 {
+			code = "0" + code
+		}
+		if eanCheckDigit(code[:12]) != code[12:] {
+			return "", "", fmt.Errorf("%w: wrong EAN13 check digit in %s", ErrBarcodeInvalid, code)
+		}
+	case models.BarcodeCode128:
+		if !isPrintableASCII(code) || len(code) > 80 {
+			return "", "", fmt.Errorf("%w: CODE128 must be up to 80 printable ASCII characters", ErrBarcodeInvalid)
+		}
+	case models.BarcodeQR:
+		if len(code) > 255 {
+			return "", "", fmt.Errorf("%w: QR code is longer than 255 bytes", ErrBarcodeInvalid)
+		}
+	default:
+		return "", "", fmt.Errorf("%w: unknown type %s", ErrBarcodeInvalid, codeType)
+	}
+	return code, codeType, nil
+}
+
+// eanCheckDigit - контрольная цифра EAN-13 для первых 12 цифр: веса 1 и 3 попеременно.
+func eanCheckDigit(body string) string {
+	sum := 0
+	for i, r := range body {
+		d := int(r - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return fmt.Sprint((10 - sum%10) % 10)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isPrintableASCII(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < 32 || r > 126 {
+			return false
+		}
+	}
+	return true
+}
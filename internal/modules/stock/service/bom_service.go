@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+// BOMService ведёт спецификации комплектов: из каких вариантов и в каком количестве собирается вариант-комплект.
+type BOMService interface {
+	Get(kitID uint) ([]models.BOMComponentDTO, error)
+	Set(kitID uint, components []models.BOMComponent) ([]models.BOMComponentDTO, error)
+}
+
+type bomService struct {
+	repo        repository.BOMRepository
+	variantRepo repository.VariantRepository
+	productRepo repository.ProductRepository
+}
+
+func NewBOMService(repo repository.BOMRepository, v repository.VariantRepository, p repository.ProductRepository) BOMService {
+	return &bomService{repo: repo, variantRepo: v, productRepo: p}
+}
+
+func (s *bomService) Get(kitID uint) ([]models.BOMComponentDTO, error) {
+	components, err := s.repo.ListByKit(kitID)
+	if err != nil {
+		return nil, err
+	}
+	return s.toDTO(components), nil
+}
+
+func (s *bomService) Set(kitID uint, components []models.BOMComponent) ([]models.BOMComponentDTO, error) {
+	if kit, err := s.variantRepo.GetByID(kitID); err != nil || kit == nil {
+		return nil, fmt.Errorf("variant %d not found", kitID)
+	}
+
+	seen := make(map[uint]bool)
+	for i := range components {
+		c := &components[i]
+		c.ID, c.KitVariantID = 0, kitID
+		if !c.Quantity.IsPositive() {
+			return nil, fmt.Errorf("component %d: quantity must be positive", c.ComponentVariantID)
+		}
+		if seen[c.ComponentVariantID] {
+			return nil, fmt.Errorf("component %d is listed twice", c.ComponentVariantID)
+		}
+		seen[c.ComponentVariantID] = true
+		if v, err := s.variantRepo.GetByID(c.ComponentVariantID); err != nil || v == nil {
+			return nil, fmt.Errorf("component variant %d not found", c.ComponentVariantID)
+		}
+		contains, err := s.containsKit(c.ComponentVariantID, kitID, map[uint]bool{})
+		if err != nil {
+			return nil, err
+		}
+		if contains {
+			return nil, errors.New("kit cannot contain itself")
+		}
+	}
+
+	if err := s.repo.ReplaceForKit(kitID, components); err != nil {
+		return nil, err
+	}
+	return s.Get(kitID)
+}
+
+// containsKit проверяет, входит ли kitID в variantID с учётом вложенных комплектов.
+func (s *bomService) containsKit(variantID, kitID uint, visited map[uint]bool) (bool, error) {
+	if variantID == kitID {
+		return true, nil
+	}
+	if visited[variantID] {
+		return false, nil
+	}
+	visited[variantID] = true
+
+	components, err := s.repo.ListByKit(variantID)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range components {
+		contains, err := s.containsKit(c.ComponentVariantID, kitID, visited)
+		if err != nil || contains {
+			return contains, err
+		}
+	}
+	return false, nil
+}
+
+func (s *bomService) toDTO(components []models.BOMComponent) []models.BOMComponentDTO {
+	ids := make([]uint, len(components))
+	for i, c := range components {
+		ids[i] = c.ComponentVariantID
+	}
+	variants, _ := s.variantRepo.GetByIDs(ids)
+	variantMap := make(map[uint]models.Variant, len(variants))
+	productIDs := make([]uint, 0, len(variants))
+	for _, v := range variants {
+		variantMap[v.ID] = v
+		productIDs = append(productIDs, v.ProductID)
+	}
+	products, _ := s.productRepo.GetByIDs(productIDs)
+	productMap := make(map[uint]string, len(products))
+	for _, p := range products {
+		productMap[p.ID] = p.Name
+	}
+
+	result := make([]models.BOMComponentDTO, len(components))
+	for i, c := range components {
+		v := variantMap[c.ComponentVariantID]
+		result[i] = models.BOMComponentDTO{
+			ComponentVariantID: c.ComponentVariantID, SKU: v.SKU, ProductName: productMap[v.ProductID], Quantity: c.Quantity,
+		}
+	}
+	return result
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+const (
+	BulkBestEffort   = "best_effort"
+	BulkAllOrNothing = "all_or_nothing"
+
+	// bulkJobTTL - сколько хранится завершённая задача, чтобы клиент успел забрать результат.
+	bulkJobTTL = 24 * time.Hour
+)
+
+type BulkService interface {
+	// Resolve возвращает документы массовой операции в порядке учётной даты, для отмены - в обратном.
+	Resolve(dto *models.BulkDocumentDTO) ([]models.Document, error)
+	// Start запускает обработку документов в фоне и сразу возвращает задачу.
+	Start(action, mode string, docs []models.Document, userID *uint) *models.BulkJobDTO
+	GetJob(id uint) (*models.BulkJobDTO, error)
+}
+
+// bulkService хранит задачи только в памяти процесса: после перезапуска сервера задачи и их результаты теряются,
+// а прерванная задача режима best_effort остаётся проведённой частично.
+type bulkService struct {
+	docs    DocumentService
+	docRepo repository.DocumentRepository
+	tx      repository.TxManager
+
+	mu     sync.Mutex
+	nextID uint
+	jobs   map[uint]*models.BulkJobDTO
+}
+
+func NewBulkService(docs DocumentService, docRepo repository.DocumentRepository, tx repository.TxManager) BulkService {
+	return &bulkService{docs: docs, docRepo: docRepo, tx: tx, jobs: make(map[uint]*models.BulkJobDTO)}
+}
+
+func (s *bulkService) Resolve(dto *models.BulkDocumentDTO) ([]models.Document, error) {
+	if (len(dto.DocumentIDs) > 0) == (dto.Filter != nil) {
+		return nil, errors.New("specify either document_ids or filter")
+	}
+
+	var docs []models.Document
+	if dto.Filter != nil {
+		filter := *dto.Filter
+		// без явного статуса берём только те документы, к которым действие применимо
+		if filter.Status == nil {
+			status := "draft"
+			if dto.Action == "cancel" {
+				status = "posted"
+			}
+			filter.Status = &status
+		}
+		found, err := s.docRepo.Search(filter)
+		if err != nil {
+			return nil, err
+		}
+		docs = found
+	} else {
+		seen := make(map[uint]bool, len(dto.DocumentIDs))
+		for _, id := range dto.DocumentIDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			doc, err := s.docRepo.GetByID(id)
+			if err != nil || doc == nil {
+				return nil, fmt.Errorf("document %d not found", id)
+			}
+			docs = append(docs, *doc)
+		}
+	}
+
+	// проводим от ранних к поздним, отменяем от поздних к ранним: отмена раннего прихода раньше
+	// опирающихся на него списаний оставила бы их без партий
+	desc := dto.Action == "cancel"
+	sort.SliceStable(docs, func(i, j int) bool {
+		di, dj := documentDate(&docs[i]), documentDate(&docs[j])
+		if !di.Equal(dj) {
+			return di.Before(dj) != desc
+		}
+		return (docs[i].ID < docs[j].ID) != desc
+	})
+	return docs, nil
+}
+
+func (s *bulkService) Start(action, mode string, docs []models.Document, userID *uint) *models.BulkJobDTO {
+	if mode == "" {
+		mode = BulkBestEffort
+	}
+	job := &models.BulkJobDTO{
+		Action: action, Mode: mode, Status: "running", CreatedBy: userID, Total: len(docs),
+		Results: make([]models.BulkResultDTO, len(docs)), StartedAt: time.Now(),
+	}
+	for i, doc := range docs {
+		job.Results[i] = models.BulkResultDTO{DocumentID: doc.ID, Number: doc.Number, DocumentDate: documentDate(&doc), Status: "pending"}
+	}
+
+	s.mu.Lock()
+	s.pruneLocked(job.StartedAt)
+	s.nextID++
+	job.ID = s.nextID
+	s.jobs[job.ID] = job
+	snapshot := copyJob(job)
+	s.mu.Unlock()
+
+	go s.run(job, userID)
+	return snapshot
+}
+
+func (s *bulkService) GetJob(id uint) (*models.BulkJobDTO, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, errors.New("bulk job not found")
+	}
+	return copyJob(job), nil
+}
+
+func (s *bulkService) run(job *models.BulkJobDTO, userID *uint) {
+	apply := s.docs.PostWithTx
+	if job.Action == "cancel" {
+		apply = s.docs.CancelWithTx
+	}
+
+	if job.Mode == BulkAllOrNothing {
+		err := s.tx.DoInTx(func(tx *gorm.DB) error {
+			for i := range job.Results {
+				if err := apply(tx, job.Results[i].DocumentID, userID); err != nil {
+					s.record(job, i, err)
+					return err
+				}
+				s.record(job, i, nil)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed bulk %s job ID=%d: %v", job.Action, job.ID, err)
+			s.rollback(job)
+		}
+	} else {
+		for i := range job.Results {
+			id := job.Results[i].DocumentID
+			err := s.tx.DoInTx(func(tx *gorm.DB) error {
+				return apply(tx, id, userID)
+			})
+			s.record(job, i, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	job.FinishedAt = &now
+	if job.Status == "running" {
+		job.Status = "completed"
+	}
+}
+
+func (s *bulkService) record(job *models.BulkJobDTO, i int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.Processed++
+	if err != nil {
+		job.Failed++
+		job.Results[i].Status = "failed"
+		job.Results[i].Error = err.Error()
+		return
+	}
+	job.Succeeded++
+	job.Results[i].Status = "done"
+}
+
+// rollback отражает откат транзакции all_or_nothing: проведённое до ошибки отменено, остальное не тронуто.
+func (s *bulkService) rollback(job *models.BulkJobDTO) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.Status = "failed"
+	job.Succeeded = 0
+	for i := range job.Results {
+		switch job.Results[i].Status {
+		case "done":
+			job.Results[i].Status = "rolled_back"
+		case "pending":
+			job.Results[i].Status = "skipped"
+		}
+	}
+}
+
+func (s *bulkService) pruneLocked(now time.Time) {
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > bulkJobTTL {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func copyJob(job *models.BulkJobDTO) *models.BulkJobDTO {
+	c := *job
+	c.Results = append([]models.BulkResultDTO(nil), job.Results...)
+	return &c
+}
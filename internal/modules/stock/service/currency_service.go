@@ -0,0 +1,137 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+type CurrencyService interface {
+	BaseCurrency() string
+	// RateToBase возвращает курс валюты к базовой на дату at; для базовой валюты - 1.
+	RateToBase(currency string, at time.Time) (decimal.Decimal, error)
+	SetRate(rate models.ExchangeRate) error
+	// ImportCSV загружает курсы из CSV "валюта;дата;курс", дата в формате YYYY-MM-DD. Возвращает число строк.
+	ImportCSV(r io.Reader) (int, error)
+	ListRates(currency string) ([]models.ExchangeRate, error)
+}
+
+type currencyService struct {
+	repo         repository.CurrencyRepository
+	baseCurrency string
+}
+
+func NewCurrencyService(repo repository.CurrencyRepository, baseCurrency string) CurrencyService {
+	return &currencyService{repo: repo, baseCurrency: normalizeCurrency(baseCurrency)}
+}
+
+func (s *currencyService) BaseCurrency() string { return s.baseCurrency }
+
+func (s *currencyService) RateToBase(currency string, at time.Time) (decimal.Decimal, error) {
+	currency = normalizeCurrency(currency)
+	if currency == "" || currency == s.baseCurrency {
+		return decimal.NewFromInt(1), nil
+	}
+	rate, err := s.repo.GetRate(currency, at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if rate == nil {
+		return decimal.Zero, fmt.Errorf("no exchange rate for %s on %s", currency, at.Format("2006-01-02"))
+	}
+	return rate.Rate, nil
+}
+
+func (s *currencyService) SetRate(rate models.ExchangeRate) error {
+	if err := s.validateRate(&rate); err != nil {
+		return err
+	}
+	return s.repo.UpsertRates([]models.ExchangeRate{rate})
+}
+
+func (s *currencyService) ImportCSV(r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = ';'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		firstCell := strings.ToLower(strings.TrimSpace(rows[0][0]))
+		if strings.Contains(firstCell, "валюта") || strings.Contains(firstCell, "currency") {
+			rows = rows[1:]
+		}
+	}
+
+	var rates []models.ExchangeRate
+	for i, row := range rows {
+		if len(row) == 0 || (len(row) == 1 && strings.TrimSpace(row[0]) == "") {
+			continue
+		}
+		if len(row) < 3 {
+			return 0, fmt.Errorf("line %d: expected currency;date;rate", i+1)
+		}
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(row[1]))
+		if err != nil {
+			return 0, fmt.Errorf("line %d: invalid date '%s'", i+1, row[1])
+		}
+		// допускаем десятичную запятую, как в выгрузках ЦБ
+		value, err := decimal.NewFromString(strings.ReplaceAll(strings.TrimSpace(row[2]), ",", "."))
+		if err != nil {
+			return 0, fmt.Errorf("line %d: invalid rate '%s'", i+1, row[2])
+		}
+
+		rate := models.ExchangeRate{Currency: row[0], Date: date, Rate: value}
+		if err := s.validateRate(&rate); err != nil {
+			return 0, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		rates = append(rates, rate)
+	}
+
+	if err := s.repo.UpsertRates(rates); err != nil {
+		return 0, err
+	}
+	return len(rates), nil
+}
+
+func (s *currencyService) ListRates(currency string) ([]models.ExchangeRate, error) {
+	return s.repo.ListRates(normalizeCurrency(currency))
+}
+
+func (s *currencyService) validateRate(rate *models.ExchangeRate) error {
+	rate.Currency = normalizeCurrency(rate.Currency)
+	if len(rate.Currency) != 3 {
+		return errors.New("currency must be a 3-letter ISO code")
+	}
+	if rate.Currency == s.baseCurrency {
+		return errors.New("rate for the base currency is always 1")
+	}
+	if !rate.Rate.IsPositive() {
+		return errors.New("rate must be positive")
+	}
+	rate.Date = time.Date(rate.Date.Year(), rate.Date.Month(), rate.Date.Day(), 0, 0, 0, 0, time.UTC)
+	return nil
+}
+
+func normalizeCurrency(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// baseUnitCost переводит цену строки документа в базовую валюту по курсу, зафиксированному при проведении.
+func baseUnitCost(doc *models.Document, price decimal.Decimal) decimal.Decimal {
+	if doc.ExchangeRate.IsZero() || doc.ExchangeRate.Equal(decimal.NewFromInt(1)) {
+		return price
+	}
+	return price.Mul(doc.ExchangeRate).Round(4)
+}
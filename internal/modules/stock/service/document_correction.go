@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func (s *documentService) Correct(id uint, payload *models.DocumentUpdateDTO, userID *uint) (*models.DocumentDTO, error) {
+	if len(payload.Items) == 0 {
+		return nil, errors.New("corrected document must have items")
+	}
+	if err := s.units.NormalizeItems(payload.Items); err != nil {
+		return nil, err
+	}
+
+	var doc *models.Document
+	err := s.tx.DoInTx(func(tx *gorm.DB) error {
+		var err error
+		doc, err = s.repo.GetByIDWithTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(doc, payload.Version); err != nil {
+			return err
+		}
+		if doc.Status != "posted" {
+			return errors.New("only posted documents can be corrected")
+		}
+		if toUpper(doc.Type) == "ORDER" && orderHasShipments(doc) {
+			return errors.New("order has posted shipments, cancel them first")
+		}
+		if returned, err := hasPostedReturns(tx, s.repo, doc); err != nil {
+			return err
+		} else if returned {
+			return errors.New("document has posted returns, cancel them first")
+		}
+		if err := s.inventory.CheckLotsUnusedWithTx(tx, doc); err != nil {
+			return err
+		}
+		if err := s.periods.CheckDocumentWithTx(tx, doc); err != nil {
+			return err
+		}
+
+		before := snapshotDocument(doc)
+		previous := *doc
+		if err := s.revertWithTx(tx, doc); err != nil {
+			return fmt.Errorf("failed to revert posted document: %w", err)
+		}
+
+		applyUpdateHeader(doc, payload)
+		if err := s.periods.CheckDocumentWithTx(tx, doc); err != nil {
+			return err
+		}
+		// исправление не должно обходить согласование: под правило документ снова проводится только новым документом
+		required, err := s.approvals.RequiresApproval(doc)
+		if err != nil {
+			return err
+		}
+		if required {
+			return fmt.Errorf("%w: corrected document falls under an approval rule, cancel it and create a new one", ErrApprovalRequired)
+		}
+		if err := s.checkWriteOffReason(doc); err != nil {
+			return err
+		}
+		if err := s.fixExchangeRate(doc); err != nil {
+			return err
+		}
+
+		if err := tx.Where("document_id = ?", doc.ID).Delete(&models.DocumentItem{}).Error; err != nil {
+			return fmt.Errorf("failed to delete old document items: %w", err)
+		}
+		// строки нужны с ID до проведения: на них ссылаются резервы заказа
+		items := payload.Items
+		for i := range items {
+			items[i].ID = 0
+			items[i].DocumentID = doc.ID
+		}
+		if err := tx.Create(&items).Error; err != nil {
+			return fmt.Errorf("failed to save corrected items: %w", err)
+		}
+		doc.Items = items
+
+		// шапку сохраняем до проведения: движения берут учётную дату документа из базы
+		if err := tx.Omit(clause.Associations).Save(doc).Error; err != nil {
+			return fmt.Errorf("failed to save corrected header: %w", err)
+		}
+		if err := s.applyWithTx(tx, doc); err != nil {
+			return err
+		}
+		// списания, опиравшиеся на прежние партии документа, перераспределяются по тому, что осталось
+		if err := s.inventory.ResequenceLotsWithTx(tx, &previous); err != nil {
+			return err
+		}
+		if _, err := s.repo.UpdateWithTx(tx, doc); err != nil {
+			return err
+		}
+
+		h := &models.DocumentHistory{
+			DocumentID: doc.ID, Action: "corrected", CreatedAt: time.Now(), CreatedBy: actor(userID, doc),
+			Snapshot: &models.DocumentCorrection{Before: before, After: snapshotDocument(doc)},
+		}
+		return s.historyRepo.CreateWithTx(tx, h)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.buildDTO(doc)
+}
+
+func snapshotDocument(doc *models.Document) models.DocumentSnapshot {
+	snapshot := models.DocumentSnapshot{
+		WarehouseID: doc.WarehouseID, ToWarehouseID: doc.ToWarehouseID, CounterpartyID: doc.CounterpartyID,
+		DocumentDate: documentDate(doc), Currency: doc.Currency, Comment: doc.Comment, WriteOffReasonID: doc.WriteOffReasonID,
+		Items: make([]models.DocumentItemSnapshot, len(doc.Items)),
+	}
+	for i, item := range doc.Items {
+		snapshot.Items[i] = models.DocumentItemSnapshot{
+			VariantID: item.VariantID, Quantity: item.Quantity, Price: item.Price,
+			UnitID: item.UnitID, UnitQuantity: item.UnitQuantity,
+			BatchNumber: item.BatchNumber, SerialNumbers: item.SerialNumbers,
+		}
+	}
+	return snapshot
+}
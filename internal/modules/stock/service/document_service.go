@@ -4,22 +4,53 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+	"github.com/shopspring/decimal"
 )
 
+// VersionConflictError - документ изменён после того, как клиент прочитал версию Expected.
+type VersionConflictError struct {
+	Expected uint
+	Current  uint
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("document was modified by someone else: expected version %d, current version is %d", e.Expected, e.Current)
+}
+
 type DocumentService interface {
-	Post(id uint) error
-	Cancel(id uint) error
+	// Post проводит документ; version, если задана, должна совпадать с текущей версией документа.
+	Post(id uint, userID *uint, version *uint) error
+	Cancel(id uint, userID *uint) error
+	// PostWithTx и CancelWithTx - то же в транзакции вызывающего, для массовой обработки.
+	PostWithTx(tx *gorm.DB, id uint, userID *uint) error
+	CancelWithTx(tx *gorm.DB, id uint, userID *uint) error
+	Ship(id uint, userID *uint) error
+	Receive(id uint, received map[uint]decimal.Decimal, userID *uint) error
+	// Submit, Approve и Reject - шаги согласования документа перед проведением.
+	Submit(id uint, userID *uint) error
+	Approve(id uint, userID *uint) error
+	// ApproverPermissions - права согласующего сверх approve_document, которых требуют правила для документа.
+	ApproverPermissions(doc *models.Document) ([]string, error)
+	Reject(id uint, userID *uint, reason string) error
+	GetHistory(id uint) ([]models.DocumentHistory, error)
+	// CreateBasedOn создаёт черновик на основании проведённого документа, GetFulfillment - выполнение заказа.
+	CreateBasedOn(baseID uint, docType string, userID *uint) (*models.Document, error)
+	GetFulfillment(orderID uint) (*models.OrderFulfillmentDTO, error)
 	Create(doc *models.Document) (*models.Document, error)
 	GetByID(id uint) (*models.Document, error)
 	GetByIDAsDTO(id uint) (*models.DocumentDTO, error)
 	ListAsDTO(status string) ([]models.DocumentListItemDTO, error)
 	Update(id uint, updatePayload *models.DocumentUpdateDTO) (*models.DocumentDTO, error)
+	// Correct исправляет проведённый документ: откатывает его движения, применяет новые шапку и строки
+	// и проводит заново под тем же номером.
+	Correct(id uint, payload *models.DocumentUpdateDTO, userID *uint) (*models.DocumentDTO, error)
 	Delete(id uint) error
 
 	SearchAsDTO(filter models.DocumentFilter) ([]models.DocumentListItemDTO, error)
@@ -37,22 +68,178 @@ type documentService struct {
 	whRepo       repository.WarehouseRepository
 	cpRepo       repository.CounterpartyRepository
 	ptRepo       repository.PriceTypeRepository
+	currency     CurrencyService
+	approvals    ApprovalService
+	reasonRepo   repository.WriteOffReasonRepository
+	units        UnitService
+	periods      PeriodService
 }
 
 func NewDocumentService(
 	repo repository.DocumentRepository, historyRepo repository.DocumentHistoryRepository, inventory InventoryService,
 	priceService PriceService, sequenceSvc SequenceService, tx repository.TxManager,
 	variantRepo repository.VariantRepository, productRepo repository.ProductRepository, whRepo repository.WarehouseRepository,
-	cpRepo repository.CounterpartyRepository, ptRepo repository.PriceTypeRepository,
+	cpRepo repository.CounterpartyRepository, ptRepo repository.PriceTypeRepository, currency CurrencyService,
+	approvals ApprovalService, reasonRepo repository.WriteOffReasonRepository, units UnitService,
+	periods PeriodService,
 ) DocumentService {
 	return &documentService{
 		repo: repo, historyRepo: historyRepo, inventory: inventory, priceService: priceService,
 		sequenceSvc: sequenceSvc, tx: tx, variantRepo: variantRepo, productRepo: productRepo,
-		whRepo: whRepo, cpRepo: cpRepo, ptRepo: ptRepo,
+		whRepo: whRepo, cpRepo: cpRepo, ptRepo: ptRepo, currency: currency, approvals: approvals,
+		reasonRepo: reasonRepo, units: units, periods: periods,
+	}
+}
+
+func (s *documentService) Post(id uint, userID *uint, version *uint) error {
+	err := s.tx.DoInTx(func(tx *gorm.DB) error {
+		if version != nil {
+			doc, err := s.repo.GetByIDWithTx(tx, id)
+			if err != nil {
+				return err
+			}
+			if err := checkVersion(doc, version); err != nil {
+				return err
+			}
+		}
+		return s.PostWithTx(tx, id, userID)
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to post document ID=%d: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// PostWithTx проводит документ в транзакции вызывающего, не фиксируя её.
+func (s *documentService) PostWithTx(tx *gorm.DB, id uint, userID *uint) error {
+	doc, err := s.repo.GetByIDWithTx(tx, id)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return errors.New("document not found")
+	}
+	if doc.Status == "posted" {
+		return errors.New("document already posted")
+	}
+	if doc.Status == "canceled" {
+		return errors.New("cannot post canceled document")
+	}
+	if doc.Status == "in_transit" {
+		return errors.New("document is in transit, use receive to complete it")
+	}
+	if err := s.periods.CheckDocumentWithTx(tx, doc); err != nil {
+		return err
+	}
+	if err := s.checkApproved(doc); err != nil {
+		return err
+	}
+	if err := s.checkWriteOffReason(doc); err != nil {
+		return err
+	}
+	if err := s.fixExchangeRate(doc); err != nil {
+		return err
+	}
+	if err := s.applyWithTx(tx, doc); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	doc.Status = "posted"
+	doc.PostedAt = &now
+	if _, err := s.repo.UpdateWithTx(tx, doc); err != nil {
+		return err
+	}
+
+	h := &models.DocumentHistory{DocumentID: doc.ID, Action: "posted", CreatedAt: now, CreatedBy: actor(userID, doc)}
+	return s.historyRepo.CreateWithTx(tx, h)
+}
+
+// applyWithTx проводит движения документа: отгрузку по заказу, остатки или цены.
+func (s *documentService) applyWithTx(tx *gorm.DB, doc *models.Document) error {
+	if err := s.trackOrderFulfillment(tx, doc, 1); err != nil {
+		return err
+	}
+	if toUpper(doc.Type) == "ORDER" {
+		doc.FulfillmentStatus = fulfillmentOpen
+	}
+
+	switch toUpper(doc.Type) {
+	case "INCOME", "OUTCOME", "ORDER", "TRANSFER", "INVENTORY", "RETURN_IN", "RETURN_OUT", "WRITE_OFF", "ASSEMBLY", "DISASSEMBLY":
+		if err := s.inventory.ProcessDocumentWithTx(tx, doc); err != nil {
+			return fmt.Errorf("inventory processing failed: %w", err)
+		}
+	case "PRICE_UPDATE":
+		if err := s.priceService.UpdatePricesFromDocumentWithTx(tx, doc); err != nil {
+			return fmt.Errorf("price update processing failed: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown document type to post: '%s'", doc.Type)
+	}
+	return nil
+}
+
+// revertWithTx откатывает движения, проведённые applyWithTx.
+func (s *documentService) revertWithTx(tx *gorm.DB, doc *models.Document) error {
+	if err := s.trackOrderFulfillment(tx, doc, -1); err != nil {
+		return err
 	}
+
+	switch toUpper(doc.Type) {
+	case "INCOME", "OUTCOME", "ORDER", "TRANSFER", "INVENTORY", "RETURN_IN", "RETURN_OUT", "WRITE_OFF", "ASSEMBLY", "DISASSEMBLY":
+		return s.inventory.RevertDocumentWithTx(tx, doc)
+	case "PRICE_UPDATE":
+		return s.priceService.RevertPricesFromDocumentWithTx(tx, doc)
+	default:
+		return fmt.Errorf("unknown document type to cancel: '%s'", doc.Type)
+	}
+}
+
+func (s *documentService) Cancel(id uint, userID *uint) error {
+	return s.tx.DoInTx(func(tx *gorm.DB) error {
+		return s.CancelWithTx(tx, id, userID)
+	})
+}
+
+// CancelWithTx отменяет документ в транзакции вызывающего, не фиксируя её.
+func (s *documentService) CancelWithTx(tx *gorm.DB, id uint, userID *uint) error {
+	doc, err := s.repo.GetByIDWithTx(tx, id)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return errors.New("document not found")
+	}
+	if doc.Status != "posted" && doc.Status != "in_transit" {
+		return errors.New("only posted documents can be canceled")
+	}
+	if err := s.periods.CheckDocumentWithTx(tx, doc); err != nil {
+		return err
+	}
+	if toUpper(doc.Type) == "ORDER" && orderHasShipments(doc) {
+		return errors.New("order has posted shipments, cancel them first")
+	}
+	if returned, err := hasPostedReturns(tx, s.repo, doc); err != nil {
+		return err
+	} else if returned {
+		return errors.New("document has posted returns, cancel them first")
+	}
+	if err := s.revertWithTx(tx, doc); err != nil {
+		return err
+	}
+
+	doc.Status = "canceled"
+	if _, err := s.repo.UpdateWithTx(tx, doc); err != nil {
+		return err
+	}
+
+	h := &models.DocumentHistory{DocumentID: doc.ID, Action: "canceled", CreatedAt: time.Now(), CreatedBy: actor(userID, doc)}
+	return s.historyRepo.CreateWithTx(tx, h)
 }
 
-func (s *documentService) Post(id uint) error {
+// Ship отгружает перемещение со склада-отправителя: товар списывается и числится в пути до приёмки.
+func (s *documentService) Ship(id uint, userID *uint) error {
 	err := s.tx.DoInTx(func(tx *gorm.DB) error {
 		doc, err := s.repo.GetByIDWithTx(tx, id)
 		if err != nil {
@@ -61,24 +248,60 @@ func (s *documentService) Post(id uint) error {
 		if doc == nil {
 			return errors.New("document not found")
 		}
-		if doc.Status == "posted" {
-			return errors.New("document already posted")
+		if toUpper(doc.Type) != "TRANSFER" {
+			return errors.New("only TRANSFER documents can be shipped")
+		}
+		if doc.Status != "draft" && doc.Status != "approved" {
+			return errors.New("only draft or approved documents can be shipped")
+		}
+		if err := s.periods.CheckDocumentWithTx(tx, doc); err != nil {
+			return err
+		}
+		if err := s.checkApproved(doc); err != nil {
+			return err
 		}
-		if doc.Status == "canceled" {
-			return errors.New("cannot post canceled document")
+		if err := s.fixExchangeRate(doc); err != nil {
+			return err
 		}
 
-		switch toUpper(doc.Type) {
-		case "INCOME", "OUTCOME", "ORDER", "TRANSFER", "INVENTORY":
-			if err := s.inventory.ProcessDocumentWithTx(tx, doc); err != nil {
-				return fmt.Errorf("inventory processing failed: %w", err)
-			}
-		case "PRICE_UPDATE":
-			if err := s.priceService.UpdatePricesFromDocumentWithTx(tx, doc); err != nil {
-				return fmt.Errorf("price update processing failed: %w", err)
-			}
-		default:
-			return fmt.Errorf("unknown document type to post: '%s'", doc.Type)
+		if err := s.inventory.ShipTransferWithTx(tx, doc); err != nil {
+			return fmt.Errorf("inventory processing failed: %w", err)
+		}
+
+		doc.Status = "in_transit"
+		if _, err := s.repo.UpdateWithTx(tx, doc); err != nil {
+			return err
+		}
+
+		h := &models.DocumentHistory{DocumentID: doc.ID, Action: "shipped", CreatedAt: time.Now(), CreatedBy: actor(userID, doc)}
+		return s.historyRepo.CreateWithTx(tx, h)
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to ship document ID=%d: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// Receive завершает перемещение на складе-получателе. received == nil означает приёмку в полном объёме.
+func (s *documentService) Receive(id uint, received map[uint]decimal.Decimal, userID *uint) error {
+	err := s.tx.DoInTx(func(tx *gorm.DB) error {
+		doc, err := s.repo.GetByIDWithTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			return errors.New("document not found")
+		}
+		if doc.Status != "in_transit" {
+			return errors.New("only documents in transit can be received")
+		}
+		if err := s.periods.CheckDocumentWithTx(tx, doc); err != nil {
+			return err
+		}
+
+		if err := s.inventory.ReceiveTransferWithTx(tx, doc, received); err != nil {
+			return fmt.Errorf("inventory processing failed: %w", err)
 		}
 
 		now := time.Now()
@@ -88,17 +311,18 @@ func (s *documentService) Post(id uint) error {
 			return err
 		}
 
-		h := &models.DocumentHistory{DocumentID: doc.ID, Action: "posted", CreatedAt: now, CreatedBy: doc.CreatedBy}
+		h := &models.DocumentHistory{DocumentID: doc.ID, Action: "received", CreatedAt: now, CreatedBy: actor(userID, doc)}
 		return s.historyRepo.CreateWithTx(tx, h)
 	})
 	if err != nil {
-		log.Printf("[ERROR] Failed to post document ID=%d: %v", id, err)
+		log.Printf("[ERROR] Failed to receive document ID=%d: %v", id, err)
 		return err
 	}
 	return nil
 }
 
-func (s *documentService) Cancel(id uint) error {
+// Submit отправляет черновик (или исправленный отклонённый документ) на согласование.
+func (s *documentService) Submit(id uint, userID *uint) error {
 	return s.tx.DoInTx(func(tx *gorm.DB) error {
 		doc, err := s.repo.GetByIDWithTx(tx, id)
 		if err != nil {
@@ -107,34 +331,109 @@ func (s *documentService) Cancel(id uint) error {
 		if doc == nil {
 			return errors.New("document not found")
 		}
-		if doc.Status != "posted" {
-			return errors.New("only posted documents can be canceled")
+		if doc.Status != "draft" && doc.Status != "rejected" {
+			return errors.New("only draft or rejected documents can be submitted")
 		}
 
-		switch toUpper(doc.Type) {
-		case "INCOME", "OUTCOME", "ORDER", "TRANSFER", "INVENTORY":
-			if err := s.inventory.RevertDocumentWithTx(tx, doc); err != nil {
-				return err
-			}
-		case "PRICE_UPDATE":
-			return errors.New("cancellation for 'PRICE_UPDATE' is not yet implemented")
-		default:
-			return fmt.Errorf("unknown document type to cancel: '%s'", doc.Type)
+		doc.Status = "submitted"
+		doc.RejectReason = ""
+		if _, err := s.repo.UpdateWithTx(tx, doc); err != nil {
+			return err
+		}
+		h := &models.DocumentHistory{DocumentID: doc.ID, Action: "submitted", CreatedAt: time.Now(), CreatedBy: actor(userID, doc)}
+		return s.historyRepo.CreateWithTx(tx, h)
+	})
+}
+
+func (s *documentService) Approve(id uint, userID *uint) error {
+	return s.tx.DoInTx(func(tx *gorm.DB) error {
+		doc, err := s.repo.GetByIDWithTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			return errors.New("document not found")
+		}
+		if doc.Status != "submitted" {
+			return errors.New("only submitted documents can be approved")
+		}
+		if userID != nil && doc.CreatedBy != nil && *userID == *doc.CreatedBy {
+			return ErrSelfApproval
 		}
 
-		doc.Status = "canceled"
+		now := time.Now()
+		doc.Status = "approved"
+		doc.ApprovedBy = userID
+		doc.ApprovedAt = &now
 		if _, err := s.repo.UpdateWithTx(tx, doc); err != nil {
 			return err
 		}
+		h := &models.DocumentHistory{DocumentID: doc.ID, Action: "approved", CreatedAt: now, CreatedBy: actor(userID, doc)}
+		return s.historyRepo.CreateWithTx(tx, h)
+	})
+}
+
+func (s *documentService) ApproverPermissions(doc *models.Document) ([]string, error) {
+	return s.approvals.ApproverPermissions(doc)
+}
+
+// Reject возвращает документ автору; причина обязательна и сохраняется в документе и истории.
+func (s *documentService) Reject(id uint, userID *uint, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return errors.New("reject reason is required")
+	}
+	return s.tx.DoInTx(func(tx *gorm.DB) error {
+		doc, err := s.repo.GetByIDWithTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			return errors.New("document not found")
+		}
+		if doc.Status != "submitted" {
+			return errors.New("only submitted documents can be rejected")
+		}
 
-		h := &models.DocumentHistory{DocumentID: doc.ID, Action: "canceled", CreatedAt: time.Now(), CreatedBy: doc.CreatedBy}
+		doc.Status = "rejected"
+		doc.RejectReason = reason
+		if _, err := s.repo.UpdateWithTx(tx, doc); err != nil {
+			return err
+		}
+		h := &models.DocumentHistory{
+			DocumentID: doc.ID, Action: "rejected", CreatedAt: time.Now(), CreatedBy: actor(userID, doc), Comment: reason,
+		}
 		return s.historyRepo.CreateWithTx(tx, h)
 	})
 }
 
+func (s *documentService) GetHistory(id uint) ([]models.DocumentHistory, error) {
+	doc, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, errors.New("document not found")
+	}
+	return s.historyRepo.GetByDocumentID(id)
+}
+
 func (s *documentService) Create(doc *models.Document) (*models.Document, error) {
-	if doc.Status == "" {
-		doc.Status = "draft"
+	// статус меняется только действиями над документом, иначе можно обойти согласование
+	doc.Status = "draft"
+	doc.RejectReason, doc.ApprovedBy, doc.ApprovedAt = "", nil, nil
+	doc.FulfillmentStatus = ""
+	doc.Version = 1
+	if doc.DocumentDate.IsZero() {
+		doc.DocumentDate = time.Now()
+	}
+	// строки всегда создаются заново: с чужими ID они перепривязались бы от документа-источника
+	for i := range doc.Items {
+		doc.Items[i].ID = 0
+		doc.Items[i].ShippedQuantity = decimal.Zero
+	}
+	if err := s.units.NormalizeItems(doc.Items); err != nil {
+		return nil, err
 	}
 	newNumber, err := s.sequenceSvc.GenerateNextDocumentNumber(doc.Type)
 	if err != nil {
@@ -148,6 +447,9 @@ func (s *documentService) GetByID(id uint) (*models.Document, error) { return s.
 
 func (s *documentService) Update(id uint, updatePayload *models.DocumentUpdateDTO) (*models.DocumentDTO, error) {
 	var finalDoc *models.Document
+	if err := s.units.NormalizeItems(updatePayload.Items); err != nil {
+		return nil, err
+	}
 
 	err := s.tx.DoInTx(func(tx *gorm.DB) error {
 		docToUpdate, err := s.repo.GetByIDWithTx(tx, id)
@@ -158,20 +460,31 @@ func (s *documentService) Update(id uint, updatePayload *models.DocumentUpdateDT
 			return errors.New("document not found")
 		}
 
-		if docToUpdate.Status != "draft" {
-			return errors.New("only draft documents can be edited")
+		if err := checkVersion(docToUpdate, updatePayload.Version); err != nil {
+			return err
+		}
+		if docToUpdate.Status != "draft" && docToUpdate.Status != "rejected" {
+			return errors.New("only draft or rejected documents can be edited")
 		}
+		if err := s.periods.CheckDocumentWithTx(tx, docToUpdate); err != nil {
+			return err
+		}
+		// исправленный после отклонения документ снова становится черновиком и заново идёт на согласование
+		docToUpdate.Status = "draft"
+		docToUpdate.RejectReason = ""
 
-		docToUpdate.WarehouseID = updatePayload.WarehouseID
-		docToUpdate.CounterpartyID = updatePayload.CounterpartyID
-		docToUpdate.PriceTypeID = updatePayload.PriceTypeID
-		docToUpdate.Comment = updatePayload.Comment
+		applyUpdateHeader(docToUpdate, updatePayload)
+		// перенести документ в закрытый период тоже нельзя
+		if err := s.periods.CheckDocumentWithTx(tx, docToUpdate); err != nil {
+			return err
+		}
 
 		if err := tx.Where("document_id = ?", docToUpdate.ID).Delete(&models.DocumentItem{}).Error; err != nil {
 			return fmt.Errorf("failed to delete old document items: %w", err)
 		}
 
 		docToUpdate.Items = updatePayload.Items
+		docToUpdate.Version++
 
 		if err := tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(docToUpdate).Error; err != nil {
 			return fmt.Errorf("failed to save updated document: %w", err)
@@ -187,7 +500,21 @@ func (s *documentService) Update(id uint, updatePayload *models.DocumentUpdateDT
 	return s.buildDTO(finalDoc)
 }
 
-func (s *documentService) Delete(id uint) error { return s.repo.Delete(id) }
+func (s *documentService) Delete(id uint) error {
+	return s.tx.DoInTx(func(tx *gorm.DB) error {
+		doc, err := s.repo.GetByIDWithTx(tx, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.periods.CheckDocumentWithTx(tx, doc); err != nil {
+			return err
+		}
+		return s.repo.DeleteWithTx(tx, id)
+	})
+}
 
 func (s *documentService) GetByIDAsDTO(id uint) (*models.DocumentDTO, error) {
 	doc, err := s.repo.GetByID(id)
@@ -239,14 +566,16 @@ func (s *documentService) ListAsDTO(status string) ([]models.DocumentListItemDTO
 		}
 
 		dtos[i] = models.DocumentListItemDTO{
-			ID:               doc.ID,
-			Type:             doc.Type,
-			Number:           doc.Number,
-			WarehouseName:    whName,
-			CounterpartyName: cpName,
-			ItemCount:        len(doc.Items),
-			Status:           doc.Status,
-			CreatedAt:        doc.CreatedAt,
+			ID:                doc.ID,
+			Type:              doc.Type,
+			Number:            doc.Number,
+			WarehouseName:     whName,
+			CounterpartyName:  cpName,
+			ItemCount:         len(doc.Items),
+			Status:            doc.Status,
+			FulfillmentStatus: doc.FulfillmentStatus,
+			DocumentDate:      documentDate(&doc),
+			CreatedAt:         doc.CreatedAt,
 		}
 	}
 
@@ -300,25 +629,120 @@ func (s *documentService) SearchAsDTO(filter models.DocumentFilter) ([]models.Do
 		}
 
 		dtos[i] = models.DocumentListItemDTO{
-			ID:               doc.ID,
-			Type:             doc.Type,
-			Number:           doc.Number,
-			WarehouseName:    whName,
-			CounterpartyName: cpName,
-			ItemCount:        len(doc.Items),
-			Status:           doc.Status,
-			CreatedAt:        doc.CreatedAt,
+			ID:                doc.ID,
+			Type:              doc.Type,
+			Number:            doc.Number,
+			WarehouseName:     whName,
+			CounterpartyName:  cpName,
+			ItemCount:         len(doc.Items),
+			Status:            doc.Status,
+			FulfillmentStatus: doc.FulfillmentStatus,
+			DocumentDate:      documentDate(&doc),
+			CreatedAt:         doc.CreatedAt,
 		}
 	}
 
 	return dtos, nil
 }
 
+// checkApproved пропускает к проведению согласованный документ либо черновик, не подпадающий под правила согласования.
+func (s *documentService) checkApproved(doc *models.Document) error {
+	switch doc.Status {
+	case "approved":
+		return nil
+	case "submitted":
+		return errors.New("document is awaiting approval")
+	case "rejected":
+		return errors.New("document was rejected, edit and submit it again")
+	}
+	required, err := s.approvals.RequiresApproval(doc)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrApprovalRequired
+	}
+	return nil
+}
+
+// applyUpdateHeader переносит в документ поля шапки из правки; строки заменяются отдельно.
+func applyUpdateHeader(doc *models.Document, payload *models.DocumentUpdateDTO) {
+	doc.WarehouseID = payload.WarehouseID
+	doc.ToWarehouseID = payload.ToWarehouseID
+	doc.CounterpartyID = payload.CounterpartyID
+	doc.PriceTypeID = payload.PriceTypeID
+	doc.EffectiveFrom = payload.EffectiveFrom
+	doc.EffectiveTo = payload.EffectiveTo
+	doc.Currency = payload.Currency
+	doc.Comment = payload.Comment
+	if payload.DocumentDate != nil {
+		doc.DocumentDate = *payload.DocumentDate
+	}
+	doc.ReservedUntil = payload.ReservedUntil
+	doc.WriteOffReasonID = payload.WriteOffReasonID
+}
+
+// checkVersion сверяет версию, с которой работал клиент, с текущей; nil - без проверки.
+func checkVersion(doc *models.Document, version *uint) error {
+	if version != nil && *version != doc.Version {
+		return &VersionConflictError{Expected: *version, Current: doc.Version}
+	}
+	return nil
+}
+
+// actor - автор действия для истории: пользователь из запроса, для внутренних вызовов - автор документа.
+func actor(userID *uint, doc *models.Document) *uint {
+	if userID != nil {
+		return userID
+	}
+	return doc.CreatedBy
+}
+
+// documentDate - учётная дата документа; у документов, созданных до её появления, это дата создания.
+func documentDate(doc *models.Document) time.Time {
+	if doc.DocumentDate.IsZero() {
+		return doc.CreatedAt
+	}
+	return doc.DocumentDate
+}
+
+// checkWriteOffReason требует у списания причину из справочника.
+func (s *documentService) checkWriteOffReason(doc *models.Document) error {
+	if toUpper(doc.Type) != "WRITE_OFF" {
+		return nil
+	}
+	if doc.WriteOffReasonID == nil {
+		return errors.New("write_off_reason_id is required for WRITE_OFF")
+	}
+	if _, err := s.reasonRepo.GetByID(*doc.WriteOffReasonID); err != nil {
+		return fmt.Errorf("write-off reason %d not found", *doc.WriteOffReasonID)
+	}
+	return nil
+}
+
+// fixExchangeRate фиксирует валюту документа и её курс к базовой на дату документа.
+func (s *documentService) fixExchangeRate(doc *models.Document) error {
+	if doc.Currency == "" {
+		doc.Currency = s.currency.BaseCurrency()
+	}
+	rate, err := s.currency.RateToBase(doc.Currency, documentDate(doc))
+	if err != nil {
+		return err
+	}
+	doc.Currency = normalizeCurrency(doc.Currency)
+	doc.ExchangeRate = rate
+	return nil
+}
+
 func (s *documentService) buildDTO(doc *models.Document) (*models.DocumentDTO, error) {
 	dto := &models.DocumentDTO{
-		ID: doc.ID, Type: doc.Type, Number: doc.Number, Comment: doc.Comment,
-		BaseDocumentID: doc.BaseDocumentID, Status: doc.Status, PostedAt: doc.PostedAt, CreatedAt: doc.CreatedAt,
+		ID: doc.ID, Type: doc.Type, Number: doc.Number, Comment: doc.Comment, DocumentDate: documentDate(doc),
+		BaseDocumentID: doc.BaseDocumentID, FulfillmentStatus: doc.FulfillmentStatus, ReservedUntil: doc.ReservedUntil, Version: doc.Version,
+		Status: doc.Status, PostedAt: doc.PostedAt, CreatedAt: doc.CreatedAt,
+		RejectReason: doc.RejectReason, ApprovedBy: doc.ApprovedBy, ApprovedAt: doc.ApprovedAt,
 		WarehouseID: doc.WarehouseID, ToWarehouseID: doc.ToWarehouseID, CounterpartyID: doc.CounterpartyID, PriceTypeID: doc.PriceTypeID,
+		EffectiveFrom: doc.EffectiveFrom, EffectiveTo: doc.EffectiveTo,
+		Currency: doc.Currency, ExchangeRate: doc.ExchangeRate, WriteOffReasonID: doc.WriteOffReasonID,
 	}
 
 	if len(doc.Items) > 0 {
@@ -328,6 +752,13 @@ func (s *documentService) buildDTO(doc *models.Document) (*models.DocumentDTO, e
 		}
 
 		variantMap, productMap := s.loadVariantAndProductMaps(variantIDs)
+		var unitIDs []uint
+		for _, item := range doc.Items {
+			if item.UnitID != nil {
+				unitIDs = append(unitIDs, *item.UnitID)
+			}
+		}
+		unitNames := s.units.UnitNames(unitIDs)
 
 		itemDTOs := make([]models.DocumentItemDTO, len(doc.Items))
 		for i, item := range doc.Items {
@@ -336,6 +767,15 @@ func (s *documentService) buildDTO(doc *models.Document) (*models.DocumentDTO, e
 			itemDTOs[i] = models.DocumentItemDTO{
 				ID: item.ID, VariantID: item.VariantID, VariantSKU: variant.SKU,
 				ProductName: product.Name, Quantity: item.Quantity, Price: item.Price,
+				BatchNumber: item.BatchNumber, ProductionDate: item.ProductionDate, ExpiryDate: item.ExpiryDate,
+				SerialNumbers: item.SerialNumbers, UnitQuantity: item.UnitQuantity,
+			}
+			if item.UnitID != nil {
+				itemDTOs[i].UnitID, itemDTOs[i].UnitName = item.UnitID, unitNames[*item.UnitID]
+			}
+			if toUpper(doc.Type) == "ORDER" {
+				shipped := item.ShippedQuantity
+				itemDTOs[i].ShippedQuantity = &shipped
 			}
 		}
 		dto.Items = itemDTOs
@@ -361,6 +801,11 @@ func (s *documentService) buildDTO(doc *models.Document) (*models.DocumentDTO, e
 			dto.PriceTypeName = pt.Name
 		}
 	}
+	if doc.WriteOffReasonID != nil {
+		if reason, _ := s.reasonRepo.GetByID(*doc.WriteOffReasonID); reason != nil {
+			dto.WriteOffReasonName = reason.Name
+		}
+	}
 	return dto, nil
 }
 
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+var (
+	ErrIdempotencyInProgress = errors.New("a request with this Idempotency-Key is still in progress")
+	ErrIdempotencyMismatch   = errors.New("Idempotency-Key has already been used for a different request")
+)
+
+// idempotencyLease - сколько ключ держится за незавершённым запросом. Запись старше считается брошенной
+// (процесс упал, не успев сохранить ответ или освободить ключ), и повтор выполняется заново.
+const idempotencyLease = 5 * time.Minute
+
+type IdempotencyService interface {
+	// Begin занимает ключ под запрос. Если по ключу уже сохранён ответ, возвращает его с replay = true.
+	Begin(userID uint, key, fingerprint string) (rec *models.IdempotencyRecord, replay bool, err error)
+	// Complete сохраняет ответ для повторов, Abort освобождает ключ, чтобы запрос можно было выполнить заново.
+	Complete(rec *models.IdempotencyRecord, status int, body []byte) error
+	Abort(rec *models.IdempotencyRecord) error
+}
+
+type idempotencyService struct {
+	repo      repository.IdempotencyRepository
+	retention time.Duration
+}
+
+func NewIdempotencyService(repo repository.IdempotencyRepository, retention time.Duration) IdempotencyService {
+	return &idempotencyService{repo: repo, retention: retention}
+}
+
+func (s *idempotencyService) Begin(userID uint, key, fingerprint string) (*models.IdempotencyRecord, bool, error) {
+	now := time.Now()
+	if err := s.repo.DeleteExpired(now); err != nil {
+		return nil, false, err
+	}
+	rec, err := s.repo.Find(userID, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if rec != nil {
+		if rec.Fingerprint != fingerprint {
+			return nil, false, ErrIdempotencyMismatch
+		}
+		switch {
+		case rec.StatusCode != 0:
+			return rec, true, nil
+		case rec.CreatedAt.After(now.Add(-idempotencyLease)):
+			return nil, false, ErrIdempotencyInProgress
+		}
+		if err := s.repo.Delete(rec.ID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	rec = &models.IdempotencyRecord{UserID: userID, Key: key, Fingerprint: fingerprint, ExpiresAt: now.Add(s.retention)}
+	if err := s.repo.Create(rec); err != nil {
+		// ключ мог занять параллельный повтор того же запроса
+		if existing, _ := s.repo.Find(userID, key); existing != nil {
+			return nil, false, ErrIdempotencyInProgress
+		}
+		return nil, false, err
+	}
+	return rec, false, nil
+}
+
+func (s *idempotencyService) Complete(rec *models.IdempotencyRecord, status int, body []byte) error {
+	rec.StatusCode = status
+	rec.Response = body
+	return s.repo.Save(rec)
+}
+
+func (s *idempotencyService) Abort(rec *models.IdempotencyRecord) error {
+	return s.repo.Delete(rec.ID)
+}
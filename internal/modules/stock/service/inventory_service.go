@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -22,6 +23,7 @@ type strategyFactoryImpl struct {
 	lotRepo      repository.LotRepository
 	variantRepo  repository.VariantRepository
 	productRepo  repository.ProductRepository
+	transitRepo  repository.TransitRepository
 }
 
 func NewStrategyFactory(
@@ -30,6 +32,7 @@ func NewStrategyFactory(
 	l repository.LotRepository,
 	v repository.VariantRepository,
 	p repository.ProductRepository,
+	t repository.TransitRepository,
 ) StrategyFactory {
 	return &strategyFactoryImpl{
 		balanceRepo:  b,
@@ -37,15 +40,18 @@ func NewStrategyFactory(
 		lotRepo:      l,
 		variantRepo:  v,
 		productRepo:  p,
+		transitRepo:  t,
 	}
 }
 
 func (f *strategyFactoryImpl) GetStrategy(policy string) (QuantityStrategy, error) {
 	switch policy {
 	case "total":
-		return NewTotalQuantityStrategy(f.balanceRepo, f.movementRepo), nil
-	case "fifo":
-		return NewFifoQuantityStrategy(f.lotRepo, f.movementRepo, f.balanceRepo, f.variantRepo, f.productRepo), nil
+		return NewTotalQuantityStrategy(f.balanceRepo, f.movementRepo, f.transitRepo), nil
+	case "average":
+		return NewAverageQuantityStrategy(f.balanceRepo, f.movementRepo, f.transitRepo), nil
+	case "fifo", "lifo", "fefo":
+		return NewFifoQuantityStrategy(f.lotRepo, f.movementRepo, f.balanceRepo, f.variantRepo, f.productRepo, f.transitRepo, repository.LotOrder(policy)), nil
 	default:
 		return nil, fmt.Errorf("unknown quantity accounting policy: %s", policy)
 	}
@@ -54,7 +60,15 @@ func (f *strategyFactoryImpl) GetStrategy(policy string) (QuantityStrategy, erro
 type InventoryService interface {
 	ProcessDocumentWithTx(tx *gorm.DB, doc *models.Document) error
 	RevertDocumentWithTx(tx *gorm.DB, doc *models.Document) error
+	ShipTransferWithTx(tx *gorm.DB, doc *models.Document) error
+	ReceiveTransferWithTx(tx *gorm.DB, doc *models.Document, received map[uint]decimal.Decimal) error
+	// ResequenceLotsWithTx заново списывает из партий более поздние документы по складам и вариантам doc.
+	ResequenceLotsWithTx(tx *gorm.DB, doc *models.Document) error
+	// CheckLotsUnusedWithTx отказывает, если из партий doc списывали документы, которые не перераспределяются по партиям.
+	CheckLotsUnusedWithTx(tx *gorm.DB, doc *models.Document) error
 	GetAvailableQuantity(warehouseID, variantID uint) (decimal.Decimal, error)
+	// GetAvailableQuantityWithTx видит остатки и резервы, уже изменённые в транзакции tx.
+	GetAvailableQuantityWithTx(tx *gorm.DB, warehouseID, variantID uint) (decimal.Decimal, error)
 	ListByWarehouseFilteredAsDTO(warehouseID uint, f models.StockFilter) ([]models.StockBalanceDTO, error)
 
 	GetStockByVariant(variantID uint) ([]models.VariantStockDTO, error)
@@ -65,6 +79,14 @@ type inventoryService struct {
 	balanceRepo     repository.BalanceRepository
 	config          *config.Config
 	whRepo          repository.WarehouseRepository
+	transitRepo     repository.TransitRepository
+	serials         *serialTracker
+
+	// для возвратов: движения и партии документа-основания
+	movementRepo repository.StockMovementRepository
+	lotRepo      repository.LotRepository
+	docRepo      repository.DocumentRepository
+	bomRepo      repository.BOMRepository
 
 	variantRepo repository.VariantRepository
 	productRepo repository.ProductRepository
@@ -82,6 +104,12 @@ func NewInventoryService(
 	u repository.UnitRepository,
 	catRepo repository.CategoryRepository,
 	whRepo repository.WarehouseRepository,
+	transitRepo repository.TransitRepository,
+	serialRepo repository.SerialRepository,
+	movementRepo repository.StockMovementRepository,
+	lotRepo repository.LotRepository,
+	docRepo repository.DocumentRepository,
+	bomRepo repository.BOMRepository,
 ) InventoryService {
 	return &inventoryService{
 		strategyFactory: factory,
@@ -93,10 +121,34 @@ func NewInventoryService(
 		unitRepo:        u,
 		catRepo:         catRepo,
 		whRepo:          whRepo,
+		transitRepo:     transitRepo,
+		serials:         newSerialTracker(serialRepo, v),
+		movementRepo:    movementRepo,
+		lotRepo:         lotRepo,
+		docRepo:         docRepo,
+		bomRepo:         bomRepo,
 	}
 }
 
 func (s *inventoryService) ProcessDocumentWithTx(tx *gorm.DB, doc *models.Document) error {
+	if err := s.processDocument(tx, doc); err != nil {
+		return err
+	}
+	if err := s.resequenceLots(tx, doc); err != nil {
+		return err
+	}
+	switch toUpper(doc.Type) {
+	case "INCOME", "OUTCOME", "ORDER", "TRANSFER":
+		return s.serials.apply(tx, doc, toUpper(doc.Type))
+	case "RETURN_IN":
+		return s.serials.apply(tx, doc, "INCOME")
+	case "RETURN_OUT", "WRITE_OFF":
+		return s.serials.apply(tx, doc, "OUTCOME")
+	}
+	return nil
+}
+
+func (s *inventoryService) processDocument(tx *gorm.DB, doc *models.Document) error {
 	strategy, err := s.strategyFactory.GetStrategy(s.config.AccountingPolicy)
 	if err != nil {
 		return err
@@ -114,14 +166,36 @@ func (s *inventoryService) ProcessDocumentWithTx(tx *gorm.DB, doc *models.Docume
 		return strategy.ProcessOutcome(tx, doc, s.config)
 	case "INCOME":
 		return strategy.ProcessIncome(tx, doc, s.config)
+	case "TRANSFER":
+		if err := validateTransfer(doc); err != nil {
+			return err
+		}
+		return strategy.ProcessTransfer(tx, doc, s.config)
 	case "INVENTORY":
 		return s.processInventory(tx, doc, strategy)
+	case "RETURN_IN":
+		return s.processReturnIn(tx, doc, strategy)
+	case "RETURN_OUT":
+		return s.processReturnOut(tx, doc, strategy)
+	case "WRITE_OFF":
+		if doc.WarehouseID == nil {
+			return errors.New("warehouse_id is required")
+		}
+		return strategy.ProcessOutcome(tx, doc, s.config)
+	case "ASSEMBLY":
+		return s.processAssembly(tx, doc, strategy)
+	case "DISASSEMBLY":
+		return s.processDisassembly(tx, doc, strategy)
 	default:
 		return fmt.Errorf("document type '%s' not supported for inventory processing", doc.Type)
 	}
 }
 
 func (s *inventoryService) RevertDocumentWithTx(tx *gorm.DB, doc *models.Document) error {
+	if err := s.serials.revert(tx, doc); err != nil {
+		return err
+	}
+
 	policy := s.config.AccountingPolicy
 	strategy, err := s.strategyFactory.GetStrategy(policy)
 	if err != nil {
@@ -140,10 +214,12 @@ func (s *inventoryService) RevertDocumentWithTx(tx *gorm.DB, doc *models.Documen
 	}
 
 	switch toUpper(doc.Type) {
-	case "INCOME":
+	case "INCOME", "RETURN_IN":
 		return strategy.RevertIncome(tx, doc, s.config)
-	case "OUTCOME":
+	case "OUTCOME", "RETURN_OUT", "WRITE_OFF":
 		return strategy.RevertOutcome(tx, doc, s.config)
+	case "TRANSFER":
+		return strategy.RevertTransfer(tx, doc, s.config)
 
 	case "INVENTORY":
 		if err := strategy.RevertOutcome(tx, doc, s.config); err != nil {
@@ -153,17 +229,57 @@ func (s *inventoryService) RevertDocumentWithTx(tx *gorm.DB, doc *models.Documen
 			return fmt.Errorf("failed to revert inventory surplus: %w", err)
 		}
 		return nil
+	case "ASSEMBLY", "DISASSEMBLY":
+		if err := strategy.RevertOutcome(tx, doc, s.config); err != nil {
+			return err
+		}
+		return strategy.RevertIncome(tx, doc, s.config)
 	default:
 		return nil
 	}
 }
 
+func (s *inventoryService) ShipTransferWithTx(tx *gorm.DB, doc *models.Document) error {
+	if err := validateTransfer(doc); err != nil {
+		return err
+	}
+	strategy, err := s.strategyFactory.GetStrategy(s.config.AccountingPolicy)
+	if err != nil {
+		return err
+	}
+	if err := strategy.ShipTransfer(tx, doc, s.config); err != nil {
+		return err
+	}
+	return s.serials.apply(tx, doc, "SHIP")
+}
+
+func (s *inventoryService) ReceiveTransferWithTx(tx *gorm.DB, doc *models.Document, received map[uint]decimal.Decimal) error {
+	if err := validateTransfer(doc); err != nil {
+		return err
+	}
+	strategy, err := s.strategyFactory.GetStrategy(s.config.AccountingPolicy)
+	if err != nil {
+		return err
+	}
+	if err := s.serials.checkFullReceipt(doc, received); err != nil {
+		return err
+	}
+	if err := strategy.ReceiveTransfer(tx, doc, received, s.config); err != nil {
+		return err
+	}
+	return s.serials.apply(tx, doc, "RECEIVE")
+}
+
 func (s *inventoryService) GetAvailableQuantity(warehouseID, variantID uint) (decimal.Decimal, error) {
-	balance, err := s.balanceRepo.GetBalanceWithTx(nil, warehouseID, variantID)
+	return s.GetAvailableQuantityWithTx(nil, warehouseID, variantID)
+}
+
+func (s *inventoryService) GetAvailableQuantityWithTx(tx *gorm.DB, warehouseID, variantID uint) (decimal.Decimal, error) {
+	balance, err := s.balanceRepo.GetBalanceWithTx(tx, warehouseID, variantID)
 	if err != nil {
 		return decimal.Zero, err
 	}
-	reservation, err := s.reservationRepo.GetReservationWithTx(nil, warehouseID, variantID)
+	reservedQty, err := s.reservationRepo.ReservedQuantityWithTx(tx, warehouseID, variantID, time.Now())
 	if err != nil {
 		return decimal.Zero, err
 	}
@@ -172,11 +288,7 @@ func (s *inventoryService) GetAvailableQuantity(warehouseID, variantID uint) (de
 	if balance != nil {
 		balanceQty = balance.Quantity
 	}
-	reservationQty := decimal.Zero
-	if reservation != nil {
-		reservationQty = reservation.Quantity
-	}
-	return balanceQty.Sub(reservationQty), nil
+	return balanceQty.Sub(reservedQty), nil
 }
 
 func (s *inventoryService) ListByWarehouseFilteredAsDTO(warehouseID uint, f models.StockFilter) ([]models.StockBalanceDTO, error) {
@@ -249,14 +361,26 @@ func (s *inventoryService) processOrder(tx *gorm.DB, doc *models.Document) error
 	if doc.WarehouseID == nil {
 		return errors.New("warehouse_id is required for order")
 	}
+	if doc.ReservedUntil != nil && !doc.ReservedUntil.After(time.Now()) {
+		return errors.New("reserved_until must be in the future")
+	}
+	// несколько строк одного варианта резервируют его вместе: сравниваем с доступным их сумму
+	required := make(map[uint]decimal.Decimal)
+	var variantIDs []uint
 	for _, item := range doc.Items {
-		available, err := s.GetAvailableQuantity(*doc.WarehouseID, item.VariantID)
+		if _, seen := required[item.VariantID]; !seen {
+			variantIDs = append(variantIDs, item.VariantID)
+		}
+		required[item.VariantID] = required[item.VariantID].Add(item.Quantity)
+	}
+	for _, variantID := range variantIDs {
+		available, err := s.GetAvailableQuantityWithTx(tx, *doc.WarehouseID, variantID)
 		if err != nil {
 			return err
 		}
 
-		if available.LessThan(item.Quantity) {
-			variant, _ := s.variantRepo.GetByID(item.VariantID)
+		if available.LessThan(required[variantID]) {
+			variant, _ := s.variantRepo.GetByID(variantID)
 			productName := "Unknown Item"
 			sku := "No SKU"
 			if variant != nil {
@@ -266,66 +390,84 @@ func (s *inventoryService) processOrder(tx *gorm.DB, doc *models.Document) error
 				}
 			}
 			return fmt.Errorf("Недостаточно товара '%s' (%s) на складе. Доступно: %s, Требуется: %s",
-				productName, sku, available.String(), item.Quantity.String())
+				productName, sku, available.String(), required[variantID].String())
 		}
+	}
+	return s.syncOrderReservation(tx, doc)
+}
 
-		res, err := s.reservationRepo.GetReservationWithTx(tx, *doc.WarehouseID, item.VariantID)
-		if err != nil {
-			return err
-		}
-		if res == nil {
-			res = &models.StockReservation{WarehouseID: *doc.WarehouseID, VariantID: item.VariantID, Quantity: decimal.Zero}
+// syncOrderReservation приводит резерв заказа к неотгруженному остатку его строк.
+// Истёкший резерв не восстанавливается: отгрузка и её отмена после срока его не трогают.
+func (s *inventoryService) syncOrderReservation(tx *gorm.DB, order *models.Document) error {
+	lines, err := s.reservationRepo.ListByDocumentWithTx(tx, order.ID)
+	if err != nil {
+		return err
+	}
+	if order.ReservedUntil != nil && !order.ReservedUntil.After(time.Now()) {
+		ids := make([]uint, len(lines))
+		for i, line := range lines {
+			ids[i] = line.ID
 		}
-		res.Quantity = res.Quantity.Add(item.Quantity)
-		if err := s.reservationRepo.SaveReservationWithTx(tx, res); err != nil {
-			return err
+		return s.reservationRepo.DeleteWithTx(tx, ids)
+	}
+
+	byItem := make(map[uint]*models.ReservationLine, len(lines))
+	for i := range lines {
+		byItem[lines[i].DocumentItemID] = &lines[i]
+	}
+	var stale []uint
+	for _, item := range order.Items {
+		remaining := item.Quantity.Sub(item.ShippedQuantity)
+		line := byItem[item.ID]
+		delete(byItem, item.ID)
+		switch {
+		case !remaining.IsPositive():
+			if line != nil {
+				stale = append(stale, line.ID)
+			}
+		case line == nil:
+			line = &models.ReservationLine{
+				DocumentID: order.ID, DocumentItemID: item.ID, WarehouseID: *order.WarehouseID,
+				VariantID: item.VariantID, Quantity: remaining, ExpiresAt: order.ReservedUntil,
+			}
+			if err := s.reservationRepo.CreateWithTx(tx, line); err != nil {
+				return err
+			}
+		case !line.Quantity.Equal(remaining):
+			line.Quantity = remaining
+			if err := s.reservationRepo.SaveWithTx(tx, line); err != nil {
+				return err
+			}
 		}
 	}
-	return nil
+	for _, line := range byItem {
+		stale = append(stale, line.ID)
+	}
+	return s.reservationRepo.DeleteWithTx(tx, stale)
 }
 
+// processReservationRelease пересчитывает резерв заказа-основания после того, как расход
+// учтён в отгруженных количествах его строк (см. trackOrderFulfillment).
 func (s *inventoryService) processReservationRelease(tx *gorm.DB, doc *models.Document) error {
 	if doc.WarehouseID == nil {
 		return errors.New("warehouse_id required")
 	}
-	for _, item := range doc.Items {
-		res, err := s.reservationRepo.GetReservationWithTx(tx, *doc.WarehouseID, item.VariantID)
-		if err != nil {
-			return err
-		}
-		if res == nil {
-			continue
-		}
-		res.Quantity = res.Quantity.Sub(item.Quantity)
-		if err := s.reservationRepo.SaveReservationWithTx(tx, res); err != nil {
-			return err
-		}
+	order, err := s.docRepo.GetByIDWithTx(tx, *doc.BaseDocumentID)
+	if err != nil {
+		return err
 	}
-	return nil
+	if order == nil || toUpper(order.Type) != "ORDER" || order.Status != "posted" {
+		return nil
+	}
+	return s.syncOrderReservation(tx, order)
 }
 
 func (s *inventoryService) revertOrder(tx *gorm.DB, doc *models.Document) error {
-	return s.processReservationRelease(tx, doc)
+	return s.reservationRepo.DeleteByDocumentWithTx(tx, doc.ID)
 }
 
 func (s *inventoryService) revertReservationRelease(tx *gorm.DB, doc *models.Document) error {
-	if doc.WarehouseID == nil {
-		return errors.New("warehouse_id required")
-	}
-	for _, item := range doc.Items {
-		res, err := s.reservationRepo.GetReservationWithTx(tx, *doc.WarehouseID, item.VariantID)
-		if err != nil {
-			return err
-		}
-		if res == nil {
-			res = &models.StockReservation{WarehouseID: *doc.WarehouseID, VariantID: item.VariantID, Quantity: decimal.Zero}
-		}
-		res.Quantity = res.Quantity.Add(item.Quantity)
-		if err := s.reservationRepo.SaveReservationWithTx(tx, res); err != nil {
-			return err
-		}
-	}
-	return nil
+	return s.processReservationRelease(tx, doc)
 }
 
 func (s *inventoryService) GetStockByVariant(variantID uint) ([]models.VariantStockDTO, error) {
@@ -338,6 +480,16 @@ func (s *inventoryService) GetStockByVariant(variantID uint) ([]models.VariantSt
 		return []models.VariantStockDTO{}, nil
 	}
 
+	components, err := s.bomRepo.ListByKit(variantID)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations, err := s.reservationsByWarehouse(variantID)
+	if err != nil {
+		return nil, err
+	}
+
 	results := make([]models.VariantStockDTO, len(warehouses))
 
 	for i, wh := range warehouses {
@@ -346,19 +498,21 @@ func (s *inventoryService) GetStockByVariant(variantID uint) ([]models.VariantSt
 			return nil, err
 		}
 
-		reservation, err := s.reservationRepo.GetReservationWithTx(nil, wh.ID, variantID)
-		if err != nil {
-			return nil, err
-		}
-
 		onHandQty := decimal.Zero
 		if balance != nil {
 			onHandQty = balance.Quantity
 		}
 
 		reservedQty := decimal.Zero
-		if reservation != nil {
-			reservedQty = reservation.Quantity
+		orders := []models.ReservationDTO{}
+		for _, order := range reservations[wh.ID] {
+			reservedQty = reservedQty.Add(order.Quantity)
+			orders = append(orders, order)
+		}
+
+		inTransitQty, err := s.transitRepo.SumPendingByDestination(wh.ID, variantID)
+		if err != nil {
+			return nil, err
 		}
 
 		results[i] = models.VariantStockDTO{
@@ -367,12 +521,46 @@ func (s *inventoryService) GetStockByVariant(variantID uint) ([]models.VariantSt
 			OnHand:        onHandQty,
 			Reserved:      reservedQty,
 			Available:     onHandQty.Sub(reservedQty),
+			InTransit:     inTransitQty,
+			Orders:        orders,
+		}
+		if len(components) > 0 {
+			buildable, err := s.buildableKits(wh.ID, components)
+			if err != nil {
+				return nil, err
+			}
+			results[i].Buildable = &buildable
 		}
 	}
 
 	return results, nil
 }
 
+// reservationsByWarehouse группирует действующие резервы варианта по складам, по строке на заказ.
+func (s *inventoryService) reservationsByWarehouse(variantID uint) (map[uint][]models.ReservationDTO, error) {
+	lines, err := s.reservationRepo.ListActiveByVariant(variantID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[uint][]models.ReservationDTO)
+	for _, line := range lines {
+		orders := result[line.WarehouseID]
+		if n := len(orders); n > 0 && orders[n-1].DocumentID == line.DocumentID {
+			orders[n-1].Quantity = orders[n-1].Quantity.Add(line.Quantity)
+			continue
+		}
+		dto := models.ReservationDTO{
+			DocumentID: line.DocumentID, DocumentNumber: line.Document.Number,
+			Quantity: line.Quantity, ExpiresAt: line.ExpiresAt,
+		}
+		if line.Document.Counterparty != nil {
+			dto.CounterpartyName = line.Document.Counterparty.Name
+		}
+		result[line.WarehouseID] = append(orders, dto)
+	}
+	return result, nil
+}
+
 func (s *inventoryService) processInventory(tx *gorm.DB, doc *models.Document, strategy QuantityStrategy) error {
 	if doc.WarehouseID == nil {
 		return errors.New("warehouse_id is required for inventory")
@@ -398,10 +586,12 @@ func (s *inventoryService) processInventory(tx *gorm.DB, doc *models.Document, s
 		}
 
 		adjustmentDoc := &models.Document{
-			ID:          doc.ID,
-			WarehouseID: doc.WarehouseID,
-			CreatedAt:   doc.CreatedAt,
-			Type:        "INVENTORY",
+			ID:           doc.ID,
+			WarehouseID:  doc.WarehouseID,
+			CreatedAt:    doc.CreatedAt,
+			DocumentDate: doc.DocumentDate,
+			Type:         "INVENTORY",
+			ExchangeRate: doc.ExchangeRate,
 		}
 
 		adjustmentItem := models.DocumentItem{
@@ -410,6 +600,9 @@ func (s *inventoryService) processInventory(tx *gorm.DB, doc *models.Document, s
 			Price:     item.Price,
 		}
 		adjustmentDoc.Items = []models.DocumentItem{adjustmentItem}
+		if err := s.serials.rejectTracked(adjustmentDoc); err != nil {
+			return err
+		}
 
 		if delta.IsPositive() {
 			// ИЗЛИШКИ = INCOME
@@ -426,6 +619,16 @@ func (s *inventoryService) processInventory(tx *gorm.DB, doc *models.Document, s
 	return nil
 }
 
+func validateTransfer(doc *models.Document) error {
+	if doc.WarehouseID == nil || doc.ToWarehouseID == nil {
+		return errors.New("warehouse_id and to_warehouse_id are required for transfer")
+	}
+	if *doc.WarehouseID == *doc.ToWarehouseID {
+		return errors.New("source and destination warehouses must be different")
+	}
+	return nil
+}
+
 func toUpper(s string) string {
 	if s == "" {
 		return s
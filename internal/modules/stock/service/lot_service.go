@@ -0,0 +1,173 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+type LotService interface {
+	SearchAsDTO(filter models.LotFilter) ([]models.LotDTO, error)
+	// TraceAsDTO возвращает все движения по партии, включая её копии на других складах после перемещений.
+	TraceAsDTO(lotID uint) (*models.LotTraceDTO, error)
+}
+
+type lotService struct {
+	lotRepo      repository.LotRepository
+	movementRepo repository.StockMovementRepository
+	docRepo      repository.DocumentRepository
+	variantRepo  repository.VariantRepository
+	productRepo  repository.ProductRepository
+	whRepo       repository.WarehouseRepository
+	cpRepo       repository.CounterpartyRepository
+}
+
+func NewLotService(
+	lotRepo repository.LotRepository, movementRepo repository.StockMovementRepository, docRepo repository.DocumentRepository,
+	variantRepo repository.VariantRepository, productRepo repository.ProductRepository,
+	whRepo repository.WarehouseRepository, cpRepo repository.CounterpartyRepository,
+) LotService {
+	return &lotService{
+		lotRepo: lotRepo, movementRepo: movementRepo, docRepo: docRepo,
+		variantRepo: variantRepo, productRepo: productRepo, whRepo: whRepo, cpRepo: cpRepo,
+	}
+}
+
+func (s *lotService) SearchAsDTO(filter models.LotFilter) ([]models.LotDTO, error) {
+	if filter.Limit == 0 {
+		filter.Limit = 100
+	}
+	lots, err := s.lotRepo.Search(filter)
+	if err != nil {
+		return nil, err
+	}
+	return s.buildLotDTOs(lots), nil
+}
+
+func (s *lotService) TraceAsDTO(lotID uint) (*models.LotTraceDTO, error) {
+	lot, err := s.lotRepo.GetByID(lotID)
+	if err != nil {
+		return nil, errors.New("lot not found")
+	}
+
+	related := []models.StockLot{*lot}
+	if lot.BatchNumber != "" {
+		if related, err = s.lotRepo.ListByBatch(lot.VariantID, lot.BatchNumber); err != nil {
+			return nil, err
+		}
+	}
+
+	lotIDs := make([]uint, len(related))
+	for i, l := range related {
+		lotIDs[i] = l.ID
+	}
+	movements, err := s.movementRepo.Search(models.MovementFilter{SourceLotIDs: lotIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	docIDs := make(map[uint]bool)
+	warehouseIDs := make(map[uint]bool)
+	for _, mv := range movements {
+		if mv.DocumentID != nil {
+			docIDs[*mv.DocumentID] = true
+		}
+		warehouseIDs[mv.WarehouseID] = true
+	}
+
+	docs, err := s.docRepo.GetByIDs(mapKeysToSlice(docIDs))
+	if err != nil {
+		return nil, err
+	}
+	docMap := make(map[uint]models.Document, len(docs))
+	counterpartyIDs := make(map[uint]bool)
+	for _, d := range docs {
+		docMap[d.ID] = d
+		if d.CounterpartyID != nil {
+			counterpartyIDs[*d.CounterpartyID] = true
+		}
+	}
+
+	counterparties, _ := s.cpRepo.GetByIDs(mapKeysToSlice(counterpartyIDs))
+	cpMap := make(map[uint]string, len(counterparties))
+	for _, cp := range counterparties {
+		cpMap[cp.ID] = cp.Name
+	}
+	whMap := s.loadWarehouseMap(mapKeysToSlice(warehouseIDs))
+
+	trace := make([]models.LotTraceMovementDTO, len(movements))
+	for i, mv := range movements {
+		item := models.LotTraceMovementDTO{
+			ID: mv.ID, LotID: *mv.SourceLotID, DocumentID: mv.DocumentID,
+			WarehouseID: mv.WarehouseID, WarehouseName: whMap[mv.WarehouseID],
+			Quantity: mv.Quantity, Type: mv.Type, CreatedAt: mv.CreatedAt,
+		}
+		if mv.DocumentID != nil {
+			doc := docMap[*mv.DocumentID]
+			item.DocumentNumber, item.DocumentType = doc.Number, doc.Type
+			if doc.CounterpartyID != nil {
+				item.CounterpartyID = doc.CounterpartyID
+				item.CounterpartyName = cpMap[*doc.CounterpartyID]
+			}
+		}
+		trace[i] = item
+	}
+
+	lotDTOs := s.buildLotDTOs(related)
+	result := &models.LotTraceDTO{Lots: lotDTOs, Movements: trace}
+	for _, l := range lotDTOs {
+		if l.ID == lot.ID {
+			result.Lot = l
+		}
+	}
+	return result, nil
+}
+
+func (s *lotService) buildLotDTOs(lots []models.StockLot) []models.LotDTO {
+	if len(lots) == 0 {
+		return []models.LotDTO{}
+	}
+
+	variantIDs := make(map[uint]bool)
+	warehouseIDs := make(map[uint]bool)
+	for _, l := range lots {
+		variantIDs[l.VariantID] = true
+		warehouseIDs[l.WarehouseID] = true
+	}
+
+	variants, _ := s.variantRepo.GetByIDs(mapKeysToSlice(variantIDs))
+	variantMap := make(map[uint]models.Variant, len(variants))
+	productIDs := make(map[uint]bool)
+	for _, v := range variants {
+		variantMap[v.ID] = v
+		productIDs[v.ProductID] = true
+	}
+	products, _ := s.productRepo.GetByIDs(mapKeysToSlice(productIDs))
+	productMap := make(map[uint]string, len(products))
+	for _, p := range products {
+		productMap[p.ID] = p.Name
+	}
+	whMap := s.loadWarehouseMap(mapKeysToSlice(warehouseIDs))
+
+	dtos := make([]models.LotDTO, len(lots))
+	for i, l := range lots {
+		variant := variantMap[l.VariantID]
+		dtos[i] = models.LotDTO{
+			ID: l.ID, WarehouseID: l.WarehouseID, WarehouseName: whMap[l.WarehouseID],
+			VariantID: l.VariantID, VariantSKU: variant.SKU, ProductName: productMap[variant.ProductID],
+			BatchNumber: l.BatchNumber, ProductionDate: l.ProductionDate, ExpiryDate: l.ExpiryDate,
+			ArrivalDate: l.ArrivalDate, CurrentQuantity: l.CurrentQuantity, UnitCost: l.UnitCost,
+		}
+	}
+	return dtos
+}
+
+func (s *lotService) loadWarehouseMap(ids []uint) map[uint]string {
+	warehouses, _ := s.whRepo.GetByIDs(ids)
+	whMap := make(map[uint]string, len(warehouses))
+	for _, wh := range warehouses {
+		whMap[wh.ID] = wh.Name
+	}
+	return whMap
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+// Состояния выполнения заказа.
+const (
+	fulfillmentOpen      = "open"
+	fulfillmentPartial   = "partially_shipped"
+	fulfillmentFulfilled = "fulfilled"
+)
+
+// basedOnTypes - какие документы можно создать на основании документа данного типа.
+var basedOnTypes = map[string][]string{
+	"ORDER":   {"OUTCOME"},
+	"OUTCOME": {"RETURN_IN"},
+	"INCOME":  {"RETURN_OUT"},
+}
+
+// CreateBasedOn создаёт черновик типа docType с шапкой и строками документа-основания.
+// Для расхода по заказу переносится только неотгруженный остаток строк.
+func (s *documentService) CreateBasedOn(baseID uint, docType string, userID *uint) (*models.Document, error) {
+	base, err := s.repo.GetByID(baseID)
+	if err != nil || base == nil {
+		return nil, errors.New("base document not found")
+	}
+	docType = toUpper(docType)
+	if !basedOnAllowed(toUpper(base.Type), docType) {
+		return nil, fmt.Errorf("cannot create %s based on %s", docType, toUpper(base.Type))
+	}
+	if base.Status != "posted" {
+		return nil, errors.New("base document must be posted")
+	}
+
+	doc := &models.Document{
+		Type: docType, WarehouseID: base.WarehouseID, ToWarehouseID: base.ToWarehouseID,
+		CounterpartyID: base.CounterpartyID, PriceTypeID: base.PriceTypeID, Currency: base.Currency,
+		Comment: base.Comment, BaseDocumentID: &base.ID, CreatedBy: userID,
+	}
+	for _, item := range base.Items {
+		qty := item.Quantity
+		if toUpper(base.Type) == "ORDER" {
+			qty = item.Quantity.Sub(item.ShippedQuantity)
+			if !qty.IsPositive() {
+				continue
+			}
+		}
+		line := models.DocumentItem{
+			VariantID: item.VariantID, Quantity: qty, Price: item.Price,
+			BatchNumber: item.BatchNumber, ProductionDate: item.ProductionDate, ExpiryDate: item.ExpiryDate,
+		}
+		// единицу ввода и серийные номера переносим, только если количество не изменилось: иначе строка остаётся
+		// в базовой единице, а номера оставшихся единиц указывает сборщик - часть номеров строки уже отгружена
+		if qty.Equal(item.Quantity) {
+			line.UnitID, line.UnitQuantity = item.UnitID, item.UnitQuantity
+			line.SerialNumbers = item.SerialNumbers
+		}
+		doc.Items = append(doc.Items, line)
+	}
+	if len(doc.Items) == 0 {
+		return nil, errors.New("nothing left to ship, order is fulfilled")
+	}
+	return s.Create(doc)
+}
+
+func (s *documentService) GetFulfillment(orderID uint) (*models.OrderFulfillmentDTO, error) {
+	order, err := s.repo.GetByID(orderID)
+	if err != nil || order == nil {
+		return nil, errors.New("document not found")
+	}
+	if toUpper(order.Type) != "ORDER" {
+		return nil, errors.New("fulfillment is tracked only for ORDER documents")
+	}
+
+	variantIDs := make([]uint, len(order.Items))
+	for i, item := range order.Items {
+		variantIDs[i] = item.VariantID
+	}
+	variantMap, productMap := s.loadVariantAndProductMaps(variantIDs)
+
+	dto := &models.OrderFulfillmentDTO{
+		OrderID: order.ID, Number: order.Number, Status: order.FulfillmentStatus,
+		Lines: make([]models.OrderFulfillmentLineDTO, len(order.Items)), Shipments: []models.DocumentListItemDTO{},
+	}
+	for i, item := range order.Items {
+		variant := variantMap[item.VariantID]
+		dto.Lines[i] = models.OrderFulfillmentLineDTO{
+			ItemID: item.ID, VariantID: item.VariantID, VariantSKU: variant.SKU, ProductName: productMap[variant.ProductID].Name,
+			Ordered: item.Quantity, Shipped: item.ShippedQuantity, Remaining: item.Quantity.Sub(item.ShippedQuantity),
+		}
+	}
+
+	linked, err := s.repo.ListByBaseDocument(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range linked {
+		if toUpper(doc.Type) != "OUTCOME" {
+			continue
+		}
+		dto.Shipments = append(dto.Shipments, models.DocumentListItemDTO{
+			ID: doc.ID, Type: doc.Type, Number: doc.Number, ItemCount: len(doc.Items), Status: doc.Status,
+			DocumentDate: documentDate(&doc), CreatedAt: doc.CreatedAt,
+		})
+	}
+	return dto, nil
+}
+
+// trackOrderFulfillment учитывает расход по заказу в отгруженных количествах строк заказа.
+// sign = 1 при проведении расхода, -1 при его отмене. Отгрузка сверх заказанного запрещена.
+func (s *documentService) trackOrderFulfillment(tx *gorm.DB, doc *models.Document, sign int) error {
+	if toUpper(doc.Type) != "OUTCOME" || doc.BaseDocumentID == nil {
+		return nil
+	}
+	order, err := s.repo.GetByIDWithTx(tx, *doc.BaseDocumentID)
+	if err != nil {
+		return fmt.Errorf("base document not found: %w", err)
+	}
+	if toUpper(order.Type) != "ORDER" {
+		return nil
+	}
+	if sign > 0 && order.Status != "posted" {
+		return fmt.Errorf("order %s is not posted", order.Number)
+	}
+
+	shipped := make(map[uint]decimal.Decimal)
+	var variantOrder []uint
+	for _, item := range doc.Items {
+		if _, ok := shipped[item.VariantID]; !ok {
+			variantOrder = append(variantOrder, item.VariantID)
+		}
+		shipped[item.VariantID] = shipped[item.VariantID].Add(item.Quantity)
+	}
+
+	changed := make(map[int]bool)
+	for _, variantID := range variantOrder {
+		left := shipped[variantID]
+		found := false
+		for i := range order.Items {
+			line := &order.Items[i]
+			if line.VariantID != variantID {
+				continue
+			}
+			found = true
+			var delta decimal.Decimal
+			if sign > 0 {
+				delta = decimal.Min(left, line.Quantity.Sub(line.ShippedQuantity))
+				line.ShippedQuantity = line.ShippedQuantity.Add(delta)
+			} else {
+				delta = decimal.Min(left, line.ShippedQuantity)
+				line.ShippedQuantity = line.ShippedQuantity.Sub(delta)
+			}
+			if delta.IsPositive() {
+				changed[i] = true
+				left = left.Sub(delta)
+			}
+		}
+		if !found {
+			return fmt.Errorf("variant %d is not in order %s", variantID, order.Number)
+		}
+		if sign > 0 && left.IsPositive() {
+			return fmt.Errorf("over-shipping order %s: variant %d exceeds ordered quantity by %s", order.Number, variantID, left.String())
+		}
+	}
+
+	for i := range changed {
+		if err := s.repo.UpdateItemShippedWithTx(tx, order.Items[i].ID, order.Items[i].ShippedQuantity); err != nil {
+			return err
+		}
+	}
+	return tx.Model(&models.Document{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+		"fulfillment_status": fulfillmentStatus(order.Items),
+		"version":            gorm.Expr("version + 1"),
+	}).Error
+}
+
+func fulfillmentStatus(items []models.DocumentItem) string {
+	anyShipped, allShipped := false, true
+	for _, item := range items {
+		if item.ShippedQuantity.IsPositive() {
+			anyShipped = true
+		}
+		if item.ShippedQuantity.LessThan(item.Quantity) {
+			allShipped = false
+		}
+	}
+	switch {
+	case anyShipped && allShipped:
+		return fulfillmentFulfilled
+	case anyShipped:
+		return fulfillmentPartial
+	default:
+		return fulfillmentOpen
+	}
+}
+
+// orderHasShipments не даёт отменить заказ, пока по нему есть проведённые расходы.
+func orderHasShipments(order *models.Document) bool {
+	for _, item := range order.Items {
+		if item.ShippedQuantity.IsPositive() {
+			return true
+		}
+	}
+	return false
+}
+
+func basedOnAllowed(baseType, docType string) bool {
+	for _, t := range basedOnTypes[baseType] {
+		if t == docType {
+			return true
+		}
+	}
+	return false
+}
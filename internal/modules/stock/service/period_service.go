@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+var ErrPeriodClosed = errors.New("period is closed")
+
+// AuditLogger - журнал аудита приложения (модуль audit).
+type AuditLogger interface {
+	Log(userID uint, action, entity string, entityID uint, details, ip string)
+}
+
+type PeriodService interface {
+	// Close закрывает период по дату LockDate включительно и сохраняет остатки на его конец.
+	Close(dto *models.PeriodCloseDTO, userID *uint) (*models.PeriodClose, error)
+	// Reopen снимает последнее закрытие области и пишет это в журнал аудита.
+	Reopen(id uint, reason string, userID *uint, ip string) (*models.PeriodClose, error)
+	List(warehouseID *uint) ([]models.PeriodClose, error)
+	GetByID(id uint) (*models.PeriodClose, error)
+	// CheckDocumentWithTx возвращает ErrPeriodClosed, если документ датирован закрытым периодом одного из своих складов.
+	CheckDocumentWithTx(tx *gorm.DB, doc *models.Document) error
+}
+
+type periodService struct {
+	repo   repository.PeriodRepository
+	whRepo repository.WarehouseRepository
+	tx     repository.TxManager
+	audit  AuditLogger
+}
+
+func NewPeriodService(repo repository.PeriodRepository, whRepo repository.WarehouseRepository, tx repository.TxManager, audit AuditLogger) PeriodService {
+	return &periodService{repo: repo, whRepo: whRepo, tx: tx, audit: audit}
+}
+
+func (s *periodService) Close(dto *models.PeriodCloseDTO, userID *uint) (*models.PeriodClose, error) {
+	lockDate := startOfDay(dto.LockDate)
+	if lockDate.After(startOfDay(time.Now())) {
+		return nil, errors.New("lock_date cannot be in the future")
+	}
+	if dto.WarehouseID != nil {
+		if wh, err := s.whRepo.GetByID(*dto.WarehouseID); err != nil || wh == nil {
+			return nil, fmt.Errorf("warehouse %d not found", *dto.WarehouseID)
+		}
+	}
+
+	period := &models.PeriodClose{
+		WarehouseID: dto.WarehouseID, LockDate: lockDate, Status: "closed", Comment: dto.Comment,
+		ClosedBy: userID, ClosedAt: time.Now(),
+	}
+	err := s.tx.DoInTx(func(tx *gorm.DB) error {
+		latest, err := s.repo.LatestWithTx(tx, dto.WarehouseID)
+		if err != nil {
+			return err
+		}
+		if latest != nil && !lockDate.After(latest.LockDate) {
+			return fmt.Errorf("period is already closed up to %s", latest.LockDate.Format(time.DateOnly))
+		}
+
+		balances, err := s.repo.ClosingBalancesWithTx(tx, dto.WarehouseID, periodEnd(lockDate))
+		if err != nil {
+			return err
+		}
+		for i := range balances {
+			balances[i].Value = balances[i].Value.Round(2)
+		}
+		period.Balances = balances
+		return s.repo.CreateWithTx(tx, period)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return period, nil
+}
+
+func (s *periodService) Reopen(id uint, reason string, userID *uint, ip string) (*models.PeriodClose, error) {
+	period, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, errors.New("period close not found")
+	}
+	if period.Status != "closed" {
+		return nil, errors.New("period is already reopened")
+	}
+
+	err = s.tx.DoInTx(func(tx *gorm.DB) error {
+		latest, err := s.repo.LatestWithTx(tx, period.WarehouseID)
+		if err != nil {
+			return err
+		}
+		// закрытия снимаются в обратном порядке, иначе остатки более поздних закрытий перестали бы сходиться
+		if latest == nil || latest.ID != period.ID {
+			return errors.New("only the latest period close can be reopened")
+		}
+		now := time.Now()
+		period.Status = "reopened"
+		period.ReopenedBy, period.ReopenedAt, period.ReopenNote = userID, &now, reason
+		return s.repo.SaveWithTx(tx, period)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.audit != nil {
+		var actor uint
+		if userID != nil {
+			actor = *userID
+		}
+		scope := "все склады"
+		if period.WarehouseID != nil {
+			scope = fmt.Sprintf("склад %d", *period.WarehouseID)
+		}
+		details := fmt.Sprintf("Переоткрыт период по %s (%s): %s", period.LockDate.Format(time.DateOnly), scope, reason)
+		s.audit.Log(actor, "REOPEN_PERIOD", "period_close", period.ID, details, ip)
+	}
+	return period, nil
+}
+
+func (s *periodService) List(warehouseID *uint) ([]models.PeriodClose, error) {
+	return s.repo.List(warehouseID)
+}
+
+func (s *periodService) GetByID(id uint) (*models.PeriodClose, error) { return s.repo.GetByID(id) }
+
+func (s *periodService) CheckDocumentWithTx(tx *gorm.DB, doc *models.Document) error {
+	var warehouses []uint
+	for _, id := range []*uint{doc.WarehouseID, doc.ToWarehouseID} {
+		if id != nil {
+			warehouses = append(warehouses, *id)
+		}
+	}
+	lock, err := s.repo.LockWithTx(tx, warehouses)
+	if err != nil || lock == nil {
+		return err
+	}
+	if documentDate(doc).Before(periodEnd(lock.LockDate)) {
+		return fmt.Errorf("%w: document %s is dated on or before the lock date %s", ErrPeriodClosed, doc.Number, lock.LockDate.Format(time.DateOnly))
+	}
+	return nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// periodEnd - начало дня, следующего за датой закрытия: всё, что раньше, относится к закрытому периоду.
+func periodEnd(lockDate time.Time) time.Time {
+	return startOfDay(lockDate).AddDate(0, 0, 1)
+}
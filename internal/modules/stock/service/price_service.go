@@ -3,6 +3,7 @@ package service
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
@@ -12,15 +13,38 @@ import (
 
 type PriceService interface {
 	UpdatePricesFromDocumentWithTx(tx *gorm.DB, doc *models.Document) error
+	// RevertPricesFromDocumentWithTx возвращает цены, действовавшие до проведения документа.
+	RevertPricesFromDocumentWithTx(tx *gorm.DB, doc *models.Document) error
 	GetPrice(itemID, priceTypeID uint) (*models.ItemPrice, error)
+	// GetPriceAt возвращает цену, действовавшую (или запланированную) на дату at.
+	GetPriceAt(itemID, priceTypeID uint, at time.Time) (*models.ItemPrice, error)
+	GetHistory(itemID, priceTypeID uint) ([]models.ItemPriceHistory, error)
+}
+
+// PriceConflictError - цены документа уже изменены более поздними документами, отмена невозможна.
+type PriceConflictError struct {
+	Conflicts []models.PriceConflictDTO
+}
+
+func (e *PriceConflictError) Error() string {
+	numbers := make([]string, 0, len(e.Conflicts))
+	seen := make(map[string]bool)
+	for _, c := range e.Conflicts {
+		if !seen[c.DocumentNumber] {
+			seen[c.DocumentNumber] = true
+			numbers = append(numbers, c.DocumentNumber)
+		}
+	}
+	return fmt.Sprintf("prices were changed by later documents: %s", strings.Join(numbers, ", "))
 }
 
 type priceService struct {
-	repo repository.PriceRepository
+	repo    repository.PriceRepository
+	docRepo repository.DocumentRepository
 }
 
-func NewPriceService(r repository.PriceRepository) PriceService {
-	return &priceService{repo: r}
+func NewPriceService(r repository.PriceRepository, docRepo repository.DocumentRepository) PriceService {
+	return &priceService{repo: r, docRepo: docRepo}
 }
 
 func (s *priceService) UpdatePricesFromDocumentWithTx(tx *gorm.DB, doc *models.Document) error {
@@ -34,7 +58,19 @@ func (s *priceService) UpdatePricesFromDocumentWithTx(tx *gorm.DB, doc *models.D
 		return nil
 	}
 
+	now := time.Now()
+	effectiveFrom := now
+	if doc.EffectiveFrom != nil {
+		effectiveFrom = *doc.EffectiveFrom
+	}
+	if doc.EffectiveTo != nil && !doc.EffectiveTo.After(effectiveFrom) {
+		return errors.New("effective_to must be later than effective_from")
+	}
+	// Текущую цену меняем сразу только для бессрочных цен, уже вступивших в силу; остальное разрешается по истории.
+	applyNow := !effectiveFrom.After(now) && doc.EffectiveTo == nil
+
 	var pricesToUpdate []models.ItemPrice
+	var history []models.ItemPriceHistory
 	for _, item := range doc.Items {
 		if item.Price == nil {
 			return fmt.Errorf("price is not set for item ID %d in document", item.VariantID)
@@ -44,15 +80,127 @@ func (s *priceService) UpdatePricesFromDocumentWithTx(tx *gorm.DB, doc *models.D
 			VariantID:   item.VariantID,
 			PriceTypeID: *doc.PriceTypeID,
 			Price:       *item.Price,
-			Currency:    "RUB",
+			Currency:    doc.Currency,
 			UpdatedAt:   time.Now(),
 		}
-		pricesToUpdate = append(pricesToUpdate, priceRecord)
+
+		h := models.ItemPriceHistory{
+			VariantID: item.VariantID, PriceTypeID: *doc.PriceTypeID, DocumentID: doc.ID,
+			NewPrice: priceRecord.Price, Currency: priceRecord.Currency,
+			EffectiveFrom: effectiveFrom, EffectiveTo: doc.EffectiveTo, Applied: applyNow, CreatedAt: now,
+		}
+		if applyNow {
+			current, err := s.repo.GetPriceWithTx(tx, item.VariantID, *doc.PriceTypeID)
+			if err != nil {
+				return err
+			}
+			if current != nil {
+				h.OldPrice, h.OldCurrency = &current.Price, current.Currency
+			}
+			pricesToUpdate = append(pricesToUpdate, priceRecord)
+		}
+		history = append(history, h)
 	}
 
+	if err := s.repo.CreateHistoryWithTx(tx, history); err != nil {
+		return err
+	}
 	return s.repo.UpsertPrices(tx, pricesToUpdate)
 }
 
+func (s *priceService) RevertPricesFromDocumentWithTx(tx *gorm.DB, doc *models.Document) error {
+	rows, err := s.repo.ListHistoryByDocumentWithTx(tx, doc.ID)
+	if err != nil {
+		return err
+	}
+
+	var conflicts []models.PriceConflictDTO
+	for _, row := range rows {
+		later, err := s.repo.ListLaterChangesWithTx(tx, row.VariantID, row.PriceTypeID, row.ID)
+		if err != nil {
+			return err
+		}
+		for _, l := range later {
+			if l.DocumentID == doc.ID {
+				continue
+			}
+			conflicts = append(conflicts, models.PriceConflictDTO{
+				VariantID: l.VariantID, PriceTypeID: l.PriceTypeID, DocumentID: l.DocumentID, Price: l.NewPrice,
+			})
+		}
+	}
+	if len(conflicts) > 0 {
+		s.fillDocumentNumbers(conflicts)
+		return &PriceConflictError{Conflicts: conflicts}
+	}
+
+	// Если одна позиция встречается в документе несколько раз, восстанавливаем цену по самой ранней записи.
+	now := time.Now()
+	for i := len(rows) - 1; i >= 0; i-- {
+		row := rows[i]
+		switch {
+		case !row.Applied:
+			// текущую цену документ не менял
+		case row.OldPrice == nil:
+			if err := s.repo.DeletePriceWithTx(tx, row.VariantID, row.PriceTypeID); err != nil {
+				return err
+			}
+		default:
+			restored := []models.ItemPrice{{
+				VariantID: row.VariantID, PriceTypeID: row.PriceTypeID, Price: *row.OldPrice, Currency: row.OldCurrency,
+			}}
+			if err := s.repo.UpsertPrices(tx, restored); err != nil {
+				return err
+			}
+		}
+
+		row.RevertedAt = &now
+		if err := s.repo.SaveHistoryWithTx(tx, &row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *priceService) GetPrice(itemID, priceTypeID uint) (*models.ItemPrice, error) {
-	return s.repo.GetPrice(itemID, priceTypeID)
+	return s.GetPriceAt(itemID, priceTypeID, time.Now())
+}
+
+func (s *priceService) GetPriceAt(itemID, priceTypeID uint, at time.Time) (*models.ItemPrice, error) {
+	row, err := s.repo.GetEffectiveHistory(itemID, priceTypeID, at)
+	if err != nil {
+		return nil, err
+	}
+	if row != nil {
+		return &models.ItemPrice{
+			VariantID: row.VariantID, PriceTypeID: row.PriceTypeID,
+			Price: row.NewPrice, Currency: row.Currency, UpdatedAt: row.CreatedAt,
+		}, nil
+	}
+
+	// Цены, заведённые до появления истории, есть только в ItemPrice.
+	price, err := s.repo.GetPrice(itemID, priceTypeID)
+	if err != nil || price == nil || price.UpdatedAt.After(at) {
+		return nil, err
+	}
+	return price, nil
+}
+
+func (s *priceService) GetHistory(itemID, priceTypeID uint) ([]models.ItemPriceHistory, error) {
+	return s.repo.ListHistory(itemID, priceTypeID)
+}
+
+func (s *priceService) fillDocumentNumbers(conflicts []models.PriceConflictDTO) {
+	ids := make(map[uint]bool)
+	for _, c := range conflicts {
+		ids[c.DocumentID] = true
+	}
+	docs, _ := s.docRepo.GetByIDs(mapKeysToSlice(ids))
+	numbers := make(map[uint]string, len(docs))
+	for _, d := range docs {
+		numbers[d.ID] = d.Number
+	}
+	for i := range conflicts {
+		conflicts[i].DocumentNumber = numbers[conflicts[i].DocumentID]
+	}
 }
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/reports"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+var (
+	ErrDocumentNotFound       = errors.New("document not found")
+	ErrPrintFormNotFound      = errors.New("print form not found")
+	ErrPrintFormNotApplicable = errors.New("print form does not apply to this document type")
+)
+
+type PrintService interface {
+	// Forms - имена форм, которыми печатаются документы типа docType; первой идёт форма по умолчанию.
+	Forms(docType string) []string
+	// FormData собирает документ для печати: реквизиты складов и контрагента, строки и итоги.
+	FormData(docID uint) (*reports.FormData, error)
+	// Print печатает документ в PDF по форме form, пустая form - форма по умолчанию для типа документа.
+	// Возвращает содержимое файла и его имя.
+	Print(docID uint, form string) ([]byte, string, error)
+}
+
+type printService struct {
+	docs         DocumentService
+	whRepo       repository.WarehouseRepository
+	cpRepo       repository.CounterpartyRepository
+	variantRepo  repository.VariantRepository
+	units        UnitService
+	balanceRepo  repository.BalanceRepository
+	movementRepo repository.StockMovementRepository
+
+	pdf          *reports.PDFGenerator
+	forms        map[string]*reports.FormTemplate
+	organization string
+}
+
+func NewPrintService(
+	docs DocumentService, whRepo repository.WarehouseRepository, cpRepo repository.CounterpartyRepository,
+	variantRepo repository.VariantRepository, units UnitService, balanceRepo repository.BalanceRepository,
+	movementRepo repository.StockMovementRepository, pdf *reports.PDFGenerator, forms map[string]*reports.FormTemplate,
+	organization string,
+) PrintService {
+	return &printService{
+		docs: docs, whRepo: whRepo, cpRepo: cpRepo, variantRepo: variantRepo, units: units,
+		balanceRepo: balanceRepo, movementRepo: movementRepo, pdf: pdf, forms: forms, organization: organization,
+	}
+}
+
+func (s *printService) Forms(docType string) []string {
+	var defaults, others []string
+	for name, form := range s.forms {
+		switch {
+		case form.IsDefaultFor(docType):
+			defaults = append(defaults, name)
+		case form.Supports(docType):
+			others = append(others, name)
+		}
+	}
+	sort.Strings(defaults)
+	sort.Strings(others)
+	return append(defaults, others...)
+}
+
+func (s *printService) Print(docID uint, form string) ([]byte, string, error) {
+	data, err := s.FormData(docID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if form == "" {
+		names := s.Forms(data.Type)
+		if len(names) == 0 {
+			return nil, "", fmt.Errorf("%w: no forms for %s", ErrPrintFormNotFound, data.Type)
+		}
+		form = names[0]
+	}
+	tpl, ok := s.forms[form]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrPrintFormNotFound, form)
+	}
+	if !tpl.Supports(data.Type) {
+		return nil, "", fmt.Errorf("%w: %s cannot print %s, available: %s",
+			ErrPrintFormNotApplicable, form, data.Type, strings.Join(s.Forms(data.Type), ", "))
+	}
+
+	content, err := s.pdf.GenerateForm(tpl, *data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to render form %s: %w", form, err)
+	}
+	return content, fmt.Sprintf("%s_%s.pdf", form, data.Number), nil
+}
+
+func (s *printService) FormData(docID uint) (*reports.FormData, error) {
+	dto, err := s.docs.GetByIDAsDTO(docID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && dto == nil) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := &reports.FormData{
+		Organization: s.organization, Type: toUpper(dto.Type), Number: dto.Number, Status: dto.Status,
+		Date: dto.DocumentDate, Currency: dto.Currency, Comment: dto.Comment,
+		Warehouse: s.warehouseParty(dto.WarehouseID), ToWarehouse: s.warehouseParty(dto.ToWarehouseID),
+	}
+	if dto.CounterpartyID != nil {
+		if cp, _ := s.cpRepo.GetByID(*dto.CounterpartyID); cp != nil {
+			data.Counterparty = reports.FormParty{Name: cp.Name, Address: cp.Address, Phone: cp.Phone, Email: cp.Email}
+		}
+	}
+
+	baseUnits := s.baseUnitNames(dto.Items)
+	book := s.bookQuantities(dto)
+	for i, item := range dto.Items {
+		price := decimal.Zero
+		if item.Price != nil {
+			price = *item.Price
+		}
+		row := reports.FormRow{
+			N: i + 1, SKU: item.VariantSKU, Name: item.ProductName, Unit: baseUnits[item.VariantID],
+			BatchNumber: item.BatchNumber, SerialNumbers: item.SerialNumbers,
+			Quantity: item.Quantity, Price: price, Amount: item.Quantity.Mul(price).Round(2),
+		}
+		if item.UnitQuantity != nil && item.UnitName != "" {
+			row.EnteredUnit, row.EnteredQuantity = item.UnitName, *item.UnitQuantity
+		}
+		if book != nil {
+			row.BookQuantity = book[item.VariantID]
+			row.Difference = item.Quantity.Sub(row.BookQuantity)
+		}
+		data.Rows = append(data.Rows, row)
+		data.TotalQuantity = data.TotalQuantity.Add(row.Quantity)
+		data.TotalAmount = data.TotalAmount.Add(row.Amount)
+	}
+	data.AmountInWords = reports.AmountInWords(data.TotalAmount, data.Currency)
+	return data, nil
+}
+
+func (s *printService) warehouseParty(id *uint) reports.FormParty {
+	if id == nil {
+		return reports.FormParty{}
+	}
+	wh, _ := s.whRepo.GetByID(*id)
+	if wh == nil {
+		return reports.FormParty{}
+	}
+	return reports.FormParty{Name: wh.Name, Address: wh.Address}
+}
+
+func (s *printService) baseUnitNames(items []models.DocumentItemDTO) map[uint]string {
+	ids := make([]uint, len(items))
+	for i, item := range items {
+		ids[i] = item.VariantID
+	}
+	variants, _ := s.variantRepo.GetByIDs(ids)
+	unitIDs := make([]uint, 0, len(variants))
+	for _, v := range variants {
+		unitIDs = append(unitIDs, v.UnitID)
+	}
+	names := s.units.UnitNames(unitIDs)
+
+	result := make(map[uint]string, len(variants))
+	for _, v := range variants {
+		result[v.ID] = names[v.UnitID]
+	}
+	return result
+}
+
+// bookQuantities - учётные остатки для инвентаризационной описи. У проведённой инвентаризации остаток
+// восстанавливается как факт минус её собственные движения, у черновика берётся текущий.
+func (s *printService) bookQuantities(doc *models.DocumentDTO) map[uint]decimal.Decimal {
+	if toUpper(doc.Type) != "INVENTORY" || doc.WarehouseID == nil {
+		return nil
+	}
+	book := make(map[uint]decimal.Decimal, len(doc.Items))
+	if doc.Status == "posted" {
+		moves, _ := s.movementRepo.ListByDocument(doc.ID)
+		adjusted := make(map[uint]decimal.Decimal)
+		for _, mv := range moves {
+			adjusted[mv.VariantID] = adjusted[mv.VariantID].Add(mv.Quantity)
+		}
+		for _, item := range doc.Items {
+			book[item.VariantID] = item.Quantity.Sub(adjusted[item.VariantID])
+		}
+		return book
+	}
+	for _, item := range doc.Items {
+		balance, _ := s.balanceRepo.GetBalanceWithTx(nil, *doc.WarehouseID, item.VariantID)
+		if balance != nil {
+			book[item.VariantID] = balance.Quantity
+		}
+	}
+	return book
+}
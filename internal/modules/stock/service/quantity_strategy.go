@@ -3,6 +3,7 @@ package service
 import (
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/config"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -11,4 +12,18 @@ type QuantityStrategy interface {
 	ProcessOutcome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error
 	RevertIncome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error
 	RevertOutcome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error
+	ProcessTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error
+	RevertTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error
+	ShipTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error
+	ReceiveTransfer(tx *gorm.DB, doc *models.Document, received map[uint]decimal.Decimal, cfg *config.Config) error
+}
+
+// movementType - тип движения прихода или расхода. Возвраты помечаются своим типом,
+// чтобы отчёты не путали их с закупками и продажами; так же списания, сборка и разборка комплектов.
+func movementType(doc *models.Document, def string) string {
+	switch t := toUpper(doc.Type); t {
+	case "RETURN_IN", "RETURN_OUT", "WRITE_OFF", "ASSEMBLY", "DISASSEMBLY":
+		return t
+	}
+	return def
 }
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+type ReservationService interface {
+	// ReleaseExpired снимает резервы, срок которых истёк к моменту now, и возвращает число затронутых заказов.
+	ReleaseExpired(now time.Time) (int, error)
+	// StartWorker запускает периодическое снятие истёкших резервов.
+	StartWorker(interval time.Duration)
+	StopWorker()
+}
+
+type reservationService struct {
+	repo        repository.ReservationRepository
+	serialRepo  repository.SerialRepository
+	historyRepo repository.DocumentHistoryRepository
+	tx          repository.TxManager
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewReservationService(
+	repo repository.ReservationRepository, serialRepo repository.SerialRepository,
+	historyRepo repository.DocumentHistoryRepository, tx repository.TxManager,
+) ReservationService {
+	return &reservationService{repo: repo, serialRepo: serialRepo, historyRepo: historyRepo, tx: tx}
+}
+
+func (s *reservationService) ReleaseExpired(now time.Time) (int, error) {
+	docIDs, err := s.repo.ListExpiredDocumentIDs(now)
+	if err != nil {
+		return 0, err
+	}
+	released := 0
+	for _, docID := range docIDs {
+		err := s.tx.DoInTx(func(tx *gorm.DB) error {
+			lines, err := s.repo.ListByDocumentWithTx(tx, docID)
+			if err != nil {
+				return err
+			}
+			var ids []uint
+			total := decimal.Zero
+			variants := make(map[uint]bool)
+			for _, line := range lines {
+				if line.ExpiresAt != nil && !line.ExpiresAt.After(now) {
+					ids = append(ids, line.ID)
+					total = total.Add(line.Quantity)
+					variants[line.VariantID] = true
+				}
+			}
+			// строки могли снять отгрузкой или отменой, пока шёл обход
+			if len(ids) == 0 {
+				return nil
+			}
+			if err := s.repo.DeleteWithTx(tx, ids); err != nil {
+				return err
+			}
+			if err := releaseReservedSerials(tx, s.serialRepo, docID, variants, now); err != nil {
+				return err
+			}
+			released++
+			h := &models.DocumentHistory{
+				DocumentID: docID, Action: "reservation_released", CreatedAt: now,
+				Comment: fmt.Sprintf("Истёк срок резерва, снято: %s", total.String()),
+			}
+			return s.historyRepo.CreateWithTx(tx, h)
+		})
+		if err != nil {
+			return released, fmt.Errorf("release reservation of document %d: %w", docID, err)
+		}
+	}
+	return released, nil
+}
+
+func (s *reservationService) StartWorker(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case now := <-ticker.C:
+				if _, err := s.ReleaseExpired(now); err != nil {
+					log.Printf("[ERROR] Failed to release expired reservations: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (s *reservationService) StopWorker() {
+	close(s.stop)
+	s.wg.Wait()
+}
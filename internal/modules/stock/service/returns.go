@@ -0,0 +1,243 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+// returnBaseTypes - документ-основание для каждого вида возврата.
+var returnBaseTypes = map[string]string{
+	"RETURN_IN":  "OUTCOME", // покупатель возвращает проданное
+	"RETURN_OUT": "INCOME",  // мы возвращаем поставщику полученное
+}
+
+// costSlice - часть отгрузки или прихода, оценённая по одной себестоимости.
+type costSlice struct {
+	qty  decimal.Decimal
+	cost decimal.Decimal
+	lot  *models.StockLot
+}
+
+// processReturnIn приходует возврат покупателя по себестоимости проданного, а не по текущим ценам:
+// возвращаемое количество сопоставляется с партиями, из которых была продажа, в порядке их списания.
+func (s *inventoryService) processReturnIn(tx *gorm.DB, doc *models.Document, strategy QuantityStrategy) error {
+	sale, err := s.returnBase(tx, doc)
+	if err != nil {
+		return err
+	}
+	if !sameWarehouse(doc.WarehouseID, sale.WarehouseID) {
+		return errors.New("return must be booked to the warehouse of the sale")
+	}
+
+	moves, err := s.movementRepo.ListActiveByDocumentWithTx(tx, sale.ID)
+	if err != nil {
+		return err
+	}
+	sold := make(map[uint][]costSlice)
+	for _, mv := range moves {
+		if mv.Type != "OUTCOME" || !mv.Quantity.IsNegative() {
+			continue
+		}
+		slice := costSlice{qty: mv.Quantity.Neg(), cost: mv.UnitCost}
+		if mv.SourceLotID != nil {
+			lot, err := s.lotRepo.GetLotByIDForUpdate(tx, *mv.SourceLotID)
+			if err != nil {
+				return fmt.Errorf("source lot with ID %d not found for movement %d", *mv.SourceLotID, mv.ID)
+			}
+			slice.cost, slice.lot = lot.UnitCost, lot
+		}
+		sold[mv.VariantID] = append(sold[mv.VariantID], slice)
+	}
+
+	returned, err := s.returnedQuantities(tx, doc)
+	if err != nil {
+		return err
+	}
+
+	receipt := &models.Document{
+		ID: doc.ID, Type: doc.Type, WarehouseID: doc.WarehouseID, CreatedAt: doc.CreatedAt, DocumentDate: doc.DocumentDate,
+		ExchangeRate: decimal.NewFromInt(1), // себестоимость партий уже в базовой валюте
+	}
+	for _, item := range doc.Items {
+		slices, err := takeSlices(sold[item.VariantID], returned[item.VariantID], item.Quantity)
+		if err != nil {
+			return fmt.Errorf("variant %d: %w", item.VariantID, err)
+		}
+		returned[item.VariantID] = returned[item.VariantID].Add(item.Quantity)
+
+		for _, sl := range slices {
+			cost := sl.cost
+			line := models.DocumentItem{VariantID: item.VariantID, Quantity: sl.qty, Price: &cost}
+			if sl.lot != nil {
+				line.BatchNumber, line.ProductionDate, line.ExpiryDate = sl.lot.BatchNumber, sl.lot.ProductionDate, sl.lot.ExpiryDate
+			}
+			receipt.Items = append(receipt.Items, line)
+		}
+	}
+	return strategy.ProcessIncome(tx, receipt, s.config)
+}
+
+// processReturnOut списывает возврат поставщику. При партионном учёте уходят партии именно этого прихода,
+// при остальных политиках - обычный расход по текущей оценке.
+func (s *inventoryService) processReturnOut(tx *gorm.DB, doc *models.Document, strategy QuantityStrategy) error {
+	income, err := s.returnBase(tx, doc)
+	if err != nil {
+		return err
+	}
+	if !sameWarehouse(doc.WarehouseID, income.WarehouseID) {
+		return errors.New("return must be shipped from the warehouse of the receipt")
+	}
+
+	received := make(map[uint]decimal.Decimal)
+	for _, item := range income.Items {
+		received[item.VariantID] = received[item.VariantID].Add(item.Quantity)
+	}
+	returned, err := s.returnedQuantities(tx, doc)
+	if err != nil {
+		return err
+	}
+	for _, item := range doc.Items {
+		returned[item.VariantID] = returned[item.VariantID].Add(item.Quantity)
+		if returned[item.VariantID].GreaterThan(received[item.VariantID]) {
+			return fmt.Errorf("variant %d: return exceeds received quantity %s", item.VariantID, received[item.VariantID].String())
+		}
+	}
+
+	if !isLotPolicy(s.config.AccountingPolicy) {
+		return strategy.ProcessOutcome(tx, doc, s.config)
+	}
+
+	for _, item := range doc.Items {
+		lots, err := s.lotRepo.ListByIncomeDocumentForUpdate(tx, income.ID, *doc.WarehouseID, item.VariantID)
+		if err != nil {
+			return err
+		}
+		left := item.Quantity
+		for i := range lots {
+			if left.IsZero() {
+				break
+			}
+			lot := &lots[i]
+			qty := decimal.Min(left, lot.CurrentQuantity)
+
+			mv := &models.StockMovement{
+				DocumentID: &doc.ID, VariantID: lot.VariantID, WarehouseID: lot.WarehouseID,
+				Quantity: qty.Neg(), UnitCost: lot.UnitCost, Type: "RETURN_OUT", SourceLotID: &lot.ID, CreatedAt: time.Now(),
+				DocumentDate: documentDate(doc),
+			}
+			if _, err := s.movementRepo.CreateWithTx(tx, mv); err != nil {
+				return err
+			}
+			lot.CurrentQuantity = lot.CurrentQuantity.Sub(qty)
+			if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
+				return err
+			}
+			left = left.Sub(qty)
+		}
+		if left.IsPositive() {
+			return fmt.Errorf("variant %d: only %s of the receipt is left in stock", item.VariantID, item.Quantity.Sub(left).String())
+		}
+		updateTotalQuantity(tx, s.balanceRepo, *doc.WarehouseID, item.VariantID, item.Quantity.Neg())
+	}
+	return nil
+}
+
+// returnBase проверяет документ-основание возврата: нужного типа и проведён.
+func (s *inventoryService) returnBase(tx *gorm.DB, doc *models.Document) (*models.Document, error) {
+	if doc.WarehouseID == nil {
+		return nil, errors.New("warehouse_id is required")
+	}
+	if doc.BaseDocumentID == nil {
+		return nil, fmt.Errorf("%s requires base_document_id", toUpper(doc.Type))
+	}
+	base, err := s.docRepo.GetByIDWithTx(tx, *doc.BaseDocumentID)
+	if err != nil {
+		return nil, fmt.Errorf("base document not found: %w", err)
+	}
+	if want := returnBaseTypes[toUpper(doc.Type)]; toUpper(base.Type) != want {
+		return nil, fmt.Errorf("%s must be based on %s, got %s", toUpper(doc.Type), want, toUpper(base.Type))
+	}
+	if base.Status != "posted" {
+		return nil, fmt.Errorf("base document %s is not posted", base.Number)
+	}
+	return base, nil
+}
+
+// returnedQuantities - сколько уже возвращено по основанию другими проведёнными возвратами того же вида.
+func (s *inventoryService) returnedQuantities(tx *gorm.DB, doc *models.Document) (map[uint]decimal.Decimal, error) {
+	linked, err := s.docRepo.ListByBaseDocumentWithTx(tx, *doc.BaseDocumentID)
+	if err != nil {
+		return nil, err
+	}
+	returned := make(map[uint]decimal.Decimal)
+	for _, other := range linked {
+		if other.ID == doc.ID || toUpper(other.Type) != toUpper(doc.Type) || other.Status != "posted" {
+			continue
+		}
+		for _, item := range other.Items {
+			returned[item.VariantID] = returned[item.VariantID].Add(item.Quantity)
+		}
+	}
+	return returned, nil
+}
+
+// takeSlices берёт qty из последовательности slices, пропустив уже использованные skip единиц.
+func takeSlices(slices []costSlice, skip, qty decimal.Decimal) ([]costSlice, error) {
+	total := decimal.Zero
+	for _, sl := range slices {
+		total = total.Add(sl.qty)
+	}
+	if skip.Add(qty).GreaterThan(total) {
+		return nil, fmt.Errorf("return exceeds sold quantity: sold %s, already returned %s", total.String(), skip.String())
+	}
+
+	var result []costSlice
+	for _, sl := range slices {
+		if qty.IsZero() {
+			break
+		}
+		if skip.GreaterThanOrEqual(sl.qty) {
+			skip = skip.Sub(sl.qty)
+			continue
+		}
+		part := decimal.Min(sl.qty.Sub(skip), qty)
+		skip = decimal.Zero
+		result = append(result, costSlice{qty: part, cost: sl.cost, lot: sl.lot})
+		qty = qty.Sub(part)
+	}
+	return result, nil
+}
+
+func isLotPolicy(policy string) bool {
+	switch policy {
+	case "fifo", "lifo", "fefo":
+		return true
+	}
+	return false
+}
+
+// hasPostedReturns - есть ли по документу проведённые возвраты: пока они есть, отменять или
+// исправлять основание нельзя, иначе возвраты повиснут на несуществующей отгрузке или приходе.
+func hasPostedReturns(tx *gorm.DB, repo repository.DocumentRepository, doc *models.Document) (bool, error) {
+	docType := toUpper(doc.Type)
+	if docType != "OUTCOME" && docType != "INCOME" {
+		return false, nil
+	}
+	linked, err := repo.ListByBaseDocumentWithTx(tx, doc.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, other := range linked {
+		if other.Status == "posted" && returnBaseTypes[toUpper(other.Type)] == docType {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,500 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+var (
+	ErrScanSessionNotFound = errors.New("scan session not found")
+	ErrScanSessionClosed   = errors.New("scan session is closed")
+	ErrScanSessionEmpty    = errors.New("nothing scanned in session")
+	ErrScanInvalid         = errors.New("invalid scan")
+)
+
+// scanDocumentTypes - документы, которые можно набирать сканированием.
+var scanDocumentTypes = map[string]bool{
+	"INCOME": true, "OUTCOME": true, "ORDER": true, "TRANSFER": true, "INVENTORY": true,
+	"RETURN_IN": true, "RETURN_OUT": true, "WRITE_OFF": true,
+}
+
+type ScanService interface {
+	// Open создаёт черновик документа и открывает на нём сеанс сканирования.
+	Open(dto models.ScanSessionCreateDTO, userID *uint) (*models.ScanSessionDTO, error)
+	GetSession(id uint) (*models.ScanSession, error)
+	// Get - состояние сеанса для продолжения после переподключения.
+	Get(id uint) (*models.ScanSessionDTO, error)
+	List(status string, warehouseID *uint) ([]models.ScanSession, error)
+	// Scan добавляет скан в черновик. Неизвестный код и скан сверх заказа записываются в сеанс,
+	// но черновик не меняют.
+	Scan(id uint, dto models.ScanDTO) (*models.ScanResultDTO, error)
+	// Close закрывает сеанс; черновик остаётся обычным документом и проводится как любой другой.
+	Close(id uint) (*models.DocumentDTO, error)
+	// Cancel удаляет открытый сеанс вместе с его черновиком.
+	Cancel(id uint) error
+}
+
+type scanService struct {
+	repo        repository.ScanSessionRepository
+	docs        DocumentService
+	docRepo     repository.DocumentRepository
+	barcodeRepo repository.BarcodeRepository
+	variantRepo repository.VariantRepository
+	productRepo repository.ProductRepository
+	whRepo      repository.WarehouseRepository
+	units       UnitService
+	txManager   repository.TxManager
+}
+
+func NewScanService(
+	repo repository.ScanSessionRepository, docs DocumentService, docRepo repository.DocumentRepository,
+	barcodeRepo repository.BarcodeRepository, variantRepo repository.VariantRepository,
+	productRepo repository.ProductRepository, whRepo repository.WarehouseRepository, units UnitService,
+	txManager repository.TxManager,
+) ScanService {
+	return &scanService{
+		repo: repo, docs: docs, docRepo: docRepo, barcodeRepo: barcodeRepo, variantRepo: variantRepo,
+		productRepo: productRepo, whRepo: whRepo, units: units, txManager: txManager,
+	}
+}
+
+func (s *scanService) Open(dto models.ScanSessionCreateDTO, userID *uint) (*models.ScanSessionDTO, error) {
+	docType := toUpper(dto.DocumentType)
+	if !scanDocumentTypes[docType] {
+		return nil, fmt.Errorf("%w: documents of type %s cannot be scanned", ErrScanInvalid, docType)
+	}
+	if wh, err := s.whRepo.GetByID(dto.WarehouseID); err != nil || wh == nil {
+		return nil, fmt.Errorf("%w: warehouse %d not found", ErrScanInvalid, dto.WarehouseID)
+	}
+
+	doc := &models.Document{
+		Type: docType, WarehouseID: &dto.WarehouseID, ToWarehouseID: dto.ToWarehouseID,
+		CounterpartyID: dto.CounterpartyID, CreatedBy: userID,
+	}
+	if dto.BaseDocumentID != nil {
+		base, err := s.docRepo.GetByID(*dto.BaseDocumentID)
+		if err != nil || base == nil {
+			return nil, fmt.Errorf("%w: base document %d not found", ErrScanInvalid, *dto.BaseDocumentID)
+		}
+		if toUpper(base.Type) != "ORDER" || !basedOnAllowed("ORDER", docType) {
+			return nil, fmt.Errorf("%w: only OUTCOME can be scanned against an ORDER", ErrScanInvalid)
+		}
+		if base.Status != "posted" {
+			return nil, fmt.Errorf("%w: order %s is not posted", ErrScanInvalid, base.Number)
+		}
+		doc.BaseDocumentID = &base.ID
+		doc.PriceTypeID, doc.Currency = base.PriceTypeID, base.Currency
+		if doc.CounterpartyID == nil {
+			doc.CounterpartyID = base.CounterpartyID
+		}
+	}
+
+	created, err := s.docs.Create(doc)
+	if err != nil {
+		return nil, err
+	}
+	session := &models.ScanSession{
+		DocumentID: created.ID, DocumentType: docType, WarehouseID: dto.WarehouseID,
+		BaseDocumentID: dto.BaseDocumentID, Status: models.ScanSessionOpen, CreatedBy: userID,
+	}
+	if err := s.repo.CreateWithTx(nil, session); err != nil {
+		// без сеанса пустой черновик никому не нужен
+		_ = s.docs.Delete(created.ID)
+		return nil, err
+	}
+	return s.Get(session.ID)
+}
+
+func (s *scanService) GetSession(id uint) (*models.ScanSession, error) {
+	session, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrScanSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *scanService) List(status string, warehouseID *uint) ([]models.ScanSession, error) {
+	return s.repo.List(status, warehouseID)
+}
+
+func (s *scanService) Scan(id uint, dto models.ScanDTO) (*models.ScanResultDTO, error) {
+	qty := decimal.NewFromInt(1)
+	if dto.Quantity != nil {
+		qty = *dto.Quantity
+	}
+	if qty.IsZero() {
+		return nil, fmt.Errorf("%w: quantity must not be zero", ErrScanInvalid)
+	}
+
+	var (
+		result  models.ScanResultDTO
+		session *models.ScanSession
+	)
+	err := s.txManager.DoInTx(func(tx *gorm.DB) error {
+		var err error
+		session, err = s.openSessionWithTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if dto.EventID != "" {
+			prev, err := s.repo.FindEventWithTx(tx, id, dto.EventID)
+			if err != nil {
+				return err
+			}
+			if prev != nil {
+				result.Event, result.Replayed = *prev, true
+				return nil
+			}
+		}
+		doc, err := s.draftWithTx(tx, session)
+		if err != nil {
+			return err
+		}
+
+		event := models.ScanEvent{SessionID: id, EventID: dto.EventID, Barcode: dto.Barcode, Serial: strings.TrimSpace(dto.Serial), Quantity: qty}
+		barcode, err := findBarcodeWithTx(s.barcodeRepo, tx, dto.Barcode)
+		if err != nil {
+			return err
+		}
+		if barcode == nil {
+			event.Result, event.Message = models.ScanUnknown, "barcode not found"
+		} else {
+			event.VariantID = &barcode.VariantID
+			if err := s.applyScanWithTx(tx, session, doc, &event); err != nil {
+				return err
+			}
+		}
+		if err := s.repo.CreateEventWithTx(tx, &event); err != nil {
+			return err
+		}
+		// сеанс с последним сканом поднимается наверх в списке открытых
+		if err := s.repo.UpdateWithTx(tx, session); err != nil {
+			return err
+		}
+		result.Event = event
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Event.VariantID != nil {
+		lines, err := s.lines(session)
+		if err != nil {
+			return nil, err
+		}
+		for i := range lines {
+			if lines[i].VariantID == *result.Event.VariantID {
+				result.Line = &lines[i]
+			}
+		}
+	}
+	return &result, nil
+}
+
+// applyScanWithTx меняет количество варианта в черновике. Скан сверх остатка заказа-основания
+// только записывается: проведение такого расхода всё равно было бы отклонено.
+func (s *scanService) applyScanWithTx(tx *gorm.DB, session *models.ScanSession, doc *models.Document, event *models.ScanEvent) error {
+	variantID := *event.VariantID
+	var line *models.DocumentItem
+	scanned := decimal.Zero
+	for i := range doc.Items {
+		if doc.Items[i].VariantID != variantID {
+			continue
+		}
+		if line == nil {
+			line = &doc.Items[i]
+		}
+		scanned = scanned.Add(doc.Items[i].Quantity)
+	}
+	if line == nil && event.Quantity.IsNegative() {
+		return fmt.Errorf("%w: variant %d is not scanned yet", ErrScanInvalid, variantID)
+	}
+	var variant models.Variant
+	if err := tx.Select("id", "track_serials").First(&variant, variantID).Error; err != nil {
+		return err
+	}
+	if variant.TrackSerials {
+		serialLine, err := scannedSerialLine(doc, event)
+		if err != nil {
+			return err
+		}
+		if serialLine != nil {
+			line = serialLine
+		}
+	} else if event.Serial != "" {
+		return fmt.Errorf("%w: variant %d does not track serial numbers", ErrScanInvalid, variantID)
+	}
+	if line != nil && line.Quantity.Add(event.Quantity).IsNegative() {
+		return fmt.Errorf("%w: cannot remove %s, scanned %s", ErrScanInvalid, event.Quantity.Neg().String(), line.Quantity.String())
+	}
+	// строка черновика должна пройти ту же проверку точности единицы, что и при проведении
+	merged := event.Quantity
+	if line != nil {
+		merged = line.Quantity.Add(event.Quantity)
+	}
+	if err := s.units.NormalizeItems([]models.DocumentItem{{VariantID: variantID, Quantity: merged}}); err != nil {
+		return fmt.Errorf("%w: %v", ErrScanInvalid, err)
+	}
+
+	var price *decimal.Decimal
+	if session.BaseDocumentID != nil {
+		order, err := s.docRepo.GetByIDWithTx(tx, *session.BaseDocumentID)
+		if err != nil {
+			return err
+		}
+		remaining, orderPrice := orderRemaining(order, variantID)
+		if total := scanned.Add(event.Quantity); total.GreaterThan(remaining) {
+			event.Result = models.ScanOverScan
+			event.Message = fmt.Sprintf("order %s has %s left to ship, scanned %s", order.Number, remaining.String(), total.String())
+			return nil
+		}
+		price = orderPrice
+	}
+
+	event.Result = models.ScanAccepted
+	if line == nil {
+		item := models.DocumentItem{DocumentID: doc.ID, VariantID: variantID, Quantity: event.Quantity, Price: price}
+		if event.Serial != "" {
+			item.SerialNumbers = []string{event.Serial}
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+	} else if qty := line.Quantity.Add(event.Quantity); qty.IsZero() {
+		if err := tx.Delete(&models.DocumentItem{}, line.ID).Error; err != nil {
+			return err
+		}
+	} else {
+		// количество из скана - в базовых единицах, единица ввода строки больше не соответствует ему
+		updated := models.DocumentItem{Quantity: qty, SerialNumbers: scanSerials(line.SerialNumbers, event)}
+		err := tx.Model(&models.DocumentItem{ID: line.ID}).Select("quantity", "unit_id", "unit_quantity", "serial_numbers").
+			Updates(&updated).Error
+		if err != nil {
+			return err
+		}
+	}
+	// черновик изменился: правка, начатая с прежней версии, должна быть отклонена
+	return tx.Model(&models.Document{}).Where("id = ?", doc.ID).Update("version", gorm.Expr("version + 1")).Error
+}
+
+// scannedSerialLine проверяет скан варианта с серийным учётом: серийный номер обязателен, сканируется по одной
+// единице и не может попасть в черновик дважды. Для снятия скана возвращает строку с этим номером.
+func scannedSerialLine(doc *models.Document, event *models.ScanEvent) (*models.DocumentItem, error) {
+	if event.Serial == "" {
+		return nil, fmt.Errorf("%w: variant %d tracks serial numbers, scan the serial number with the barcode", ErrScanInvalid, *event.VariantID)
+	}
+	if !event.Quantity.Abs().Equal(decimal.NewFromInt(1)) {
+		return nil, fmt.Errorf("%w: serial-tracked variant %d is scanned one unit at a time", ErrScanInvalid, *event.VariantID)
+	}
+	var found *models.DocumentItem
+	for i := range doc.Items {
+		if doc.Items[i].VariantID == *event.VariantID && slices.Contains(doc.Items[i].SerialNumbers, event.Serial) {
+			found = &doc.Items[i]
+		}
+	}
+	if event.Quantity.IsPositive() && found != nil {
+		return nil, fmt.Errorf("%w: serial number %s is already scanned", ErrScanInvalid, event.Serial)
+	}
+	if event.Quantity.IsNegative() && found == nil {
+		return nil, fmt.Errorf("%w: serial number %s is not scanned", ErrScanInvalid, event.Serial)
+	}
+	return found, nil
+}
+
+// scanSerials - серийные номера строки после скана: номер добавляется или снимается вместе с единицей.
+func scanSerials(serials []string, event *models.ScanEvent) []string {
+	if event.Serial == "" {
+		return serials
+	}
+	if event.Quantity.IsPositive() {
+		return append(slices.Clone(serials), event.Serial)
+	}
+	return slices.DeleteFunc(slices.Clone(serials), func(s string) bool { return s == event.Serial })
+}
+
+// orderRemaining - неотгруженный остаток варианта по заказу и цена его первой строки.
+func orderRemaining(order *models.Document, variantID uint) (decimal.Decimal, *decimal.Decimal) {
+	remaining := decimal.Zero
+	var price *decimal.Decimal
+	for _, item := range order.Items {
+		if item.VariantID != variantID {
+			continue
+		}
+		remaining = remaining.Add(item.Quantity.Sub(item.ShippedQuantity))
+		if price == nil {
+			price = item.Price
+		}
+	}
+	return remaining, price
+}
+
+func (s *scanService) Close(id uint) (*models.DocumentDTO, error) {
+	var docID uint
+	err := s.txManager.DoInTx(func(tx *gorm.DB) error {
+		session, err := s.openSessionWithTx(tx, id)
+		if err != nil {
+			return err
+		}
+		doc, err := s.draftWithTx(tx, session)
+		if err != nil {
+			return err
+		}
+		if len(doc.Items) == 0 {
+			return ErrScanSessionEmpty
+		}
+		now := time.Now()
+		session.Status, session.ClosedAt = models.ScanSessionClosed, &now
+		docID = doc.ID
+		return s.repo.UpdateWithTx(tx, session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.docs.GetByIDAsDTO(docID)
+}
+
+func (s *scanService) Cancel(id uint) error {
+	var docID uint
+	err := s.txManager.DoInTx(func(tx *gorm.DB) error {
+		session, err := s.openSessionWithTx(tx, id)
+		if err != nil {
+			return err
+		}
+		docID = session.DocumentID
+		return s.repo.DeleteWithTx(tx, id)
+	})
+	if err != nil {
+		return err
+	}
+	// черновик мог уже уйти дальше черновика - такой документ не удаляем
+	if doc, err := s.docRepo.GetByID(docID); err == nil && doc != nil && doc.Status == "draft" {
+		return s.docs.Delete(docID)
+	}
+	return nil
+}
+
+func (s *scanService) openSessionWithTx(tx *gorm.DB, id uint) (*models.ScanSession, error) {
+	session, err := s.repo.GetForUpdateWithTx(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrScanSessionNotFound
+	}
+	if session.Status != models.ScanSessionOpen {
+		return nil, fmt.Errorf("%w: session %d is %s", ErrScanSessionClosed, id, session.Status)
+	}
+	return session, nil
+}
+
+// draftWithTx - черновик сеанса. Проведённый, отправленный на согласование или удалённый документ
+// сканированием больше не меняется.
+func (s *scanService) draftWithTx(tx *gorm.DB, session *models.ScanSession) (*models.Document, error) {
+	doc, err := s.docRepo.GetByIDWithTx(tx, session.DocumentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: document %d was deleted", ErrScanSessionClosed, session.DocumentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doc.Status != "draft" {
+		return nil, fmt.Errorf("%w: document %s is %s", ErrScanSessionClosed, doc.Number, doc.Status)
+	}
+	return doc, nil
+}
+
+func (s *scanService) Get(id uint) (*models.ScanSessionDTO, error) {
+	session, err := s.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+	dto := &models.ScanSessionDTO{ScanSession: *session}
+	if doc, err := s.docRepo.GetByID(session.DocumentID); err == nil && doc != nil {
+		dto.DocumentNumber, dto.DocumentStatus = doc.Number, doc.Status
+	}
+	if dto.Lines, err = s.lines(session); err != nil {
+		return nil, err
+	}
+	count, err := s.repo.CountEvents(id)
+	if err != nil {
+		return nil, err
+	}
+	dto.ScanCount = int(count)
+	if dto.Unknown, err = s.repo.ListEvents(id, models.ScanUnknown); err != nil {
+		return nil, err
+	}
+	if dto.OverScans, err = s.repo.ListEvents(id, models.ScanOverScan); err != nil {
+		return nil, err
+	}
+	return dto, nil
+}
+
+// lines - строки черновика, сведённые по вариантам, с остатком заказа-основания.
+func (s *scanService) lines(session *models.ScanSession) ([]models.ScanLineDTO, error) {
+	lines := []models.ScanLineDTO{}
+	doc, err := s.docRepo.GetByID(session.DocumentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return lines, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var order *models.Document
+	if session.BaseDocumentID != nil {
+		if order, err = s.docRepo.GetByID(*session.BaseDocumentID); err != nil {
+			return nil, err
+		}
+	}
+
+	index := make(map[uint]int)
+	var variantIDs []uint
+	for _, item := range doc.Items {
+		i, ok := index[item.VariantID]
+		if !ok {
+			i = len(lines)
+			index[item.VariantID] = i
+			variantIDs = append(variantIDs, item.VariantID)
+			lines = append(lines, models.ScanLineDTO{VariantID: item.VariantID})
+		}
+		lines[i].Quantity = lines[i].Quantity.Add(item.Quantity)
+	}
+
+	variants, err := s.variantRepo.GetByIDs(variantIDs)
+	if err != nil {
+		return nil, err
+	}
+	productIDs := make([]uint, 0, len(variants))
+	for _, v := range variants {
+		productIDs = append(productIDs, v.ProductID)
+	}
+	products, err := s.productRepo.GetByIDs(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	productNames := make(map[uint]string, len(products))
+	for _, p := range products {
+		productNames[p.ID] = p.Name
+	}
+	for _, v := range variants {
+		line := &lines[index[v.ID]]
+		line.VariantSKU, line.ProductName = v.SKU, productNames[v.ProductID]
+		if order != nil {
+			remaining, _ := orderRemaining(order, v.ID)
+			line.Remaining = &remaining
+		}
+	}
+	return lines, nil
+}
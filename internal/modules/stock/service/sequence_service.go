@@ -52,6 +52,16 @@ func (s *sequenceService) GenerateNextDocumentNumber(docType string) (string, er
 				prefix = "УЦ"
 			case "ORDER":
 				prefix = "ЗК"
+			case "RETURN_IN":
+				prefix = "ВП"
+			case "RETURN_OUT":
+				prefix = "ВПС"
+			case "WRITE_OFF":
+				prefix = "СП"
+			case "ASSEMBLY":
+				prefix = "СБ"
+			case "DISASSEMBLY":
+				prefix = "РК"
 			default:
 				prefix = "ДОК"
 			}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+// Состояния серийной единицы. Пустой статус - единица не числится на складе (новая или отменённый приход).
+const (
+	serialInStock   = "in_stock"
+	serialReserved  = "reserved"
+	serialInTransit = "in_transit"
+	serialShipped   = "shipped"
+)
+
+// serialRelease - тип движения, которым истёкший резерв заказа возвращает единицу в свободный остаток.
+const serialRelease = "RELEASE"
+
+var (
+	ErrSerialNotFound  = errors.New("serial number not found")
+	ErrSerialAmbiguous = errors.New("serial number belongs to several variants, specify variant_id")
+)
+
+type SerialService interface {
+	GetHistory(serial string, variantID *uint) (*models.SerialHistoryDTO, error)
+}
+
+// serialTracker проверяет серийные номера в строках документов и переводит единицы между состояниями.
+type serialTracker struct {
+	repo        repository.SerialRepository
+	variantRepo repository.VariantRepository
+}
+
+func newSerialTracker(repo repository.SerialRepository, variantRepo repository.VariantRepository) *serialTracker {
+	return &serialTracker{repo: repo, variantRepo: variantRepo}
+}
+
+// apply проводит серийные номера документа. action - тип документа либо SHIP/RECEIVE для перемещения в два шага.
+func (t *serialTracker) apply(tx *gorm.DB, doc *models.Document, action string) error {
+	tracked, err := t.trackedVariants(doc)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range doc.Items {
+		if !tracked[item.VariantID] {
+			if len(item.SerialNumbers) > 0 {
+				return fmt.Errorf("variant %d is not serial-tracked, remove serial numbers", item.VariantID)
+			}
+			continue
+		}
+		if err := validateSerials(item); err != nil {
+			return err
+		}
+
+		units, err := t.repo.GetUnitsForUpdate(tx, item.VariantID, item.SerialNumbers)
+		if err != nil {
+			return err
+		}
+		unitMap := make(map[string]*models.SerialUnit, len(units))
+		for i := range units {
+			unitMap[units[i].Serial] = &units[i]
+		}
+
+		for _, serial := range item.SerialNumbers {
+			unit := unitMap[serial]
+			if unit == nil {
+				if action != "INCOME" {
+					return fmt.Errorf("серийный номер %s не найден на складе", serial)
+				}
+				unit = &models.SerialUnit{VariantID: item.VariantID, Serial: serial}
+			}
+
+			if action == "OUTCOME" && unit.Status == serialReserved {
+				if err := t.checkReservedFor(tx, doc, unit); err != nil {
+					return err
+				}
+			}
+
+			fromWh, fromStatus := unit.WarehouseID, unit.Status
+			toWh, toStatus, err := serialTransition(doc, action, unit)
+			if err != nil {
+				return err
+			}
+
+			unit.WarehouseID, unit.Status = toWh, toStatus
+			if err := t.repo.SaveUnitWithTx(tx, unit); err != nil {
+				return err
+			}
+			mv := &models.SerialMovement{
+				SerialUnitID: unit.ID, DocumentID: doc.ID, Type: action,
+				FromWarehouseID: fromWh, ToWarehouseID: toWh, FromStatus: fromStatus, ToStatus: toStatus,
+				CreatedAt: time.Now(),
+			}
+			if err := t.repo.CreateMovementWithTx(tx, mv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkReservedFor разрешает отгрузить зарезервированную единицу только по заказу, который её зарезервировал,
+// чтобы отгрузка одного заказа не забрала единицы, обещанные другому.
+func (t *serialTracker) checkReservedFor(tx *gorm.DB, doc *models.Document, unit *models.SerialUnit) error {
+	reservation, err := t.repo.GetLastReservationWithTx(tx, unit.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if reservation == nil || doc.BaseDocumentID == nil || reservation.DocumentID != *doc.BaseDocumentID {
+		return fmt.Errorf("серийный номер %s зарезервирован другим заказом", unit.Serial)
+	}
+	return nil
+}
+
+// revert возвращает все единицы документа в состояние до его проведения.
+func (t *serialTracker) revert(tx *gorm.DB, doc *models.Document) error {
+	mvs, err := t.repo.ListMovementsByDocumentWithTx(tx, doc.ID)
+	if err != nil {
+		return err
+	}
+
+	// единицы, снятые с истёкшего резерва, уже свободны - отменять по ним нечего
+	released := make(map[uint]bool)
+	for i := len(mvs) - 1; i >= 0; i-- {
+		mv := mvs[i]
+		if mv.Type == serialRelease {
+			released[mv.SerialUnitID] = true
+			continue
+		}
+		if mv.Type == "CANCEL" || released[mv.SerialUnitID] {
+			continue
+		}
+		unit, err := t.repo.GetUnitByIDForUpdate(tx, mv.SerialUnitID)
+		if err != nil {
+			return err
+		}
+		if unit.Status != mv.ToStatus || !sameWarehouse(unit.WarehouseID, mv.ToWarehouseID) {
+			return fmt.Errorf("серийный номер %s уже участвует в другом документе", unit.Serial)
+		}
+
+		unit.WarehouseID, unit.Status = mv.FromWarehouseID, mv.FromStatus
+		if err := t.repo.SaveUnitWithTx(tx, unit); err != nil {
+			return err
+		}
+		cancel := &models.SerialMovement{
+			SerialUnitID: unit.ID, DocumentID: doc.ID, Type: "CANCEL",
+			FromWarehouseID: mv.ToWarehouseID, ToWarehouseID: mv.FromWarehouseID,
+			FromStatus: mv.ToStatus, ToStatus: mv.FromStatus, CreatedAt: time.Now(),
+		}
+		if err := t.repo.CreateMovementWithTx(tx, cancel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseReservedSerials возвращает в свободный остаток единицы, которые заказ docID ещё держит в резерве,
+// только по вариантам variants. Отгруженные и уже снятые единицы не трогаются.
+func releaseReservedSerials(tx *gorm.DB, repo repository.SerialRepository, docID uint, variants map[uint]bool, now time.Time) error {
+	mvs, err := repo.ListMovementsByDocumentWithTx(tx, docID)
+	if err != nil {
+		return err
+	}
+	done := make(map[uint]bool)
+	for _, mv := range mvs {
+		if mv.Type == serialRelease || mv.Type == "CANCEL" {
+			done[mv.SerialUnitID] = true
+		}
+	}
+
+	for _, mv := range mvs {
+		if mv.Type != "ORDER" || mv.ToStatus != serialReserved || done[mv.SerialUnitID] {
+			continue
+		}
+		done[mv.SerialUnitID] = true
+		unit, err := repo.GetUnitByIDForUpdate(tx, mv.SerialUnitID)
+		if err != nil {
+			return err
+		}
+		if !variants[unit.VariantID] || unit.Status != serialReserved || !sameWarehouse(unit.WarehouseID, mv.ToWarehouseID) {
+			continue
+		}
+
+		unit.Status = serialInStock
+		if err := repo.SaveUnitWithTx(tx, unit); err != nil {
+			return err
+		}
+		release := &models.SerialMovement{
+			SerialUnitID: unit.ID, DocumentID: docID, Type: serialRelease,
+			FromWarehouseID: unit.WarehouseID, ToWarehouseID: unit.WarehouseID,
+			FromStatus: serialReserved, ToStatus: serialInStock, CreatedAt: now,
+		}
+		if err := repo.CreateMovementWithTx(tx, release); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFullReceipt запрещает частичную приёмку серийного товара: неизвестно, какие единицы потеряны.
+func (t *serialTracker) checkFullReceipt(doc *models.Document, received map[uint]decimal.Decimal) error {
+	if received == nil {
+		return nil
+	}
+	tracked, err := t.trackedVariants(doc)
+	if err != nil {
+		return err
+	}
+
+	shipped := make(map[uint]decimal.Decimal)
+	for _, item := range doc.Items {
+		shipped[item.VariantID] = shipped[item.VariantID].Add(item.Quantity)
+	}
+	for variantID, qty := range shipped {
+		if tracked[variantID] && !received[variantID].Equal(qty) {
+			return fmt.Errorf("partial receipt is not supported for serial-tracked variant %d", variantID)
+		}
+	}
+	return nil
+}
+
+// rejectTracked запрещает документу менять остаток серийного товара: его типы не переводят серийные единицы,
+// и они остались бы числиться на складе при уменьшившемся остатке.
+func (t *serialTracker) rejectTracked(doc *models.Document) error {
+	tracked, err := t.trackedVariants(doc)
+	if err != nil {
+		return err
+	}
+	for _, item := range doc.Items {
+		if tracked[item.VariantID] {
+			return fmt.Errorf("variant %d is serial-tracked: %s documents cannot change its stock", item.VariantID, toUpper(doc.Type))
+		}
+	}
+	return nil
+}
+
+func (t *serialTracker) trackedVariants(doc *models.Document) (map[uint]bool, error) {
+	ids := make(map[uint]bool)
+	for _, item := range doc.Items {
+		ids[item.VariantID] = true
+	}
+	variants, err := t.variantRepo.GetByIDs(mapKeysToSlice(ids))
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[uint]bool, len(variants))
+	for _, v := range variants {
+		tracked[v.ID] = v.TrackSerials
+	}
+	return tracked, nil
+}
+
+func validateSerials(item models.DocumentItem) error {
+	if !item.Quantity.Equal(decimal.NewFromInt(int64(len(item.SerialNumbers)))) {
+		return fmt.Errorf("variant %d: количество серийных номеров (%d) не совпадает с количеством (%s)",
+			item.VariantID, len(item.SerialNumbers), item.Quantity.String())
+	}
+	seen := make(map[string]bool, len(item.SerialNumbers))
+	for _, serial := range item.SerialNumbers {
+		if strings.TrimSpace(serial) == "" {
+			return fmt.Errorf("variant %d: пустой серийный номер", item.VariantID)
+		}
+		if seen[serial] {
+			return fmt.Errorf("variant %d: серийный номер %s указан дважды", item.VariantID, serial)
+		}
+		seen[serial] = true
+	}
+	return nil
+}
+
+// serialTransition проверяет, что единица может участвовать в операции, и возвращает её новое место и статус.
+func serialTransition(doc *models.Document, action string, unit *models.SerialUnit) (*uint, string, error) {
+	atWarehouse := func(statuses ...string) error {
+		if !sameWarehouse(unit.WarehouseID, doc.WarehouseID) {
+			return fmt.Errorf("серийного номера %s нет на складе документа", unit.Serial)
+		}
+		for _, st := range statuses {
+			if unit.Status == st {
+				return nil
+			}
+		}
+		return fmt.Errorf("серийный номер %s недоступен (статус: %s)", unit.Serial, unit.Status)
+	}
+
+	switch action {
+	case "INCOME":
+		if unit.Status == serialInStock || unit.Status == serialReserved || unit.Status == serialInTransit {
+			return nil, "", fmt.Errorf("серийный номер %s уже числится на складе", unit.Serial)
+		}
+		return doc.WarehouseID, serialInStock, nil
+	case "ORDER":
+		return doc.WarehouseID, serialReserved, atWarehouse(serialInStock)
+	case "OUTCOME":
+		return nil, serialShipped, atWarehouse(serialInStock, serialReserved)
+	case "TRANSFER":
+		return doc.ToWarehouseID, serialInStock, atWarehouse(serialInStock)
+	case "SHIP":
+		return nil, serialInTransit, atWarehouse(serialInStock)
+	case "RECEIVE":
+		if unit.Status != serialInTransit {
+			return nil, "", fmt.Errorf("серийный номер %s не находится в пути", unit.Serial)
+		}
+		return doc.ToWarehouseID, serialInStock, nil
+	default:
+		return nil, "", fmt.Errorf("serial numbers are not supported for '%s'", action)
+	}
+}
+
+func sameWarehouse(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+type serialService struct {
+	repo        repository.SerialRepository
+	docRepo     repository.DocumentRepository
+	variantRepo repository.VariantRepository
+	productRepo repository.ProductRepository
+	whRepo      repository.WarehouseRepository
+}
+
+func NewSerialService(
+	repo repository.SerialRepository, docRepo repository.DocumentRepository,
+	variantRepo repository.VariantRepository, productRepo repository.ProductRepository, whRepo repository.WarehouseRepository,
+) SerialService {
+	return &serialService{repo: repo, docRepo: docRepo, variantRepo: variantRepo, productRepo: productRepo, whRepo: whRepo}
+}
+
+func (s *serialService) GetHistory(serial string, variantID *uint) (*models.SerialHistoryDTO, error) {
+	units, err := s.repo.FindBySerial(serial, variantID)
+	if err != nil {
+		return nil, err
+	}
+	if len(units) == 0 {
+		return nil, ErrSerialNotFound
+	}
+	if len(units) > 1 {
+		return nil, ErrSerialAmbiguous
+	}
+	unit := units[0]
+
+	dto := &models.SerialHistoryDTO{
+		Serial: unit.Serial, VariantID: unit.VariantID, Status: unit.Status, WarehouseID: unit.WarehouseID,
+		History: []models.SerialHistoryEntryDTO{},
+	}
+	if variant, _ := s.variantRepo.GetByID(unit.VariantID); variant != nil {
+		dto.VariantSKU = variant.SKU
+		if p, _ := s.productRepo.GetByID(variant.ProductID); p != nil {
+			dto.ProductName = p.Name
+		}
+	}
+	if unit.WarehouseID != nil {
+		if wh, _ := s.whRepo.GetByID(*unit.WarehouseID); wh != nil {
+			dto.WarehouseName = wh.Name
+		}
+	}
+
+	mvs, err := s.repo.ListMovementsByUnit(unit.ID)
+	if err != nil {
+		return nil, err
+	}
+	docIDs := make(map[uint]bool)
+	for _, mv := range mvs {
+		docIDs[mv.DocumentID] = true
+	}
+	docs, _ := s.docRepo.GetByIDs(mapKeysToSlice(docIDs))
+	docMap := make(map[uint]models.Document, len(docs))
+	for _, d := range docs {
+		docMap[d.ID] = d
+	}
+
+	for _, mv := range mvs {
+		doc := docMap[mv.DocumentID]
+		dto.History = append(dto.History, models.SerialHistoryEntryDTO{
+			DocumentID: mv.DocumentID, DocumentNumber: doc.Number, DocumentType: doc.Type, Type: mv.Type,
+			FromWarehouseID: mv.FromWarehouseID, ToWarehouseID: mv.ToWarehouseID,
+			FromStatus: mv.FromStatus, ToStatus: mv.ToStatus, CreatedAt: mv.CreatedAt,
+		})
+	}
+	return dto, nil
+}
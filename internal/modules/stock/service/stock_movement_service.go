@@ -37,6 +37,7 @@ func NewStockMovementService(r repository.StockMovementRepository, docRepo repos
 }
 
 func (s *movementService) Create(itemID, warehouseID uint, counterpartyID *uint, qty decimal.Decimal, mtype, comment string) (*stock.StockMovement, error) {
+	now := time.Now()
 	return s.repo.Create(&stock.StockMovement{
 		VariantID:      itemID,
 		WarehouseID:    warehouseID,
@@ -44,7 +45,8 @@ func (s *movementService) Create(itemID, warehouseID uint, counterpartyID *uint,
 		Quantity:       qty,
 		Type:           mtype,
 		Comment:        comment,
-		CreatedAt:      time.Now(),
+		CreatedAt:      now,
+		DocumentDate:   now,
 	})
 }
 func (s *movementService) GetByID(id uint) (*stock.StockMovement, error) { return s.repo.GetByID(id) }
@@ -118,6 +120,7 @@ func (s *movementService) ListAsDTO() ([]stock.StockMovementDTO, error) {
 			WarehouseName:  whMap[mv.WarehouseID],
 			Quantity:       mv.Quantity,
 			Type:           mv.Type,
+			DocumentDate:   mv.DocumentDate,
 			CreatedAt:      mv.CreatedAt,
 		}
 	}
@@ -170,6 +173,8 @@ func (s *movementService) SearchAsDTO(filter stock.MovementFilter) ([]stock.Stoc
 			WarehouseName:  whMap[mv.WarehouseID],
 			Quantity:       mv.Quantity,
 			Type:           mv.Type,
+			SourceLotID:    mv.SourceLotID,
+			DocumentDate:   mv.DocumentDate,
 			CreatedAt:      mv.CreatedAt,
 		}
 	}
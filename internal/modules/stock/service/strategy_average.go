@@ -0,0 +1,280 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/config"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// AverageQuantityStrategy ведёт скользящую среднюю себестоимость по складу и варианту.
+// Средняя пересчитывается на каждом поступлении, расход оценивается по текущей средней.
+type AverageQuantityStrategy struct {
+	balanceRepo  repository.BalanceRepository
+	movementRepo repository.StockMovementRepository
+	transitRepo  repository.TransitRepository
+}
+
+func NewAverageQuantityStrategy(b repository.BalanceRepository, m repository.StockMovementRepository, t repository.TransitRepository) QuantityStrategy {
+	return &AverageQuantityStrategy{balanceRepo: b, movementRepo: m, transitRepo: t}
+}
+
+func (s *AverageQuantityStrategy) ProcessIncome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	if doc.WarehouseID == nil {
+		return errors.New("warehouse_id is required")
+	}
+	for _, it := range doc.Items {
+		bal, err := s.balanceRepo.GetBalanceWithTx(tx, *doc.WarehouseID, it.VariantID)
+		if err != nil {
+			return err
+		}
+
+		// Без цены (например, излишки инвентаризации) приходуем по текущей средней.
+		unitCost := decimal.Zero
+		if it.Price != nil {
+			unitCost = baseUnitCost(doc, *it.Price)
+		} else if bal != nil {
+			unitCost = bal.AvgCost
+		}
+
+		mv := &models.StockMovement{
+			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.WarehouseID,
+			Quantity: it.Quantity, UnitCost: unitCost, Type: movementType(doc, "INCOME"), CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
+		}
+		if _, err := s.movementRepo.CreateWithTx(tx, mv); err != nil {
+			return err
+		}
+
+		if err := s.applyAverageCost(tx, *doc.WarehouseID, it.VariantID, it.Quantity, unitCost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AverageQuantityStrategy) ProcessOutcome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	if doc.WarehouseID == nil {
+		return errors.New("warehouse_id is required")
+	}
+	for _, it := range doc.Items {
+		unitCost, err := s.takeOut(tx, *doc.WarehouseID, it.VariantID, it.Quantity, cfg)
+		if err != nil {
+			return err
+		}
+
+		mv := &models.StockMovement{
+			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.WarehouseID,
+			Quantity: it.Quantity.Neg(), UnitCost: unitCost, Type: movementType(doc, "OUTCOME"), CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
+		}
+		if _, err := s.movementRepo.CreateWithTx(tx, mv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AverageQuantityStrategy) RevertIncome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	return s.revertMovements(tx, doc, func(mv models.StockMovement) bool { return mv.Quantity.IsPositive() })
+}
+
+func (s *AverageQuantityStrategy) RevertOutcome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	return s.revertMovements(tx, doc, func(mv models.StockMovement) bool { return mv.Quantity.IsNegative() })
+}
+
+func (s *AverageQuantityStrategy) ProcessTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	for _, it := range doc.Items {
+		unitCost, err := s.takeOut(tx, *doc.WarehouseID, it.VariantID, it.Quantity, cfg)
+		if err != nil {
+			return err
+		}
+
+		out := &models.StockMovement{
+			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.WarehouseID,
+			Quantity: it.Quantity.Neg(), UnitCost: unitCost, Type: "TRANSFER", CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
+		}
+		if _, err := s.movementRepo.CreateWithTx(tx, out); err != nil {
+			return err
+		}
+		in := &models.StockMovement{
+			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.ToWarehouseID,
+			Quantity: it.Quantity, UnitCost: unitCost, Type: "TRANSFER", CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
+		}
+		if _, err := s.movementRepo.CreateWithTx(tx, in); err != nil {
+			return err
+		}
+
+		if err := s.applyAverageCost(tx, *doc.ToWarehouseID, it.VariantID, it.Quantity, unitCost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AverageQuantityStrategy) ShipTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	for _, it := range doc.Items {
+		unitCost, err := s.takeOut(tx, *doc.WarehouseID, it.VariantID, it.Quantity, cfg)
+		if err != nil {
+			return err
+		}
+
+		out := &models.StockMovement{
+			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.WarehouseID,
+			Quantity: it.Quantity.Neg(), UnitCost: unitCost, Type: "TRANSFER", CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
+		}
+		if _, err := s.movementRepo.CreateWithTx(tx, out); err != nil {
+			return err
+		}
+
+		row := &models.StockInTransit{
+			DocumentID: doc.ID, VariantID: it.VariantID,
+			FromWarehouseID: *doc.WarehouseID, ToWarehouseID: *doc.ToWarehouseID,
+			ArrivalDate: time.Now(), UnitCost: unitCost, Quantity: it.Quantity, ShippedAt: time.Now(),
+		}
+		if err := s.transitRepo.CreateWithTx(tx, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AverageQuantityStrategy) ReceiveTransfer(tx *gorm.DB, doc *models.Document, received map[uint]decimal.Decimal, cfg *config.Config) error {
+	return receiveTransit(tx, doc, received, s.transitRepo, s.movementRepo,
+		func(row *models.StockInTransit, qty decimal.Decimal) (*uint, error) {
+			return nil, s.applyAverageCost(tx, row.ToWarehouseID, row.VariantID, qty, row.UnitCost)
+		})
+}
+
+func (s *AverageQuantityStrategy) RevertTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	moves, err := s.movementRepo.ListActiveByDocumentWithTx(tx, doc.ID)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.AllowNegativeStock {
+		// Сколько товара документ в итоге оставил на каждом складе получателя.
+		type key struct{ wh, variant uint }
+		credited := make(map[key]decimal.Decimal)
+		for _, mv := range moves {
+			if mv.WarehouseID != *doc.ToWarehouseID {
+				continue
+			}
+			k := key{mv.WarehouseID, mv.VariantID}
+			credited[k] = credited[k].Add(mv.Quantity)
+		}
+		for k, qty := range credited {
+			if !qty.IsPositive() {
+				continue
+			}
+			bal, err := s.balanceRepo.GetBalanceWithTx(tx, k.wh, k.variant)
+			if err != nil {
+				return err
+			}
+			if bal == nil || bal.Quantity.LessThan(qty) {
+				return fmt.Errorf("cannot cancel transfer: goods of variant %d have already left destination warehouse %d", k.variant, k.wh)
+			}
+		}
+	}
+
+	if err := s.revertMovements(tx, doc, func(models.StockMovement) bool { return true }); err != nil {
+		return err
+	}
+	return s.transitRepo.DeleteByDocumentWithTx(tx, doc.ID)
+}
+
+// takeOut списывает количество по текущей средней и возвращает её для оценки движения.
+func (s *AverageQuantityStrategy) takeOut(tx *gorm.DB, whID, variantID uint, qty decimal.Decimal, cfg *config.Config) (decimal.Decimal, error) {
+	bal, err := s.balanceRepo.GetBalanceWithTx(tx, whID, variantID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	currentQty, unitCost := decimal.Zero, decimal.Zero
+	if bal != nil {
+		currentQty, unitCost = bal.Quantity, bal.AvgCost
+	}
+	if !cfg.AllowNegativeStock && currentQty.LessThan(qty) {
+		return decimal.Zero, fmt.Errorf("not enough stock for variant %d: have=%s, need=%s", variantID, currentQty.String(), qty.String())
+	}
+
+	return unitCost, s.applyAverageCost(tx, whID, variantID, qty.Neg(), unitCost)
+}
+
+// revertMovements сторнирует отобранные движения документа, возвращая в остаток их стоимость.
+// Движения сворачиваются по складу и варианту, чтобы промежуточный нулевой остаток не сбил среднюю.
+func (s *AverageQuantityStrategy) revertMovements(tx *gorm.DB, doc *models.Document, match func(models.StockMovement) bool) error {
+	moves, err := s.movementRepo.ListActiveByDocumentWithTx(tx, doc.ID)
+	if err != nil {
+		return err
+	}
+
+	type key struct{ wh, variant uint }
+	type delta struct{ qty, value decimal.Decimal }
+	var order []key
+	deltas := make(map[key]*delta)
+
+	for _, mv := range moves {
+		if !match(mv) {
+			continue
+		}
+		if err := reverseMovement(tx, doc, mv, s.movementRepo); err != nil {
+			return err
+		}
+
+		k := key{mv.WarehouseID, mv.VariantID}
+		d, ok := deltas[k]
+		if !ok {
+			d = &delta{}
+			deltas[k] = d
+			order = append(order, k)
+		}
+		d.qty = d.qty.Sub(mv.Quantity)
+		d.value = d.value.Sub(mv.Quantity.Mul(mv.UnitCost))
+	}
+
+	for _, k := range order {
+		d := deltas[k]
+		if err := s.applyAverageValue(tx, k.wh, k.variant, d.qty, d.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyAverageCost меняет остаток на qty по цене unitCost.
+func (s *AverageQuantityStrategy) applyAverageCost(tx *gorm.DB, whID, variantID uint, qty, unitCost decimal.Decimal) error {
+	return s.applyAverageValue(tx, whID, variantID, qty, qty.Mul(unitCost))
+}
+
+// applyAverageValue меняет остаток на qty и его стоимость на value, пересчитывая среднюю.
+// Расход по текущей средней её не меняет; при нулевом или отрицательном остатке средняя сохраняется.
+func (s *AverageQuantityStrategy) applyAverageValue(tx *gorm.DB, whID, variantID uint, qty, value decimal.Decimal) error {
+	bal, err := s.balanceRepo.GetBalanceWithTx(tx, whID, variantID)
+	if err != nil {
+		return err
+	}
+	if bal == nil {
+		bal = &models.StockBalance{WarehouseID: whID, VariantID: variantID, Quantity: decimal.Zero}
+	}
+
+	newQty := bal.Quantity.Add(qty)
+	switch {
+	case !bal.Quantity.IsPositive() && qty.IsPositive():
+		bal.AvgCost = value.Div(qty).Round(4)
+	case newQty.IsPositive():
+		total := bal.Quantity.Mul(bal.AvgCost).Add(value)
+		bal.AvgCost = total.Div(newQty).Round(4)
+	}
+	bal.Quantity = newQty
+
+	return s.balanceRepo.SaveBalanceWithTx(tx, bal)
+}
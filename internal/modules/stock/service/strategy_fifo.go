@@ -18,6 +18,8 @@ type FifoQuantityStrategy struct {
 	balanceRepo  repository.BalanceRepository
 	variantRepo  repository.VariantRepository
 	productRepo  repository.ProductRepository
+	transitRepo  repository.TransitRepository
+	order        repository.LotOrder
 }
 
 func NewFifoQuantityStrategy(
@@ -26,6 +28,8 @@ func NewFifoQuantityStrategy(
 	b repository.BalanceRepository,
 	v repository.VariantRepository,
 	p repository.ProductRepository,
+	t repository.TransitRepository,
+	order repository.LotOrder,
 ) QuantityStrategy {
 	return &FifoQuantityStrategy{
 		lotRepo:      l,
@@ -33,6 +37,8 @@ func NewFifoQuantityStrategy(
 		balanceRepo:  b,
 		variantRepo:  v,
 		productRepo:  p,
+		transitRepo:  t,
+		order:        order,
 	}
 }
 
@@ -41,9 +47,14 @@ func (s *FifoQuantityStrategy) ProcessIncome(tx *gorm.DB, doc *models.Document,
 		return errors.New("warehouse_id is required")
 	}
 	for _, it := range doc.Items {
+		unitCost := decimal.Zero
+		if it.Price != nil {
+			unitCost = baseUnitCost(doc, *it.Price)
+		}
 		lot := &models.StockLot{
 			WarehouseID: *doc.WarehouseID, VariantID: it.VariantID,
-			IncomeDocumentID: doc.ID, ArrivalDate: doc.CreatedAt, CurrentQuantity: it.Quantity,
+			IncomeDocumentID: doc.ID, ArrivalDate: documentDate(doc), CurrentQuantity: it.Quantity,
+			UnitCost: unitCost, BatchNumber: it.BatchNumber, ProductionDate: it.ProductionDate, ExpiryDate: it.ExpiryDate,
 		}
 		if err := s.lotRepo.CreateWithTx(tx, lot); err != nil {
 			return err
@@ -51,7 +62,8 @@ func (s *FifoQuantityStrategy) ProcessIncome(tx *gorm.DB, doc *models.Document,
 
 		mv := &models.StockMovement{
 			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.WarehouseID,
-			Quantity: it.Quantity, Type: "INCOME", CreatedAt: time.Now(),
+			Quantity: it.Quantity, UnitCost: unitCost, Type: movementType(doc, "INCOME"), CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
 		}
 		if _, err := s.movementRepo.CreateWithTx(tx, mv); err != nil {
 			return err
@@ -67,7 +79,7 @@ func (s *FifoQuantityStrategy) ProcessOutcome(tx *gorm.DB, doc *models.Document,
 		return errors.New("warehouse_id is required")
 	}
 	for _, it := range doc.Items {
-		lots, err := s.lotRepo.GetOldestLotsForUpdate(tx, *doc.WarehouseID, it.VariantID)
+		lots, err := s.lotRepo.GetLotsForUpdate(tx, *doc.WarehouseID, it.VariantID, s.order)
 		if err != nil {
 			return err
 		}
@@ -78,21 +90,12 @@ func (s *FifoQuantityStrategy) ProcessOutcome(tx *gorm.DB, doc *models.Document,
 		}
 
 		if !cfg.AllowNegativeStock && totalQtyInLots.LessThan(it.Quantity) {
-			variant, _ := s.variantRepo.GetByID(it.VariantID)
-			productName := "Unknown"
-			sku := "?"
-			if variant != nil {
-				sku = variant.SKU
-				if p, _ := s.productRepo.GetByID(variant.ProductID); p != nil {
-					productName = p.Name
-				}
-			}
+			productName, sku := s.describeVariant(it.VariantID)
 			return fmt.Errorf("Недостаточно товара '%s' (%s). В наличии: %s, Нужно: %s",
 				productName, sku, totalQtyInLots.String(), it.Quantity.String())
 		}
 
 		qtyToShip := it.Quantity
-		lotsToDelete := []uint{}
 		for i := range lots {
 			if qtyToShip.IsZero() {
 				break
@@ -103,40 +106,35 @@ func (s *FifoQuantityStrategy) ProcessOutcome(tx *gorm.DB, doc *models.Document,
 
 			mv := &models.StockMovement{
 				DocumentID: &doc.ID, VariantID: lot.VariantID, WarehouseID: lot.WarehouseID,
-				Quantity: qtyFromLot.Neg(), Type: "OUTCOME", SourceLotID: &lot.ID, CreatedAt: time.Now(),
+				Quantity: qtyFromLot.Neg(), UnitCost: lot.UnitCost, Type: movementType(doc, "OUTCOME"), SourceLotID: &lot.ID, CreatedAt: time.Now(),
+				DocumentDate: documentDate(doc),
 			}
 			if _, err := s.movementRepo.CreateWithTx(tx, mv); err != nil {
 				return err
 			}
 
+			// Исчерпанные партии не удаляем: на них ссылаются движения, и отмена документа должна их восстановить.
 			lot.CurrentQuantity = lot.CurrentQuantity.Sub(qtyFromLot)
-			if lot.CurrentQuantity.IsZero() {
-				lotsToDelete = append(lotsToDelete, lot.ID)
-			} else {
-				if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
-					return err
-				}
+			if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
+				return err
 			}
 			qtyToShip = qtyToShip.Sub(qtyFromLot)
 		}
 
-		if err := s.lotRepo.DeleteWithTx(tx, lotsToDelete); err != nil {
-			return err
-		}
 		updateTotalQuantity(tx, s.balanceRepo, *doc.WarehouseID, it.VariantID, it.Quantity.Neg())
 	}
 	return nil
 }
 
 func (s *FifoQuantityStrategy) RevertIncome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
-	if err := s.revertMovementsAndUpdateBalance(tx, doc); err != nil {
+	if err := revertDocumentMovements(tx, doc, s.movementRepo, s.balanceRepo); err != nil {
 		return err
 	}
 	return s.lotRepo.DeleteByIncomeDocumentID(tx, doc.ID)
 }
 
 func (s *FifoQuantityStrategy) RevertOutcome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
-	moves, err := s.movementRepo.ListByDocumentWithTx(tx, doc.ID)
+	moves, err := s.movementRepo.ListActiveByDocumentWithTx(tx, doc.ID)
 	if err != nil {
 		return err
 	}
@@ -157,34 +155,271 @@ func (s *FifoQuantityStrategy) RevertOutcome(tx *gorm.DB, doc *models.Document,
 		}
 	}
 
-	return s.revertMovementsAndUpdateBalance(tx, doc)
+	return revertDocumentMovements(tx, doc, s.movementRepo, s.balanceRepo)
 }
 
-func updateTotalQuantity(tx *gorm.DB, balanceRepo repository.BalanceRepository, whID, varID uint, qtyChange decimal.Decimal) {
-	bal, _ := balanceRepo.GetBalanceWithTx(tx, whID, varID)
-	if bal == nil {
-		bal = &models.StockBalance{WarehouseID: whID, VariantID: varID, Quantity: decimal.Zero}
+func (s *FifoQuantityStrategy) ProcessTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	for _, it := range doc.Items {
+		lots, err := s.lotRepo.GetLotsForUpdate(tx, *doc.WarehouseID, it.VariantID, s.order)
+		if err != nil {
+			return err
+		}
+
+		totalQtyInLots := decimal.Zero
+		for _, lot := range lots {
+			totalQtyInLots = totalQtyInLots.Add(lot.CurrentQuantity)
+		}
+
+		// Перемещать можно только то, что лежит в партиях: иначе у получателя не будет ни даты прихода, ни себестоимости.
+		if totalQtyInLots.LessThan(it.Quantity) {
+			productName, sku := s.describeVariant(it.VariantID)
+			return fmt.Errorf("Недостаточно товара '%s' (%s) для перемещения. В наличии: %s, Нужно: %s",
+				productName, sku, totalQtyInLots.String(), it.Quantity.String())
+		}
+
+		qtyToMove := it.Quantity
+		for i := range lots {
+			if qtyToMove.IsZero() {
+				break
+			}
+
+			lot := &lots[i]
+			qtyFromLot := decimal.Min(qtyToMove, lot.CurrentQuantity)
+
+			out := &models.StockMovement{
+				DocumentID: &doc.ID, VariantID: lot.VariantID, WarehouseID: lot.WarehouseID,
+				Quantity: qtyFromLot.Neg(), UnitCost: lot.UnitCost, Type: "TRANSFER", SourceLotID: &lot.ID, CreatedAt: time.Now(),
+				DocumentDate: documentDate(doc),
+			}
+			if _, err := s.movementRepo.CreateWithTx(tx, out); err != nil {
+				return err
+			}
+
+			lot.CurrentQuantity = lot.CurrentQuantity.Sub(qtyFromLot)
+			if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
+				return err
+			}
+
+			destLot := &models.StockLot{
+				WarehouseID: *doc.ToWarehouseID, VariantID: lot.VariantID,
+				IncomeDocumentID: doc.ID, ArrivalDate: lot.ArrivalDate, CurrentQuantity: qtyFromLot,
+				UnitCost: lot.UnitCost, BatchNumber: lot.BatchNumber, ProductionDate: lot.ProductionDate, ExpiryDate: lot.ExpiryDate,
+			}
+			if err := s.lotRepo.CreateWithTx(tx, destLot); err != nil {
+				return err
+			}
+
+			in := &models.StockMovement{
+				DocumentID: &doc.ID, VariantID: lot.VariantID, WarehouseID: *doc.ToWarehouseID,
+				Quantity: qtyFromLot, UnitCost: lot.UnitCost, Type: "TRANSFER", SourceLotID: &destLot.ID, CreatedAt: time.Now(),
+				DocumentDate: documentDate(doc),
+			}
+			if _, err := s.movementRepo.CreateWithTx(tx, in); err != nil {
+				return err
+			}
+
+			qtyToMove = qtyToMove.Sub(qtyFromLot)
+		}
+
+		updateTotalQuantity(tx, s.balanceRepo, *doc.WarehouseID, it.VariantID, it.Quantity.Neg())
+		updateTotalQuantity(tx, s.balanceRepo, *doc.ToWarehouseID, it.VariantID, it.Quantity)
 	}
-	bal.Quantity = bal.Quantity.Add(qtyChange)
-	balanceRepo.SaveBalanceWithTx(tx, bal)
+	return nil
+}
+
+func (s *FifoQuantityStrategy) ShipTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	for _, it := range doc.Items {
+		lots, err := s.lotRepo.GetLotsForUpdate(tx, *doc.WarehouseID, it.VariantID, s.order)
+		if err != nil {
+			return err
+		}
+
+		totalQtyInLots := decimal.Zero
+		for _, lot := range lots {
+			totalQtyInLots = totalQtyInLots.Add(lot.CurrentQuantity)
+		}
+		if totalQtyInLots.LessThan(it.Quantity) {
+			productName, sku := s.describeVariant(it.VariantID)
+			return fmt.Errorf("Недостаточно товара '%s' (%s) для отгрузки. В наличии: %s, Нужно: %s",
+				productName, sku, totalQtyInLots.String(), it.Quantity.String())
+		}
+
+		qtyToShip := it.Quantity
+		for i := range lots {
+			if qtyToShip.IsZero() {
+				break
+			}
+
+			lot := &lots[i]
+			qtyFromLot := decimal.Min(qtyToShip, lot.CurrentQuantity)
+
+			out := &models.StockMovement{
+				DocumentID: &doc.ID, VariantID: lot.VariantID, WarehouseID: lot.WarehouseID,
+				Quantity: qtyFromLot.Neg(), UnitCost: lot.UnitCost, Type: "TRANSFER", SourceLotID: &lot.ID, CreatedAt: time.Now(),
+				DocumentDate: documentDate(doc),
+			}
+			if _, err := s.movementRepo.CreateWithTx(tx, out); err != nil {
+				return err
+			}
+
+			lot.CurrentQuantity = lot.CurrentQuantity.Sub(qtyFromLot)
+			if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
+				return err
+			}
+
+			row := &models.StockInTransit{
+				DocumentID: doc.ID, VariantID: lot.VariantID,
+				FromWarehouseID: lot.WarehouseID, ToWarehouseID: *doc.ToWarehouseID,
+				SourceLotID: &lot.ID, ArrivalDate: lot.ArrivalDate, UnitCost: lot.UnitCost,
+				Quantity: qtyFromLot, ShippedAt: time.Now(),
+			}
+			if err := s.transitRepo.CreateWithTx(tx, row); err != nil {
+				return err
+			}
+
+			qtyToShip = qtyToShip.Sub(qtyFromLot)
+		}
+
+		updateTotalQuantity(tx, s.balanceRepo, *doc.WarehouseID, it.VariantID, it.Quantity.Neg())
+	}
+	return nil
 }
 
-func (s *FifoQuantityStrategy) revertMovementsAndUpdateBalance(tx *gorm.DB, doc *models.Document) error {
-	moves, err := s.movementRepo.ListByDocumentWithTx(tx, doc.ID)
+func (s *FifoQuantityStrategy) ReceiveTransfer(tx *gorm.DB, doc *models.Document, received map[uint]decimal.Decimal, cfg *config.Config) error {
+	return receiveTransit(tx, doc, received, s.transitRepo, s.movementRepo,
+		func(row *models.StockInTransit, qty decimal.Decimal) (*uint, error) {
+			lot := &models.StockLot{
+				WarehouseID: row.ToWarehouseID, VariantID: row.VariantID,
+				IncomeDocumentID: doc.ID, ArrivalDate: row.ArrivalDate, CurrentQuantity: qty,
+				UnitCost: row.UnitCost,
+			}
+			if row.SourceLotID != nil {
+				source, err := s.lotRepo.GetLotByIDForUpdate(tx, *row.SourceLotID)
+				if err != nil {
+					return nil, fmt.Errorf("source lot with ID %d not found for transit record %d", *row.SourceLotID, row.ID)
+				}
+				lot.BatchNumber, lot.ProductionDate, lot.ExpiryDate = source.BatchNumber, source.ProductionDate, source.ExpiryDate
+			}
+			if err := s.lotRepo.CreateWithTx(tx, lot); err != nil {
+				return nil, err
+			}
+			updateTotalQuantity(tx, s.balanceRepo, row.ToWarehouseID, row.VariantID, qty)
+			return &lot.ID, nil
+		})
+}
+
+func (s *FifoQuantityStrategy) RevertTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	rows, err := s.transitRepo.ListByDocumentForUpdate(tx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		return s.revertTransit(tx, doc, rows)
+	}
+
+	moves, err := s.movementRepo.ListActiveByDocumentWithTx(tx, doc.ID)
 	if err != nil {
 		return err
 	}
 
+	// Сначала забираем товар из партий получателя: если его уже продали, отменять нечего.
 	for _, mv := range moves {
-		cancel := &models.StockMovement{
-			DocumentID: &doc.ID, VariantID: mv.VariantID, WarehouseID: mv.WarehouseID,
-			Quantity: mv.Quantity.Neg(), Type: "CANCEL", CreatedAt: time.Now(), SourceLotID: mv.SourceLotID,
+		if mv.Type != "TRANSFER" || !mv.Quantity.IsPositive() || mv.SourceLotID == nil {
+			continue
+		}
+		lot, err := s.lotRepo.GetLotByIDForUpdate(tx, *mv.SourceLotID)
+		if err != nil {
+			return fmt.Errorf("destination lot with ID %d not found for movement %d", *mv.SourceLotID, mv.ID)
+		}
+		if lot.CurrentQuantity.LessThan(mv.Quantity) {
+			productName, sku := s.describeVariant(mv.VariantID)
+			return fmt.Errorf("Нельзя отменить перемещение: товар '%s' (%s) уже списан со склада получателя", productName, sku)
 		}
-		if _, err := s.movementRepo.CreateWithTx(tx, cancel); err != nil {
+		lot.CurrentQuantity = lot.CurrentQuantity.Sub(mv.Quantity)
+		if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
 			return err
 		}
+	}
 
-		updateTotalQuantity(tx, s.balanceRepo, mv.WarehouseID, mv.VariantID, mv.Quantity.Neg())
+	for _, mv := range moves {
+		if mv.Type != "TRANSFER" || mv.Quantity.IsPositive() || mv.SourceLotID == nil {
+			continue
+		}
+		lot, err := s.lotRepo.GetLotByIDForUpdate(tx, *mv.SourceLotID)
+		if err != nil {
+			return fmt.Errorf("source lot with ID %d not found for movement %d", *mv.SourceLotID, mv.ID)
+		}
+		lot.CurrentQuantity = lot.CurrentQuantity.Sub(mv.Quantity)
+		if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
+			return err
+		}
 	}
-	return nil
+
+	if err := revertDocumentMovements(tx, doc, s.movementRepo, s.balanceRepo); err != nil {
+		return err
+	}
+	return s.lotRepo.DeleteByIncomeDocumentID(tx, doc.ID)
+}
+
+func (s *FifoQuantityStrategy) revertTransit(tx *gorm.DB, doc *models.Document, rows []models.StockInTransit) error {
+	for _, row := range rows {
+		if row.DestLotID == nil || row.ReceivedQuantity.IsZero() {
+			continue
+		}
+		lot, err := s.lotRepo.GetLotByIDForUpdate(tx, *row.DestLotID)
+		if err != nil {
+			return fmt.Errorf("destination lot with ID %d not found for transit record %d", *row.DestLotID, row.ID)
+		}
+		if lot.CurrentQuantity.LessThan(row.ReceivedQuantity) {
+			productName, sku := s.describeVariant(row.VariantID)
+			return fmt.Errorf("Нельзя отменить перемещение: товар '%s' (%s) уже списан со склада получателя", productName, sku)
+		}
+		lot.CurrentQuantity = lot.CurrentQuantity.Sub(row.ReceivedQuantity)
+		if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range rows {
+		if row.SourceLotID == nil {
+			continue
+		}
+		lot, err := s.lotRepo.GetLotByIDForUpdate(tx, *row.SourceLotID)
+		if err != nil {
+			return fmt.Errorf("source lot with ID %d not found for transit record %d", *row.SourceLotID, row.ID)
+		}
+		lot.CurrentQuantity = lot.CurrentQuantity.Add(row.Quantity)
+		if err := s.lotRepo.SaveWithTx(tx, lot); err != nil {
+			return err
+		}
+	}
+
+	if err := revertDocumentMovements(tx, doc, s.movementRepo, s.balanceRepo); err != nil {
+		return err
+	}
+	if err := s.lotRepo.DeleteByIncomeDocumentID(tx, doc.ID); err != nil {
+		return err
+	}
+	return s.transitRepo.DeleteByDocumentWithTx(tx, doc.ID)
+}
+
+func (s *FifoQuantityStrategy) describeVariant(variantID uint) (string, string) {
+	productName := "Unknown"
+	sku := "?"
+	if variant, _ := s.variantRepo.GetByID(variantID); variant != nil {
+		sku = variant.SKU
+		if p, _ := s.productRepo.GetByID(variant.ProductID); p != nil {
+			productName = p.Name
+		}
+	}
+	return productName, sku
+}
+
+func updateTotalQuantity(tx *gorm.DB, balanceRepo repository.BalanceRepository, whID, varID uint, qtyChange decimal.Decimal) {
+	bal, _ := balanceRepo.GetBalanceWithTx(tx, whID, varID)
+	if bal == nil {
+		bal = &models.StockBalance{WarehouseID: whID, VariantID: varID, Quantity: decimal.Zero}
+	}
+	bal.Quantity = bal.Quantity.Add(qtyChange)
+	balanceRepo.SaveBalanceWithTx(tx, bal)
 }
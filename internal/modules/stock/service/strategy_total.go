@@ -15,10 +15,11 @@ import (
 type TotalQuantityStrategy struct {
 	balanceRepo  repository.BalanceRepository
 	movementRepo repository.StockMovementRepository
+	transitRepo  repository.TransitRepository
 }
 
-func NewTotalQuantityStrategy(b repository.BalanceRepository, m repository.StockMovementRepository) QuantityStrategy {
-	return &TotalQuantityStrategy{balanceRepo: b, movementRepo: m}
+func NewTotalQuantityStrategy(b repository.BalanceRepository, m repository.StockMovementRepository, t repository.TransitRepository) QuantityStrategy {
+	return &TotalQuantityStrategy{balanceRepo: b, movementRepo: m, transitRepo: t}
 }
 
 func (s *TotalQuantityStrategy) ProcessIncome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
@@ -28,7 +29,8 @@ func (s *TotalQuantityStrategy) ProcessIncome(tx *gorm.DB, doc *models.Document,
 	for _, it := range doc.Items {
 		mv := &models.StockMovement{
 			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.WarehouseID,
-			Quantity: it.Quantity, Type: "INCOME", CreatedAt: time.Now(),
+			Quantity: it.Quantity, Type: movementType(doc, "INCOME"), CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
 		}
 		if _, err := s.movementRepo.CreateWithTx(tx, mv); err != nil {
 			return err
@@ -70,7 +72,8 @@ func (s *TotalQuantityStrategy) ProcessOutcome(tx *gorm.DB, doc *models.Document
 
 		mv := &models.StockMovement{
 			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.WarehouseID,
-			Quantity: it.Quantity.Neg(), Type: "OUTCOME", CreatedAt: time.Now(),
+			Quantity: it.Quantity.Neg(), Type: movementType(doc, "OUTCOME"), CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
 		}
 		if _, err := s.movementRepo.CreateWithTx(tx, mv); err != nil {
 			return err
@@ -87,6 +90,145 @@ func (s *TotalQuantityStrategy) ProcessOutcome(tx *gorm.DB, doc *models.Document
 	return nil
 }
 
+func (s *TotalQuantityStrategy) ProcessTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	for _, it := range doc.Items {
+		bal, err := s.balanceRepo.GetBalanceWithTx(tx, *doc.WarehouseID, it.VariantID)
+		if err != nil {
+			return err
+		}
+
+		currentQty := decimal.Zero
+		if bal != nil {
+			currentQty = bal.Quantity
+		}
+		if !cfg.AllowNegativeStock && currentQty.LessThan(it.Quantity) {
+			return fmt.Errorf("not enough stock for variant %d: have=%s, need=%s", it.VariantID, currentQty.String(), it.Quantity.String())
+		}
+
+		out := &models.StockMovement{
+			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.WarehouseID,
+			Quantity: it.Quantity.Neg(), Type: "TRANSFER", CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
+		}
+		if _, err := s.movementRepo.CreateWithTx(tx, out); err != nil {
+			return err
+		}
+		in := &models.StockMovement{
+			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.ToWarehouseID,
+			Quantity: it.Quantity, Type: "TRANSFER", CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
+		}
+		if _, err := s.movementRepo.CreateWithTx(tx, in); err != nil {
+			return err
+		}
+
+		updateTotalQuantity(tx, s.balanceRepo, *doc.WarehouseID, it.VariantID, it.Quantity.Neg())
+		updateTotalQuantity(tx, s.balanceRepo, *doc.ToWarehouseID, it.VariantID, it.Quantity)
+	}
+	return nil
+}
+
+func (s *TotalQuantityStrategy) ShipTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	for _, it := range doc.Items {
+		bal, err := s.balanceRepo.GetBalanceWithTx(tx, *doc.WarehouseID, it.VariantID)
+		if err != nil {
+			return err
+		}
+
+		currentQty := decimal.Zero
+		if bal != nil {
+			currentQty = bal.Quantity
+		}
+		if !cfg.AllowNegativeStock && currentQty.LessThan(it.Quantity) {
+			return fmt.Errorf("not enough stock for variant %d: have=%s, need=%s", it.VariantID, currentQty.String(), it.Quantity.String())
+		}
+
+		out := &models.StockMovement{
+			DocumentID: &doc.ID, VariantID: it.VariantID, WarehouseID: *doc.WarehouseID,
+			Quantity: it.Quantity.Neg(), Type: "TRANSFER", CreatedAt: time.Now(),
+			DocumentDate: documentDate(doc),
+		}
+		if _, err := s.movementRepo.CreateWithTx(tx, out); err != nil {
+			return err
+		}
+
+		// политика total не ведёт себестоимость, поэтому UnitCost в пути остаётся нулевым, как и у её движений
+		row := &models.StockInTransit{
+			DocumentID: doc.ID, VariantID: it.VariantID,
+			FromWarehouseID: *doc.WarehouseID, ToWarehouseID: *doc.ToWarehouseID,
+			ArrivalDate: time.Now(), Quantity: it.Quantity, ShippedAt: time.Now(),
+		}
+		if err := s.transitRepo.CreateWithTx(tx, row); err != nil {
+			return err
+		}
+
+		updateTotalQuantity(tx, s.balanceRepo, *doc.WarehouseID, it.VariantID, it.Quantity.Neg())
+	}
+	return nil
+}
+
+func (s *TotalQuantityStrategy) ReceiveTransfer(tx *gorm.DB, doc *models.Document, received map[uint]decimal.Decimal, cfg *config.Config) error {
+	return receiveTransit(tx, doc, received, s.transitRepo, s.movementRepo,
+		func(row *models.StockInTransit, qty decimal.Decimal) (*uint, error) {
+			updateTotalQuantity(tx, s.balanceRepo, row.ToWarehouseID, row.VariantID, qty)
+			return nil, nil
+		})
+}
+
+func (s *TotalQuantityStrategy) RevertTransfer(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
+	rows, err := s.transitRepo.ListByDocumentForUpdate(tx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		for _, row := range rows {
+			if row.ReceivedQuantity.IsZero() || cfg.AllowNegativeStock {
+				continue
+			}
+			bal, err := s.balanceRepo.GetBalanceWithTx(tx, row.ToWarehouseID, row.VariantID)
+			if err != nil {
+				return err
+			}
+			if bal == nil || bal.Quantity.LessThan(row.ReceivedQuantity) {
+				return fmt.Errorf("cannot cancel transfer: goods of variant %d have already left destination warehouse %d", row.VariantID, row.ToWarehouseID)
+			}
+		}
+		if err := revertDocumentMovements(tx, doc, s.movementRepo, s.balanceRepo); err != nil {
+			return err
+		}
+		return s.transitRepo.DeleteByDocumentWithTx(tx, doc.ID)
+	}
+
+	moves, err := s.movementRepo.ListActiveByDocumentWithTx(tx, doc.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, mv := range moves {
+		if mv.Type != "TRANSFER" || !mv.Quantity.IsPositive() || cfg.AllowNegativeStock {
+			continue
+		}
+		bal, err := s.balanceRepo.GetBalanceWithTx(tx, mv.WarehouseID, mv.VariantID)
+		if err != nil {
+			return err
+		}
+		if bal == nil || bal.Quantity.LessThan(mv.Quantity) {
+			return fmt.Errorf("cannot cancel transfer: goods of variant %d have already left destination warehouse %d", mv.VariantID, mv.WarehouseID)
+		}
+	}
+
+	for _, mv := range moves {
+		if mv.Type != "TRANSFER" {
+			continue
+		}
+		if err := reverseMovement(tx, doc, mv, s.movementRepo); err != nil {
+			return err
+		}
+		updateTotalQuantity(tx, s.balanceRepo, mv.WarehouseID, mv.VariantID, mv.Quantity.Neg())
+	}
+	return nil
+}
+
 func (s *TotalQuantityStrategy) RevertIncome(tx *gorm.DB, doc *models.Document, cfg *config.Config) error {
 	// ... (здесь логика отмены, которая работает с StockBalance)
 	return nil
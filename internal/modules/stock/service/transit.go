@@ -0,0 +1,150 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// splitReceipt распределяет фактически принятое количество по строкам транзита (старые партии первыми).
+// received == nil означает, что всё отгруженное принято полностью.
+func splitReceipt(rows []models.StockInTransit, received map[uint]decimal.Decimal) ([]decimal.Decimal, error) {
+	shipped := make(map[uint]decimal.Decimal)
+	for _, row := range rows {
+		shipped[row.VariantID] = shipped[row.VariantID].Add(row.Quantity)
+	}
+
+	remaining := make(map[uint]decimal.Decimal, len(shipped))
+	for variantID, qty := range shipped {
+		remaining[variantID] = qty
+	}
+	if received != nil {
+		for variantID, qty := range received {
+			shippedQty, ok := shipped[variantID]
+			if !ok {
+				return nil, fmt.Errorf("variant %d was not shipped with this transfer", variantID)
+			}
+			if qty.IsNegative() {
+				return nil, fmt.Errorf("received quantity for variant %d cannot be negative", variantID)
+			}
+			if qty.GreaterThan(shippedQty) {
+				return nil, fmt.Errorf("received quantity for variant %d exceeds shipped: shipped=%s, received=%s",
+					variantID, shippedQty.String(), qty.String())
+			}
+		}
+		for variantID := range remaining {
+			remaining[variantID] = received[variantID]
+		}
+	}
+
+	parts := make([]decimal.Decimal, len(rows))
+	for i, row := range rows {
+		take := decimal.Min(remaining[row.VariantID], row.Quantity)
+		parts[i] = take
+		remaining[row.VariantID] = remaining[row.VariantID].Sub(take)
+	}
+	return parts, nil
+}
+
+// receiveTransit приходует товар из транзита на склад-получатель. Недостача фиксируется движением LOSS.
+// credit вызывается для каждой строки с ненулевым принятым количеством: обновляет остаток получателя
+// и, если стратегия ведёт партии, возвращает ID созданной партии.
+func receiveTransit(
+	tx *gorm.DB, doc *models.Document, received map[uint]decimal.Decimal,
+	transitRepo repository.TransitRepository, movementRepo repository.StockMovementRepository,
+	credit func(row *models.StockInTransit, qty decimal.Decimal) (*uint, error),
+) error {
+	rows, err := transitRepo.ListByDocumentForUpdate(tx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return errors.New("nothing is in transit for this document")
+	}
+
+	parts, err := splitReceipt(rows, received)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range rows {
+		row := &rows[i]
+		if row.ReceivedAt != nil {
+			return errors.New("transfer has already been received")
+		}
+
+		take := parts[i]
+		lost := row.Quantity.Sub(take)
+
+		if take.IsPositive() {
+			lotID, err := credit(row, take)
+			if err != nil {
+				return err
+			}
+			row.DestLotID = lotID
+		}
+
+		in := &models.StockMovement{
+			DocumentID: &doc.ID, VariantID: row.VariantID, WarehouseID: row.ToWarehouseID,
+			Quantity: row.Quantity, UnitCost: row.UnitCost, Type: "TRANSFER", SourceLotID: row.DestLotID, CreatedAt: now,
+			DocumentDate: documentDate(doc),
+		}
+		if _, err := movementRepo.CreateWithTx(tx, in); err != nil {
+			return err
+		}
+		if lost.IsPositive() {
+			loss := &models.StockMovement{
+				DocumentID: &doc.ID, VariantID: row.VariantID, WarehouseID: row.ToWarehouseID,
+				Quantity: lost.Neg(), UnitCost: row.UnitCost, Type: "LOSS", SourceLotID: row.SourceLotID, CreatedAt: now,
+				DocumentDate: documentDate(doc),
+				Comment:      "Недостача при приёмке перемещения",
+			}
+			if _, err := movementRepo.CreateWithTx(tx, loss); err != nil {
+				return err
+			}
+		}
+
+		row.ReceivedQuantity = take
+		row.ReceivedAt = &now
+		if err := transitRepo.SaveWithTx(tx, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revertDocumentMovements сторнирует все движения документа и возвращает остатки в исходное состояние.
+func revertDocumentMovements(tx *gorm.DB, doc *models.Document, movementRepo repository.StockMovementRepository, balanceRepo repository.BalanceRepository) error {
+	moves, err := movementRepo.ListActiveByDocumentWithTx(tx, doc.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, mv := range moves {
+		if err := reverseMovement(tx, doc, mv, movementRepo); err != nil {
+			return err
+		}
+		updateTotalQuantity(tx, balanceRepo, mv.WarehouseID, mv.VariantID, mv.Quantity.Neg())
+	}
+	return nil
+}
+
+// reverseMovement записывает сторно движения и помечает движение сторнированным: исправленный документ хранит
+// и старые движения, и их сторно, а повторная отмена должна трогать только действующие.
+func reverseMovement(tx *gorm.DB, doc *models.Document, mv models.StockMovement, movementRepo repository.StockMovementRepository) error {
+	cancel := &models.StockMovement{
+		DocumentID: &doc.ID, VariantID: mv.VariantID, WarehouseID: mv.WarehouseID,
+		Quantity: mv.Quantity.Neg(), UnitCost: mv.UnitCost, Type: "CANCEL", CreatedAt: time.Now(), SourceLotID: mv.SourceLotID,
+		DocumentDate: documentDate(doc),
+	}
+	if _, err := movementRepo.CreateWithTx(tx, cancel); err != nil {
+		return err
+	}
+	return movementRepo.MarkReversedWithTx(tx, mv.ID, cancel.ID)
+}
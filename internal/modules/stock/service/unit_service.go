@@ -1,26 +1,173 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
 	stock "github.com/maksroxx/flowkeeper/internal/modules/stock/models"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
 )
 
+// DefaultUnitPrecision - точность единицы, если она не задана: столько знаков хранят колонки количества.
+const DefaultUnitPrecision = 4
+
 type UnitService interface {
-	Create(name string) (*stock.Unit, error)
+	Create(u *stock.Unit) (*stock.Unit, error)
 	GetByID(id uint) (*stock.Unit, error)
 	List() ([]stock.Unit, error)
 	Update(u *stock.Unit) (*stock.Unit, error)
 	Delete(id uint) error
+
+	// ListVariantUnits и SetVariantUnits ведут дополнительные единицы варианта с коэффициентами пересчёта.
+	ListVariantUnits(variantID uint) ([]stock.VariantUnitDTO, error)
+	SetVariantUnits(variantID uint, units []stock.VariantUnit) ([]stock.VariantUnitDTO, error)
+	// NormalizeItems пересчитывает строки, введённые в дополнительных единицах, в базовую
+	// и проверяет точность количеств по единицам.
+	NormalizeItems(items []stock.DocumentItem) error
+	UnitNames(ids []uint) map[uint]string
+}
+
+type unitService struct {
+	repo        repository.UnitRepository
+	variantUnit repository.VariantUnitRepository
+	variantRepo repository.VariantRepository
+}
+
+func NewUnitService(r repository.UnitRepository, vu repository.VariantUnitRepository, v repository.VariantRepository) UnitService {
+	return &unitService{repo: r, variantUnit: vu, variantRepo: v}
+}
+
+func (s *unitService) Create(u *stock.Unit) (*stock.Unit, error) {
+	if err := validatePrecision(u.Precision); err != nil {
+		return nil, err
+	}
+	return s.repo.Create(u)
+}
+func (s *unitService) GetByID(id uint) (*stock.Unit, error) { return s.repo.GetByID(id) }
+func (s *unitService) List() ([]stock.Unit, error)          { return s.repo.List() }
+func (s *unitService) Update(u *stock.Unit) (*stock.Unit, error) {
+	if err := validatePrecision(u.Precision); err != nil {
+		return nil, err
+	}
+	return s.repo.Update(u)
+}
+func (s *unitService) Delete(id uint) error { return s.repo.Delete(id) }
+
+func (s *unitService) ListVariantUnits(variantID uint) ([]stock.VariantUnitDTO, error) {
+	units, err := s.variantUnit.ListByVariant(variantID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(units))
+	for i, u := range units {
+		ids[i] = u.UnitID
+	}
+	names := s.UnitNames(ids)
+
+	result := make([]stock.VariantUnitDTO, len(units))
+	for i, u := range units {
+		result[i] = stock.VariantUnitDTO{UnitID: u.UnitID, UnitName: names[u.UnitID], Factor: u.Factor}
+	}
+	return result, nil
+}
+
+func (s *unitService) SetVariantUnits(variantID uint, units []stock.VariantUnit) ([]stock.VariantUnitDTO, error) {
+	variant, err := s.variantRepo.GetByID(variantID)
+	if err != nil || variant == nil {
+		return nil, fmt.Errorf("variant %d not found", variantID)
+	}
+
+	seen := make(map[uint]bool)
+	for i := range units {
+		u := &units[i]
+		u.ID, u.VariantID = 0, variantID
+		if !u.Factor.IsPositive() {
+			return nil, fmt.Errorf("unit %d: factor must be positive", u.UnitID)
+		}
+		if u.UnitID == variant.UnitID {
+			return nil, errors.New("base unit of the variant cannot be added as an alternative")
+		}
+		if seen[u.UnitID] {
+			return nil, fmt.Errorf("unit %d is listed twice", u.UnitID)
+		}
+		seen[u.UnitID] = true
+		if unit, err := s.repo.GetByID(u.UnitID); err != nil || unit == nil {
+			return nil, fmt.Errorf("unit %d not found", u.UnitID)
+		}
+	}
+
+	if err := s.variantUnit.ReplaceForVariant(variantID, units); err != nil {
+		return nil, err
+	}
+	return s.ListVariantUnits(variantID)
 }
 
-type unitService struct{ repo repository.UnitRepository }
+func (s *unitService) NormalizeItems(items []stock.DocumentItem) error {
+	for i := range items {
+		item := &items[i]
+		variant, err := s.variantRepo.GetByID(item.VariantID)
+		if err != nil || variant == nil {
+			continue // несуществующий вариант отклонит проведение
+		}
 
-func NewUnitService(r repository.UnitRepository) UnitService { return &unitService{repo: r} }
+		if item.UnitID != nil && *item.UnitID != variant.UnitID {
+			alt, err := s.variantUnit.Get(variant.ID, *item.UnitID)
+			if err != nil {
+				return err
+			}
+			if alt == nil {
+				return fmt.Errorf("unit %d is not configured for variant %s", *item.UnitID, variant.SKU)
+			}
+			if item.UnitQuantity == nil {
+				return fmt.Errorf("variant %s: unit_quantity is required when unit_id is set", variant.SKU)
+			}
+			if err := s.checkPrecision(*item.UnitID, *item.UnitQuantity, variant.SKU); err != nil {
+				return err
+			}
+			item.Quantity = item.UnitQuantity.Mul(alt.Factor)
+		} else {
+			item.UnitID, item.UnitQuantity = nil, nil
+		}
+
+		if variant.UnitID != 0 {
+			if err := s.checkPrecision(variant.UnitID, item.Quantity, variant.SKU); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *unitService) UnitNames(ids []uint) map[uint]string {
+	names := make(map[uint]string, len(ids))
+	units, _ := s.repo.GetByIDs(ids)
+	for _, u := range units {
+		names[u.ID] = u.Name
+	}
+	return names
+}
+
+func (s *unitService) checkPrecision(unitID uint, qty decimal.Decimal, sku string) error {
+	unit, err := s.repo.GetByID(unitID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && unit == nil) {
+		// единица удалена из справочника - ограничения точности нет
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !qty.Equal(qty.Truncate(int32(unit.Precision))) {
+		return fmt.Errorf("variant %s: quantity %s is not allowed for unit %q (at most %d decimal places)", sku, qty.String(), unit.Name, unit.Precision)
+	}
+	return nil
+}
 
-func (s *unitService) Create(name string) (*stock.Unit, error) {
-	return s.repo.Create(&stock.Unit{Name: name})
+func validatePrecision(p int) error {
+	if p < 0 || p > DefaultUnitPrecision {
+		return fmt.Errorf("precision must be between 0 and %d", DefaultUnitPrecision)
+	}
+	return nil
 }
-func (s *unitService) GetByID(id uint) (*stock.Unit, error)      { return s.repo.GetByID(id) }
-func (s *unitService) List() ([]stock.Unit, error)               { return s.repo.List() }
-func (s *unitService) Update(u *stock.Unit) (*stock.Unit, error) { return s.repo.Update(u) }
-func (s *unitService) Delete(id uint) error                      { return s.repo.Delete(id) }
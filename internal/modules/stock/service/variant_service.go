@@ -22,14 +22,16 @@ type variantService struct {
 	repo        repository.VariantRepository
 	productRepo repository.ProductRepository
 	unitRepo    repository.UnitRepository
+	barcodeRepo repository.BarcodeRepository
 }
 
 func NewVariantService(
 	repo repository.VariantRepository,
 	productRepo repository.ProductRepository,
 	unitRepo repository.UnitRepository,
+	barcodeRepo repository.BarcodeRepository,
 ) VariantService {
-	return &variantService{repo: repo, productRepo: productRepo, unitRepo: unitRepo}
+	return &variantService{repo: repo, productRepo: productRepo, unitRepo: unitRepo, barcodeRepo: barcodeRepo}
 }
 
 func (s *variantService) Create(v *models.Variant, images []string) (*models.Variant, error) {
@@ -110,6 +112,7 @@ func (s *variantService) buildDTO(variant *models.Variant) (*models.VariantDTO,
 		SKU:             variant.SKU,
 		Characteristics: variant.Characteristics,
 		UnitID:          variant.UnitID,
+		TrackSerials:    variant.TrackSerials,
 	}
 
 	for _, img := range variant.Images {
@@ -122,6 +125,9 @@ func (s *variantService) buildDTO(variant *models.Variant) (*models.VariantDTO,
 	if unit, err := s.unitRepo.GetByID(variant.UnitID); err == nil && unit != nil {
 		dto.UnitName = unit.Name
 	}
+	if codes, err := s.barcodeRepo.ListByVariant(variant.ID); err == nil {
+		dto.Barcodes = codes
+	}
 
 	return dto, nil
 }
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	stock "github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+)
+
+type WriteOffReasonService interface {
+	Create(r *stock.WriteOffReason) (*stock.WriteOffReason, error)
+	List() ([]stock.WriteOffReason, error)
+	Update(r *stock.WriteOffReason) (*stock.WriteOffReason, error)
+	Delete(id uint) error
+}
+
+type writeOffReasonService struct {
+	repo repository.WriteOffReasonRepository
+}
+
+func NewWriteOffReasonService(r repository.WriteOffReasonRepository) WriteOffReasonService {
+	return &writeOffReasonService{repo: r}
+}
+
+func (s *writeOffReasonService) Create(r *stock.WriteOffReason) (*stock.WriteOffReason, error) {
+	if err := normalizeWriteOffReason(r); err != nil {
+		return nil, err
+	}
+	r.ID = 0
+	return s.repo.Create(r)
+}
+
+func (s *writeOffReasonService) List() ([]stock.WriteOffReason, error) { return s.repo.List() }
+
+func (s *writeOffReasonService) Update(r *stock.WriteOffReason) (*stock.WriteOffReason, error) {
+	if _, err := s.repo.GetByID(r.ID); err != nil {
+		return nil, err
+	}
+	if err := normalizeWriteOffReason(r); err != nil {
+		return nil, err
+	}
+	return s.repo.Update(r)
+}
+
+func (s *writeOffReasonService) Delete(id uint) error { return s.repo.Delete(id) }
+
+func normalizeWriteOffReason(r *stock.WriteOffReason) error {
+	r.Code = strings.ToUpper(strings.TrimSpace(r.Code))
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Code == "" || r.Name == "" {
+		return errors.New("code and name are required")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestDocumentApproval_Integration(t *testing.T) {
+	router, db := setupTestRouter("approval_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	admin := NewTestHelper(t, router)
+
+	wh := admin.CreateWarehouse("Основной")
+	variant := admin.CreateVariant(gin.H{"product_id": 1, "sku": "APPR-1"})
+	income := admin.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(100), Price: decimalPtr(decimal.NewFromInt(500))}},
+	})
+	admin.PostDocument(income.ID)
+
+	w := admin.PerformRequest("POST", "/api/v1/stock/approval-rules", gin.H{"document_type": "outcome", "min_amount": "100000"})
+	admin.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+
+	worker := admin.AsUser(2, "worker", "view_stock", "create_document", "post_document")
+	manager := admin.AsUser(3, "manager", "view_stock", "create_document", "approve_document", "post_document")
+	docPath := func(id uint, action string) string { return fmt.Sprintf("/api/v1/stock/documents/%d/%s", id, action) }
+
+	outcome := func(qty int64) models.Document {
+		return models.Document{
+			Type: "OUTCOME", WarehouseID: &wh.ID,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty), Price: decimalPtr(decimal.NewFromInt(2000))}},
+		}
+	}
+
+	// 1. Крупный расход нельзя провести без согласования
+	big := worker.CreateDocument(outcome(60))
+	w = worker.PerformRequestWithHeaders("POST", docPath(big.ID, "post"), nil, admin.IfMatch(big.ID))
+	admin.Assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+
+	// 2. Статус нельзя выставить при создании в обход согласования
+	forged := outcome(60)
+	forged.Status = "approved"
+	admin.Assert.Equal("draft", worker.CreateDocument(forged).Status)
+
+	// 3. Согласовать может только пользователь с approve_document
+	w = worker.PerformRequest("POST", docPath(big.ID, "submit"), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = worker.PerformRequest("POST", docPath(big.ID, "approve"), nil)
+	admin.Assert.Equal(http.StatusForbidden, w.Code)
+	w = worker.PerformRequestWithHeaders("POST", docPath(big.ID, "post"), nil, admin.IfMatch(big.ID))
+	admin.Assert.Equal(http.StatusInternalServerError, w.Code, "документ на согласовании не проводится")
+
+	// 4. Отклонение требует причину, отклонённый документ правится и отправляется заново
+	w = manager.PerformRequest("POST", docPath(big.ID, "reject"), gin.H{})
+	admin.Assert.Equal(http.StatusBadRequest, w.Code)
+	w = manager.PerformRequest("POST", docPath(big.ID, "reject"), gin.H{"reason": "слишком большая партия"})
+	admin.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	rejected := admin.GetDocument(big.ID)
+	admin.Assert.Equal("rejected", rejected.Status)
+	admin.Assert.Equal("слишком большая партия", rejected.RejectReason)
+
+	worker.UpdateDocument(big.ID, models.DocumentUpdateDTO{
+		WarehouseID: &wh.ID,
+		Items:       []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(55), Price: decimalPtr(decimal.NewFromInt(2000))}},
+	})
+	edited := admin.GetDocument(big.ID)
+	admin.Assert.Equal("draft", edited.Status)
+	admin.Assert.Empty(edited.RejectReason)
+
+	w = worker.PerformRequest("POST", docPath(big.ID, "submit"), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code)
+	w = manager.PerformRequest("POST", docPath(big.ID, "approve"), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	approved := admin.GetDocument(big.ID)
+	admin.Assert.Equal("approved", approved.Status)
+	admin.Assert.NotNil(approved.ApprovedBy)
+	admin.Assert.Equal(uint(3), *approved.ApprovedBy)
+	admin.Assert.NotNil(approved.ApprovedAt)
+
+	worker.PostDocument(big.ID)
+	admin.Assert.Equal("posted", admin.GetDocument(big.ID).Status)
+
+	// 5. История хранит, кто и что делал
+	w = admin.PerformRequest("GET", docPath(big.ID, "history"), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code)
+	var history []models.DocumentHistory
+	admin.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &history))
+
+	type step struct {
+		action string
+		by     uint
+	}
+	var steps []step
+	for _, h := range history {
+		admin.Assert.NotNil(h.CreatedBy, h.Action)
+		steps = append(steps, step{h.Action, *h.CreatedBy})
+		if h.Action == "rejected" {
+			admin.Assert.Equal("слишком большая партия", h.Comment)
+		}
+	}
+	admin.Assert.Equal([]step{
+		{"submitted", 2}, {"rejected", 3}, {"submitted", 2}, {"approved", 3}, {"posted", 2},
+	}, steps)
+
+	// 6. Документ ниже порога проводится сразу
+	small := worker.CreateDocument(outcome(10))
+	worker.PostDocument(small.ID)
+
+	// 7. Отключённое правило не действует
+	var rules []models.ApprovalRule
+	w = admin.PerformRequest("GET", "/api/v1/stock/approval-rules", nil)
+	admin.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &rules))
+	admin.Assert.Len(rules, 1)
+	w = admin.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/approval-rules/%d", rules[0].ID),
+		gin.H{"document_type": "OUTCOME", "min_amount": "100000", "active": false})
+	admin.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	unrestricted := worker.CreateDocument(outcome(30))
+	worker.PostDocument(unrestricted.ID)
+
+	// 8. Правило срабатывает строго выше порога и может требовать отдельного права согласующего
+	w = admin.PerformRequest("POST", "/api/v1/stock/approval-rules",
+		gin.H{"document_type": "OUTCOME", "min_amount": "4000", "approver_permission": "approve_large"})
+	admin.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	atLimit := worker.CreateDocument(outcome(2))
+	worker.PostDocument(atLimit.ID)
+
+	large := worker.CreateDocument(outcome(3))
+	w = worker.PerformRequest("POST", docPath(large.ID, "submit"), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = manager.PerformRequest("POST", docPath(large.ID, "approve"), nil)
+	admin.Assert.Equal(http.StatusForbidden, w.Code, "approve_document alone is not enough")
+	director := admin.AsUser(4, "director", "view_stock", "create_document", "approve_document", "approve_large", "post_document")
+	w = director.PerformRequest("POST", docPath(large.ID, "approve"), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	// 9. Автор не согласует собственный документ
+	own := director.CreateDocument(outcome(3))
+	w = director.PerformRequest("POST", docPath(own.ID, "submit"), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = director.PerformRequest("POST", docPath(own.ID, "approve"), nil)
+	admin.Assert.Equal(http.StatusForbidden, w.Code, w.Body.String())
+	admin.Assert.Equal("submitted", admin.GetDocument(own.ID).Status)
+}
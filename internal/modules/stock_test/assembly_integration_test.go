@@ -0,0 +1,150 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestAssembly_Integration(t *testing.T) {
+	router, db := setupTestRouter("assembly_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	category := h.CreateCategory("Подарки")
+	product := h.CreateProduct(gin.H{"name": "Подарочный набор", "category_id": category.ID})
+	kit := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "KIT-1"})
+	tea := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "KIT-TEA"})
+	cup := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "KIT-CUP"})
+
+	setBOM := func(kitID uint, components []gin.H) *httptest.ResponseRecorder {
+		return h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/variants/%d/bom", kitID), gin.H{"components": components})
+	}
+	stockOf := func(variantID uint) models.VariantStockDTO {
+		w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/variants/%d/stock", variantID), nil)
+		h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+		var rows []models.VariantStockDTO
+		h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &rows))
+		h.Assert.Len(rows, 1)
+		return rows[0]
+	}
+	balance := func(variantID uint) decimal.Decimal {
+		return findBalance(h.GetBalances(wh.ID), variantID).Quantity
+	}
+	lotCosts := func(docID, variantID uint) []decimal.Decimal {
+		var lots []models.StockLot
+		h.Assert.NoError(db.Where("income_document_id = ? AND variant_id = ?", docID, variantID).Order("id").Find(&lots).Error)
+		costs := make([]decimal.Decimal, len(lots))
+		for i, lot := range lots {
+			costs[i] = lot.UnitCost
+		}
+		return costs
+	}
+
+	// 1. Спецификация: 2 пачки чая и кружка на набор; комплект не может входить сам в себя
+	resp := setBOM(kit.ID, []gin.H{{"component_variant_id": tea.ID, "quantity": 2}, {"component_variant_id": cup.ID, "quantity": 1}})
+	h.Assert.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	resp = setBOM(tea.ID, []gin.H{{"component_variant_id": kit.ID, "quantity": 1}})
+	h.Assert.Equal(http.StatusBadRequest, resp.Code, resp.Body.String())
+	h.Assert.Contains(resp.Body.String(), "kit cannot contain itself")
+
+	w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/variants/%d/bom", kit.ID), nil)
+	var bom []models.BOMComponentDTO
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &bom))
+	h.Assert.Len(bom, 2)
+	h.Assert.Equal("KIT-TEA", bom[0].SKU)
+
+	for _, in := range []struct {
+		variant    uint
+		qty, price int64
+	}{{tea.ID, 4, 100}, {tea.ID, 4, 120}, {cup.ID, 5, 300}} {
+		doc := h.CreateDocument(models.Document{
+			Type: "INCOME", WarehouseID: &wh.ID,
+			Items: []models.DocumentItem{{VariantID: in.variant, Quantity: decimal.NewFromInt(in.qty), Price: decimalPtr(decimal.NewFromInt(in.price))}},
+		})
+		h.PostDocument(doc.ID)
+	}
+
+	// 2. Из 8 пачек чая и 5 кружек собирается 4 набора
+	kitStock := stockOf(kit.ID)
+	h.Assert.NotNil(kitStock.Buildable)
+	h.Assert.True(decimal.NewFromInt(4).Equal(*kitStock.Buildable), kitStock.Buildable.String())
+	h.Assert.Nil(stockOf(tea.ID).Buildable)
+
+	// 3. Сборка 3 наборов: чай по FIFO 4x100 + 2x120, кружки 3x300 - набор стоит (640+900)/3
+	assembly := h.CreateDocument(models.Document{
+		Type: "ASSEMBLY", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: kit.ID, Quantity: decimal.NewFromInt(3)}},
+	})
+	h.Assert.True(strings.HasPrefix(h.GetDocument(assembly.ID).Number, "СБ"))
+	h.PostDocument(assembly.ID)
+
+	h.Assert.True(decimal.NewFromInt(3).Equal(balance(kit.ID)))
+	h.Assert.True(decimal.NewFromInt(2).Equal(balance(tea.ID)))
+	h.Assert.True(decimal.NewFromInt(2).Equal(balance(cup.ID)))
+	costs := lotCosts(assembly.ID, kit.ID)
+	h.Assert.Len(costs, 1)
+	h.Assert.True(decimal.RequireFromString("513.3333").Equal(costs[0].Round(4)), costs[0].String())
+	h.Assert.True(decimal.NewFromInt(1).Equal(*stockOf(kit.ID).Buildable))
+
+	// 4. Компонентов не хватает - сборка не проводится и ничего не списывает
+	tooMany := h.CreateDocument(models.Document{
+		Type: "ASSEMBLY", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: kit.ID, Quantity: decimal.NewFromInt(2)}},
+	})
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", tooMany.ID), nil, h.IfMatch(tooMany.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code)
+	h.Assert.True(decimal.NewFromInt(2).Equal(balance(tea.ID)))
+
+	// 5. Разборка набора: компоненты возвращаются, себестоимость делится по последним оценкам (чай 120, кружка 300)
+	disassembly := h.CreateDocument(models.Document{
+		Type: "DISASSEMBLY", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: kit.ID, Quantity: decimal.NewFromInt(1)}},
+	})
+	h.PostDocument(disassembly.ID)
+	h.Assert.True(decimal.NewFromInt(2).Equal(balance(kit.ID)))
+	h.Assert.True(decimal.NewFromInt(4).Equal(balance(tea.ID)))
+	h.Assert.True(decimal.NewFromInt(3).Equal(balance(cup.ID)))
+
+	teaCost := lotCosts(disassembly.ID, tea.ID)[0]
+	cupCost := lotCosts(disassembly.ID, cup.ID)[0]
+	total := teaCost.Mul(decimal.NewFromInt(2)).Add(cupCost)
+	h.Assert.True(costs[0].Round(2).Equal(total.Round(2)), total.String())
+	h.Assert.True(teaCost.LessThan(cupCost))
+
+	// 6. Отмена разборки и сборки восстанавливает остатки
+	h.CancelDocument(disassembly.ID)
+	h.Assert.True(decimal.NewFromInt(3).Equal(balance(kit.ID)))
+	h.Assert.True(decimal.NewFromInt(2).Equal(balance(tea.ID)))
+
+	h.CancelDocument(assembly.ID)
+	h.Assert.True(balance(kit.ID).IsZero())
+	h.Assert.True(decimal.NewFromInt(8).Equal(balance(tea.ID)))
+	h.Assert.True(decimal.NewFromInt(5).Equal(balance(cup.ID)))
+	h.Assert.True(decimal.NewFromInt(4).Equal(*stockOf(kit.ID).Buildable))
+
+	// 7. Нулевое и отрицательное количество в сборке и разборке отклоняется при проведении
+	for _, docType := range []string{"ASSEMBLY", "DISASSEMBLY"} {
+		for _, qty := range []int64{0, -1} {
+			bad := h.CreateDocument(models.Document{
+				Type: docType, WarehouseID: &wh.ID,
+				Items: []models.DocumentItem{{VariantID: kit.ID, Quantity: decimal.NewFromInt(qty)}},
+			})
+			w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", bad.ID), nil, h.IfMatch(bad.ID))
+			h.Assert.NotEqual(http.StatusOK, w.Code)
+			h.Assert.Contains(w.Body.String(), "quantity must be positive")
+		}
+	}
+	h.Assert.True(decimal.NewFromInt(8).Equal(balance(tea.ID)))
+	h.Assert.True(balance(kit.ID).IsZero())
+}
@@ -0,0 +1,79 @@
+package stocktest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/config"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+func TestAverageCostStrategy_Integration(t *testing.T) {
+	_, db := setupTestRouter("average_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	assert := require.New(t)
+
+	balanceRepo := repository.NewBalanceRepository(db)
+	movementRepo := repository.NewStockMovementRepository(db)
+	factory := service.NewStrategyFactory(
+		balanceRepo, movementRepo, repository.NewLotRepository(db),
+		repository.NewVariantRepository(db), repository.NewProductRepository(db), repository.NewTransitRepository(db),
+	)
+	strategy, err := factory.GetStrategy("average")
+	assert.NoError(err)
+	cfg := &config.Config{AccountingPolicy: "average"}
+
+	wh := models.Warehouse{Name: "Склад"}
+	assert.NoError(db.Create(&wh).Error)
+	variant := models.Variant{ProductID: 1, SKU: "AVG-TEST"}
+	assert.NoError(db.Create(&variant).Error)
+
+	docSeq := 0
+	post := func(docType string, qty, price int64) *models.Document {
+		docSeq++
+		doc := &models.Document{Type: docType, Number: fmt.Sprintf("AVG-%d", docSeq), WarehouseID: &wh.ID}
+		item := models.DocumentItem{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty)}
+		if price > 0 {
+			item.Price = decimalPtr(decimal.NewFromInt(price))
+		}
+		doc.Items = []models.DocumentItem{item}
+		assert.NoError(db.Create(doc).Error)
+		return doc
+	}
+	balance := func() *models.StockBalance {
+		bal, err := balanceRepo.GetBalanceWithTx(nil, wh.ID, variant.ID)
+		assert.NoError(err)
+		return bal
+	}
+
+	// 1. Два прихода: 10 по 100 и 10 по 130 дают среднюю 115
+	assert.NoError(strategy.ProcessIncome(db, post("INCOME", 10, 100), cfg))
+	income2 := post("INCOME", 10, 130)
+	assert.NoError(strategy.ProcessIncome(db, income2, cfg))
+	assert.True(decimal.NewFromInt(115).Equal(balance().AvgCost), "Средняя должна быть 115, получили %s", balance().AvgCost)
+
+	// 2. Расход оценивается по текущей средней и её не меняет
+	outcome := post("OUTCOME", 5, 200)
+	assert.NoError(strategy.ProcessOutcome(db, outcome, cfg))
+	var mv models.StockMovement
+	assert.NoError(db.Where("document_id = ? AND type = ?", outcome.ID, "OUTCOME").First(&mv).Error)
+	assert.True(decimal.NewFromInt(115).Equal(mv.UnitCost))
+	assert.True(decimal.NewFromInt(15).Equal(balance().Quantity))
+	assert.True(decimal.NewFromInt(115).Equal(balance().AvgCost))
+
+	// 3. Отмена расхода возвращает товар по той же цене
+	assert.NoError(strategy.RevertOutcome(db, outcome, cfg))
+	assert.True(decimal.NewFromInt(20).Equal(balance().Quantity))
+	assert.True(decimal.NewFromInt(115).Equal(balance().AvgCost))
+
+	// 4. Отмена второго прихода восстанавливает прежнюю среднюю
+	assert.NoError(strategy.RevertIncome(db, income2, cfg))
+	assert.True(decimal.NewFromInt(10).Equal(balance().Quantity))
+	assert.True(decimal.NewFromInt(100).Equal(balance().AvgCost))
+}
@@ -0,0 +1,219 @@
+package stocktest
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestBarcodes_Integration(t *testing.T) {
+	// модуль читает ./config/stock_config.yml: шрифты из репозитория и свой префикс внутреннего диапазона
+	fonts, _ := filepath.Abs("../../../assets/fonts")
+	if err := os.MkdirAll("config", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("config")
+	cfg := fmt.Sprintf("fonts_dir: %q\nbarcode_ean_prefix: \"210\"\n", fonts+"/")
+	if err := os.WriteFile("config/stock_config.yml", []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	router, db := setupTestRouter("barcodes_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	shirt := h.CreateVariant(gin.H{"product_id": 1, "sku": "SHIRT-M", "characteristics": gin.H{"Размер": "M", "Цвет": "Синий"}})
+	hat := h.CreateVariant(gin.H{"product_id": 1, "sku": "CAP-1"})
+	addBarcode := func(variantID uint, body interface{}) (int, models.Barcode, string) {
+		w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/variants/%d/barcodes", variantID), body)
+		var created models.Barcode
+		json.Unmarshal(w.Body.Bytes(), &created)
+		return w.Code, created, w.Body.String()
+	}
+	findByBarcode := func(code string) (int, models.VariantDTO) {
+		w := h.PerformRequest("GET", "/api/v1/stock/variants/by-barcode/"+code, nil)
+		var found models.VariantDTO
+		json.Unmarshal(w.Body.Bytes(), &found)
+		return w.Code, found
+	}
+
+	// 1. Свои коды выдаются по порядку из диапазона 210 с верной контрольной цифрой
+	code, first, body := addBarcode(shirt.ID, nil)
+	h.Assert.Equal(http.StatusCreated, code, body)
+	h.Assert.Equal("2100000000012", first.Code)
+	h.Assert.Equal(models.BarcodeEAN13, first.Type)
+	h.Assert.True(first.Generated)
+	_, second, _ := addBarcode(hat.ID, gin.H{"type": "EAN13"})
+	h.Assert.Equal("2100000000029", second.Code)
+
+	// код из диапазона, заведённый вручную, при выдаче пропускается
+	code, manual, body := addBarcode(hat.ID, gin.H{"code": "2100000000036"})
+	h.Assert.Equal(http.StatusCreated, code, body)
+	h.Assert.False(manual.Generated)
+	_, third, _ := addBarcode(hat.ID, nil)
+	h.Assert.Equal("2100000000043", third.Code)
+
+	// 2. Заводские коды: тип определяется по содержимому
+	code, factory, body := addBarcode(shirt.ID, gin.H{"code": "4601234567893"})
+	h.Assert.Equal(http.StatusCreated, code, body)
+	h.Assert.Equal("4601234567893", factory.Code)
+	_, box, _ := addBarcode(shirt.ID, gin.H{"code": "BOX-SHIRT-M-12"})
+	h.Assert.Equal(models.BarcodeCode128, box.Type)
+	code, qr, body := addBarcode(shirt.ID, gin.H{"code": "https://example.com/p/shirt-m", "type": "qr"})
+	h.Assert.Equal(http.StatusCreated, code, body)
+	h.Assert.Equal(models.BarcodeQR, qr.Type, "type is case-insensitive")
+
+	// 3. Неверная контрольная цифра, чужой тип и занятый код
+	code, _, _ = addBarcode(shirt.ID, gin.H{"code": "4601234567890"})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, _, _ = addBarcode(shirt.ID, gin.H{"code": "460123456789"})
+	h.Assert.Equal(http.StatusBadRequest, code, "12 digits are UPC-A and must carry its own check digit")
+	code, _, _ = addBarcode(shirt.ID, gin.H{"code": "ABC", "type": "EAN13"})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, _, _ = addBarcode(shirt.ID, gin.H{"code": "Код", "type": "CODE128"})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, _, body = addBarcode(hat.ID, gin.H{"code": "4601234567893"})
+	h.Assert.Equal(http.StatusConflict, code)
+	h.Assert.Contains(body, fmt.Sprintf("variant %d", shirt.ID))
+	code, _, _ = addBarcode(999, nil)
+	h.Assert.Equal(http.StatusNotFound, code)
+
+	// 4. Поиск по скану, в том числе UPC-A без ведущего нуля
+	code, found := findByBarcode("4601234567893")
+	h.Assert.Equal(http.StatusOK, code)
+	h.Assert.Equal(shirt.ID, found.ID)
+	h.Assert.Equal("SHIRT-M", found.SKU)
+	h.Assert.Len(found.Barcodes, 4)
+	code, found = findByBarcode("BOX-SHIRT-M-12")
+	h.Assert.Equal(http.StatusOK, code)
+	h.Assert.Equal(shirt.ID, found.ID)
+	code, upc, body := addBarcode(hat.ID, gin.H{"code": "012345678905"})
+	h.Assert.Equal(http.StatusCreated, code, body)
+	h.Assert.Equal(models.BarcodeEAN13, upc.Type)
+	h.Assert.Equal("0012345678905", upc.Code)
+	code, found = findByBarcode("012345678905")
+	h.Assert.Equal(http.StatusOK, code)
+	h.Assert.Equal(hat.ID, found.ID)
+	code, found = findByBarcode("0012345678905")
+	h.Assert.Equal(http.StatusOK, code)
+	h.Assert.Equal(hat.ID, found.ID)
+	code, _ = findByBarcode("0000000000000")
+	h.Assert.Equal(http.StatusNotFound, code)
+
+	// 5. Штрихкоды в карточке варианта и удаление
+	w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/variants/%d", hat.ID), nil)
+	var card models.VariantDTO
+	json.Unmarshal(w.Body.Bytes(), &card)
+	h.Assert.Len(card.Barcodes, 4)
+	w = h.PerformRequest("DELETE", fmt.Sprintf("/api/v1/stock/variants/%d/barcodes/%d", hat.ID, manual.ID), nil)
+	h.Assert.Equal(http.StatusNoContent, w.Code)
+	w = h.PerformRequest("DELETE", fmt.Sprintf("/api/v1/stock/variants/%d/barcodes/%d", shirt.ID, second.ID), nil)
+	h.Assert.Equal(http.StatusNotFound, w.Code, "barcode of another variant must not be deleted")
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/variants/%d/barcodes", hat.ID), nil)
+	var hatCodes []models.Barcode
+	json.Unmarshal(w.Body.Bytes(), &hatCodes)
+	h.Assert.Len(hatCodes, 3)
+	code, _ = findByBarcode("2100000000036")
+	h.Assert.Equal(http.StatusNotFound, code)
+
+	// 6. Этикетки: полочные с ценой из вида цен и ценники; вариант без кодов печатается с CODE128 из артикула
+	retail := h.CreatePriceType("Розница")
+	prices := h.CreateDocument(models.Document{Type: "PRICE_UPDATE", PriceTypeID: &retail.ID, Items: []models.DocumentItem{
+		{VariantID: shirt.ID, Price: decimalPtr(decimal.RequireFromString("1299.90"))},
+	}})
+	h.PostDocument(prices.ID)
+	plain := h.CreateVariant(gin.H{"product_id": 1, "sku": "PLAIN-1"})
+	labels := func(client *TestHelper, query string) (int, []byte) {
+		w := client.PerformRequest("GET", "/api/v1/stock/variants/labels?"+query, nil)
+		return w.Code, w.Body.Bytes()
+	}
+	isPDF := func(code int, body []byte) {
+		h.Assert.Equal(http.StatusOK, code, string(body))
+		h.Assert.True(bytes.HasPrefix(body, []byte("%PDF")), "response is not a PDF")
+	}
+	isPDF(labels(h, fmt.Sprintf("variant_id=%d&variant_id=%d&variant_id=%d&price_type_id=%d", shirt.ID, hat.ID, plain.ID, retail.ID)))
+	isPDF(labels(h, fmt.Sprintf("variant_id=%d&format=price&copies=50&price_type_id=%d", shirt.ID, retail.ID)))
+
+	code, _ = labels(h, "format=shelf")
+	h.Assert.Equal(http.StatusBadRequest, code, "variants are required")
+	code, _ = labels(h, fmt.Sprintf("variant_id=%d&format=sticker", shirt.ID))
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, _ = labels(h, fmt.Sprintf("variant_id=%d&price_type_id=999", shirt.ID))
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, _ = labels(h, "variant_id=999")
+	h.Assert.Equal(http.StatusNotFound, code)
+
+	// на этикетке цена на сегодня: действующая акция, а не последняя бессрочная цена
+	shirtLabel := func() string {
+		code, body := labels(h, fmt.Sprintf("variant_id=%d&price_type_id=%d", shirt.ID, retail.ID))
+		isPDF(code, body)
+		return pdfText(t, body)
+	}
+	h.Assert.Contains(shirtLabel(), "1299.90")
+	promoEnd := time.Now().Add(24 * time.Hour)
+	promo := h.CreateDocument(models.Document{Type: "PRICE_UPDATE", PriceTypeID: &retail.ID, EffectiveTo: &promoEnd, Items: []models.DocumentItem{
+		{VariantID: shirt.ID, Price: decimalPtr(decimal.RequireFromString("999"))},
+	}})
+	h.PostDocument(promo.ID)
+	text := shirtLabel()
+	h.Assert.Contains(text, "999.00")
+	h.Assert.NotContains(text, "1299.90")
+
+	// ценники к дате смены цен: запланированная цена, а не действующая сегодня
+	now := time.Now()
+	raise := time.Date(now.Year(), now.Month(), now.Day()+3, 0, 0, 0, 0, time.Local)
+	planned := h.CreateDocument(models.Document{Type: "PRICE_UPDATE", PriceTypeID: &retail.ID, EffectiveFrom: &raise, Items: []models.DocumentItem{
+		{VariantID: shirt.ID, Price: decimalPtr(decimal.RequireFromString("1499"))},
+	}})
+	h.PostDocument(planned.ID)
+	code, pdf := labels(h, fmt.Sprintf("variant_id=%d&price_type_id=%d&date=%s", shirt.ID, retail.ID, raise.Format(time.DateOnly)))
+	isPDF(code, pdf)
+	h.Assert.Contains(pdfText(t, pdf), "1499.00")
+	h.Assert.Contains(shirtLabel(), "999.00")
+	code, _ = labels(h, fmt.Sprintf("variant_id=%d&date=tomorrow", shirt.ID))
+	h.Assert.Equal(http.StatusBadRequest, code)
+
+	// 7. С правом просмотра можно искать и печатать, но не заводить коды
+	viewer := h.AsUser(4, "viewer", "view_stock")
+	w = viewer.PerformRequest("GET", "/api/v1/stock/variants/by-barcode/4601234567893", nil)
+	h.Assert.Equal(http.StatusOK, w.Code)
+	isPDF(labels(viewer, fmt.Sprintf("variant_id=%d", shirt.ID)))
+	w = viewer.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/variants/%d/barcodes", shirt.ID), nil)
+	h.Assert.Equal(http.StatusForbidden, w.Code)
+}
+
+// pdfText распаковывает потоки PDF и собирает строки, выведенные оператором Tj; строки шрифтов UTF-8 записаны в UTF-16BE.
+func pdfText(t *testing.T, pdf []byte) string {
+	shown := regexp.MustCompile(`(?s)\((.*?)\)Tj`)
+	var out []string
+	for _, m := range regexp.MustCompile(`(?s)stream\r?\n(.*?)endstream`).FindAllSubmatch(pdf, -1) {
+		r, err := zlib.NewReader(bytes.NewReader(m[1]))
+		if err != nil {
+			continue
+		}
+		content, err := io.ReadAll(r)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatal(err)
+		}
+		for _, text := range shown.FindAllSubmatch(content, -1) {
+			out = append(out, strings.ReplaceAll(string(text[1]), "\x00", ""))
+		}
+	}
+	return strings.Join(out, "\n")
+}
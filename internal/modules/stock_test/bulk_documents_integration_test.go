@@ -0,0 +1,123 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestBulkDocuments_Integration(t *testing.T) {
+	router, db := setupTestRouter("bulk_documents_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "BULK-1"})
+
+	now := time.Now()
+	draft := func(docType string, daysAgo int, qty int64, comment string) models.Document {
+		return h.CreateDocument(models.Document{
+			Type: docType, WarehouseID: &wh.ID, DocumentDate: now.AddDate(0, 0, -daysAgo), Comment: comment,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty), Price: decimalPtr(decimal.NewFromInt(10))}},
+		})
+	}
+	run := func(payload gin.H) models.BulkJobDTO {
+		w := h.PerformRequest("POST", "/api/v1/stock/documents/bulk", payload)
+		h.Assert.Equal(http.StatusAccepted, w.Code, w.Body.String())
+		var job models.BulkJobDTO
+		h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &job))
+		h.Assert.Eventually(func() bool {
+			w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/bulk/%d", job.ID), nil)
+			h.Assert.Equal(http.StatusOK, w.Code)
+			h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &job))
+			return job.Status != "running"
+		}, 5*time.Second, 20*time.Millisecond)
+		return job
+	}
+	status := func(id uint) string { return h.GetDocument(id).Status }
+
+	// 1. Best-effort по фильтру: документы идут по дате, ошибка одного не мешает остальным
+	sale := draft("OUTCOME", 2, 12, "month-end")
+	income := draft("INCOME", 5, 10, "month-end")
+	late := draft("INCOME", 1, 5, "month-end")
+	draft("INCOME", 1, 1, "other")
+
+	job := run(gin.H{"action": "post", "filter": gin.H{"search": "month-end"}})
+	h.Assert.Equal("completed", job.Status)
+	h.Assert.Equal(3, job.Total)
+	h.Assert.Equal(3, job.Processed)
+	h.Assert.Equal(2, job.Succeeded)
+	h.Assert.Equal(1, job.Failed)
+	h.Assert.Equal([]uint{income.ID, sale.ID, late.ID}, []uint{job.Results[0].DocumentID, job.Results[1].DocumentID, job.Results[2].DocumentID})
+	h.Assert.Equal("failed", job.Results[1].Status)
+	h.Assert.Contains(job.Results[1].Error, "inventory processing failed")
+	h.Assert.Equal("posted", status(income.ID))
+	h.Assert.Equal("draft", status(sale.ID))
+	h.Assert.Equal("posted", status(late.ID))
+
+	// 2. All-or-nothing: ошибка откатывает уже проведённые документы пакета
+	first := draft("INCOME", 3, 3, "") // к дате продажи её покрывают только приходы, датированные раньше
+	tooBig := draft("OUTCOME", 0, 100, "")
+	job = run(gin.H{"action": "post", "mode": "all_or_nothing", "document_ids": []uint{tooBig.ID, first.ID, sale.ID}})
+	h.Assert.Equal("failed", job.Status)
+	h.Assert.Equal(0, job.Succeeded)
+	h.Assert.Equal([]string{"rolled_back", "rolled_back", "failed"}, []string{job.Results[0].Status, job.Results[1].Status, job.Results[2].Status})
+	h.Assert.Equal("draft", status(sale.ID))
+	h.Assert.Equal("draft", status(first.ID))
+	h.Assert.Equal("draft", status(tooBig.ID))
+
+	job = run(gin.H{"action": "post", "mode": "all_or_nothing", "document_ids": []uint{first.ID, sale.ID}})
+	h.Assert.Equal("completed", job.Status)
+	h.Assert.Equal("posted", status(sale.ID))
+	balance := findBalance(h.GetBalances(wh.ID), variant.ID)
+	h.Assert.True(decimal.NewFromInt(6).Equal(balance.Quantity), balance.Quantity.String())
+
+	// 3. Массовая отмена идёт от поздних документов к ранним: продажа возвращает товар в партию прихода до его отмены
+	job = run(gin.H{"action": "cancel", "document_ids": []uint{income.ID, sale.ID}})
+	h.Assert.Equal("completed", job.Status, job.Results)
+	h.Assert.Equal(2, job.Succeeded)
+	h.Assert.Equal([]uint{sale.ID, income.ID}, []uint{job.Results[0].DocumentID, job.Results[1].DocumentID})
+	h.Assert.Equal("canceled", status(sale.ID))
+	h.Assert.Equal("canceled", status(income.ID))
+
+	// 4. Проверки запроса и прав
+	w := h.PerformRequest("POST", "/api/v1/stock/documents/bulk", gin.H{"action": "post"})
+	h.Assert.Equal(http.StatusBadRequest, w.Code)
+	w = h.PerformRequest("POST", "/api/v1/stock/documents/bulk", gin.H{"action": "archive", "document_ids": []uint{first.ID}})
+	h.Assert.Equal(http.StatusBadRequest, w.Code)
+	w = h.PerformRequest("GET", "/api/v1/stock/documents/bulk/999", nil)
+	h.Assert.Equal(http.StatusNotFound, w.Code)
+
+	clerk := h.AsUser(5, "clerk", "view_stock", "post_document:INCOME")
+	w = clerk.PerformRequest("POST", "/api/v1/stock/documents/bulk", gin.H{"action": "post", "document_ids": []uint{tooBig.ID}})
+	h.Assert.Equal(http.StatusForbidden, w.Code)
+	w = clerk.PerformRequest("POST", "/api/v1/stock/documents/bulk", gin.H{"action": "cancel", "document_ids": []uint{first.ID}})
+	h.Assert.Equal(http.StatusForbidden, w.Code)
+
+	// 5. Заказ в пакете видит приход и резервы, проведённые раньше в той же транзакции
+	kept := h.CreateVariant(gin.H{"product_id": 1, "sku": "BULK-2"})
+	line := func(docType string, qty int64) models.Document {
+		return h.CreateDocument(models.Document{
+			Type: docType, WarehouseID: &wh.ID,
+			Items: []models.DocumentItem{{VariantID: kept.ID, Quantity: decimal.NewFromInt(qty), Price: decimalPtr(decimal.NewFromInt(10))}},
+		})
+	}
+	supply, order := line("INCOME", 5), line("ORDER", 3)
+	job = run(gin.H{"action": "post", "mode": "all_or_nothing", "document_ids": []uint{supply.ID, order.ID}})
+	h.Assert.Equal("completed", job.Status, job.Results)
+	h.Assert.Equal("posted", status(order.ID))
+
+	second, third := line("ORDER", 2), line("ORDER", 1)
+	job = run(gin.H{"action": "post", "mode": "all_or_nothing", "document_ids": []uint{second.ID, third.ID}})
+	h.Assert.Equal("failed", job.Status)
+	h.Assert.Contains(job.Results[1].Error, "Доступно: 0")
+	h.Assert.Equal("draft", status(second.ID))
+}
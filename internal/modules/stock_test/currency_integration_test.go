@@ -0,0 +1,84 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/reports"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestMultiCurrency_Integration(t *testing.T) {
+	router, db := setupTestRouter("currency_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	// 1. Загрузка курсов из CSV
+	csv := "currency;date;rate\nUSD;2020-01-01;90\nusd;2020-01-02;91,5\n"
+	w := h.UploadFile("/api/v1/stock/currency-rates/import", "rates.csv", []byte(csv))
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	w = h.PerformRequest("GET", "/api/v1/stock/currency-rates?currency=USD", nil)
+	h.Assert.Equal(http.StatusOK, w.Code)
+	var list struct {
+		BaseCurrency string                `json:"base_currency"`
+		Rates        []models.ExchangeRate `json:"rates"`
+	}
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &list))
+	h.Assert.Equal("RUB", list.BaseCurrency)
+	h.Assert.Len(list.Rates, 2)
+	h.Assert.True(decimal.RequireFromString("91.5").Equal(list.Rates[0].Rate))
+
+	wh := h.CreateWarehouse("Валютный склад")
+	category := h.CreateCategory("Импорт")
+	product := h.CreateProduct(gin.H{"name": "Импортный товар", "category_id": category.ID})
+	variant := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "IMPORT-1"})
+
+	// 2. Закупка в долларах: себестоимость партии в рублях по курсу на дату документа
+	income := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID, Currency: "USD",
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(5), Price: decimalPtr(decimal.NewFromInt(10))}},
+	})
+	h.PostDocument(income.ID)
+	posted := h.GetDocument(income.ID)
+	h.Assert.True(decimal.RequireFromString("91.5").Equal(posted.ExchangeRate))
+
+	var lot models.StockLot
+	h.Assert.NoError(db.Where("income_document_id = ?", income.ID).First(&lot).Error)
+	h.Assert.True(decimal.NewFromInt(915).Equal(lot.UnitCost), lot.UnitCost.String())
+
+	// 3. Без курса валюты документ не проводится
+	cny := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID, Currency: "CNY",
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), Price: decimalPtr(decimal.NewFromInt(70))}},
+	})
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", cny.ID), nil, h.IfMatch(cny.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code)
+
+	// 4. Продажи в рублях и в долларах
+	saleRub := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(2), Price: decimalPtr(decimal.NewFromInt(1200))}},
+	})
+	h.PostDocument(saleRub.ID)
+	saleUsd := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID, Currency: "USD",
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), Price: decimalPtr(decimal.NewFromInt(20))}},
+	})
+	h.PostDocument(saleUsd.ID)
+
+	// 5. Отчёт о прибыли считает выручку и себестоимость в рублях
+	repo := reports.NewRepository(db, "fifo")
+	records, err := repo.GetFIFOProfitData(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), nil)
+	h.Assert.NoError(err)
+	h.Assert.Len(records, 1)
+	h.Assert.True(decimal.NewFromInt(2400+1830).Equal(records[0].Revenue), records[0].Revenue.String())
+	h.Assert.True(decimal.NewFromInt(3*915).Equal(records[0].Cost), records[0].Cost.String())
+}
@@ -0,0 +1,153 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestDocumentCorrection_Integration(t *testing.T) {
+	router, db := setupTestRouter("document_correction_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	shop := h.CreateWarehouse("Магазин")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "CORR-1"})
+	line := func(qty, price int64) []models.DocumentItem {
+		return []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty), Price: decimalPtr(decimal.NewFromInt(price))}}
+	}
+	correct := func(id uint, payload models.DocumentUpdateDTO) (int, []byte) {
+		w := h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/correct", id), payload, h.IfMatch(id))
+		return w.Code, w.Body.Bytes()
+	}
+	quantity := func(warehouseID uint) decimal.Decimal {
+		return findBalance(h.GetBalances(warehouseID), variant.ID).Quantity
+	}
+
+	income := h.CreateDocument(models.Document{Type: "INCOME", WarehouseID: &wh.ID, Items: line(10, 10)})
+	h.PostDocument(income.ID)
+	sale := h.CreateDocument(models.Document{Type: "OUTCOME", WarehouseID: &wh.ID, Items: line(4, 15)})
+	h.PostDocument(sale.ID)
+	posted := h.GetDocument(income.ID)
+
+	// 0. Исправление без версии документа не принимается
+	w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/correct", income.ID), models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: line(12, 11)})
+	h.Assert.Equal(http.StatusPreconditionRequired, w.Code, w.Body.String())
+	h.Assert.True(decimal.NewFromInt(6).Equal(quantity(wh.ID)), quantity(wh.ID).String())
+
+	// 1. Исправление перепроводит документ под тем же номером
+	code, body := correct(income.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, Comment: "пересчёт", Items: line(12, 11), Version: &posted.Version})
+	h.Assert.Equal(http.StatusOK, code, string(body))
+	var corrected models.DocumentDTO
+	h.Assert.NoError(json.Unmarshal(body, &corrected))
+	h.Assert.Equal(income.Number, corrected.Number)
+	h.Assert.Equal("posted", corrected.Status)
+	h.Assert.Greater(corrected.Version, posted.Version)
+	h.Assert.True(decimal.NewFromInt(8).Equal(quantity(wh.ID)), quantity(wh.ID).String())
+
+	var moves []models.StockMovement
+	h.Assert.NoError(db.Where("document_id = ?", income.ID).Order("id").Find(&moves).Error)
+	h.Assert.Len(moves, 3, "old movement, its reversal and the new one")
+	h.Assert.NotNil(moves[0].ReversedByID)
+	h.Assert.Equal(moves[1].ID, *moves[0].ReversedByID)
+	h.Assert.Equal("CANCEL", moves[1].Type)
+	h.Assert.Nil(moves[2].ReversedByID)
+	h.Assert.True(decimal.NewFromInt(12).Equal(moves[2].Quantity))
+
+	// 2. В истории - снимок строк до и после
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/%d/history", income.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code)
+	var history []models.DocumentHistory
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &history))
+	var entry *models.DocumentHistory
+	for i := range history {
+		if history[i].Action == "corrected" {
+			entry = &history[i]
+		}
+	}
+	h.Assert.NotNil(entry)
+	h.Assert.NotNil(entry.Snapshot)
+	h.Assert.True(decimal.NewFromInt(10).Equal(entry.Snapshot.Before.Items[0].Quantity))
+	h.Assert.True(decimal.NewFromInt(12).Equal(entry.Snapshot.After.Items[0].Quantity))
+	h.Assert.Equal("пересчёт", entry.Snapshot.After.Comment)
+
+	// 3. Устаревшая версия и черновик отклоняются
+	code, _ = correct(income.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: line(11, 11), Version: &posted.Version})
+	h.Assert.Equal(http.StatusConflict, code)
+	draft := h.CreateDocument(models.Document{Type: "INCOME", WarehouseID: &wh.ID, Items: line(1, 1)})
+	code, _ = correct(draft.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: line(2, 1)})
+	h.Assert.NotEqual(http.StatusOK, code)
+
+	// 4. Исправление, после которого остаток ушёл бы в минус, откатывается целиком
+	code, body = correct(sale.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: line(50, 15)})
+	h.Assert.NotEqual(http.StatusOK, code, string(body))
+	h.Assert.True(decimal.NewFromInt(8).Equal(quantity(wh.ID)), quantity(wh.ID).String())
+	h.Assert.Equal("posted", h.GetDocument(sale.ID).Status)
+	h.Assert.True(decimal.NewFromInt(4).Equal(h.GetDocument(sale.ID).Items[0].Quantity))
+
+	// 5. Уменьшение продажи возвращает товар; перенос прихода, из которого она списана, запрещён
+	code, body = correct(sale.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: line(2, 15)})
+	h.Assert.Equal(http.StatusOK, code, string(body))
+	h.Assert.True(decimal.NewFromInt(10).Equal(quantity(wh.ID)), quantity(wh.ID).String())
+	code, body = correct(income.ID, models.DocumentUpdateDTO{WarehouseID: &shop.ID, Items: line(3, 11)})
+	h.Assert.NotEqual(http.StatusOK, code, "moving the income away would leave the sale without stock: "+string(body))
+	h.Assert.True(decimal.NewFromInt(10).Equal(quantity(wh.ID)))
+
+	// 6. Исправление заказа пересчитывает его резерв; прежний резерв заказа не мешает занять весь остаток
+	order := h.CreateDocument(models.Document{Type: "ORDER", WarehouseID: &wh.ID, Items: line(3, 20)})
+	h.PostDocument(order.ID)
+	code, body = correct(order.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: line(10, 20)})
+	h.Assert.Equal(http.StatusOK, code, string(body))
+	var reserved []models.ReservationLine
+	h.Assert.NoError(db.Where("document_id = ?", order.ID).Find(&reserved).Error)
+	h.Assert.Len(reserved, 1)
+	h.Assert.True(decimal.NewFromInt(10).Equal(reserved[0].Quantity))
+
+	// 7. Новая учётная дата доходит до движений документа
+	earlier := time.Now().AddDate(0, 0, -3).Truncate(time.Second)
+	code, body = correct(income.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, DocumentDate: &earlier, Items: line(12, 11)})
+	h.Assert.Equal(http.StatusOK, code, string(body))
+	h.Assert.NoError(db.Where("document_id = ? AND reversed_by_id IS NULL AND type <> ?", income.ID, "CANCEL").Find(&moves).Error)
+	h.Assert.Len(moves, 1)
+	h.Assert.True(earlier.Equal(moves[0].DocumentDate), moves[0].DocumentDate.String())
+
+	// 8. Без права на отмену исправлять нельзя
+	clerk := h.AsUser(3, "clerk", "view_stock", "post_document")
+	w = clerk.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/correct", income.ID), models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: line(12, 11)})
+	h.Assert.Equal(http.StatusForbidden, w.Code)
+
+	// 9. Отмена исправленных документов сторнирует только действующие движения
+	h.CancelDocument(order.ID)
+	h.CancelDocument(sale.ID)
+	h.Assert.True(decimal.NewFromInt(12).Equal(quantity(wh.ID)), quantity(wh.ID).String())
+	h.CancelDocument(income.ID)
+	h.Assert.True(quantity(wh.ID).IsZero(), quantity(wh.ID).String())
+	for _, id := range []uint{income.ID, sale.ID} {
+		var net decimal.Decimal
+		h.Assert.NoError(db.Model(&models.StockMovement{}).Where("document_id = ?", id).Select("COALESCE(SUM(quantity), 0)").Scan(&net).Error)
+		h.Assert.True(net.IsZero(), "document %d: %s", id, net.String())
+	}
+
+	// 10. Приход, из партий которого уже сделано перемещение, не исправляется
+	supply := h.CreateDocument(models.Document{Type: "INCOME", WarehouseID: &wh.ID, Items: line(5, 10)})
+	h.PostDocument(supply.ID)
+	transfer := h.CreateDocument(models.Document{Type: "TRANSFER", WarehouseID: &wh.ID, ToWarehouseID: &shop.ID, Items: line(2, 10)})
+	h.PostDocument(transfer.ID)
+	code, body = correct(supply.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: line(5, 12)})
+	h.Assert.NotEqual(http.StatusOK, code, string(body))
+	h.Assert.Contains(string(body), transfer.Number)
+	h.Assert.True(decimal.NewFromInt(3).Equal(quantity(wh.ID)), quantity(wh.ID).String())
+	h.Assert.True(decimal.NewFromInt(2).Equal(quantity(shop.ID)), quantity(shop.ID).String())
+	h.CancelDocument(transfer.ID)
+	code, body = correct(supply.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: line(5, 12)})
+	h.Assert.Equal(http.StatusOK, code, string(body))
+}
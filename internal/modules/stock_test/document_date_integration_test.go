@@ -0,0 +1,199 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/reports"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestDocumentDate_Integration(t *testing.T) {
+	router, db := setupTestRouter("document_date_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	unit := h.CreateUnit("шт")
+	category := h.CreateCategory("Бакалея")
+	product := h.CreateProduct(gin.H{"name": "Рис", "category_id": category.ID})
+	variant := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "DD-1", "unit_id": unit.ID})
+
+	now := time.Now()
+	day := func(offset int) time.Time { return now.AddDate(0, 0, offset) }
+	income := func(date time.Time, qty, price int64) models.Document {
+		doc := h.CreateDocument(models.Document{
+			Type: "INCOME", WarehouseID: &wh.ID, DocumentDate: date,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty), Price: decimalPtr(decimal.NewFromInt(price))}},
+		})
+		h.PostDocument(doc.ID)
+		return doc
+	}
+	outcomeCost := func(docID uint) decimal.Decimal {
+		var moves []models.StockMovement
+		h.Assert.NoError(db.Where("document_id = ? AND type = ?", docID, "OUTCOME").Find(&moves).Error)
+		total := decimal.Zero
+		for _, mv := range moves {
+			total = total.Add(mv.Quantity.Neg().Mul(mv.UnitCost))
+		}
+		return total
+	}
+
+	// 1. Без даты документ датируется моментом создания, дату можно поменять в черновике
+	draft := h.CreateDocument(models.Document{Type: "INCOME", WarehouseID: &wh.ID})
+	h.Assert.WithinDuration(now, draft.DocumentDate, time.Minute)
+	h.UpdateDocument(draft.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, DocumentDate: &[]time.Time{day(-3)}[0]})
+	h.Assert.WithinDuration(day(-3), h.GetDocument(draft.ID).DocumentDate, time.Second)
+	h.DeleteDocument(draft.ID)
+
+	// 2. Два прихода и продажа сегодня: списание из первой партии
+	income(day(-5), 5, 100)
+	income(day(-1), 5, 200)
+	sale := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(4)}},
+	})
+	h.PostDocument(sale.ID)
+	h.Assert.True(decimal.NewFromInt(400).Equal(outcomeCost(sale.ID)), outcomeCost(sale.ID).String())
+
+	// 3. Приход задним числом встаёт в начало очереди, и продажа пересчитывается по нему
+	backdated := income(day(-10), 3, 50)
+	h.Assert.True(decimal.NewFromInt(3*50+1*100).Equal(outcomeCost(sale.ID)), outcomeCost(sale.ID).String())
+	h.Assert.True(decimal.NewFromInt(9).Equal(findBalance(h.GetBalances(wh.ID), variant.ID).Quantity))
+
+	var lots []models.StockLot
+	h.Assert.NoError(db.Where("variant_id = ?", variant.ID).Order("arrival_date").Find(&lots).Error)
+	h.Assert.Len(lots, 3)
+	h.Assert.Equal(backdated.ID, lots[0].IncomeDocumentID)
+	h.Assert.WithinDuration(day(-10), lots[0].ArrivalDate, time.Second)
+	left := []int64{0, 4, 5}
+	for i, lot := range lots {
+		h.Assert.True(decimal.NewFromInt(left[i]).Equal(lot.CurrentQuantity), "lot %d: %s", i, lot.CurrentQuantity.String())
+	}
+
+	var moves []models.StockMovement
+	h.Assert.NoError(db.Where("document_id = ?", backdated.ID).Find(&moves).Error)
+	h.Assert.Len(moves, 1)
+	h.Assert.WithinDuration(day(-10), moves[0].DocumentDate, time.Second)
+
+	// 4. Отмена продажи возвращает товар в те партии, из которых он списан после пересчёта
+	h.CancelDocument(sale.ID)
+	h.Assert.NoError(db.Where("variant_id = ?", variant.ID).Order("arrival_date").Find(&lots).Error)
+	for i, qty := range []int64{3, 5, 5} {
+		h.Assert.True(decimal.NewFromInt(qty).Equal(lots[i].CurrentQuantity), "lot %d: %s", i, lots[i].CurrentQuantity.String())
+	}
+
+	// 5. Отчёты и фильтр документов берут период по учётной дате
+	rows, err := reports.NewRepository(db, "fifo").GetMovementsData(day(-11), day(-9), &wh.ID)
+	h.Assert.NoError(err)
+	h.Assert.Len(rows, 1)
+	h.Assert.Equal(backdated.Number, rows[0].DocumentNumber)
+
+	from := day(-11).UTC().Format(time.RFC3339)
+	to := day(-9).UTC().Format(time.RFC3339)
+	w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents?date_from=%s&date_to=%s", from, to), nil)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var list []models.DocumentListItemDTO
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &list))
+	h.Assert.Len(list, 1)
+	h.Assert.Equal(backdated.ID, list[0].ID)
+
+	// 6. Продажа задним числом берёт только партии, пришедшие к её дате: более поздний приход её не покрывает
+	today := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(4)}},
+	})
+	h.PostDocument(today.ID)
+	sellAt := func(date time.Time, qty int64) models.Document {
+		return h.CreateDocument(models.Document{
+			Type: "OUTCOME", WarehouseID: &wh.ID, DocumentDate: date,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty)}},
+		})
+	}
+	tooMuch := sellAt(day(-3), 9)
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", tooMuch.ID), nil, h.IfMatch(tooMuch.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code, "на третий день назад было только 8 единиц")
+	h.Assert.Contains(w.Body.String(), "Недостаточно товара")
+	h.Assert.Equal("draft", h.GetDocument(tooMuch.ID).Status)
+	h.Assert.True(decimal.NewFromInt(9).Equal(findBalance(h.GetBalances(wh.ID), variant.ID).Quantity))
+
+	enough := sellAt(day(-3), 8)
+	h.PostDocument(enough.ID)
+	h.Assert.True(decimal.NewFromInt(3*50+5*100).Equal(outcomeCost(enough.ID)), outcomeCost(enough.ID).String())
+	h.Assert.True(decimal.NewFromInt(4*200).Equal(outcomeCost(today.ID)), outcomeCost(today.ID).String())
+}
+
+func TestBackdatedOutcomeUnderLIFO_Integration(t *testing.T) {
+	// модуль читает ./config/stock_config.yml: списываем сначала последние партии
+	if err := os.MkdirAll("config", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("config")
+	if err := os.WriteFile("config/stock_config.yml", []byte("accounting_policy: lifo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	router, db := setupTestRouter("document_date_lifo_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "DD-LIFO-1"})
+
+	now := time.Now()
+	day := func(offset int) time.Time { return now.AddDate(0, 0, offset) }
+	create := func(docType string, date time.Time, qty, price int64) models.Document {
+		return h.CreateDocument(models.Document{
+			Type: docType, WarehouseID: &wh.ID, DocumentDate: date,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty), Price: decimalPtr(decimal.NewFromInt(price))}},
+		})
+	}
+	lotsLeft := func() []int64 {
+		var lots []models.StockLot
+		h.Assert.NoError(db.Where("variant_id = ?", variant.ID).Order("arrival_date").Find(&lots).Error)
+		left := make([]int64, len(lots))
+		for i, lot := range lots {
+			left[i] = lot.CurrentQuantity.IntPart()
+		}
+		return left
+	}
+
+	for _, in := range []models.Document{create("INCOME", day(-5), 5, 100), create("INCOME", day(-1), 5, 200)} {
+		h.PostDocument(in.ID)
+	}
+
+	// 1. Продажа задним числом берёт последнюю партию на свою дату, а не вчерашний приход
+	sale := create("OUTCOME", day(-3), 3, 300)
+	h.PostDocument(sale.ID)
+	h.Assert.Equal([]int64{2, 5}, lotsLeft())
+
+	var moves []models.StockMovement
+	h.Assert.NoError(db.Where("document_id = ? AND type = ?", sale.ID, "OUTCOME").Find(&moves).Error)
+	h.Assert.Len(moves, 1)
+	h.Assert.True(decimal.NewFromInt(100).Equal(moves[0].UnitCost), moves[0].UnitCost.String())
+	h.Assert.WithinDuration(day(-3), moves[0].DocumentDate, time.Second)
+
+	// 2. Товар, пришедший позже даты документа, задним числом не списывается
+	tooMuch := create("OUTCOME", day(-3), 3, 300)
+	w := h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", tooMuch.ID), nil, h.IfMatch(tooMuch.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code, "на третий день назад оставалось только 2 единицы")
+	h.Assert.Contains(w.Body.String(), "Недостаточно товара")
+	h.Assert.Equal("draft", h.GetDocument(tooMuch.ID).Status)
+	h.Assert.Equal([]int64{2, 5}, lotsLeft())
+
+	// 3. Продажа текущим числом по-прежнему берёт последнюю партию, отмена возвращает товар в свои партии
+	today := create("OUTCOME", now, 4, 300)
+	h.PostDocument(today.ID)
+	h.Assert.Equal([]int64{2, 1}, lotsLeft())
+	h.CancelDocument(sale.ID)
+	h.Assert.Equal([]int64{5, 1}, lotsLeft())
+}
@@ -40,7 +40,7 @@ func TestReservationLifecycle_Integration(t *testing.T) {
 		Type: "ORDER", WarehouseID: &warehouse.ID,
 		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(10)}},
 	})
-	w := h.PerformRequest("POST", "/api/v1/stock/documents/"+fmt.Sprintf("%d", orderDoc2.ID)+"/post", nil)
+	w := h.PerformRequestWithHeaders("POST", "/api/v1/stock/documents/"+fmt.Sprintf("%d", orderDoc2.ID)+"/post", nil, h.IfMatch(orderDoc2.ID))
 	h.Assert.Equal(http.StatusInternalServerError, w.Code, "Проведение заказа сверх доступного остатка должно вернуть ошибку")
 	h.Assert.Contains(w.Body.String(), "not enough available stock")
 
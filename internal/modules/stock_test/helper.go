@@ -4,22 +4,28 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
 	"github.com/maksroxx/flowkeeper/internal/config"
+	"github.com/maksroxx/flowkeeper/internal/modules/audit"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock"
 	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/users"
 )
 
+const testJWTSecret = "test-secret"
+
 func setupTestRouter(dbName string) (*gin.Engine, *gorm.DB) {
 	dbPath := fmt.Sprintf("test_%s.db", dbName)
 	os.Remove(dbPath)
@@ -29,7 +35,14 @@ func setupTestRouter(dbName string) (*gin.Engine, *gorm.DB) {
 	}
 	db.Exec("PRAGMA journal_mode = WAL;")
 
-	stockModule := stock.NewModule(config.AuthConfig{})
+	if err := db.AutoMigrate(&users.Role{}, &users.User{}, &audit.AuditLog{}); err != nil {
+		panic(fmt.Sprintf("Failed to migrate audit log: %v", err))
+	}
+	// записи аудита сохраняются по одной, чтобы тесты видели их сразу
+	auditLog := audit.NewAsyncService(db, config.AuditConfig{BatchSize: 1})
+	auditLog.StartWorker()
+
+	stockModule := stock.NewModule(config.AuthConfig{JWTSecret: testJWTSecret}, auditLog)
 	err = stockModule.Migrate(db)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to migrate database: %v", err))
@@ -46,20 +59,61 @@ type TestHelper struct {
 	T      *testing.T
 	Router *gin.Engine
 	Assert *require.Assertions
+	Token  string
 }
 
+// NewTestHelper создаёт помощника, выполняющего запросы от имени администратора.
 func NewTestHelper(t *testing.T, router *gin.Engine) *TestHelper {
-	return &TestHelper{T: t, Router: router, Assert: require.New(t)}
+	return &TestHelper{T: t, Router: router, Assert: require.New(t), Token: testToken(1, "admin")}
+}
+
+// AsUser возвращает помощника, выполняющего запросы от имени пользователя с заданной ролью и правами.
+func (h *TestHelper) AsUser(userID uint, role string, permissions ...string) *TestHelper {
+	return &TestHelper{T: h.T, Router: h.Router, Assert: h.Assert, Token: testToken(userID, role, permissions...)}
+}
+
+func testToken(userID uint, role string, permissions ...string) string {
+	claims := jwt.MapClaims{"sub": userID, "role": role, "permissions": permissions}
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	return token
 }
 
 func (h *TestHelper) PerformRequest(method, path string, body interface{}) *httptest.ResponseRecorder {
+	return h.PerformRequestWithHeaders(method, path, body, nil)
+}
+
+// PerformRequestWithHeaders - то же, что PerformRequest, с дополнительными заголовками (Idempotency-Key, If-Match).
+func (h *TestHelper) PerformRequestWithHeaders(method, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
 	var reqBody []byte
 	if body != nil {
 		reqBody, _ = json.Marshal(body)
 	}
-	w := httptest.NewRecorder()
 	req, _ := http.NewRequest(method, path, bytes.NewReader(reqBody))
 	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return h.serve(req)
+}
+
+// UploadFile отправляет файл в поле "file" формы multipart/form-data.
+func (h *TestHelper) UploadFile(path, filename string, content []byte) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	part, _ := mw.CreateFormFile("file", filename)
+	part.Write(content)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", path, body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return h.serve(req)
+}
+
+func (h *TestHelper) serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
 	h.Router.ServeHTTP(w, req)
 	return w
 }
@@ -217,18 +271,23 @@ func (h *TestHelper) ListDocuments(status ...string) []models.Document {
 	return docs
 }
 func (h *TestHelper) UpdateDocument(docID uint, payload interface{}) models.Document {
-	w := h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/documents/%d", docID), payload)
-	h.Assert.Equal(http.StatusOK, w.Code)
+	w := h.PerformRequestWithHeaders("PUT", fmt.Sprintf("/api/v1/stock/documents/%d", docID), payload, h.IfMatch(docID))
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
 	var doc models.Document
 	json.Unmarshal(w.Body.Bytes(), &doc)
 	return doc
 }
+
+// IfMatch - заголовок с текущей версией документа для правки.
+func (h *TestHelper) IfMatch(docID uint) map[string]string {
+	return map[string]string{"If-Match": fmt.Sprint(h.GetDocument(docID).Version)}
+}
 func (h *TestHelper) DeleteDocument(docID uint) {
 	w := h.PerformRequest("DELETE", fmt.Sprintf("/api/v1/stock/documents/%d", docID), nil)
 	h.Assert.Equal(http.StatusNoContent, w.Code)
 }
 func (h *TestHelper) PostDocument(docID uint) {
-	w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", docID), nil)
+	w := h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", docID), nil, h.IfMatch(docID))
 	h.Assert.Equal(http.StatusOK, w.Code, fmt.Sprintf("Failed to post document. Body: %s", w.Body.String()))
 }
 func (h *TestHelper) CancelDocument(docID uint) {
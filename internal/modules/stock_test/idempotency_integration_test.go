@@ -0,0 +1,159 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/handler"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+func TestIdempotencyAndVersions_Integration(t *testing.T) {
+	router, db := setupTestRouter("idempotency_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "IDEM-1"})
+	payload := models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(5), Price: decimalPtr(decimal.NewFromInt(10))}},
+	}
+	withKey := func(key string) map[string]string { return map[string]string{"Idempotency-Key": key} }
+	countDocs := func() int64 {
+		var n int64
+		db.Model(&models.Document{}).Count(&n)
+		return n
+	}
+
+	// 1. Повтор создания с тем же ключом возвращает тот же документ, а не создаёт второй
+	w := h.PerformRequestWithHeaders("POST", "/api/v1/stock/documents", payload, withKey("scan-1"))
+	h.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	var created models.Document
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &created))
+	h.Assert.Equal(uint(1), created.Version)
+
+	retry := h.PerformRequestWithHeaders("POST", "/api/v1/stock/documents", payload, withKey("scan-1"))
+	h.Assert.Equal(http.StatusCreated, retry.Code)
+	h.Assert.Equal("true", retry.Header().Get("Idempotent-Replayed"))
+	h.Assert.JSONEq(w.Body.String(), retry.Body.String())
+	h.Assert.Equal(int64(1), countDocs())
+
+	other := payload
+	other.Comment = "другой"
+	w = h.PerformRequestWithHeaders("POST", "/api/v1/stock/documents", other, withKey("scan-1"))
+	h.Assert.Equal(http.StatusUnprocessableEntity, w.Code, "same key, different request")
+
+	// ключи разных пользователей не пересекаются
+	clerk := h.AsUser(9, "clerk", "view_stock", "create_document", "post_document")
+	w = clerk.PerformRequestWithHeaders("POST", "/api/v1/stock/documents", payload, withKey("scan-1"))
+	h.Assert.Equal(http.StatusCreated, w.Code)
+	h.Assert.Empty(w.Header().Get("Idempotent-Replayed"))
+	h.Assert.Equal(int64(2), countDocs())
+
+	// 2. Правка устаревшей версии отклоняется с текущей версией в ответе
+	update := models.DocumentUpdateDTO{WarehouseID: &wh.ID, Comment: "первая правка", Items: payload.Items, Version: &created.Version}
+	w = h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/documents/%d", created.ID), update)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var updated models.DocumentDTO
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &updated))
+	h.Assert.Equal(uint(2), updated.Version)
+
+	update.Comment = "вторая правка по старой копии"
+	w = h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/documents/%d", created.ID), update)
+	h.Assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+	var conflict struct {
+		CurrentVersion uint `json:"current_version"`
+	}
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &conflict))
+	h.Assert.Equal(uint(2), conflict.CurrentVersion)
+	h.Assert.Equal("первая правка", h.GetDocument(created.ID).Comment)
+
+	// правка без версии в теле и без If-Match не принимается
+	update.Version = nil
+	w = h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/documents/%d", created.ID), update)
+	h.Assert.Equal(http.StatusPreconditionRequired, w.Code, w.Body.String())
+	h.Assert.Equal("первая правка", h.GetDocument(created.ID).Comment)
+
+	// 3. Проведение по If-Match и повтор проведения с ключом
+	postPath := fmt.Sprintf("/api/v1/stock/documents/%d/post", created.ID)
+	w = h.PerformRequestWithHeaders("POST", postPath, nil, map[string]string{"If-Match": "1"})
+	h.Assert.Equal(http.StatusConflict, w.Code)
+	w = h.PerformRequestWithHeaders("POST", postPath, nil, map[string]string{"If-Match": "abc"})
+	h.Assert.Equal(http.StatusBadRequest, w.Code)
+	w = h.PerformRequest("POST", postPath, nil)
+	h.Assert.Equal(http.StatusPreconditionRequired, w.Code, "posting without a version is refused")
+	h.Assert.Equal("draft", h.GetDocument(created.ID).Status)
+
+	headers := map[string]string{"If-Match": `"2"`, "Idempotency-Key": "post-1"}
+	w = h.PerformRequestWithHeaders("POST", postPath, nil, headers)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	retry = h.PerformRequestWithHeaders("POST", postPath, nil, headers)
+	h.Assert.Equal(http.StatusOK, retry.Code, "retry is replayed instead of failing as already posted")
+	h.Assert.Equal("true", retry.Header().Get("Idempotent-Replayed"))
+
+	doc := h.GetDocument(created.ID)
+	h.Assert.Equal("posted", doc.Status)
+	h.Assert.Equal(uint(3), doc.Version)
+	balance := findBalance(h.GetBalances(wh.ID), variant.ID)
+	h.Assert.True(decimal.NewFromInt(5).Equal(balance.Quantity), balance.Quantity.String())
+
+	// 4. Ошибки сервера не сохраняются: повтор с тем же ключом выполняется заново
+	cancelPath := fmt.Sprintf("/api/v1/stock/documents/%d/cancel", created.ID)
+	w = h.PerformRequestWithHeaders("POST", cancelPath, nil, withKey("cancel-1"))
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	w = h.PerformRequestWithHeaders("POST", cancelPath, nil, withKey("cancel-2"))
+	h.Assert.Equal(http.StatusInternalServerError, w.Code)
+	var stored int64
+	db.Model(&models.IdempotencyRecord{}).Where("key = ?", "cancel-2").Count(&stored)
+	h.Assert.Zero(stored)
+
+	// 5. Паника в обработчике освобождает ключ: повтор выполняется заново, а не получает 409
+	panicking := true
+	engine := gin.New()
+	engine.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, _ any) { c.AbortWithStatus(http.StatusInternalServerError) }))
+	engine.Use(handler.Idempotent(service.NewIdempotencyService(repository.NewIdempotencyRepository(db), time.Hour)))
+	engine.POST("/flaky", func(c *gin.Context) {
+		if panicking {
+			panic("handler crashed")
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	flaky := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/flaky", nil)
+		req.Header.Set("Idempotency-Key", "panic-1")
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+	h.Assert.Equal(http.StatusInternalServerError, flaky().Code)
+	panicking = false
+	w = flaky()
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	h.Assert.Empty(w.Header().Get("Idempotent-Replayed"))
+
+	// 6. Ключ, брошенный упавшим процессом, держится только на время аренды, а не весь срок хранения
+	keys := service.NewIdempotencyService(repository.NewIdempotencyRepository(db), 24*time.Hour)
+	stuck, _, err := keys.Begin(1, "stuck-1", "fp")
+	h.Assert.NoError(err)
+	_, _, err = keys.Begin(1, "stuck-1", "fp")
+	h.Assert.ErrorIs(err, service.ErrIdempotencyInProgress)
+
+	h.Assert.NoError(db.Model(&models.IdempotencyRecord{}).Where("id = ?", stuck.ID).
+		Update("created_at", time.Now().Add(-10*time.Minute)).Error)
+	taken, replay, err := keys.Begin(1, "stuck-1", "fp")
+	h.Assert.NoError(err)
+	h.Assert.False(replay)
+	h.Assert.NotEqual(stuck.ID, taken.ID)
+}
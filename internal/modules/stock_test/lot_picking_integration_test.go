@@ -0,0 +1,92 @@
+package stocktest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/config"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+func TestLotPickingOrder_Integration(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	expiry := func(days int) *time.Time {
+		d := now.AddDate(0, 0, days)
+		return &d
+	}
+
+	// Три партии: старая с дальним сроком, средняя без срока, свежая с ближайшим сроком.
+	incomes := []struct {
+		batch   string
+		arrival time.Time
+		expiry  *time.Time
+	}{
+		{"B-OLD", now.AddDate(0, 0, -10), expiry(60)},
+		{"B-NOEXP", now.AddDate(0, 0, -5), nil},
+		{"B-NEW", now.AddDate(0, 0, -1), expiry(7)},
+	}
+
+	cases := []struct {
+		policy string
+		want   string
+	}{
+		{"fifo", "B-OLD"},
+		{"lifo", "B-NEW"},
+		{"fefo", "B-NEW"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			_, db := setupTestRouter("lot_picking_" + tc.policy)
+			sqlDB, _ := db.DB()
+			defer sqlDB.Close()
+			assert := require.New(t)
+
+			factory := service.NewStrategyFactory(
+				repository.NewBalanceRepository(db), repository.NewStockMovementRepository(db), repository.NewLotRepository(db),
+				repository.NewVariantRepository(db), repository.NewProductRepository(db), repository.NewTransitRepository(db),
+			)
+			strategy, err := factory.GetStrategy(tc.policy)
+			assert.NoError(err)
+			cfg := &config.Config{AccountingPolicy: tc.policy}
+
+			wh := models.Warehouse{Name: "Склад"}
+			assert.NoError(db.Create(&wh).Error)
+			variant := models.Variant{ProductID: 1, SKU: "LOT-" + tc.policy}
+			assert.NoError(db.Create(&variant).Error)
+
+			for i, in := range incomes {
+				doc := &models.Document{
+					Type: "INCOME", Number: fmt.Sprintf("IN-%d", i), WarehouseID: &wh.ID, CreatedAt: in.arrival,
+					Items: []models.DocumentItem{{
+						VariantID: variant.ID, Quantity: decimal.NewFromInt(5), Price: decimalPtr(decimal.NewFromInt(100)),
+						BatchNumber: in.batch, ExpiryDate: in.expiry,
+					}},
+				}
+				assert.NoError(db.Create(doc).Error)
+				assert.NoError(strategy.ProcessIncome(db, doc, cfg))
+			}
+
+			outcome := &models.Document{
+				Type: "OUTCOME", Number: "OUT-1", WarehouseID: &wh.ID,
+				Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(3)}},
+			}
+			assert.NoError(db.Create(outcome).Error)
+			assert.NoError(strategy.ProcessOutcome(db, outcome, cfg))
+
+			var mv models.StockMovement
+			assert.NoError(db.Where("document_id = ? AND type = ?", outcome.ID, "OUTCOME").First(&mv).Error)
+			assert.NotNil(mv.SourceLotID)
+
+			var lot models.StockLot
+			assert.NoError(db.First(&lot, *mv.SourceLotID).Error)
+			assert.Equal(tc.want, lot.BatchNumber, "Политика %s должна списать партию %s", tc.policy, tc.want)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestLotsAndRecallTrace_Integration(t *testing.T) {
+	router, db := setupTestRouter("lots_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Склад скоропорта")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "MILK-1L"})
+	customer := h.CreateCounterparty(gin.H{"name": "ООО Покупатель"})
+
+	produced := time.Now().AddDate(0, 0, -2).Truncate(time.Second)
+	soon := time.Now().AddDate(0, 0, 5).Truncate(time.Second)
+	later := time.Now().AddDate(0, 2, 0).Truncate(time.Second)
+
+	// 1. Две партии с разными сроками годности
+	incomeDoc := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{
+			{VariantID: variant.ID, Quantity: decimal.NewFromInt(10), Price: decimalPtr(decimal.NewFromInt(50)),
+				BatchNumber: "B-001", ProductionDate: &produced, ExpiryDate: &soon},
+		},
+	})
+	h.PostDocument(incomeDoc.ID)
+	incomeDoc2 := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{
+			{VariantID: variant.ID, Quantity: decimal.NewFromInt(10), Price: decimalPtr(decimal.NewFromInt(55)),
+				BatchNumber: "B-002", ExpiryDate: &later},
+		},
+	})
+	h.PostDocument(incomeDoc2.ID)
+
+	// 2. Фильтр "истекает в течение 7 дней" возвращает только первую партию
+	w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/lots?warehouse_id=%d&expires_within_days=7", wh.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var lots []models.LotDTO
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &lots))
+	h.Assert.Len(lots, 1)
+	h.Assert.Equal("B-001", lots[0].BatchNumber)
+	h.Assert.Equal("MILK-1L", lots[0].VariantSKU)
+	h.Assert.NotNil(lots[0].ProductionDate)
+
+	// 3. Продажа покупателю списывает из первой партии
+	outcomeDoc := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID, CounterpartyID: &customer.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(4), Price: decimalPtr(decimal.NewFromInt(80))}},
+	})
+	h.PostDocument(outcomeDoc.ID)
+
+	// 4. Трассировка партии показывает, кому ушёл товар
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/lots/%d/trace", lots[0].ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var trace models.LotTraceDTO
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &trace))
+	h.Assert.Equal(lots[0].ID, trace.Lot.ID)
+
+	var sale *models.LotTraceMovementDTO
+	for i := range trace.Movements {
+		if trace.Movements[i].Type == "OUTCOME" {
+			sale = &trace.Movements[i]
+		}
+	}
+	h.Assert.NotNil(sale, "В трассировке должна быть продажа")
+	h.Assert.Equal("ООО Покупатель", sale.CounterpartyName)
+	h.Assert.True(decimal.NewFromInt(-4).Equal(sale.Quantity))
+}
@@ -0,0 +1,160 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestOrderFulfillment_Integration(t *testing.T) {
+	router, db := setupTestRouter("order_fulfillment_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	customer := h.CreateCounterparty(gin.H{"name": "ООО Покупатель"})
+	shirt := h.CreateVariant(gin.H{"product_id": 1, "sku": "FUL-SHIRT"})
+	socks := h.CreateVariant(gin.H{"product_id": 1, "sku": "FUL-SOCKS"})
+	hat := h.CreateVariant(gin.H{"product_id": 1, "sku": "FUL-CAP"})
+
+	income := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{
+			{VariantID: shirt.ID, Quantity: decimal.NewFromInt(50), Price: decimalPtr(decimal.NewFromInt(100))},
+			{VariantID: hat.ID, Quantity: decimal.NewFromInt(50), Price: decimalPtr(decimal.NewFromInt(40))},
+		},
+	})
+	h.PostDocument(income.ID)
+
+	order := h.CreateDocument(models.Document{
+		Type: "ORDER", WarehouseID: &wh.ID, CounterpartyID: &customer.ID, Comment: "Заказ к выставке",
+		Items: []models.DocumentItem{
+			{VariantID: shirt.ID, Quantity: decimal.NewFromInt(10), Price: decimalPtr(decimal.NewFromInt(250))},
+			{VariantID: hat.ID, Quantity: decimal.NewFromInt(4), Price: decimalPtr(decimal.NewFromInt(90))},
+		},
+	})
+
+	createBased := func(baseID uint, docType string) *httptest.ResponseRecorder {
+		return h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/create-based", baseID), gin.H{"type": docType})
+	}
+	fulfillment := func() models.OrderFulfillmentDTO {
+		w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/%d/fulfillment", order.ID), nil)
+		h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+		var dto models.OrderFulfillmentDTO
+		h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &dto))
+		return dto
+	}
+
+	// 1. Черновик заказа не может быть основанием, недопустимые пары типов отклоняются
+	h.Assert.Equal(http.StatusBadRequest, createBased(order.ID, "OUTCOME").Code)
+	h.PostDocument(order.ID)
+	h.Assert.Equal(http.StatusBadRequest, createBased(order.ID, "INCOME").Code)
+	h.Assert.Equal("open", fulfillment().Status)
+
+	// 2. Расход на основании заказа копирует шапку и строки
+	res := createBased(order.ID, "outcome")
+	h.Assert.Equal(http.StatusCreated, res.Code, res.Body.String())
+	var first models.Document
+	h.Assert.NoError(json.Unmarshal(res.Body.Bytes(), &first))
+	h.Assert.Equal("OUTCOME", first.Type)
+	h.Assert.Equal("draft", first.Status)
+	h.Assert.Equal(order.ID, *first.BaseDocumentID)
+	h.Assert.Equal(customer.ID, *first.CounterpartyID)
+	h.Assert.Equal("Заказ к выставке", first.Comment)
+	h.Assert.Len(first.Items, 2)
+
+	// 3. Частичная отгрузка: 6 футболок из 10, кепки не отгружаем
+	h.UpdateDocument(first.ID, models.DocumentUpdateDTO{
+		WarehouseID: &wh.ID, CounterpartyID: &customer.ID,
+		Items: []models.DocumentItem{{VariantID: shirt.ID, Quantity: decimal.NewFromInt(6), Price: decimalPtr(decimal.NewFromInt(250))}},
+	})
+	h.PostDocument(first.ID)
+
+	dto := fulfillment()
+	h.Assert.Equal("partially_shipped", dto.Status)
+	h.Assert.True(decimal.NewFromInt(6).Equal(dto.Lines[0].Shipped))
+	h.Assert.True(decimal.NewFromInt(4).Equal(dto.Lines[0].Remaining))
+	h.Assert.True(decimal.Zero.Equal(dto.Lines[1].Shipped))
+	h.Assert.Len(dto.Shipments, 1)
+	h.Assert.Equal("partially_shipped", h.GetDocument(order.ID).FulfillmentStatus)
+
+	// 4. Отгрузить сверх заказанного нельзя
+	over := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID, BaseDocumentID: &order.ID,
+		Items: []models.DocumentItem{{VariantID: shirt.ID, Quantity: decimal.NewFromInt(5), Price: decimalPtr(decimal.NewFromInt(250))}},
+	})
+	w := h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", over.ID), nil, h.IfMatch(over.ID))
+	h.Assert.Equal(http.StatusInternalServerError, w.Code)
+	h.Assert.Contains(w.Body.String(), "over-shipping")
+	h.Assert.Equal("draft", h.GetDocument(over.ID).Status)
+
+	foreign := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID, BaseDocumentID: &order.ID,
+		Items: []models.DocumentItem{{VariantID: socks.ID, Quantity: decimal.NewFromInt(1)}},
+	})
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", foreign.ID), nil, h.IfMatch(foreign.ID))
+	h.Assert.Contains(w.Body.String(), "is not in order")
+
+	// 5. Второй расход на основании берёт только остаток и закрывает заказ
+	res = createBased(order.ID, "OUTCOME")
+	h.Assert.Equal(http.StatusCreated, res.Code, res.Body.String())
+	var second models.Document
+	h.Assert.NoError(json.Unmarshal(res.Body.Bytes(), &second))
+	h.Assert.Len(second.Items, 2)
+	h.Assert.True(decimal.NewFromInt(4).Equal(second.Items[0].Quantity))
+	h.Assert.True(decimal.NewFromInt(4).Equal(second.Items[1].Quantity))
+	h.PostDocument(second.ID)
+
+	h.Assert.Equal("fulfilled", fulfillment().Status)
+	h.Assert.Equal(http.StatusBadRequest, createBased(order.ID, "OUTCOME").Code, "заказ выполнен полностью")
+
+	// 6. Заказ с отгрузками не отменяется; отмена расхода возвращает заказ в работу
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/cancel", order.ID), nil)
+	h.Assert.NotEqual(http.StatusOK, w.Code)
+
+	h.CancelDocument(second.ID)
+	dto = fulfillment()
+	h.Assert.Equal("partially_shipped", dto.Status)
+	h.Assert.True(decimal.NewFromInt(6).Equal(dto.Lines[0].Shipped))
+	h.Assert.True(decimal.Zero.Equal(dto.Lines[1].Shipped))
+
+	h.CancelDocument(first.ID)
+	h.Assert.Equal("open", fulfillment().Status)
+	h.CancelDocument(order.ID)
+
+	// 7. Расход на остаток частично отгруженного серийного заказа - без номеров: часть из них уже отгружена
+	phone := h.CreateVariant(gin.H{"product_id": 1, "sku": "FUL-PHONE", "track_serials": true})
+	serials := []string{"FUL-SN-1", "FUL-SN-2", "FUL-SN-3"}
+	h.PostDocument(h.CreateDocument(models.Document{Type: "INCOME", WarehouseID: &wh.ID, Items: []models.DocumentItem{
+		{VariantID: phone.ID, Quantity: decimal.NewFromInt(3), Price: decimalPtr(decimal.NewFromInt(300)), SerialNumbers: serials},
+	}}).ID)
+	phoneOrder := h.CreateDocument(models.Document{Type: "ORDER", WarehouseID: &wh.ID, CounterpartyID: &customer.ID, Items: []models.DocumentItem{
+		{VariantID: phone.ID, Quantity: decimal.NewFromInt(3), SerialNumbers: serials},
+	}})
+	h.PostDocument(phoneOrder.ID)
+
+	res = createBased(phoneOrder.ID, "OUTCOME")
+	h.Assert.Equal(http.StatusCreated, res.Code, res.Body.String())
+	var whole models.Document
+	h.Assert.NoError(json.Unmarshal(res.Body.Bytes(), &whole))
+	h.Assert.Equal(serials, whole.Items[0].SerialNumbers, "untouched line keeps its serial numbers")
+	h.DeleteDocument(whole.ID)
+
+	h.PostDocument(h.CreateDocument(models.Document{Type: "OUTCOME", WarehouseID: &wh.ID, BaseDocumentID: &phoneOrder.ID, Items: []models.DocumentItem{
+		{VariantID: phone.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"FUL-SN-1"}},
+	}}).ID)
+	res = createBased(phoneOrder.ID, "OUTCOME")
+	h.Assert.Equal(http.StatusCreated, res.Code, res.Body.String())
+	var rest models.Document
+	h.Assert.NoError(json.Unmarshal(res.Body.Bytes(), &rest))
+	h.Assert.True(decimal.NewFromInt(2).Equal(rest.Items[0].Quantity))
+	h.Assert.Empty(rest.Items[0].SerialNumbers)
+}
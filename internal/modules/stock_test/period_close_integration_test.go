@@ -0,0 +1,124 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/audit"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestPeriodClose_Integration(t *testing.T) {
+	router, db := setupTestRouter("period_close_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	central := h.CreateWarehouse("Основной")
+	shop := h.CreateWarehouse("Магазин")
+	unit := h.CreateUnit("шт")
+	category := h.CreateCategory("Бакалея")
+	product := h.CreateProduct(gin.H{"name": "Гречка", "category_id": category.ID})
+	variant := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "PC-1", "unit_id": unit.ID})
+
+	now := time.Now()
+	day := func(offset int) time.Time { return now.AddDate(0, 0, offset) }
+	draft := func(docType string, wh uint, date time.Time, qty, price int64) models.Document {
+		return h.CreateDocument(models.Document{
+			Type: docType, WarehouseID: &wh, DocumentDate: date,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty), Price: decimalPtr(decimal.NewFromInt(price))}},
+		})
+	}
+	closePeriod := func(wh *uint, date time.Time) (*http.Response, models.PeriodClose) {
+		w := h.PerformRequest("POST", "/api/v1/stock/periods", gin.H{"warehouse_id": wh, "lock_date": date, "comment": "Месяц сдан"})
+		var period models.PeriodClose
+		if w.Code == http.StatusCreated {
+			h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &period))
+		}
+		return w.Result(), period
+	}
+
+	h.PostDocument(draft("INCOME", central.ID, day(-40), 10, 10).ID)
+	sale := draft("OUTCOME", central.ID, day(-35), 3, 15)
+	h.PostDocument(sale.ID)
+	h.PostDocument(draft("INCOME", shop.ID, day(-38), 4, 12).ID)
+	h.PostDocument(draft("INCOME", central.ID, day(-20), 5, 20).ID)
+
+	// 1. Закрытие склада сохраняет остатки и оценку на конец периода
+	resp, closed := closePeriod(&central.ID, day(-30))
+	h.Assert.Equal(http.StatusCreated, resp.StatusCode)
+	w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/periods/%d", closed.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code)
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &closed))
+	h.Assert.Equal("closed", closed.Status)
+	h.Assert.Len(closed.Balances, 1)
+	h.Assert.Equal(central.ID, closed.Balances[0].WarehouseID)
+	h.Assert.True(decimal.NewFromInt(7).Equal(closed.Balances[0].Quantity), closed.Balances[0].Quantity.String())
+	h.Assert.True(decimal.NewFromInt(70).Equal(closed.Balances[0].Value), closed.Balances[0].Value.String())
+
+	resp, _ = closePeriod(&central.ID, day(-31))
+	h.Assert.Equal(http.StatusBadRequest, resp.StatusCode, "closing earlier than the current lock")
+	resp, _ = closePeriod(nil, day(1))
+	h.Assert.Equal(http.StatusBadRequest, resp.StatusCode, "closing a future date")
+
+	// 2. Документы закрытого периода нельзя проводить, отменять, править и удалять, включая сам день закрытия
+	late := draft("INCOME", central.ID, day(-30), 1, 10)
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", late.ID), nil, h.IfMatch(late.ID))
+	h.Assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/cancel", sale.ID), nil)
+	h.Assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+	w = h.PerformRequestWithHeaders("PUT", fmt.Sprintf("/api/v1/stock/documents/%d", late.ID), models.DocumentUpdateDTO{WarehouseID: &central.ID}, h.IfMatch(late.ID))
+	h.Assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+	w = h.PerformRequest("DELETE", fmt.Sprintf("/api/v1/stock/documents/%d", late.ID), nil)
+	h.Assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+
+	current := draft("INCOME", central.ID, day(-5), 1, 10)
+	back := day(-33)
+	w = h.PerformRequestWithHeaders("PUT", fmt.Sprintf("/api/v1/stock/documents/%d", current.ID), models.DocumentUpdateDTO{WarehouseID: &central.ID, DocumentDate: &back}, h.IfMatch(current.ID))
+	h.Assert.Equal(http.StatusConflict, w.Code, "moving a document into the closed period")
+	h.PostDocument(current.ID)
+
+	// 3. Другой склад закрытие не затрагивает, пока не закрыт общий период
+	h.PostDocument(draft("INCOME", shop.ID, day(-32), 1, 12).ID)
+	resp, global := closePeriod(nil, day(-31))
+	h.Assert.Equal(http.StatusCreated, resp.StatusCode)
+	blocked := draft("INCOME", shop.ID, day(-32), 1, 12)
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", blocked.ID), nil, h.IfMatch(blocked.ID))
+	h.Assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+
+	// 4. Переоткрытие - отдельное право, причина обязательна, снимается только последнее закрытие
+	manager := h.AsUser(7, "manager", "view_stock", "close_period")
+	w = manager.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/periods/%d/reopen", closed.ID), gin.H{"reason": "Исправление"})
+	h.Assert.Equal(http.StatusForbidden, w.Code)
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/periods/%d/reopen", closed.ID), gin.H{})
+	h.Assert.Equal(http.StatusBadRequest, w.Code)
+
+	resp, newer := closePeriod(&central.ID, day(-25))
+	h.Assert.Equal(http.StatusCreated, resp.StatusCode)
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/periods/%d/reopen", closed.ID), gin.H{"reason": "Исправление"})
+	h.Assert.Equal(http.StatusBadRequest, w.Code, "only the latest close can be reopened")
+
+	for _, id := range []uint{newer.ID, closed.ID, global.ID} {
+		w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/periods/%d/reopen", id), gin.H{"reason": "Исправление ошибки в приходе"})
+		h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	}
+	h.CancelDocument(sale.ID)
+
+	var reopened models.PeriodClose
+	h.Assert.NoError(db.First(&reopened, closed.ID).Error)
+	h.Assert.Equal("reopened", reopened.Status)
+	h.Assert.Equal("Исправление ошибки в приходе", reopened.ReopenNote)
+	h.Assert.NotNil(reopened.ReopenedBy)
+
+	h.Assert.Eventually(func() bool {
+		var count int64
+		db.Model(&audit.AuditLog{}).Where("action = ? AND entity = ? AND entity_id = ?", "REOPEN_PERIOD", "period_close", closed.ID).Count(&count)
+		return count == 1
+	}, 2*time.Second, 20*time.Millisecond)
+}
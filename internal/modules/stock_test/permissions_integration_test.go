@@ -0,0 +1,168 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/bootstrap"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/users"
+)
+
+func TestStockPermissions_Integration(t *testing.T) {
+	router, db := setupTestRouter("permissions_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	admin := NewTestHelper(t, router)
+
+	whA := admin.CreateWarehouse("Склад А")
+	whB := admin.CreateWarehouse("Склад Б")
+	variant := admin.CreateVariant(gin.H{"product_id": 1, "sku": "PERM-1"})
+
+	worker := admin.AsUser(2, "worker", "view_stock", "create_document", "post_document:INCOME", fmt.Sprintf("warehouse:%d", whA.ID))
+	viewer := admin.AsUser(3, "viewer", "view_stock")
+
+	income := func(whID uint) models.Document {
+		return models.Document{
+			Type: "INCOME", WarehouseID: &whID,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(5), Price: decimalPtr(decimal.NewFromInt(10))}},
+		}
+	}
+
+	// 1. Без токена доступа нет, с view_stock можно только читать
+	anonymous := admin.AsUser(0, "")
+	anonymous.Token = ""
+	w := anonymous.PerformRequest("GET", "/api/v1/stock/warehouses", nil)
+	admin.Assert.Equal(http.StatusUnauthorized, w.Code)
+
+	w = viewer.PerformRequest("GET", "/api/v1/stock/warehouses", nil)
+	admin.Assert.Equal(http.StatusOK, w.Code)
+	w = viewer.PerformRequest("POST", "/api/v1/stock/documents", income(whA.ID))
+	admin.Assert.Equal(http.StatusForbidden, w.Code)
+	w = viewer.PerformRequest("POST", "/api/v1/stock/warehouses", gin.H{"name": "Новый"})
+	admin.Assert.Equal(http.StatusForbidden, w.Code, "справочники требуют manage_directories")
+
+	// 2. Кладовщик склада А создаёт и проводит приход на своём складе
+	doc := worker.CreateDocument(income(whA.ID))
+	worker.PostDocument(doc.ID)
+
+	// 3. Чужой склад недоступен
+	w = worker.PerformRequest("POST", "/api/v1/stock/documents", income(whB.ID))
+	admin.Assert.Equal(http.StatusForbidden, w.Code, w.Body.String())
+
+	foreign := admin.CreateDocument(income(whB.ID))
+	w = worker.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", foreign.ID), nil, admin.IfMatch(foreign.ID))
+	admin.Assert.Equal(http.StatusForbidden, w.Code)
+	admin.Assert.Equal("draft", admin.GetDocument(foreign.ID).Status)
+
+	// 4. Право проводить ограничено типом документа, отмены нет вовсе
+	outcome := worker.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &whA.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), Price: decimalPtr(decimal.NewFromInt(20))}},
+	})
+	w = worker.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", outcome.ID), nil, admin.IfMatch(outcome.ID))
+	admin.Assert.Equal(http.StatusForbidden, w.Code)
+	admin.Assert.Contains(w.Body.String(), "post_document:OUTCOME")
+
+	w = worker.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/cancel", doc.ID), nil)
+	admin.Assert.Equal(http.StatusForbidden, w.Code)
+
+	// 5. Администратор может всё
+	admin.PostDocument(outcome.ID)
+	admin.CancelDocument(outcome.ID)
+
+	// 6. Чтение тоже ограничено складами: документы, остатки, партии и движения склада Б не видны
+	admin.PostDocument(foreign.ID)
+	w = worker.PerformRequest("GET", "/api/v1/stock/documents", nil)
+	admin.Assert.Equal(http.StatusOK, w.Code)
+	var docs []models.DocumentDTO
+	admin.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &docs))
+	admin.Assert.NotEmpty(docs)
+	for _, d := range docs {
+		admin.Assert.Equal(whA.Name, d.WarehouseName)
+	}
+	for _, path := range []string{"", "/history", "/fulfillment", "/print"} {
+		w = worker.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/%d%s", foreign.ID, path), nil)
+		admin.Assert.Equal(http.StatusForbidden, w.Code, path)
+	}
+	w = worker.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/%d", doc.ID), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code)
+
+	w = worker.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/balances/warehouse/%d", whB.ID), nil)
+	admin.Assert.Equal(http.StatusForbidden, w.Code)
+	w = worker.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/balances/warehouse/%d", whA.ID), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code)
+
+	var lots []models.LotDTO
+	w = worker.PerformRequest("GET", "/api/v1/stock/lots", nil)
+	admin.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &lots))
+	admin.Assert.NotEmpty(lots)
+	for _, lot := range lots {
+		admin.Assert.Equal(whA.ID, lot.WarehouseID)
+	}
+	var movements []models.StockMovementDTO
+	w = worker.PerformRequest("GET", "/api/v1/stock/movements", nil)
+	admin.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &movements))
+	admin.Assert.NotEmpty(movements)
+	for _, mv := range movements {
+		admin.Assert.Equal(whA.ID, mv.WarehouseID)
+	}
+
+	// 7. Перемещение на чужой склад провести нельзя: оно приходует товар на склад-получатель
+	mover := admin.AsUser(4, "worker", "view_stock", "create_document", "post_document:TRANSFER", fmt.Sprintf("warehouse:%d", whA.ID))
+	transfer := mover.CreateDocument(models.Document{
+		Type: "TRANSFER", WarehouseID: &whA.ID, ToWarehouseID: &whB.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1)}},
+	})
+	w = mover.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", transfer.ID), nil, admin.IfMatch(transfer.ID))
+	admin.Assert.Equal(http.StatusForbidden, w.Code)
+	admin.Assert.Equal("draft", admin.GetDocument(transfer.ID).Status)
+
+	// 8. Сеанс сканирования и фоновая задача чужого склада и чужого пользователя не видны
+	w = admin.PerformRequest("POST", "/api/v1/stock/scan-sessions", gin.H{"document_type": "INCOME", "warehouse_id": whB.ID})
+	admin.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	var session models.ScanSession
+	admin.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &session))
+	w = worker.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/scan-sessions/%d", session.ID), nil)
+	admin.Assert.Equal(http.StatusForbidden, w.Code)
+	var sessions []models.ScanSession
+	w = worker.PerformRequest("GET", "/api/v1/stock/scan-sessions", nil)
+	admin.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &sessions))
+	admin.Assert.Empty(sessions)
+
+	w = admin.PerformRequest("POST", "/api/v1/stock/documents/bulk", gin.H{"action": "post", "document_ids": []uint{transfer.ID}})
+	admin.Assert.Equal(http.StatusAccepted, w.Code, w.Body.String())
+	var job models.BulkJobDTO
+	admin.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &job))
+	w = worker.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/bulk/%d", job.ID), nil)
+	admin.Assert.Equal(http.StatusNotFound, w.Code)
+	w = admin.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/bulk/%d", job.ID), nil)
+	admin.Assert.Equal(http.StatusOK, w.Code)
+}
+
+func TestBootstrapUpgradesRolePermissions_Integration(t *testing.T) {
+	router, db := setupTestRouter("bootstrap_roles_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	// база, установленная до проверки прав: у ролей только прежние права, одно выдано вручную
+	db.Create(&users.Role{Name: "worker", Permissions: users.Permissions{"view_dashboard", "view_stock", "create_document", "warehouse:7"}})
+	db.Create(&users.Role{Name: "manager", Permissions: users.Permissions{"view_dashboard", "view_stock", "view_reports", "create_document"}})
+
+	bootstrap.Run(db)
+	bootstrap.Run(db)
+
+	role := func(name string) users.Role {
+		var r users.Role
+		h.Assert.NoError(db.Where("name = ?", name).First(&r).Error)
+		return r
+	}
+	h.Assert.Equal(users.Permissions{"view_dashboard", "view_stock", "create_document", "warehouse:7", "post_document"}, role("worker").Permissions)
+	h.Assert.Subset(role("manager").Permissions, []string{"approve_document", "post_document", "cancel_document", "manage_directories", "close_period"})
+}
@@ -0,0 +1,75 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestPriceUpdateCancel_Integration(t *testing.T) {
+	router, db := setupTestRouter("price_cancel_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	retail := h.CreatePriceType("Розница")
+	variantA := h.CreateVariant(gin.H{"product_id": 1, "sku": "PRICE-A"})
+	variantB := h.CreateVariant(gin.H{"product_id": 1, "sku": "PRICE-B"})
+
+	newPriceDoc := func(items ...models.DocumentItem) models.Document {
+		doc := h.CreateDocument(models.Document{Type: "PRICE_UPDATE", PriceTypeID: &retail.ID, Items: items})
+		h.PostDocument(doc.ID)
+		return doc
+	}
+
+	first := newPriceDoc(models.DocumentItem{VariantID: variantA.ID, Price: decimalPtr(decimal.NewFromInt(100))})
+	second := newPriceDoc(
+		models.DocumentItem{VariantID: variantA.ID, Price: decimalPtr(decimal.NewFromInt(120))},
+		models.DocumentItem{VariantID: variantB.ID, Price: decimalPtr(decimal.NewFromInt(50))},
+	)
+	third := newPriceDoc(models.DocumentItem{VariantID: variantB.ID, Price: decimalPtr(decimal.NewFromInt(55))})
+
+	// 1. Цену B уже поменял третий документ - отмена второго отклоняется со списком конфликтов
+	w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/cancel", second.ID), nil)
+	h.Assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+	var resp struct {
+		Conflicts []models.PriceConflictDTO `json:"conflicts"`
+	}
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	h.Assert.Len(resp.Conflicts, 1)
+	h.Assert.Equal(variantB.ID, resp.Conflicts[0].VariantID)
+	h.Assert.Equal(third.ID, resp.Conflicts[0].DocumentID)
+	h.Assert.Equal(third.Number, resp.Conflicts[0].DocumentNumber)
+	h.Assert.True(decimal.NewFromInt(120).Equal(h.GetPrice(variantA.ID, retail.ID).Price))
+
+	// исправление второго отклоняется так же: перепроведение начинается с отмены его цен
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/correct", second.ID), models.DocumentUpdateDTO{
+		PriceTypeID: &retail.ID, Items: []models.DocumentItem{{VariantID: variantA.ID, Price: decimalPtr(decimal.NewFromInt(125))}},
+	}, h.IfMatch(second.ID))
+	h.Assert.Equal(http.StatusConflict, w.Code, w.Body.String())
+	resp.Conflicts = nil
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	h.Assert.Len(resp.Conflicts, 1)
+	h.Assert.Equal(third.ID, resp.Conflicts[0].DocumentID)
+	h.Assert.True(decimal.NewFromInt(120).Equal(h.GetPrice(variantA.ID, retail.ID).Price))
+
+	// 2. После отмены третьего отмена второго проходит и возвращает прежние цены
+	h.CancelDocument(third.ID)
+	h.Assert.True(decimal.NewFromInt(50).Equal(h.GetPrice(variantB.ID, retail.ID).Price))
+
+	h.CancelDocument(second.ID)
+	h.Assert.True(decimal.NewFromInt(100).Equal(h.GetPrice(variantA.ID, retail.ID).Price))
+
+	// 3. Цены B до второго документа не было - она удаляется
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/prices?item_id=%d&price_type_id=%d", variantB.ID, retail.ID), nil)
+	h.Assert.Equal(http.StatusNotFound, w.Code)
+
+	h.CancelDocument(first.ID)
+	h.Assert.Equal("canceled", h.GetDocument(first.ID).Status)
+}
@@ -0,0 +1,78 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestScheduledPrices_Integration(t *testing.T) {
+	router, db := setupTestRouter("price_schedule_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	retail := h.CreatePriceType("Розница")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "PROMO-1"})
+
+	priceOn := func(date time.Time) (int, models.ItemPrice) {
+		path := fmt.Sprintf("/api/v1/stock/prices?item_id=%d&price_type_id=%d&date=%s",
+			variant.ID, retail.ID, date.Format("2006-01-02"))
+		w := h.PerformRequest("GET", path, nil)
+		var price models.ItemPrice
+		json.Unmarshal(w.Body.Bytes(), &price)
+		return w.Code, price
+	}
+
+	// 1. Базовая цена с сегодняшнего дня и акция через 10 дней на 10 дней
+	base := h.CreateDocument(models.Document{
+		Type: "PRICE_UPDATE", PriceTypeID: &retail.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Price: decimalPtr(decimal.NewFromInt(100))}},
+	})
+	h.PostDocument(base.ID)
+
+	promoFrom := time.Now().AddDate(0, 0, 10)
+	promoTo := time.Now().AddDate(0, 0, 20)
+	promo := h.CreateDocument(models.Document{
+		Type: "PRICE_UPDATE", PriceTypeID: &retail.ID, EffectiveFrom: &promoFrom, EffectiveTo: &promoTo,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Price: decimalPtr(decimal.NewFromInt(80))}},
+	})
+	h.PostDocument(promo.ID)
+
+	// 2. Запланированная акция не меняет текущую цену
+	h.Assert.True(decimal.NewFromInt(100).Equal(h.GetPrice(variant.ID, retail.ID).Price))
+
+	code, price := priceOn(time.Now().AddDate(0, 0, 15))
+	h.Assert.Equal(http.StatusOK, code)
+	h.Assert.True(decimal.NewFromInt(80).Equal(price.Price), "в период акции действует акционная цена")
+
+	code, price = priceOn(time.Now().AddDate(0, 0, 25))
+	h.Assert.Equal(http.StatusOK, code)
+	h.Assert.True(decimal.NewFromInt(100).Equal(price.Price), "после акции возвращается базовая цена")
+
+	code, _ = priceOn(time.Now().AddDate(0, 0, -5))
+	h.Assert.Equal(http.StatusNotFound, code, "до первой цены цены нет")
+
+	// 3. История содержит обе записи
+	w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/prices/history?item_id=%d&price_type_id=%d", variant.ID, retail.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code)
+	var history []models.ItemPriceHistory
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &history))
+	h.Assert.Len(history, 2)
+	h.Assert.True(history[0].Applied)
+	h.Assert.False(history[1].Applied)
+	h.Assert.NotNil(history[1].EffectiveTo)
+
+	// 4. Отмена акции не трогает базовую цену
+	h.CancelDocument(promo.ID)
+	code, price = priceOn(time.Now().AddDate(0, 0, 15))
+	h.Assert.Equal(http.StatusOK, code)
+	h.Assert.True(decimal.NewFromInt(100).Equal(price.Price))
+}
@@ -0,0 +1,143 @@
+package stocktest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/reports"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+// pickingList - своя форма, подключаемая из каталога форм без пересборки.
+const pickingList = `
+name: picking_list
+title: "Лист подбора {{.Number}}"
+document_types: [OUTCOME]
+fields:
+  - {label: "Склад:", value: "{{.Warehouse.Name}}"}
+columns:
+  - {title: "Артикул", width: 60, value: "{{.SKU}}"}
+  - {title: "Кол-во", width: 30, align: R, value: "{{qty .Quantity}}", total: "{{qty .TotalQuantity}}"}
+signatures: ["Собрал"]
+`
+
+func TestPrintForms_Integration(t *testing.T) {
+	// модуль читает ./config/stock_config.yml: подставляем шрифты из репозитория и каталог своих форм
+	formsDir := t.TempDir()
+	fonts, _ := filepath.Abs("../../../assets/fonts")
+	if err := os.WriteFile(filepath.Join(formsDir, "picking_list.yml"), []byte(pickingList), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("config", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("config")
+	cfg := fmt.Sprintf("fonts_dir: %q\nprint_forms_dir: %q\norganization_name: \"ООО Печать\"\n", fonts+"/", formsDir)
+	if err := os.WriteFile("config/stock_config.yml", []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	router, db := setupTestRouter("print_forms_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	shop := h.CreateWarehouse("Магазин")
+	customer := h.CreateCounterparty(gin.H{"name": "ООО Покупатель", "address": "г. Москва, ул. Ленина, 1", "phone": "+7 900 000-00-00"})
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "PRN-1"})
+	line := func(qty int64, price string) []models.DocumentItem {
+		p := decimal.RequireFromString(price)
+		return []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty), Price: &p}}
+	}
+	print := func(docID uint, form string) (int, []byte) {
+		path := fmt.Sprintf("/api/v1/stock/documents/%d/print", docID)
+		if form != "" {
+			path += "?form=" + form
+		}
+		w := h.PerformRequest("GET", path, nil)
+		return w.Code, w.Body.Bytes()
+	}
+	isPDF := func(code int, body []byte) {
+		h.Assert.Equal(http.StatusOK, code, string(body))
+		h.Assert.True(bytes.HasPrefix(body, []byte("%PDF")), "response is not a PDF")
+	}
+
+	income := h.CreateDocument(models.Document{Type: "INCOME", WarehouseID: &wh.ID, Items: line(10, "40")})
+	h.PostDocument(income.ID)
+	sale := h.CreateDocument(models.Document{Type: "OUTCOME", WarehouseID: &wh.ID, CounterpartyID: &customer.ID, Items: line(2, "75.50")})
+	h.PostDocument(sale.ID)
+	transfer := h.CreateDocument(models.Document{Type: "TRANSFER", WarehouseID: &wh.ID, ToWarehouseID: &shop.ID, Items: line(3, "40")})
+	count := h.CreateDocument(models.Document{Type: "INVENTORY", WarehouseID: &wh.ID, Items: line(7, "40")})
+
+	// 1. Форма по умолчанию для каждого типа документа
+	for _, doc := range []models.Document{income, sale, transfer, count} {
+		code, body := print(doc.ID, "")
+		isPDF(code, body)
+	}
+
+	// 2. Явно выбранные встроенные формы и своя форма из каталога
+	w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/%d/print?form=waybill", sale.ID), nil)
+	isPDF(w.Code, w.Body.Bytes())
+	h.Assert.Equal("application/pdf", w.Header().Get("Content-Type"))
+	h.Assert.Contains(w.Header().Get("Content-Disposition"), "waybill_")
+	isPDF(print(sale.ID, "invoice"))
+	isPDF(print(sale.ID, "picking_list"))
+
+	// 3. Неизвестная форма, форма не для этого типа и несуществующий документ
+	code, _ := print(sale.ID, "unknown")
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, body := print(sale.ID, "transfer_note")
+	h.Assert.Equal(http.StatusBadRequest, code)
+	h.Assert.Contains(string(body), "waybill")
+	code, _ = print(income.ID, "picking_list")
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, _ = print(999, "")
+	h.Assert.Equal(http.StatusNotFound, code)
+
+	// 4. Печать доступна с правом просмотра
+	viewer := h.AsUser(4, "viewer", "view_stock")
+	w = viewer.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/%d/print", sale.ID), nil)
+	isPDF(w.Code, w.Body.Bytes())
+
+	// 5. Сумма прописью и проверка макетов при загрузке
+	h.Assert.Equal("Сто пятьдесят один рубль 00 копеек", reports.AmountInWords(decimal.RequireFromString("151"), "RUB"))
+	h.Assert.Equal("Две тысячи двадцать два рубля 15 копеек", reports.AmountInWords(decimal.RequireFromString("2022.15"), "RUB"))
+	h.Assert.Equal("Один миллион одиннадцать долларов 01 цент", reports.AmountInWords(decimal.RequireFromString("1000011.01"), "USD"))
+	h.Assert.Equal("Ноль KZT 50", reports.AmountInWords(decimal.RequireFromString("0.5"), "KZT"))
+
+	_, err := reports.ParseFormTemplate([]byte("name: broken\ndocument_types: [INCOME]\ncolumns:\n  - {title: x, width: 10, value: \"{{.Missing\"}\n"))
+	h.Assert.Error(err)
+	_, err = reports.ParseFormTemplate([]byte("name: no_columns\ndocument_types: [INCOME]\n"))
+	h.Assert.Error(err)
+
+	// 6. Строка, введённая в коробках, печатается и в коробках, и в базовой единице
+	w = h.PerformRequest("POST", "/api/v1/stock/units", gin.H{"name": "box", "precision": 0})
+	h.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	var box models.Unit
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &box))
+	w = h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/variants/%d/units", variant.ID), gin.H{"units": []gin.H{{"unit_id": box.ID, "factor": 12}}})
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	boxes := h.CreateDocument(models.Document{Type: "INCOME", WarehouseID: &wh.ID, Items: []models.DocumentItem{
+		{VariantID: variant.ID, UnitID: &box.ID, UnitQuantity: decimalPtr(decimal.NewFromInt(3)), Price: decimalPtr(decimal.NewFromInt(40))},
+	}})
+	h.PostDocument(boxes.ID)
+	boxSale := h.CreateDocument(models.Document{Type: "OUTCOME", WarehouseID: &wh.ID, CounterpartyID: &customer.ID, Items: []models.DocumentItem{
+		{VariantID: variant.ID, UnitID: &box.ID, UnitQuantity: decimalPtr(decimal.NewFromInt(2)), Price: decimalPtr(decimal.NewFromInt(75))},
+	}})
+	for _, doc := range []models.Document{boxes, boxSale} {
+		code, body := print(doc.ID, "")
+		isPDF(code, body)
+		text := pdfText(t, body)
+		h.Assert.Contains(text, fmt.Sprintf("%d box", doc.Items[0].UnitQuantity.IntPart()))
+		h.Assert.Contains(text, doc.Items[0].Quantity.String())
+	}
+}
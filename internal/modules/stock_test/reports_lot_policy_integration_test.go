@@ -0,0 +1,78 @@
+package stocktest
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/reports"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestReportsUnderFEFO_Integration(t *testing.T) {
+	// модуль читает ./config/stock_config.yml: включаем списание по ближайшему сроку годности
+	if err := os.MkdirAll("config", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("config")
+	if err := os.WriteFile("config/stock_config.yml", []byte("accounting_policy: fefo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	router, db := setupTestRouter("reports_fefo_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	unit := h.CreateUnit("шт")
+	category := h.CreateCategory("Молочка")
+	product := h.CreateProduct(gin.H{"name": "Кефир", "category_id": category.ID})
+	variant := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "FEFO-1", "unit_id": unit.ID})
+
+	// 1. Две партии: дальний срок по 100, ближний по 120
+	income := func(price int64, days int) {
+		expiry := time.Now().AddDate(0, 0, days)
+		doc := h.CreateDocument(models.Document{Type: "INCOME", WarehouseID: &wh.ID, Items: []models.DocumentItem{{
+			VariantID: variant.ID, Quantity: decimal.NewFromInt(5), Price: decimalPtr(decimal.NewFromInt(price)), ExpiryDate: &expiry,
+		}}})
+		h.PostDocument(doc.ID)
+	}
+	income(100, 60)
+	income(120, 10)
+
+	// 2. Продажа списывает партию с ближайшим сроком
+	sale := h.CreateDocument(models.Document{Type: "OUTCOME", WarehouseID: &wh.ID, Items: []models.DocumentItem{{
+		VariantID: variant.ID, Quantity: decimal.NewFromInt(3), Price: decimalPtr(decimal.NewFromInt(200)),
+	}}})
+	h.PostDocument(sale.ID)
+
+	// 3. Остатки и себестоимость оцениваются по партиям, а не по средней цене
+	repo := reports.NewRepository(db, "fefo")
+	stock, err := repo.GetStockData(&wh.ID)
+	h.Assert.NoError(err)
+	h.Assert.Len(stock, 1)
+	h.Assert.True(decimal.NewFromInt(7).Equal(stock[0].Quantity), stock[0].Quantity.String())
+	h.Assert.True(decimal.NewFromInt(5*100+2*120).Equal(stock[0].TotalValue), stock[0].TotalValue.String())
+
+	profit, err := repo.GetFIFOProfitData(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), &wh.ID)
+	h.Assert.NoError(err)
+	h.Assert.Len(profit, 1)
+	h.Assert.True(decimal.NewFromInt(600).Equal(profit[0].Revenue), profit[0].Revenue.String())
+	h.Assert.True(decimal.NewFromInt(3*120).Equal(profit[0].Cost), profit[0].Cost.String())
+
+	// 4. Отменённая продажа не попадает ни в выручку, ни в рейтинг продаж
+	h.CancelDocument(sale.ID)
+	profit, err = repo.GetFIFOProfitData(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), &wh.ID)
+	h.Assert.NoError(err)
+	h.Assert.Empty(profit)
+	ranking, err := repo.GetSalesRanking(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), &wh.ID)
+	h.Assert.NoError(err)
+	h.Assert.Empty(ranking)
+	profit, err = reports.NewRepository(db, "average").GetFIFOProfitData(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), &wh.ID)
+	h.Assert.NoError(err)
+	h.Assert.Empty(profit)
+}
@@ -0,0 +1,241 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/config"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/repository"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/service"
+)
+
+func TestReservationLines_Integration(t *testing.T) {
+	router, db := setupTestRouter("reservation_lines_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	customer := h.CreateCounterparty(gin.H{"name": "ООО Резерв"})
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "RSV-1"})
+
+	h.PostDocument(h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(20), Price: decimalPtr(decimal.NewFromInt(5))}},
+	}).ID)
+
+	stock := func() models.VariantStockDTO {
+		w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/variants/%d/stock", variant.ID), nil)
+		h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+		var rows []models.VariantStockDTO
+		h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &rows))
+		h.Assert.Len(rows, 1)
+		return rows[0]
+	}
+
+	// 1. Каждый заказ держит свой резерв, склад показывает, кто именно
+	open := h.CreateDocument(models.Document{
+		Type: "ORDER", WarehouseID: &wh.ID, CounterpartyID: &customer.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(8)}},
+	})
+	h.PostDocument(open.ID)
+
+	until := time.Now().Add(time.Hour)
+	expiring := h.CreateDocument(models.Document{
+		Type: "ORDER", WarehouseID: &wh.ID, ReservedUntil: &until,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(5)}},
+	})
+	h.PostDocument(expiring.ID)
+
+	row := stock()
+	h.Assert.True(decimal.NewFromInt(13).Equal(row.Reserved), row.Reserved.String())
+	h.Assert.True(decimal.NewFromInt(7).Equal(row.Available), row.Available.String())
+	h.Assert.Len(row.Orders, 2)
+	h.Assert.Equal(open.ID, row.Orders[0].DocumentID)
+	h.Assert.Equal(open.Number, row.Orders[0].DocumentNumber)
+	h.Assert.Equal("ООО Резерв", row.Orders[0].CounterpartyName)
+	h.Assert.Nil(row.Orders[0].ExpiresAt)
+	h.Assert.Equal(expiring.ID, row.Orders[1].DocumentID)
+	h.Assert.NotNil(row.Orders[1].ExpiresAt)
+
+	past := time.Now().Add(-time.Minute)
+	late := h.CreateDocument(models.Document{
+		Type: "ORDER", WarehouseID: &wh.ID, ReservedUntil: &past,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1)}},
+	})
+	w := h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", late.ID), nil, h.IfMatch(late.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code, "reservation that has already expired")
+
+	// строки одного варианта проверяются по сумме: 4 + 4 при доступных 7 не резервируются
+	split := h.CreateDocument(models.Document{
+		Type: "ORDER", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(4)}, {VariantID: variant.ID, Quantity: decimal.NewFromInt(4)}},
+	})
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", split.ID), nil, h.IfMatch(split.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code, w.Body.String())
+	h.Assert.Contains(w.Body.String(), "Требуется: 8")
+	h.Assert.True(decimal.NewFromInt(13).Equal(stock().Reserved))
+
+	// 2. Отгрузка уменьшает резерв своего заказа, её отмена возвращает его
+	shipment := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID, BaseDocumentID: &open.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(3)}},
+	})
+	h.PostDocument(shipment.ID)
+	row = stock()
+	h.Assert.True(decimal.NewFromInt(10).Equal(row.Reserved), row.Reserved.String())
+	h.Assert.True(decimal.NewFromInt(5).Equal(row.Orders[0].Quantity), row.Orders[0].Quantity.String())
+	h.Assert.True(decimal.NewFromInt(17).Equal(row.OnHand))
+
+	h.CancelDocument(shipment.ID)
+	h.Assert.True(decimal.NewFromInt(13).Equal(stock().Reserved))
+
+	// 3. Истёкший резерв снимается и попадает в историю заказа
+	releaser := service.NewReservationService(
+		repository.NewReservationRepository(db), repository.NewSerialRepository(db),
+		repository.NewDocumentHistoryRepository(db), repository.NewTxManager(db),
+	)
+	released, err := releaser.ReleaseExpired(time.Now())
+	h.Assert.NoError(err)
+	h.Assert.Zero(released)
+
+	released, err = releaser.ReleaseExpired(until.Add(time.Minute))
+	h.Assert.NoError(err)
+	h.Assert.Equal(1, released)
+
+	row = stock()
+	h.Assert.True(decimal.NewFromInt(8).Equal(row.Reserved), row.Reserved.String())
+	h.Assert.True(decimal.NewFromInt(12).Equal(row.Available), row.Available.String())
+	h.Assert.Len(row.Orders, 1)
+
+	var history []models.DocumentHistory
+	h.Assert.NoError(db.Where("document_id = ? AND action = ?", expiring.ID, "reservation_released").Find(&history).Error)
+	h.Assert.Len(history, 1)
+	h.Assert.Contains(history[0].Comment, "5")
+
+	// 4. Отмена заказа снимает его резерв целиком
+	h.CancelDocument(open.ID)
+	row = stock()
+	h.Assert.True(row.Reserved.IsZero(), row.Reserved.String())
+	h.Assert.Empty(row.Orders)
+
+	// 5. Истёкший резерв освобождает и серийные номера заказа, кроме уже отгруженных
+	phone := h.CreateVariant(gin.H{"product_id": 1, "sku": "RSV-SN", "track_serials": true})
+	h.PostDocument(h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{
+			VariantID: phone.ID, Quantity: decimal.NewFromInt(3), Price: decimalPtr(decimal.NewFromInt(100)),
+			SerialNumbers: []string{"RSV-SN-1", "RSV-SN-2", "RSV-SN-3"},
+		}},
+	}).ID)
+	serialOrder := h.CreateDocument(models.Document{
+		Type: "ORDER", WarehouseID: &wh.ID, ReservedUntil: &until,
+		Items: []models.DocumentItem{{VariantID: phone.ID, Quantity: decimal.NewFromInt(3), SerialNumbers: []string{"RSV-SN-1", "RSV-SN-2", "RSV-SN-3"}}},
+	})
+	h.PostDocument(serialOrder.ID)
+	serialShipment := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID, BaseDocumentID: &serialOrder.ID,
+		Items: []models.DocumentItem{{VariantID: phone.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"RSV-SN-1"}}},
+	})
+	h.PostDocument(serialShipment.ID)
+
+	serialStatus := func(serial string) string {
+		w := h.PerformRequest("GET", "/api/v1/stock/serials/"+serial, nil)
+		h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+		var history models.SerialHistoryDTO
+		h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &history))
+		return history.Status
+	}
+	h.Assert.Equal("reserved", serialStatus("RSV-SN-2"))
+
+	released, err = releaser.ReleaseExpired(until.Add(time.Minute))
+	h.Assert.NoError(err)
+	h.Assert.Equal(1, released)
+	h.Assert.Equal("shipped", serialStatus("RSV-SN-1"))
+	h.Assert.Equal("in_stock", serialStatus("RSV-SN-2"))
+	h.Assert.Equal("in_stock", serialStatus("RSV-SN-3"))
+
+	// освобождённый номер можно зарезервировать другим заказом, отмена истёкшего заказа его уже не трогает
+	h.PostDocument(h.CreateDocument(models.Document{
+		Type: "ORDER", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: phone.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"RSV-SN-2"}}},
+	}).ID)
+	h.CancelDocument(serialShipment.ID)
+	h.CancelDocument(serialOrder.ID)
+	h.Assert.Equal("in_stock", serialStatus("RSV-SN-1"))
+	h.Assert.Equal("reserved", serialStatus("RSV-SN-2"))
+	h.Assert.Equal("in_stock", serialStatus("RSV-SN-3"))
+}
+
+func TestReservationLinesBackfill_Integration(t *testing.T) {
+	router, db := setupTestRouter("reservation_backfill_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "RSV-BF"})
+	h.PostDocument(h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(20), Price: decimalPtr(decimal.NewFromInt(5))}},
+	}).ID)
+
+	order := func(qty, shipped int64) models.Document {
+		doc := h.CreateDocument(models.Document{
+			Type: "ORDER", WarehouseID: &wh.ID,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty)}},
+		})
+		h.PostDocument(doc.ID)
+		if shipped > 0 {
+			h.PostDocument(h.CreateDocument(models.Document{
+				Type: "OUTCOME", WarehouseID: &wh.ID, BaseDocumentID: &doc.ID,
+				Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(shipped)}},
+			}).ID)
+		}
+		return doc
+	}
+	partial := order(8, 3)
+	full := order(4, 4)
+
+	// База до построчных резервов: строк резерва нет, отгруженное по строкам заказа не велось
+	h.Assert.NoError(db.Exec("DELETE FROM reservation_lines").Error)
+	h.Assert.NoError(db.Exec("UPDATE document_items SET shipped_quantity = 0").Error)
+	h.Assert.NoError(db.Exec("UPDATE documents SET fulfillment_status = 'open' WHERE UPPER(type) = 'ORDER'").Error)
+
+	h.Assert.NoError(stock.NewModule(config.AuthConfig{JWTSecret: testJWTSecret}, nil).Migrate(db))
+
+	var lines []models.ReservationLine
+	h.Assert.NoError(db.Find(&lines).Error)
+	h.Assert.Len(lines, 1)
+	h.Assert.Equal(partial.ID, lines[0].DocumentID)
+	h.Assert.True(decimal.NewFromInt(5).Equal(lines[0].Quantity), lines[0].Quantity.String())
+
+	h.Assert.Equal("partially_shipped", h.GetDocument(partial.ID).FulfillmentStatus)
+	var item models.DocumentItem
+	h.Assert.NoError(db.Where("document_id = ?", partial.ID).First(&item).Error)
+	h.Assert.True(decimal.NewFromInt(3).Equal(item.ShippedQuantity), item.ShippedQuantity.String())
+	h.Assert.Equal("fulfilled", h.GetDocument(full.ID).FulfillmentStatus)
+
+	// Резерв, снятый воркером, и просроченный заказ не восстанавливаются повторной миграцией
+	released := order(2, 0)
+	expired := order(3, 0)
+	h.Assert.NoError(db.Exec("DELETE FROM reservation_lines WHERE document_id = ?", released.ID).Error)
+	h.Assert.NoError(db.Create(&models.DocumentHistory{DocumentID: released.ID, Action: "reservation_released"}).Error)
+	h.Assert.NoError(db.Exec("UPDATE documents SET reserved_until = ? WHERE id = ?", time.Now().Add(-time.Hour), expired.ID).Error)
+	h.Assert.NoError(db.Exec("DELETE FROM reservation_lines").Error)
+
+	h.Assert.NoError(stock.NewModule(config.AuthConfig{JWTSecret: testJWTSecret}, nil).Migrate(db))
+	lines = nil
+	h.Assert.NoError(db.Find(&lines).Error)
+	h.Assert.Len(lines, 1)
+	h.Assert.Equal(partial.ID, lines[0].DocumentID)
+	h.Assert.True(decimal.NewFromInt(5).Equal(lines[0].Quantity), lines[0].Quantity.String())
+}
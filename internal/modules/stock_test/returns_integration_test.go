@@ -0,0 +1,164 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/reports"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestReturns_Integration(t *testing.T) {
+	router, db := setupTestRouter("returns_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	customer := h.CreateCounterparty(gin.H{"name": "Покупатель"})
+	supplier := h.CreateCounterparty(gin.H{"name": "Поставщик"})
+	category := h.CreateCategory("Электроника")
+	product := h.CreateProduct(gin.H{"name": "Наушники", "category_id": category.ID})
+	variant := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "RET-1"})
+
+	income := func(qty, price int64) models.Document {
+		doc := h.CreateDocument(models.Document{
+			Type: "INCOME", WarehouseID: &wh.ID, CounterpartyID: &supplier.ID,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty), Price: decimalPtr(decimal.NewFromInt(price))}},
+		})
+		h.PostDocument(doc.ID)
+		return doc
+	}
+	createBased := func(baseID uint, docType string) models.Document {
+		w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/create-based", baseID), gin.H{"type": docType})
+		h.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+		var doc models.Document
+		h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &doc))
+		return doc
+	}
+	setQty := func(doc models.Document, qty int64) {
+		doc.Items[0].Quantity = decimal.NewFromInt(qty)
+		h.UpdateDocument(doc.ID, models.DocumentUpdateDTO{
+			WarehouseID: doc.WarehouseID, CounterpartyID: doc.CounterpartyID, Items: doc.Items,
+		})
+	}
+	lotsOf := func(docID uint) []models.StockLot {
+		var lots []models.StockLot
+		h.Assert.NoError(db.Where("income_document_id = ?", docID).Order("id").Find(&lots).Error)
+		return lots
+	}
+	profit := func() reports.ProfitRecord {
+		records, err := reports.NewRepository(db, "fifo").GetFIFOProfitData(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), nil)
+		h.Assert.NoError(err)
+		h.Assert.Len(records, 1)
+		return records[0]
+	}
+
+	income(5, 100)
+	income(5, 130)
+
+	sale := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID, CounterpartyID: &customer.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(7), Price: decimalPtr(decimal.NewFromInt(300))}},
+	})
+	h.PostDocument(sale.ID)
+
+	// Свежий приход дороже: возврат не должен оцениваться по нему
+	latest := income(5, 200)
+
+	// 1. Возврат покупателя создаётся на основании продажи и приходует товар по себестоимости проданных партий
+	ret := createBased(sale.ID, "RETURN_IN")
+	h.Assert.Equal("RETURN_IN", ret.Type)
+	h.Assert.Equal(sale.ID, *ret.BaseDocumentID)
+	h.Assert.Equal(customer.ID, *ret.CounterpartyID)
+	setQty(ret, 6)
+	h.PostDocument(ret.ID)
+
+	lots := lotsOf(ret.ID)
+	h.Assert.Len(lots, 2)
+	h.Assert.True(decimal.NewFromInt(5).Equal(lots[0].CurrentQuantity))
+	h.Assert.True(decimal.NewFromInt(100).Equal(lots[0].UnitCost))
+	h.Assert.True(decimal.NewFromInt(1).Equal(lots[1].CurrentQuantity))
+	h.Assert.True(decimal.NewFromInt(130).Equal(lots[1].UnitCost))
+	h.Assert.True(decimal.NewFromInt(3 + 5 + 6).Equal(findBalance(h.GetBalances(wh.ID), variant.ID).Quantity))
+
+	// 2. Отчёт о прибыли вычитает возврат: продано 7, возвращено 6
+	rec := profit()
+	h.Assert.True(decimal.NewFromInt(1).Equal(rec.Quantity), rec.Quantity.String())
+	h.Assert.True(decimal.NewFromInt(300).Equal(rec.Revenue), rec.Revenue.String())
+	h.Assert.True(decimal.NewFromInt(130).Equal(rec.Cost), rec.Cost.String())
+
+	// 3. Вернуть больше проданного нельзя, остаток возвращается по следующей партии
+	extra := createBased(sale.ID, "RETURN_IN")
+	setQty(extra, 2)
+	w := h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", extra.ID), nil, h.IfMatch(extra.ID))
+	h.Assert.Equal(http.StatusInternalServerError, w.Code)
+	h.Assert.Contains(w.Body.String(), "exceeds sold quantity")
+
+	setQty(extra, 1)
+	h.PostDocument(extra.ID)
+	lots = lotsOf(extra.ID)
+	h.Assert.Len(lots, 1)
+	h.Assert.True(decimal.NewFromInt(130).Equal(lots[0].UnitCost))
+	h.Assert.True(decimal.Zero.Equal(profit().Revenue))
+
+	// 4. Пока по продаже есть проведённые возвраты, её нельзя ни исправить, ни отменить
+	posted := h.GetDocument(sale.ID)
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/correct", sale.ID), models.DocumentUpdateDTO{
+		WarehouseID: &wh.ID, CounterpartyID: &customer.ID, Version: &posted.Version,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), Price: decimalPtr(decimal.NewFromInt(300))}},
+	})
+	h.Assert.NotEqual(http.StatusOK, w.Code, w.Body.String())
+	h.Assert.Contains(w.Body.String(), "posted returns")
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/cancel", sale.ID), nil)
+	h.Assert.NotEqual(http.StatusOK, w.Code, w.Body.String())
+	h.Assert.Contains(w.Body.String(), "posted returns")
+	h.Assert.True(decimal.NewFromInt(7).Equal(h.GetDocument(sale.ID).Items[0].Quantity))
+
+	// 5. Отмена возврата возвращает продажу в отчёт
+	h.CancelDocument(extra.ID)
+	h.CancelDocument(ret.ID)
+	h.Assert.True(decimal.NewFromInt(7).Equal(profit().Quantity))
+
+	// 6. Возврат должен ссылаться на подходящий документ
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/create-based", latest.ID), gin.H{"type": "RETURN_IN"})
+	h.Assert.Equal(http.StatusBadRequest, w.Code)
+	wrong := h.CreateDocument(models.Document{
+		Type: "RETURN_IN", WarehouseID: &wh.ID, BaseDocumentID: &latest.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1)}},
+	})
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", wrong.ID), nil, h.IfMatch(wrong.ID))
+	h.Assert.Contains(w.Body.String(), "must be based on OUTCOME")
+
+	// 7. Возврат поставщику списывает партии своего прихода, а не самые старые
+	retOut := createBased(latest.ID, "RETURN_OUT")
+	h.Assert.Equal(supplier.ID, *retOut.CounterpartyID)
+	setQty(retOut, 2)
+	h.PostDocument(retOut.ID)
+
+	latestLots := lotsOf(latest.ID)
+	h.Assert.True(decimal.NewFromInt(3).Equal(latestLots[0].CurrentQuantity))
+	h.Assert.True(decimal.NewFromInt(3 + 5 - 2).Equal(findBalance(h.GetBalances(wh.ID), variant.ID).Quantity))
+
+	var moves []models.StockMovement
+	h.Assert.NoError(db.Where("document_id = ? AND type = ?", retOut.ID, "RETURN_OUT").Find(&moves).Error)
+	h.Assert.Len(moves, 1)
+	h.Assert.True(decimal.NewFromInt(200).Equal(moves[0].UnitCost))
+
+	tooMuch := createBased(latest.ID, "RETURN_OUT")
+	setQty(tooMuch, 4)
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", tooMuch.ID), nil, h.IfMatch(tooMuch.ID))
+	h.Assert.Contains(w.Body.String(), "exceeds received quantity")
+
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/cancel", latest.ID), nil)
+	h.Assert.Contains(w.Body.String(), "posted returns")
+
+	h.CancelDocument(retOut.ID)
+	h.Assert.True(decimal.NewFromInt(5).Equal(lotsOf(latest.ID)[0].CurrentQuantity))
+}
@@ -0,0 +1,259 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestScanSessions_Integration(t *testing.T) {
+	router, db := setupTestRouter("scan_sessions_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	customer := h.CreateCounterparty(gin.H{"name": "ООО Покупатель"})
+	shirt := h.CreateVariant(gin.H{"product_id": 1, "sku": "SCAN-SHIRT"})
+	mug := h.CreateVariant(gin.H{"product_id": 1, "sku": "SCAN-MUG"})
+	extra := h.CreateVariant(gin.H{"product_id": 1, "sku": "SCAN-EXTRA"})
+	addBarcode := func(variantID uint, code string) string {
+		w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/variants/%d/barcodes", variantID), gin.H{"code": code})
+		h.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+		var created models.Barcode
+		json.Unmarshal(w.Body.Bytes(), &created)
+		return created.Code
+	}
+	shirtCode := addBarcode(shirt.ID, "")
+	mugCode := addBarcode(mug.ID, "MUG-BOX")
+	extraCode := addBarcode(extra.ID, "0012345678905")
+
+	line := func(variantID uint, qty int64, price string) models.DocumentItem {
+		p := decimal.RequireFromString(price)
+		return models.DocumentItem{VariantID: variantID, Quantity: decimal.NewFromInt(qty), Price: &p}
+	}
+	income := h.CreateDocument(models.Document{Type: "INCOME", WarehouseID: &wh.ID, Items: []models.DocumentItem{
+		line(shirt.ID, 20, "100"), line(mug.ID, 20, "50"), line(extra.ID, 5, "10"),
+	}})
+	h.PostDocument(income.ID)
+	order := h.CreateDocument(models.Document{Type: "ORDER", WarehouseID: &wh.ID, CounterpartyID: &customer.ID, Items: []models.DocumentItem{
+		line(shirt.ID, 5, "250"), line(mug.ID, 2, "90"),
+	}})
+	h.PostDocument(order.ID)
+
+	open := func(client *TestHelper, body gin.H) (int, models.ScanSessionDTO, string) {
+		w := client.PerformRequest("POST", "/api/v1/stock/scan-sessions", body)
+		var session models.ScanSessionDTO
+		json.Unmarshal(w.Body.Bytes(), &session)
+		return w.Code, session, w.Body.String()
+	}
+	scan := func(client *TestHelper, sessionID uint, body gin.H) (int, models.ScanResultDTO) {
+		w := client.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/scan-sessions/%d/scans", sessionID), body)
+		var result models.ScanResultDTO
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+	getSession := func(sessionID uint) models.ScanSessionDTO {
+		w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/scan-sessions/%d", sessionID), nil)
+		h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+		var session models.ScanSessionDTO
+		json.Unmarshal(w.Body.Bytes(), &session)
+		return session
+	}
+	scanLine := func(session models.ScanSessionDTO, variantID uint) *models.ScanLineDTO {
+		for i := range session.Lines {
+			if session.Lines[i].VariantID == variantID {
+				return &session.Lines[i]
+			}
+		}
+		return nil
+	}
+
+	// 1. Сеанс отгрузки по заказу: черновик расхода с контрагентом заказа
+	code, picking, body := open(h, gin.H{"document_type": "OUTCOME", "warehouse_id": wh.ID, "base_document_id": order.ID})
+	h.Assert.Equal(http.StatusCreated, code, body)
+	h.Assert.Equal(models.ScanSessionOpen, picking.Status)
+	h.Assert.Equal("draft", picking.DocumentStatus)
+	draft := h.GetDocument(picking.DocumentID)
+	h.Assert.Equal(customer.ID, *draft.CounterpartyID)
+	h.Assert.Empty(draft.Items)
+
+	// 2. Сканы сводятся в строку на вариант; повтор события после переподключения не удваивает количество
+	code, res := scan(h, picking.ID, gin.H{"barcode": shirtCode, "event_id": "tsd-1"})
+	h.Assert.Equal(http.StatusOK, code)
+	h.Assert.Equal(models.ScanAccepted, res.Event.Result)
+	h.Assert.True(res.Line.Quantity.Equal(decimal.NewFromInt(1)))
+	h.Assert.True(res.Line.Remaining.Equal(decimal.NewFromInt(5)))
+	_, res = scan(h, picking.ID, gin.H{"barcode": shirtCode, "event_id": "tsd-1"})
+	h.Assert.True(res.Replayed)
+	h.Assert.True(res.Line.Quantity.Equal(decimal.NewFromInt(1)), "replayed scan must not add quantity")
+	_, res = scan(h, picking.ID, gin.H{"barcode": shirtCode, "quantity": 3, "event_id": "tsd-2"})
+	h.Assert.True(res.Line.Quantity.Equal(decimal.NewFromInt(4)))
+	_, res = scan(h, picking.ID, gin.H{"barcode": mugCode, "quantity": 2})
+	h.Assert.Equal(models.ScanAccepted, res.Event.Result)
+
+	// 3. Сверх остатка заказа и товар не из заказа - over_scan, неизвестный код - unknown; черновик не меняется
+	_, res = scan(h, picking.ID, gin.H{"barcode": shirtCode, "quantity": 2})
+	h.Assert.Equal(models.ScanOverScan, res.Event.Result)
+	h.Assert.True(res.Line.Quantity.Equal(decimal.NewFromInt(4)))
+	_, res = scan(h, picking.ID, gin.H{"barcode": extraCode})
+	h.Assert.Equal(models.ScanOverScan, res.Event.Result)
+	code, res = scan(h, picking.ID, gin.H{"barcode": "0000000000017"})
+	h.Assert.Equal(http.StatusOK, code)
+	h.Assert.Equal(models.ScanUnknown, res.Event.Result)
+	h.Assert.Nil(res.Line)
+
+	// 4. Отрицательное количество снимает ошибочный скан, но не больше отсканированного
+	_, res = scan(h, picking.ID, gin.H{"barcode": shirtCode, "quantity": -1})
+	h.Assert.True(res.Line.Quantity.Equal(decimal.NewFromInt(3)))
+	code, _ = scan(h, picking.ID, gin.H{"barcode": mugCode, "quantity": -5})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, _ = scan(h, picking.ID, gin.H{"barcode": mugCode, "quantity": 0})
+	h.Assert.Equal(http.StatusBadRequest, code)
+
+	// 5. Состояние сеанса после переподключения и список открытых сеансов склада
+	state := getSession(picking.ID)
+	h.Assert.Len(state.Lines, 2)
+	h.Assert.True(scanLine(state, shirt.ID).Quantity.Equal(decimal.NewFromInt(3)))
+	h.Assert.True(scanLine(state, mug.ID).Quantity.Equal(decimal.NewFromInt(2)))
+	h.Assert.Equal(7, state.ScanCount)
+	h.Assert.Len(state.Unknown, 1)
+	h.Assert.Len(state.OverScans, 2)
+	w := h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/scan-sessions?status=open&warehouse_id=%d", wh.ID), nil)
+	var openSessions []models.ScanSession
+	json.Unmarshal(w.Body.Bytes(), &openSessions)
+	h.Assert.Len(openSessions, 1)
+
+	// 6. Закрытый сеанс - обычный черновик, который проводится как любой расход по заказу
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/scan-sessions/%d/close", picking.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	code, _ = scan(h, picking.ID, gin.H{"barcode": shirtCode})
+	h.Assert.Equal(http.StatusConflict, code)
+	draft = h.GetDocument(picking.DocumentID)
+	h.Assert.Len(draft.Items, 2)
+	for _, item := range draft.Items {
+		h.Assert.NotNil(item.Price, "price is taken from the order")
+	}
+	h.PostDocument(picking.DocumentID)
+	balances := h.GetBalances(wh.ID)
+	h.Assert.True(findBalance(balances, shirt.ID).Quantity.Equal(decimal.NewFromInt(17)))
+	h.Assert.True(findBalance(balances, mug.ID).Quantity.Equal(decimal.NewFromInt(18)))
+
+	// 7. Приёмка без основания: сколько угодно сканов, UPC-A без ведущего нуля тоже находится
+	_, receiving, _ := open(h, gin.H{"document_type": "income", "warehouse_id": wh.ID})
+	for i := 0; i < 3; i++ {
+		_, res = scan(h, receiving.ID, gin.H{"barcode": mugCode})
+	}
+	h.Assert.True(res.Line.Quantity.Equal(decimal.NewFromInt(3)))
+	h.Assert.Nil(res.Line.Remaining)
+	_, res = scan(h, receiving.ID, gin.H{"barcode": "012345678905", "quantity": 10})
+	h.Assert.Equal(models.ScanAccepted, res.Event.Result)
+	h.Assert.Equal(extra.ID, *res.Event.VariantID)
+	h.Assert.Len(getSession(receiving.ID).Lines, 2)
+
+	// дробное количество проверяется по точности единицы варианта: в штуках - только целое
+	w = h.PerformRequest("POST", "/api/v1/stock/units", gin.H{"name": "шт", "precision": 0})
+	h.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	var pcs models.Unit
+	json.Unmarshal(w.Body.Bytes(), &pcs)
+	bolt := h.CreateVariant(gin.H{"product_id": 1, "sku": "SCAN-BOLT", "unit_id": pcs.ID})
+	boltCode := addBarcode(bolt.ID, "BOLT-BOX")
+	code, _ = scan(h, receiving.ID, gin.H{"barcode": boltCode, "quantity": 1.5})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	_, res = scan(h, receiving.ID, gin.H{"barcode": boltCode, "quantity": 2})
+	h.Assert.True(res.Line.Quantity.Equal(decimal.NewFromInt(2)))
+	code, _ = scan(h, receiving.ID, gin.H{"barcode": boltCode, "quantity": -0.5})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	h.Assert.Len(getSession(receiving.ID).Lines, 3)
+
+	// 8. Пустой сеанс не закрывается, отказ от сеанса удаляет его черновик
+	_, empty, _ := open(h, gin.H{"document_type": "WRITE_OFF", "warehouse_id": wh.ID})
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/scan-sessions/%d/close", empty.ID), nil)
+	h.Assert.Equal(http.StatusBadRequest, w.Code)
+	w = h.PerformRequest("DELETE", fmt.Sprintf("/api/v1/stock/scan-sessions/%d", empty.ID), nil)
+	h.Assert.Equal(http.StatusNoContent, w.Code)
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/scan-sessions/%d", empty.ID), nil)
+	h.Assert.Equal(http.StatusNotFound, w.Code)
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/%d", empty.DocumentID), nil)
+	h.Assert.Equal(http.StatusNotFound, w.Code)
+
+	// 9. Недопустимые сеансы
+	code, _, _ = open(h, gin.H{"document_type": "PRICE_UPDATE", "warehouse_id": wh.ID})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, _, _ = open(h, gin.H{"document_type": "INCOME", "warehouse_id": wh.ID, "base_document_id": order.ID})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	code, _, _ = open(h, gin.H{"document_type": "INCOME", "warehouse_id": 999})
+	h.Assert.Equal(http.StatusBadRequest, code)
+
+	// 10. Права: просмотр сеанса - view_stock, сканирование - право на создание документа типа сеанса
+	viewer := h.AsUser(3, "viewer", "view_stock")
+	w = viewer.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/scan-sessions/%d", receiving.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code)
+	code, _ = scan(viewer, receiving.ID, gin.H{"barcode": mugCode})
+	h.Assert.Equal(http.StatusForbidden, code)
+	picker := h.AsUser(4, "picker", "view_stock", "create_document:OUTCOME")
+	code, _ = scan(picker, receiving.ID, gin.H{"barcode": mugCode})
+	h.Assert.Equal(http.StatusForbidden, code)
+	code, _, body = open(picker, gin.H{"document_type": "OUTCOME", "warehouse_id": wh.ID})
+	h.Assert.Equal(http.StatusCreated, code, body)
+	code, _, _ = open(picker, gin.H{"document_type": "INCOME", "warehouse_id": wh.ID})
+	h.Assert.Equal(http.StatusForbidden, code)
+}
+
+func TestScanSessionSerials_Integration(t *testing.T) {
+	router, db := setupTestRouter("scan_serials_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	phone := h.CreateVariant(gin.H{"product_id": 1, "sku": "SCAN-PHONE", "track_serials": true})
+	w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/variants/%d/barcodes", phone.ID), gin.H{"code": "PHONE-BOX"})
+	h.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+
+	w = h.PerformRequest("POST", "/api/v1/stock/scan-sessions", gin.H{"document_type": "INCOME", "warehouse_id": wh.ID})
+	h.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	var session models.ScanSessionDTO
+	json.Unmarshal(w.Body.Bytes(), &session)
+	scan := func(body gin.H) (int, string) {
+		w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/scan-sessions/%d/scans", session.ID), body)
+		return w.Code, w.Body.String()
+	}
+
+	// 1. Серийный товар сканируется вместе с номером и по одной единице
+	code, body := scan(gin.H{"barcode": "PHONE-BOX"})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	h.Assert.Contains(body, "serial")
+	code, _ = scan(gin.H{"barcode": "PHONE-BOX", "serial": "SN-1", "quantity": 2})
+	h.Assert.Equal(http.StatusBadRequest, code)
+	for _, serial := range []string{"SN-1", "SN-2", "SN-3"} {
+		code, body = scan(gin.H{"barcode": "PHONE-BOX", "serial": serial})
+		h.Assert.Equal(http.StatusOK, code, body)
+	}
+	code, _ = scan(gin.H{"barcode": "PHONE-BOX", "serial": "SN-2"})
+	h.Assert.Equal(http.StatusBadRequest, code, "serial number is scanned twice")
+
+	// 2. Снятие скана убирает и его номер
+	code, body = scan(gin.H{"barcode": "PHONE-BOX", "serial": "SN-9", "quantity": -1})
+	h.Assert.Equal(http.StatusBadRequest, code, body)
+	code, body = scan(gin.H{"barcode": "PHONE-BOX", "serial": "SN-2", "quantity": -1})
+	h.Assert.Equal(http.StatusOK, code, body)
+	draft := h.GetDocument(session.DocumentID)
+	h.Assert.Len(draft.Items, 1)
+	h.Assert.True(decimal.NewFromInt(2).Equal(draft.Items[0].Quantity))
+	h.Assert.ElementsMatch([]string{"SN-1", "SN-3"}, draft.Items[0].SerialNumbers)
+
+	// 3. Черновик сеанса проводится: номера приходуются на склад
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/scan-sessions/%d/close", session.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	h.PostDocument(session.DocumentID)
+	var units []models.SerialUnit
+	h.Assert.NoError(db.Where("variant_id = ? AND status = ?", phone.ID, "in_stock").Find(&units).Error)
+	h.Assert.Len(units, 2)
+}
@@ -0,0 +1,145 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestSerialTracking_Integration(t *testing.T) {
+	router, db := setupTestRouter("serials_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	whA := h.CreateWarehouse("Склад А")
+	whB := h.CreateWarehouse("Склад Б")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "PHONE-X", "track_serials": true})
+	h.Assert.True(variant.TrackSerials)
+
+	// 1. Количество серийных номеров должно совпадать с количеством
+	badIncome := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &whA.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(2), SerialNumbers: []string{"SN-1"}}},
+	})
+	w := h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", badIncome.ID), nil, h.IfMatch(badIncome.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code)
+
+	income := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &whA.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(2), SerialNumbers: []string{"SN-1", "SN-2"}}},
+	})
+	h.PostDocument(income.ID)
+
+	// 2. Нельзя продать неизвестный номер и номер с другого склада
+	unknown := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &whA.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"SN-404"}}},
+	})
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", unknown.ID), nil, h.IfMatch(unknown.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code)
+
+	transfer := h.CreateDocument(models.Document{
+		Type: "TRANSFER", WarehouseID: &whA.ID, ToWarehouseID: &whB.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"SN-2"}}},
+	})
+	h.PostDocument(transfer.ID)
+
+	wrongWh := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &whA.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"SN-2"}}},
+	})
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", wrongWh.ID), nil, h.IfMatch(wrongWh.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code)
+
+	// 3. Продажа со склада Б проходит
+	sale := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &whB.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"SN-2"}}},
+	})
+	h.PostDocument(sale.ID)
+
+	// 4. Перемещение нельзя отменить, пока номер продан
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/cancel", transfer.ID), nil)
+	h.Assert.NotEqual(http.StatusOK, w.Code)
+
+	// 5. История единицы: приход, перемещение, продажа
+	w = h.PerformRequest("GET", "/api/v1/stock/serials/SN-2", nil)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var history models.SerialHistoryDTO
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &history))
+	h.Assert.Equal("shipped", history.Status)
+	h.Assert.Nil(history.WarehouseID)
+	h.Assert.Equal("PHONE-X", history.VariantSKU)
+	h.Assert.Len(history.History, 3)
+	h.Assert.Equal("INCOME", history.History[0].Type)
+	h.Assert.Equal("TRANSFER", history.History[1].Type)
+	h.Assert.Equal("OUTCOME", history.History[2].Type)
+	h.Assert.Equal(sale.ID, history.History[2].DocumentID)
+
+	// 6. Отмена продажи возвращает номер на склад Б
+	h.CancelDocument(sale.ID)
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/serials/SN-2?variant_id=%d", variant.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code)
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &history))
+	h.Assert.Equal("in_stock", history.Status)
+	h.Assert.Equal(whB.ID, *history.WarehouseID)
+
+	w = h.PerformRequest("GET", "/api/v1/stock/serials/SN-404", nil)
+	h.Assert.Equal(http.StatusNotFound, w.Code)
+
+	// 7. Инвентаризация с расхождением и сборка не переводят номера, поэтому серийный товар в них не допускается
+	postFails := func(doc models.Document) {
+		created := h.CreateDocument(doc)
+		w := h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", created.ID), nil, h.IfMatch(created.ID))
+		h.Assert.NotEqual(http.StatusOK, w.Code)
+		h.Assert.Contains(w.Body.String(), "serial-tracked")
+	}
+	postFails(models.Document{Type: "INVENTORY", WarehouseID: &whA.ID, Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.Zero}}})
+	h.PostDocument(h.CreateDocument(models.Document{
+		Type: "INVENTORY", WarehouseID: &whA.ID, Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1)}},
+	}).ID)
+
+	kit := h.CreateVariant(gin.H{"product_id": 1, "sku": "PHONE-KIT"})
+	w = h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/variants/%d/bom", kit.ID), gin.H{"components": []gin.H{{"component_variant_id": variant.ID, "quantity": 1}}})
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	postFails(models.Document{Type: "ASSEMBLY", WarehouseID: &whA.ID, Items: []models.DocumentItem{{VariantID: kit.ID, Quantity: decimal.NewFromInt(1)}}})
+	h.Assert.True(decimal.NewFromInt(1).Equal(findBalance(h.GetBalances(whA.ID), variant.ID).Quantity))
+	w = h.PerformRequest("GET", "/api/v1/stock/serials/SN-1", nil)
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &history))
+	h.Assert.Equal("in_stock", history.Status)
+
+	// 8. Номер, зарезервированный заказом, нельзя отгрузить другим документом - только по этому заказу
+	h.PostDocument(h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &whA.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"SN-3"}}},
+	}).ID)
+	order := h.CreateDocument(models.Document{
+		Type: "ORDER", WarehouseID: &whA.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"SN-3"}}},
+	})
+	h.PostDocument(order.ID)
+
+	foreign := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &whA.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"SN-3"}}},
+	})
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", foreign.ID), nil, h.IfMatch(foreign.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code)
+	h.Assert.Contains(w.Body.String(), "зарезервирован другим заказом")
+
+	shipment := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &whA.ID, BaseDocumentID: &order.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(1), SerialNumbers: []string{"SN-3"}}},
+	})
+	h.PostDocument(shipment.ID)
+	w = h.PerformRequest("GET", "/api/v1/stock/serials/SN-3", nil)
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &history))
+	h.Assert.Equal("shipped", history.Status)
+}
@@ -0,0 +1,61 @@
+package stocktest
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestTransferLifecycle_Integration(t *testing.T) {
+	router, db := setupTestRouter("transfer_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	source := h.CreateWarehouse("Склад-отправитель")
+	target := h.CreateWarehouse("Склад-получатель")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "TRANSFER-TEST"})
+
+	// 1. Две партии по разной цене
+	incomeDoc1 := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &source.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(10), Price: decimalPtr(decimal.NewFromInt(100))}},
+	})
+	h.PostDocument(incomeDoc1.ID)
+	incomeDoc2 := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &source.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(10), Price: decimalPtr(decimal.NewFromInt(120))}},
+	})
+	h.PostDocument(incomeDoc2.ID)
+
+	// 2. Перемещаем 15 шт: вся первая партия и половина второй
+	transferDoc := h.CreateDocument(models.Document{
+		Type: "TRANSFER", WarehouseID: &source.ID, ToWarehouseID: &target.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(15)}},
+	})
+	h.PostDocument(transferDoc.ID)
+
+	h.Assert.True(decimal.NewFromInt(5).Equal(h.GetBalances(source.ID)[0].Quantity))
+	h.Assert.True(decimal.NewFromInt(15).Equal(h.GetBalances(target.ID)[0].Quantity))
+
+	var targetLots []models.StockLot
+	db.Where("warehouse_id = ? AND current_quantity > 0", target.ID).Order("arrival_date asc, id asc").Find(&targetLots)
+	h.Assert.Len(targetLots, 2, "У получателя должны появиться две партии")
+	h.Assert.True(decimal.NewFromInt(10).Equal(targetLots[0].CurrentQuantity))
+	h.Assert.True(decimal.NewFromInt(100).Equal(targetLots[0].UnitCost), "Себестоимость первой партии должна сохраниться")
+	h.Assert.True(decimal.NewFromInt(5).Equal(targetLots[1].CurrentQuantity))
+	h.Assert.True(decimal.NewFromInt(120).Equal(targetLots[1].UnitCost), "Себестоимость второй партии должна сохраниться")
+
+	// 3. Отмена возвращает товар на склад-отправитель
+	h.CancelDocument(transferDoc.ID)
+
+	h.Assert.True(decimal.NewFromInt(20).Equal(h.GetBalances(source.ID)[0].Quantity))
+	h.Assert.True(decimal.Zero.Equal(h.GetBalances(target.ID)[0].Quantity))
+
+	var sourceLots []models.StockLot
+	db.Where("warehouse_id = ? AND current_quantity > 0", source.ID).Find(&sourceLots)
+	h.Assert.Len(sourceLots, 2, "Обе партии отправителя должны быть восстановлены")
+}
@@ -0,0 +1,120 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestTransferInTransit_Integration(t *testing.T) {
+	router, db := setupTestRouter("transit_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	source := h.CreateWarehouse("Склад-отправитель")
+	target := h.CreateWarehouse("Склад-получатель")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "TRANSIT-TEST"})
+
+	incomeDoc := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &source.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(10), Price: decimalPtr(decimal.NewFromInt(100))}},
+	})
+	h.PostDocument(incomeDoc.ID)
+
+	transferDoc := h.CreateDocument(models.Document{
+		Type: "TRANSFER", WarehouseID: &source.ID, ToWarehouseID: &target.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(8)}},
+	})
+
+	// 1. Отгрузка: товар ушёл с отправителя, но ещё не пришёл к получателю
+	w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/ship", transferDoc.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	h.Assert.Equal("in_transit", h.GetDocument(transferDoc.ID).Status)
+	h.Assert.True(decimal.NewFromInt(2).Equal(h.GetBalances(source.ID)[0].Quantity))
+	h.Assert.Empty(h.GetBalances(target.ID), "До приёмки у получателя не должно быть остатка")
+
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/variants/%d/stock", variant.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code)
+	var stock []models.VariantStockDTO
+	json.Unmarshal(w.Body.Bytes(), &stock)
+	for _, s := range stock {
+		if s.WarehouseID == target.ID {
+			h.Assert.True(decimal.NewFromInt(8).Equal(s.InTransit), "В пути на склад-получатель должно быть 8 шт")
+		}
+	}
+
+	// 2. Провести перемещение в пути обычным способом нельзя
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", transferDoc.ID), nil, h.IfMatch(transferDoc.ID))
+	h.Assert.NotEqual(http.StatusOK, w.Code)
+
+	// 3. Частичная приёмка: 6 из 8, недостача фиксируется движением LOSS
+	w = h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/receive", transferDoc.ID), models.TransferReceiptDTO{
+		Items: []models.TransferReceiptItemDTO{{VariantID: variant.ID, Quantity: decimal.NewFromInt(6)}},
+	})
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	h.Assert.Equal("posted", h.GetDocument(transferDoc.ID).Status)
+	h.Assert.True(decimal.NewFromInt(6).Equal(h.GetBalances(target.ID)[0].Quantity))
+
+	var loss models.StockMovement
+	h.Assert.NoError(db.Where("document_id = ? AND type = ?", transferDoc.ID, "LOSS").First(&loss).Error)
+	h.Assert.True(decimal.NewFromInt(-2).Equal(loss.Quantity))
+
+	var targetLot models.StockLot
+	h.Assert.NoError(db.Where("warehouse_id = ?", target.ID).First(&targetLot).Error)
+	h.Assert.True(decimal.NewFromInt(6).Equal(targetLot.CurrentQuantity))
+	h.Assert.True(decimal.NewFromInt(100).Equal(targetLot.UnitCost), "Себестоимость должна сохраниться")
+
+	// 4. Отмена возвращает всё отгруженное количество отправителю
+	h.CancelDocument(transferDoc.ID)
+	h.Assert.True(decimal.NewFromInt(10).Equal(h.GetBalances(source.ID)[0].Quantity))
+	h.Assert.True(decimal.Zero.Equal(h.GetBalances(target.ID)[0].Quantity))
+
+	var transitCount int64
+	db.Model(&models.StockInTransit{}).Where("document_id = ?", transferDoc.ID).Count(&transitCount)
+	h.Assert.Zero(transitCount)
+}
+
+func TestTransferInTransitTotalPolicy_Integration(t *testing.T) {
+	// модуль читает ./config/stock_config.yml: политика total не ведёт себестоимость
+	if err := os.MkdirAll("config", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("config")
+	if err := os.WriteFile("config/stock_config.yml", []byte("accounting_policy: total\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	router, db := setupTestRouter("transit_total_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	source := h.CreateWarehouse("Склад-отправитель")
+	target := h.CreateWarehouse("Склад-получатель")
+	variant := h.CreateVariant(gin.H{"product_id": 1, "sku": "TRANSIT-TOTAL"})
+	h.PostDocument(h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &source.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(10), Price: decimalPtr(decimal.NewFromInt(100))}},
+	}).ID)
+
+	// цена в строке перемещения - не себестоимость, в пути она не сохраняется
+	transfer := h.CreateDocument(models.Document{
+		Type: "TRANSFER", WarehouseID: &source.ID, ToWarehouseID: &target.ID,
+		Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(4), Price: decimalPtr(decimal.NewFromInt(500))}},
+	})
+	w := h.PerformRequest("POST", fmt.Sprintf("/api/v1/stock/documents/%d/ship", transfer.ID), nil)
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	var rows []models.StockInTransit
+	h.Assert.NoError(db.Where("document_id = ?", transfer.ID).Find(&rows).Error)
+	h.Assert.Len(rows, 1)
+	h.Assert.True(rows[0].UnitCost.IsZero(), rows[0].UnitCost.String())
+}
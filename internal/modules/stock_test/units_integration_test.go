@@ -0,0 +1,146 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/config"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestUnitConversions_Integration(t *testing.T) {
+	router, _ := setupTestRouter("units_db")
+	h := NewTestHelper(t, router)
+
+	createUnit := func(payload gin.H) models.Unit {
+		w := h.PerformRequest("POST", "/api/v1/stock/units", payload)
+		h.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+		var unit models.Unit
+		h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &unit))
+		return unit
+	}
+
+	// 1. Точность единицы: по умолчанию 4 знака, у штук - 0
+	pcs := createUnit(gin.H{"name": "шт", "precision": 0})
+	h.Assert.Equal(0, pcs.Precision)
+	kg := createUnit(gin.H{"name": "кг", "precision": 3})
+	box := createUnit(gin.H{"name": "кор", "precision": 0})
+	pallet := createUnit(gin.H{"name": "пал"})
+	h.Assert.Equal(4, pallet.Precision)
+	w := h.PerformRequest("POST", "/api/v1/stock/units", gin.H{"name": "мг", "precision": 9})
+	h.Assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/units/%d", pallet.ID), gin.H{"name": "паллета"})
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &pallet))
+	h.Assert.Equal("паллета", pallet.Name)
+	h.Assert.Equal(4, pallet.Precision)
+
+	wh := h.CreateWarehouse("Основной")
+	category := h.CreateCategory("Бакалея")
+	product := h.CreateProduct(gin.H{"name": "Сок", "category_id": category.ID})
+	juice := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "JUICE-1", "unit_id": pcs.ID})
+	cheese := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "CHEESE-1", "unit_id": kg.ID})
+
+	// 2. Коробка - 12 штук, паллета - 40 коробок
+	w = h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/variants/%d/units", juice.ID), gin.H{"units": []gin.H{
+		{"unit_id": box.ID, "factor": 12}, {"unit_id": pallet.ID, "factor": 480},
+	}})
+	h.Assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var units []models.VariantUnitDTO
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &units))
+	h.Assert.Len(units, 2)
+	h.Assert.Equal("кор", units[0].UnitName)
+
+	w = h.PerformRequest("PUT", fmt.Sprintf("/api/v1/stock/variants/%d/units", juice.ID), gin.H{"units": []gin.H{{"unit_id": pcs.ID, "factor": 1}}})
+	h.Assert.Equal(http.StatusBadRequest, w.Code)
+
+	// 3. Строка вводится в коробках и паллетах, хранится и проводится в штуках
+	income := h.CreateDocument(models.Document{
+		Type: "INCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{
+			{VariantID: juice.ID, UnitID: &box.ID, UnitQuantity: decimalPtr(decimal.NewFromInt(2)), Price: decimalPtr(decimal.NewFromInt(50))},
+			{VariantID: juice.ID, UnitID: &pallet.ID, UnitQuantity: decimalPtr(decimal.NewFromInt(1)), Price: decimalPtr(decimal.NewFromInt(50))},
+			{VariantID: cheese.ID, Quantity: decimal.RequireFromString("0.125"), Price: decimalPtr(decimal.NewFromInt(800))},
+		},
+	})
+	h.Assert.True(decimal.NewFromInt(24).Equal(income.Items[0].Quantity))
+	h.Assert.True(decimal.NewFromInt(480).Equal(income.Items[1].Quantity))
+
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/%d", income.ID), nil)
+	var dto models.DocumentDTO
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &dto))
+	h.Assert.Equal("кор", dto.Items[0].UnitName)
+	h.Assert.True(decimal.NewFromInt(2).Equal(*dto.Items[0].UnitQuantity))
+	h.Assert.Empty(dto.Items[2].UnitName)
+
+	// Правка с теми же строками не пересчитывает количество повторно
+	doc := h.GetDocument(income.ID)
+	doc.Items[0].UnitQuantity = decimalPtr(decimal.NewFromInt(3))
+	h.UpdateDocument(income.ID, models.DocumentUpdateDTO{WarehouseID: &wh.ID, Items: doc.Items})
+	doc = h.GetDocument(income.ID)
+	h.Assert.True(decimal.NewFromInt(36).Equal(doc.Items[0].Quantity))
+	h.Assert.True(decimal.NewFromInt(480).Equal(doc.Items[1].Quantity))
+
+	h.PostDocument(income.ID)
+	balances := h.GetBalances(wh.ID)
+	h.Assert.True(decimal.NewFromInt(516).Equal(findBalance(balances, juice.ID).Quantity))
+	h.Assert.True(decimal.RequireFromString("0.125").Equal(findBalance(balances, cheese.ID).Quantity))
+
+	// 4. Точность: полторы штуки, полкоробки и неизвестная единица отклоняются
+	rejected := []models.DocumentItem{
+		{VariantID: juice.ID, Quantity: decimal.RequireFromString("1.5")},
+		{VariantID: juice.ID, UnitID: &box.ID, UnitQuantity: decimalPtr(decimal.RequireFromString("0.5"))},
+		{VariantID: juice.ID, UnitID: &kg.ID, UnitQuantity: decimalPtr(decimal.NewFromInt(1))},
+		{VariantID: juice.ID, UnitID: &box.ID},
+		{VariantID: cheese.ID, Quantity: decimal.RequireFromString("0.1255")},
+	}
+	for _, item := range rejected {
+		w := h.PerformRequest("POST", "/api/v1/stock/documents", models.Document{
+			Type: "OUTCOME", WarehouseID: &wh.ID, Items: []models.DocumentItem{item},
+		})
+		h.Assert.NotEqual(http.StatusCreated, w.Code, w.Body.String())
+		h.Assert.Contains(w.Body.String(), "variant ")
+	}
+
+	// Дробная паллета допустима, если в штуках выходит целое
+	outcome := h.CreateDocument(models.Document{
+		Type: "OUTCOME", WarehouseID: &wh.ID,
+		Items: []models.DocumentItem{{VariantID: juice.ID, UnitID: &pallet.ID, UnitQuantity: decimalPtr(decimal.RequireFromString("0.25"))}},
+	})
+	h.Assert.True(decimal.NewFromInt(120).Equal(outcome.Items[0].Quantity))
+	h.PostDocument(outcome.ID)
+	h.Assert.True(decimal.NewFromInt(396).Equal(findBalance(h.GetBalances(wh.ID), juice.ID).Quantity))
+}
+
+func TestUnitPrecisionUpgrade_Integration(t *testing.T) {
+	router, db := setupTestRouter("units_upgrade_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	// База до появления точности: единицы заведены без неё
+	h.Assert.NoError(db.Migrator().DropColumn(&models.Unit{}, "precision"))
+	h.Assert.NoError(db.Exec("INSERT INTO units (name) VALUES ('шт'), ('кг')").Error)
+
+	module := stock.NewModule(config.AuthConfig{JWTSecret: testJWTSecret}, nil)
+	h.Assert.NoError(module.Migrate(db))
+	precision := func(name string) int {
+		var unit models.Unit
+		h.Assert.NoError(db.Where("name = ?", name).First(&unit).Error)
+		return unit.Precision
+	}
+	h.Assert.Equal(0, precision("шт"))
+	h.Assert.Equal(4, precision("кг"))
+
+	// повторная миграция не трогает точность, заданную в справочнике
+	h.Assert.NoError(db.Model(&models.Unit{}).Where("name = ?", "шт").Update("precision", 2).Error)
+	h.Assert.NoError(module.Migrate(db))
+	h.Assert.Equal(2, precision("шт"))
+}
@@ -0,0 +1,124 @@
+package stocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/maksroxx/flowkeeper/internal/modules/reports"
+	"github.com/maksroxx/flowkeeper/internal/modules/stock/models"
+)
+
+func TestWriteOff_Integration(t *testing.T) {
+	router, db := setupTestRouter("write_off_db")
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	h := NewTestHelper(t, router)
+
+	wh := h.CreateWarehouse("Основной")
+	unit := h.CreateUnit("шт")
+	category := h.CreateCategory("Продукты")
+	product := h.CreateProduct(gin.H{"name": "Йогурт", "category_id": category.ID})
+	variant := h.CreateVariant(gin.H{"product_id": product.ID, "sku": "WO-1", "unit_id": unit.ID})
+
+	// 1. Стандартные причины заводятся при миграции, свои добавляются в справочник
+	w := h.PerformRequest("GET", "/api/v1/stock/write-off-reasons", nil)
+	h.Assert.Equal(http.StatusOK, w.Code)
+	var reasons []models.WriteOffReason
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &reasons))
+	byCode := make(map[string]models.WriteOffReason)
+	for _, r := range reasons {
+		byCode[r.Code] = r
+	}
+	for _, code := range []string{"DAMAGE", "EXPIRED", "THEFT", "SAMPLES"} {
+		h.Assert.Contains(byCode, code)
+	}
+	w = h.PerformRequest("POST", "/api/v1/stock/write-off-reasons", gin.H{"code": "shrink", "name": "Усушка"})
+	h.Assert.Equal(http.StatusCreated, w.Code, w.Body.String())
+	var shrink models.WriteOffReason
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &shrink))
+	h.Assert.Equal("SHRINK", shrink.Code)
+
+	for _, price := range []int64{10, 14} {
+		doc := h.CreateDocument(models.Document{
+			Type: "INCOME", WarehouseID: &wh.ID,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(5), Price: decimalPtr(decimal.NewFromInt(price))}},
+		})
+		h.PostDocument(doc.ID)
+	}
+
+	writeOff := func(reasonID *uint, qty int64) models.Document {
+		return h.CreateDocument(models.Document{
+			Type: "WRITE_OFF", WarehouseID: &wh.ID, WriteOffReasonID: reasonID,
+			Items: []models.DocumentItem{{VariantID: variant.ID, Quantity: decimal.NewFromInt(qty)}},
+		})
+	}
+
+	// 2. Без причины списание не проводится
+	noReason := writeOff(nil, 1)
+	w = h.PerformRequestWithHeaders("POST", fmt.Sprintf("/api/v1/stock/documents/%d/post", noReason.ID), nil, h.IfMatch(noReason.ID))
+	h.Assert.Equal(http.StatusInternalServerError, w.Code, w.Body.String())
+	h.Assert.Contains(w.Body.String(), "write_off_reason_id is required")
+
+	// 3. Списание расходует партии по FIFO и фиксирует себестоимость
+	damage := byCode["DAMAGE"]
+	damaged := writeOff(&damage.ID, 7)
+	h.Assert.True(strings.HasPrefix(h.GetDocument(damaged.ID).Number, "СП"))
+	h.PostDocument(damaged.ID)
+	h.Assert.True(decimal.NewFromInt(3).Equal(findBalance(h.GetBalances(wh.ID), variant.ID).Quantity))
+
+	var moves []models.StockMovement
+	h.Assert.NoError(db.Where("document_id = ?", damaged.ID).Order("id").Find(&moves).Error)
+	h.Assert.Len(moves, 2)
+	cost := decimal.Zero
+	for _, mv := range moves {
+		h.Assert.Equal("WRITE_OFF", mv.Type)
+		cost = cost.Add(mv.Quantity.Abs().Mul(mv.UnitCost))
+	}
+	h.Assert.True(decimal.NewFromInt(5*10+2*14).Equal(cost), cost.String())
+
+	w = h.PerformRequest("GET", fmt.Sprintf("/api/v1/stock/documents/%d", damaged.ID), nil)
+	var dto models.DocumentDTO
+	h.Assert.NoError(json.Unmarshal(w.Body.Bytes(), &dto))
+	h.Assert.Equal("Порча", dto.WriteOffReasonName)
+
+	samples := byCode["SAMPLES"]
+	sampled := writeOff(&samples.ID, 1)
+	h.PostDocument(sampled.ID)
+
+	// 4. Отчёт по причинам: отменённое списание не учитывается
+	stolen := writeOff(&shrink.ID, 1)
+	h.PostDocument(stolen.ID)
+	h.CancelDocument(stolen.ID)
+	h.Assert.True(decimal.NewFromInt(2).Equal(findBalance(h.GetBalances(wh.ID), variant.ID).Quantity))
+
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	rows, err := reports.NewRepository(db, "fifo").GetWriteOffData(from, to, &wh.ID)
+	h.Assert.NoError(err)
+	h.Assert.Len(rows, 2)
+	totals := make(map[string]decimal.Decimal)
+	for _, row := range rows {
+		h.Assert.Equal("Основной", row.WarehouseName)
+		h.Assert.Equal("шт", row.Unit)
+		totals[row.ReasonCode] = row.TotalCost
+	}
+	h.Assert.True(decimal.NewFromInt(78).Equal(totals["DAMAGE"]), totals["DAMAGE"].String())
+	h.Assert.True(decimal.NewFromInt(14).Equal(totals["SAMPLES"]), totals["SAMPLES"].String())
+
+	svc := reports.NewService(reports.NewRepository(db, "fifo"))
+	for _, format := range []string{"csv", "xlsx"} {
+		data, ext, err := svc.GenerateReport(reports.ReportRequest{Type: "write_offs", Format: format, DateFrom: from, DateTo: to})
+		h.Assert.NoError(err)
+		h.Assert.Equal(format, ext)
+		h.Assert.NotEmpty(data)
+		if format == "csv" {
+			h.Assert.Contains(string(data), "Порча")
+		}
+	}
+}
@@ -3,6 +3,7 @@ package users
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -64,6 +65,8 @@ func AuthMiddleware(cfg config.AuthConfig) gin.HandlerFunc {
 	}
 }
 
+// RequirePermission пропускает запрос, если у пользователя есть право requiredPerm.
+// Право, ограниченное областью ("post_document:INCOME"), тоже подходит - точную проверку делает обработчик.
 func RequirePermission(requiredPerm string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, _ := c.Get("userRole")
@@ -86,7 +89,7 @@ func RequirePermission(requiredPerm string) gin.HandlerFunc {
 		}
 
 		for _, p := range perms {
-			if p == requiredPerm {
+			if p == "all" || p == requiredPerm || strings.HasPrefix(p, requiredPerm+":") {
 				c.Next()
 				return
 			}
@@ -95,3 +98,81 @@ func RequirePermission(requiredPerm string) gin.HandlerFunc {
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Missing permission: %s", requiredPerm)})
 	}
 }
+
+// HasPermission проверяет право с учётом области. "post_document" действует для любой области,
+// "post_document:INCOME" - только для scope "INCOME". Пустой scope требует неограниченного права.
+func HasPermission(c *gin.Context, perm, scope string) bool {
+	if IsAdmin(c) {
+		return true
+	}
+	for _, p := range contextPermissions(c) {
+		if p == "all" || p == perm {
+			return true
+		}
+		if scope != "" && strings.EqualFold(p, perm+":"+scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// WarehouseAllowed проверяет доступ к складу. Права "warehouse:<id>" ограничивают пользователя
+// перечисленными складами; без них доступны все склады.
+func WarehouseAllowed(c *gin.Context, warehouseID uint) bool {
+	allowed, restricted := AllowedWarehouses(c)
+	if !restricted {
+		return true
+	}
+	for _, id := range allowed {
+		if id == warehouseID {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedWarehouses возвращает склады из прав "warehouse:<id>". restricted=false - пользователь
+// не ограничен складами, и список для фильтрации не нужен.
+func AllowedWarehouses(c *gin.Context) (allowed []uint, restricted bool) {
+	if IsAdmin(c) {
+		return nil, false
+	}
+	allowed = []uint{}
+	for _, p := range contextPermissions(c) {
+		idStr, ok := strings.CutPrefix(p, "warehouse:")
+		if !ok {
+			continue
+		}
+		restricted = true
+		if id, err := strconv.ParseUint(idStr, 10, 64); err == nil {
+			allowed = append(allowed, uint(id))
+		}
+	}
+	return allowed, restricted
+}
+
+// IsAdmin - запрос от пользователя с ролью admin, которому доступно всё.
+func IsAdmin(c *gin.Context) bool {
+	role, _ := c.Get("userRole")
+	return role == "admin"
+}
+
+// CurrentUserID возвращает ID пользователя из токена.
+func CurrentUserID(c *gin.Context) (uint, bool) {
+	idVal, _ := c.Get("userID")
+	switch v := idVal.(type) {
+	case float64:
+		return uint(v), true
+	case uint:
+		return v, true
+	case int:
+		return uint(v), true
+	}
+	return 0, false
+}
+
+func contextPermissions(c *gin.Context) []string {
+	permsInterface, _ := c.Get("permissions")
+	perms, _ := permsInterface.([]string)
+	return perms
+}
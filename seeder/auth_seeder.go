@@ -50,6 +50,7 @@ func seedRoles(db *gorm.DB) {
 				"view_dashboard",
 				"view_stock",
 				"create_document",
+				"post_document",
 				"view_inventory",
 			},
 		},
@@ -62,6 +63,10 @@ func seedRoles(db *gorm.DB) {
 				"view_counterparties",
 				"create_document",
 				"approve_document",
+				"post_document",
+				"cancel_document",
+				"manage_directories",
+				"close_period",
 			},
 		},
 	}